@@ -1,140 +1,1381 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/config"
+	"github.com/ppeble/s3-personal-backup/pkg/credential"
+	"github.com/ppeble/s3-personal-backup/pkg/history"
+	"github.com/ppeble/s3-personal-backup/pkg/localdir"
+	"github.com/ppeble/s3-personal-backup/pkg/lock"
 	"github.com/ppeble/s3-personal-backup/pkg/logger"
+	"github.com/ppeble/s3-personal-backup/pkg/metrics"
+	"github.com/ppeble/s3-personal-backup/pkg/notify"
 	"github.com/ppeble/s3-personal-backup/pkg/reporter"
+	"github.com/ppeble/s3-personal-backup/pkg/s3"
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
 	"github.com/ppeble/s3-personal-backup/pkg/worker"
 )
 
 func main() {
 	processVars()
 
-	// Maybe I need an s3 client for each worker process?
-	// Maybe I can't have one at the top that I pass to
-	// every routine
-	s3Client, err := minio.New(
-		viper.GetString("s3Host"),
-		&minio.Options{
-			Creds: credentials.NewStaticV4(
-				viper.GetString("s3AccessKey"),
-				viper.GetString("s3SecretKey"),
-				"",
-			),
-			Secure: true,
-		},
-	)
+	err := readConfig()
+	if err != nil {
+		setupFailed(err)
+	}
+
+	out, err := newOutput()
+	if err != nil {
+		setupFailed(err)
+	}
+	logs = out
+
+	command := flag.Arg(0)
+	switch command {
+	case "", "run", "unlock", "plan", "apply":
+	case "history":
+		showHistory(flag.Args()[1:])
+		return
+	default:
+		setupFailed(fmt.Errorf("unknown command '%s'", command))
+	}
+
+	host, err := os.Hostname()
 	if err != nil {
-		panic(err)
+		setupFailed(fmt.Errorf("unable to get the host name, err: %s", err))
 	}
 
-	var workerWg sync.WaitGroup
-	remoteActionChan := make(chan backup.RemoteAction, 20)
+	profiles := []string{viper.GetString("profile")}
+	if viper.GetBool("all") {
+		profiles = config.Names(viper.GetViper())
+		if len(profiles) == 0 {
+			setupFailed(errors.New("--all needs profiles in the config file"))
+		}
+	}
+
+	// A plan is applied to the profiles that it was made for
+	if command == "apply" {
+		plan, err := readPlan(flag.Args()[1:], host)
+		if err != nil {
+			setupFailed(err)
+		}
+		applying = &plan
+
+		profiles = make([]string, len(plan.Profiles))
+		for i, p := range plan.Profiles {
+			profiles[i] = p.Name
+		}
+	}
+
+	// Every profile is checked before anything runs, a typo in the last
+	// profile shouldn't only show up once the others are done. That goes
+	// for every bucket being there and writable too, unless all that is
+	// wanted is to unlock them.
+	loaded := make([]config.Profile, len(profiles))
+	storages := make([][]backup.Storage, len(profiles))
+	for i, name := range profiles {
+		profile, err := config.Load(viper.GetViper(), name, overridden)
+		if command == "plan" {
+			profile.DryRun = true
+		}
+
+		if err == nil && applying != nil {
+			err = checkPlan(*applying, profile)
+		}
+
+		if err == nil {
+			storages[i], err = newStorages(profile, profile.DryRun || command == "unlock")
+		}
+
+		if err != nil {
+			if name != "" {
+				err = fmt.Errorf("profile '%s': %s", name, err)
+			}
+			setupFailed(err)
+		}
+
+		loaded[i] = profile
+	}
+
+	notifications, err := newNotifications()
+	if err != nil {
+		setupFailed(err)
+	}
+
+	runTracer, err = newTracer(host)
+	if err != nil {
+		setupFailed(err)
+	}
+
+	if err := reporter.CheckFormat(viper.GetString("reportFormat")); err != nil {
+		setupFailed(err)
+	}
+
+	locks := newLocks(loaded, storages, host, command == "unlock")
+	if command == "unlock" {
+		unlock(locks, viper.GetBool("force"))
+		return
+	}
+
+	// Exiting doesn't run deferred calls, the locks are released by hand
+	// on every way out from here on
+	for _, l := range locks {
+		if err := l.Acquire(); err != nil {
+			releaseLocks(locks)
+			setupFailed(fmt.Errorf("%s: %s", l.name, err))
+		}
+	}
+
+	if err := serveMetrics(); err != nil {
+		releaseLocks(locks)
+		setupFailed(err)
+	}
+	loadTextfile()
+
+	shutdownTimeout := viper.GetDuration("shutdownTimeout")
+	ctx := interruptible(shutdownTimeout)
+
+	// The process exits once the run is done, transfers are never cut
+	// short other than by the grace running out
+	transfers, _ := worker.Grace(ctx, shutdownTimeout)
 
-	reportChan := make(chan backup.LogEntry)
 	reportOut := log.New(os.Stdout, "REPORT: ", log.Ldate|log.Ltime|log.LUTC)
+	started := time.Now()
+
+	if viper.GetString("reportFormat") == reporter.HTMLFormat {
+		r := reporter.NewHTMLReport(host, started)
+		runReport = &r
+	}
+
+	if command == "plan" {
+		plan := backup.NewPlan(host, started)
+		planning = &plan
+	}
+
+	ctx, span := runTracer.Start(ctx, "backup", trace.Attr("run", runID(started)))
+
+	// A single profile reports the way it always has, with --all the other
+	// profiles still run and a failure shows up in the combined report
+	if len(loaded) == 1 {
+		summary, outcome, err := run(ctx, transfers, loaded[0], storages[0], host, reportOut)
+		if err != nil && !errors.Is(err, errInterrupted) {
+			logs.Error("backup failed", logger.KV("err", err))
+		}
+		finished(loaded[0], outcome)
+		endTrace(span, outcome, err)
+		writeReport()
+		writePlan(err != nil)
+
+		all := notify.NewSummary(host, started, result(loaded[0], summary, outcome, err))
+		record(runID(started), all, loaded, storages)
+		notifyAll(notifications, all)
+		finish(locks, outcome)
+	}
+
+	combined := reporter.NewCombinedReporter(reportOut, "profile")
+	outcomes := make([]backup.Outcome, len(loaded))
+	results := make([]notify.Profile, len(loaded))
+	anyFailed := false
+
+	for i, profile := range loaded {
+		// Nothing new is started once the run is stopped
+		if ctx.Err() != nil {
+			combined.Add(profile.Name, backup.ReportSummary{}, errInterrupted)
+			report(profile.Name, nil, backup.Aborted, errInterrupted)
+			outcomes[i] = backup.Aborted
+			results[i] = result(profile, backup.ReportSummary{}, backup.Aborted, errInterrupted)
+			anyFailed = true
+			continue
+		}
+
+		summary, outcome, err := run(ctx, transfers, profile, storages[i], host, reportOut)
+		if err != nil && !errors.Is(err, errInterrupted) {
+			logs.Error("profile failed", logger.KV("profile", profile.Name), logger.KV("err", err))
+		}
+		finished(profile, outcome)
+
+		combined.Add(profile.Name, summary, err)
+		outcomes[i] = outcome
+		results[i] = result(profile, summary, outcome, err)
+		anyFailed = anyFailed || err != nil
+	}
+
+	if runReport == nil {
+		combined.Print()
+	}
+	endTrace(span, backup.CombineOutcomes(outcomes...), nil)
+	writeReport()
+	writePlan(anyFailed)
+
+	all := notify.NewSummary(host, started, results...)
+	record(runID(started), all, loaded, storages)
+	notifyAll(notifications, all)
+	finish(locks, backup.CombineOutcomes(outcomes...))
+}
+
+// logs is where everything but the reports is logged. That is stdout, as
+// text, until the flags for it are read.
+var logs, _ = logger.NewOutput(os.Stdout, logger.InfoLevel, logger.TextFormat)
+
+// newOutput is where and how to log according to the log flags
+func newOutput() (logger.Output, error) {
+	level, err := logger.ParseLevel(viper.GetString("logLevel"))
+	if err != nil {
+		return logger.Output{}, err
+	}
+
+	quiet, verbose := viper.GetBool("quiet"), viper.GetBool("verbose")
+	switch {
+	case quiet && verbose:
+		return logger.Output{}, errors.New("--quiet and --verbose can't be used together")
+	case quiet:
+		level = logger.WarnLevel
+	case verbose:
+		level = logger.DebugLevel
+	}
+
+	var out io.Writer = os.Stdout
+	if path := viper.GetString("logFile"); path != "" {
+		f, err := logger.OpenRotatingFile(path, viper.GetInt64("logMaxSize")<<20, viper.GetInt("logMaxFiles"))
+		if err != nil {
+			return logger.Output{}, err
+		}
+
+		out = f
+	}
+
+	return logger.NewOutput(out, level, viper.GetString("logFormat"))
+}
+
+// exitSetup is what the process exits with when it never got as far as
+// backing anything up, for a broken config or a lock that is held. The
+// other exit codes are those of each backup.Outcome.
+const exitSetup = 3
+
+func setupFailed(err error) {
+	logs.Error("unable to start the backup", logger.KV("err", err))
+	os.Exit(exitSetup)
+}
+
+// runMetrics are the metrics of every profile that is run
+var runMetrics = metrics.NewRun()
+
+// serveMetrics serves the metrics on /metrics of --metricsListen, if it is
+// set, for as long as the process runs. It only fails if it can't listen,
+// that shows up before anything is backed up.
+func serveMetrics() error {
+	addr := viper.GetString("metricsListen")
+	if addr == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("unable to serve metrics, err: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", runMetrics)
+
+	go http.Serve(l, mux)
+
+	logs.Info("serving metrics", logger.KV("address", l.Addr().String()))
+	return nil
+}
+
+// finished records how a run of profile went in the metrics, a dry run
+// changes nothing and doesn't count
+func finished(profile config.Profile, outcome backup.Outcome) {
+	if !profile.DryRun {
+		runMetrics.Finished(profile.Name, outcome)
+	}
+}
+
+// loadTextfile carries over when each profile last succeeded from the
+// last time --metricsTextfile was written, if it is set
+func loadTextfile() {
+	path := viper.GetString("metricsTextfile")
+	if path == "" {
+		return
+	}
+
+	if err := runMetrics.LoadLastSuccess(path); err != nil {
+		logs.Warn("unable to read the last metrics textfile", logger.KV("path", path), logger.KV("err", err))
+	}
+}
+
+// writeTextfile writes the metrics to --metricsTextfile, if it is set. Not
+// being able to write it doesn't change how the run went.
+func writeTextfile() {
+	path := viper.GetString("metricsTextfile")
+	if path == "" {
+		return
+	}
+
+	if err := runMetrics.WriteTextfile(path); err != nil {
+		logs.Error("unable to write the metrics textfile", logger.KV("path", path), logger.KV("err", err))
+	}
+}
 
-	var reportGenerator backup.Reporter
-	if viper.GetBool("dryRun") {
-		r := reporter.NewDryRunReporter(reportChan, reportOut)
-		reportGenerator = &r
+// runHistory is everything that is logged about the files of a run, for
+// the history
+var runHistory = history.NewRecorder()
+
+// record keeps the run in the history and, with --historyUpload, puts it on
+// every destination of the profiles that aren't dry runs. Not being able to
+// doesn't change how the run went.
+func record(id string, summary notify.Summary, profiles []config.Profile, storages [][]backup.Storage) {
+	r := runHistory.Run(id, summary)
+
+	if err := history.NewStore(viper.GetString("historyDir")).Save(r); err != nil {
+		logs.Error("unable to save the run to the history", logger.KV("id", id), logger.KV("err", err))
 	} else {
-		r := reporter.NewReporter(reportChan, reportOut)
-		reportGenerator = &r
+		logs.Info("saved the run to the history", logger.KV("id", id))
+	}
+
+	if !viper.GetBool("historyUpload") {
+		return
+	}
+
+	// The run may well have been stopped by now, uploading it is still
+	// worth a try
+	ctx := context.Background()
+
+	for i, profile := range profiles {
+		if profile.DryRun {
+			continue
+		}
+
+		for j, storage := range storages[i] {
+			if err := history.Upload(ctx, storage, r); err != nil {
+				logs.Error("unable to upload the run",
+					logger.KV("profile", profile.Name), logger.KV("destination", profile.Destinations[j].Name), logger.KV("err", err),
+				)
+			}
+		}
+	}
+}
+
+// showHistory lists the last --historyLimit runs or, with show <id>, prints
+// the whole of one of them
+func showHistory(args []string) {
+	store := history.NewStore(viper.GetString("historyDir"))
+
+	var err error
+	switch {
+	case len(args) == 0:
+		var runs []history.Run
+		if runs, err = store.List(viper.GetInt("historyLimit")); err == nil {
+			err = history.PrintList(os.Stdout, runs)
+		}
+	case len(args) == 2 && args[0] == "show":
+		var r history.Run
+		if r, err = store.Get(args[1]); err == nil {
+			err = history.PrintRun(os.Stdout, r)
+		}
+	default:
+		err = errors.New("it is either history or history show <id>")
+	}
+
+	if err != nil {
+		logs.Error("unable to show the history", logger.KV("err", err))
+		os.Exit(1)
+	}
+}
+
+// defaultHistoryDir is where the history is kept unless --historyDir says
+// otherwise. Without a home directory that is the current one.
+func defaultHistoryDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share", "s3-personal-backup", "history")
+}
+
+// runTracer traces every run with --traceFile or --traceEndpoint, without
+// either of them it is nil and nothing is traced
+var runTracer *trace.Tracer
+
+// traceTimeout is how long exporting the trace gets, the same as each
+// notifier
+const traceTimeout = time.Minute
+
+// newTracer exports to the file and the OTLP/HTTP endpoint that are set,
+// if any
+func newTracer(host string) (*trace.Tracer, error) {
+	var exporters []trace.Exporter
+
+	if path := viper.GetString("traceFile"); path != "" {
+		exporters = append(exporters, trace.NewFile(path))
+	}
+
+	if endpoint := viper.GetString("traceEndpoint"); endpoint != "" {
+		e, err := trace.NewHTTP(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+
+	if len(exporters) == 0 {
+		return nil, nil
+	}
+
+	resource := []trace.Attribute{
+		trace.Attr("service.name", "s3-personal-backup"),
+		trace.Attr("host.name", host),
+	}
+
+	return trace.NewTracer(resource, exporters...), nil
+}
+
+// endTrace ends the span of the whole run and exports it, with everything
+// in it. An exporter that fails is logged, it doesn't change how the run
+// went.
+func endTrace(span *trace.Span, outcome backup.Outcome, err error) {
+	span.SetAttributes(trace.Attr("outcome", outcome.String()))
+	span.End(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), traceTimeout)
+	defer cancel()
+
+	for _, err := range runTracer.Export(ctx) {
+		logs.Error("unable to export the trace", logger.KV("err", err))
+	}
+}
+
+// notifyTimeout is how long each notifier gets, a mail server that doesn't
+// answer shouldn't keep the run from ending
+const notifyTimeout = time.Minute
+
+// newNotifications are the notifiers in the config file, mail is sent
+// through the --smtpHost server
+func newNotifications() ([]notify.Notification, error) {
+	notifiers, err := config.Notifiers(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+
+	server := notify.SMTP{
+		Addr:     viper.GetString("smtpHost"),
+		Username: viper.GetString("smtpUsername"),
+		Password: viper.GetString("smtpPassword"),
+		From:     viper.GetString("smtpFrom"),
+	}
+
+	notifications := make([]notify.Notification, len(notifiers))
+	for i, n := range notifiers {
+		var notifier notify.Notifier
+		switch {
+		case n.Webhook != "":
+			notifier, err = notify.NewWebhook(n.Webhook)
+		case len(n.Email) > 0:
+			notifier, err = notify.NewEmail(server, n.Email...)
+		default:
+			notifier = notify.NewCommand(n.Command)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("notifier %d: %s", i+1, err)
+		}
+
+		notifications[i] = notify.Notification{Notifier: notifier, Rule: n.Rule}
+	}
+
+	return notifications, nil
+}
+
+// result is how a profile went, for the notifiers and the history
+func result(profile config.Profile, summary backup.ReportSummary, outcome backup.Outcome, err error) notify.Profile {
+	p := notify.Profile{Name: profile.Name, Outcome: outcome, DryRun: profile.DryRun, ReportSummary: summary}
+	if err != nil {
+		p.Error = err.Error()
+	}
+
+	return p
+}
+
+// notifyAll tells the notifiers about the run once its report is printed.
+// A notifier that fails is logged, it doesn't change how the run went.
+func notifyAll(notifications []notify.Notification, summary notify.Summary) {
+	for i, err := range notify.Send(notifications, summary, notifyTimeout) {
+		if err != nil {
+			logs.Error("unable to notify", logger.KV("notifier", notifications[i].String()), logger.KV("err", err))
+		}
+	}
+}
+
+// finish ends the process with the exit code of outcome
+func finish(locks []runLock, outcome backup.Outcome) {
+	writeTextfile()
+
+	switch outcome {
+	case backup.Succeeded:
+		logs.Info("backup succeeded", logger.KV("outcome", outcome.String()))
+	case backup.Aborted:
+		logs.Warn("stopped before the backup was done", logger.KV("outcome", outcome.String()))
+	default:
+		logs.Error("backup "+outcome.String(), logger.KV("outcome", outcome.String()))
+	}
+
+	releaseLocks(locks)
+	os.Exit(outcome.ExitCode())
+}
+
+// errInterrupted is what a run that was stopped by a signal fails with, the
+// report still covers whatever it got done
+var errInterrupted = errors.New("interrupted")
+
+// interruptible is cancelled by the first SIGINT or SIGTERM. A second one
+// isn't caught any more and ends the process right away, without waiting
+// up to grace for whatever is in flight.
+func interruptible(grace time.Duration) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+
+		logs.Warn(
+			"stopping once what is in flight is done, send the signal again to stop right away",
+			logger.KV("signal", sig.String()), logger.KV("grace", grace),
+		)
+		cancel()
+	}()
+
+	return ctx
+}
+
+// runLock is a lock that a run takes, named for the errors about it
+type runLock struct {
+	lock.Lock
+	name string
+}
+
+// newLocks are the lock file and, with --remoteLock, a lock in every
+// destination. A dry run never writes anything, not even a lock, but all
+// of them are there to unlock.
+func newLocks(profiles []config.Profile, storages [][]backup.Storage, host string, unlocking bool) []runLock {
+	self := lock.Self(host)
+	expiry := viper.GetDuration("lockExpiry")
+
+	path := viper.GetString("lockFile")
+	locks := []runLock{{lock.NewFile(path, self, expiry), fmt.Sprintf("lock file '%s'", path)}}
+
+	if !viper.GetBool("remoteLock") {
+		return locks
 	}
 
-	go reportGenerator.Run()
+	for i, profile := range profiles {
+		if profile.DryRun && !unlocking {
+			continue
+		}
+
+		for j, d := range profile.Destinations {
+			name := "remote lock"
+			if d.Name != "" {
+				name += fmt.Sprintf(" of destination '%s'", d.Name)
+			}
+			if profile.Name != "" {
+				name += fmt.Sprintf(" of profile '%s'", profile.Name)
+			}
 
-	logger := logger.NewLogger(os.Stdout, reportChan, &workerWg)
+			locks = append(locks, runLock{lock.NewRemote(storages[i][j], self, expiry), name})
+		}
+	}
+
+	return locks
+}
 
-	targetDirs := strings.Split(viper.GetString("targetDirs"), ",")
-	localFileProcessors := make([]backup.FileGatherer, len(targetDirs))
-	for i, targetDir := range targetDirs {
-		p := backup.NewLocalFileProcessor(targetDir)
-		localFileProcessors[i] = &p
+// releaseLocks releases in reverse, the lock file is held until the very end
+func releaseLocks(locks []runLock) {
+	for i := len(locks) - 1; i >= 0; i-- {
+		if err := locks[i].Release(); err != nil {
+			logs.Error("unable to release "+locks[i].name, logger.KV("err", err))
+		}
 	}
+}
 
-	remoteFileProcessor, err := backup.NewRemoteFileProcessor(
-		viper.GetString("s3BucketName"),
-		s3Client.ListObjects,
-		s3Client.RemoveObject,
-		s3Client.FPutObject,
+// unlock removes the locks that were left behind, those that are still
+// held only with force
+func unlock(locks []runLock, force bool) {
+	failed := false
+	for _, l := range locks {
+		removed, err := l.Break(force)
+		if errors.As(err, &lock.HeldError{}) {
+			logs.Error("not removing "+l.name+", it is still held. --force removes it anyway.", logger.KV("err", err))
+			failed = true
+		} else if err != nil {
+			logs.Error("unable to remove "+l.name, logger.KV("err", err))
+			failed = true
+		} else if removed {
+			logs.Info("removed " + l.name)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// newStorages makes the storage for every destination of a profile
+func newStorages(profile config.Profile, dryRun bool) ([]backup.Storage, error) {
+	storages := make([]backup.Storage, len(profile.Destinations))
+	for i, d := range profile.Destinations {
+		s, err := newStorage(d, dryRun)
+		if err != nil {
+			if d.Name != "" {
+				err = fmt.Errorf("destination '%s': %s", d.Name, err)
+			}
+			return nil, err
+		}
+
+		storages[i] = s
+	}
+
+	return storages, nil
+}
+
+// newStorage makes the storage of a single destination and checks that it
+// can be backed up to, before any actions are queued. A dry run only checks
+// that the bucket is there, it never writes anything.
+func newStorage(d config.Destination, dryRun bool) (backup.Storage, error) {
+	ctx := context.Background()
+
+	if d.Storage == "local" {
+		s, err := localdir.NewStorage(d.StorageDir)
+		if err == nil && !dryRun {
+			err = backup.Preflight(ctx, &s)
+		}
+		return &s, err
+	}
+
+	creds, err := credential.New(credential.Options{
+		Source:        d.Credentials,
+		AccessKey:     d.S3AccessKey,
+		SecretKey:     d.S3SecretKey,
+		SecretKeyFile: d.S3SecretKeyFile,
+		File:          d.CredentialsFile,
+		FileProfile:   d.CredentialsProfile,
+		Process:       d.CredentialProcess,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s3.NewClient(d.S3Host, creds, s3.Options{
+		InsecureHTTP:  d.InsecureHTTP,
+		CACertFile:    d.CACertFile,
+		SkipTLSVerify: d.SkipTLSVerify,
+		Region:        d.Region,
+		BucketLookup:  d.BucketLookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := s3.NewStorage(
+		d.S3BucketName,
+		client.ListObjects,
+		client.StatObject,
+		client.GetObjectTagging,
+		func(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+			return client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+		},
+		client.PutObject,
+		client.RemoveObject,
+		client.ComposeObject,
 	)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	err = s3.EnsureBucket(ctx, d.S3BucketName, d.Region, d.CreateBucket && !dryRun, client.BucketExists, client.MakeBucket)
+	if err == nil && !dryRun {
+		err = backup.Preflight(ctx, &s)
+	}
+
+	return &s, err
+}
+
+// run backs up a single profile to each of its destinations and reports on
+// every one of them. With several destinations the summary is the total over
+// all of them, the outcome is that of all of them together and the error is
+// that of the first one that failed, the others still ran to the end.
+//
+// Cancelling ctx stops the run, transfers is what the transfers that are in
+// flight by then run with. The reports still cover what was done and the
+// error is errInterrupted.
+func run(ctx, transfers context.Context, profile config.Profile, storages []backup.Storage, host string, reportOut *log.Logger) (_ backup.ReportSummary, profileOutcome backup.Outcome, err error) {
+	ctx, span := trace.Start(ctx, "profile", trace.Attr("profile", profile.Name), trace.Attr("dry_run", profile.DryRun))
+	defer func() {
+		span.SetAttributes(trace.Attr("outcome", profileOutcome.String()))
+		span.End(err)
+	}()
+
+	localFileProcessors := make([]backup.FileGatherer, len(profile.TargetDirs))
+	for i, targetDir := range profile.TargetDirs {
+		p := backup.NewLocalFileProcessor(targetDir, profile.Excludes...)
+		localFileProcessors[i] = metrics.TimeLocal(&p, func(d time.Duration) {
+			runMetrics.Gathered(profile.Name, "", "local", d)
+		})
+	}
+
+	tagging := newTagging(profile, host)
+
+	var mirror backup.Mirror
+	reportGenerators := make([]backup.Reporter, len(storages))
+
+	// finished waits until everything queued for a destination is done and
+	// in its report, then lets its workers go
+	finished := make([]func(), len(storages))
+
+	// recorders have what is queued for each destination with the plan
+	// command, appliers only let through what is planned with apply
+	recorders := make([]*backup.Recorder, len(storages))
+	appliers := make([]*backup.Applier, len(storages))
+
+	// sweepers remove the blobs nothing points at any more from each
+	// destination that keeps them, once it is done
+	sweepers := make([]*backup.ContentAddressedProcessor, len(storages))
+
+	// Whatever can fail is set up for every destination before anything
+	// is started, so there is nothing to stop when it does
+	remotes := make([]backup.Remote, len(storages))
+	packStores := make([]backup.PackStore, len(storages))
+
+	for i, storage := range storages {
+		// A local directory has no storage classes to pick from
+		var classes backup.StorageClasses
+		if d := profile.Destinations[i]; d.Storage != "local" {
+			classes = backup.NewStorageClasses(d.StorageClass, d.StorageClassRules...)
+		}
+
+		if profile.Dedup {
+			p, err := backup.NewContentAddressedProcessor(storage)
+			if err != nil {
+				return backup.ReportSummary{}, backup.Failed, err
+			}
+			p = p.WithStorageClasses(classes).WithTagging(tagging)
+			remotes[i] = &p
+
+			if !profile.DryRun {
+				sweepers[i] = &p
+			}
+		} else {
+			p, err := backup.NewRemoteFileProcessor(storage)
+			if err != nil {
+				return backup.ReportSummary{}, backup.Failed, err
+			}
+			p = p.WithStorageClasses(classes).WithTagging(tagging)
+			remotes[i] = &p
+		}
+
+		packStore, err := backup.NewPackStore(storage, profile.PackCompress)
+		if err != nil {
+			return backup.ReportSummary{}, backup.Failed, err
+		}
+		packStores[i] = packStore
 	}
 
-	for i := 0; i < viper.GetInt("remoteWorkerCount"); i++ {
-		if viper.GetBool("dryRun") {
-			go worker.NewDryRunActionWorker(
-				&workerWg,
-				remoteActionChan,
-				reportChan,
-			).Run()
+	for i := range storages {
+		var workerWg sync.WaitGroup
+
+		remoteActionChan := make(chan backup.RemoteAction, 20)
+
+		reportChan := make(chan backup.LogEntry)
+
+		// Every destination gets a report of its own, it has to be clear
+		// which one is which when there are several
+		out := reportOut
+		if len(storages) > 1 {
+			out = log.New(reportOut.Writer(), fmt.Sprintf("%s%s: ", reportOut.Prefix(), profile.Destinations[i].Name), reportOut.Flags())
+		}
+
+		var reportGenerator backup.Reporter
+		if profile.DryRun {
+			r := reporter.NewDryRunReporter(reportChan, out)
+			reportGenerator = &r
 		} else {
-			go worker.NewRemoteActionWorker(
-				remoteFileProcessor.Put,
-				remoteFileProcessor.Remove,
-				&workerWg,
-				remoteActionChan,
-				logger,
-			).Run()
-		}
-	}
-
-	processor := backup.NewProcessor(
-		localFileProcessors,
-		&remoteFileProcessor,
-		logger,
-		&workerWg,
-		remoteActionChan,
-	)
+			r := reporter.NewReporter(reportChan, out).WithTargetDirs(profile.TargetDirs...)
+			reportGenerator = &r
+		}
+		reportGenerators[i] = reportGenerator
+
+		destination := profile.Destinations[i].Name
+
+		// What is done for a destination is traced in a span of its own,
+		// the transfers run with a context of their own
+		dctx, dspan := trace.Start(ctx, "destination", trace.Attr("destination", destination))
+		dtransfers := trace.Into(transfers, dctx)
+
+		reported := make(chan struct{})
+		go func() {
+			reportGenerator.Run()
+			close(reported)
+		}()
+
+		finished[i] = func() {
+			workerWg.Wait()
+			close(remoteActionChan)
+			close(reportChan)
+			<-reported
+			dspan.End(nil)
+		}
+		logger := logger.NewLogger(logs, reportChan, &workerWg).WithObserver(func(e backup.LogEntry) {
+			runMetrics.Observe(profile.Name, destination, e)
+			runHistory.Observe(profile.Name, destination, e)
+		})
+
+		remote := remotes[i]
+		packStore := packStores[i]
+
+		for i := 0; i < profile.RemoteWorkerCount; i++ {
+			if profile.DryRun {
+				go worker.NewDryRunActionWorker(
+					&workerWg,
+					remoteActionChan,
+					reportChan,
+				).Run(ctx)
+			} else {
+				go worker.NewRemoteActionWorker(
+					remote.Put,
+					remote.Remove,
+					remote.Copy,
+					packStore.Pack,
+					packStore.RemovePack,
+					&workerWg,
+					remoteActionChan,
+					logger,
+				).Run(dctx, dtransfers)
+			}
+		}
+
+		processor := backup.NewProcessor(
+			localFileProcessors,
+			metrics.TimeRemote(remote, func(d time.Duration) {
+				runMetrics.Gathered(profile.Name, destination, "remote", d)
+			}),
+			profile.GatherWorkerCount,
+			profile.DetectMoves,
+			logger,
+			&workerWg,
+			remoteActionChan,
+		)
+
+		processor = processor.WithDestination(destination)
+
+		if planning != nil {
+			recorders[i] = &backup.Recorder{}
+			processor = processor.WithRecorder(recorders[i])
+		}
+
+		if applying != nil {
+			appliers[i] = backup.NewApplier(planned(profile.Name, destination))
+			processor = processor.WithPlan(appliers[i])
+		}
+
+		if profile.PackThreshold > 0 {
+			processor = processor.WithPacks(&packStore, profile.PackThreshold, profile.PackMaxSize)
+		}
+
+		mirror = append(mirror, processor)
+	}
+
+	errs := mirror.Process(ctx)
+
+	// Stopping isn't a failure of the destination, what it got done is
+	// still reported
+	for i, err := range errs {
+		if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+			errs[i] = errInterrupted
+		}
+	}
+
+	// Only a destination that was compared to the end has a plan
+	for i, d := range profile.Destinations {
+		if planning != nil && errs[i] == nil {
+			planning.Add(profile.Name, d.Name, recorders[i].Actions())
+		}
+
+		if applying != nil && appliers[i].Ignored() > 0 {
+			logs.Warn("left alone what isn't in the plan",
+				logger.KV("profile", profile.Name), logger.KV("destination", d.Name), logger.KV("actions", appliers[i].Ignored()),
+			)
+		}
+	}
+
+	// Only a destination that got everything done is swept, and only when
+	// the run could have left a blob behind
+	sweep := func(i int, summary backup.ReportSummary) {
+		if sweepers[i] == nil || errs[i] != nil || summary.Pushed+summary.Removed == 0 {
+			return
+		}
+
+		destination := profile.Destinations[i].Name
+
+		removed, err := sweepers[i].Sweep(ctx)
+		if err != nil {
+			logs.Error("unable to remove unused blobs",
+				logger.KV("profile", profile.Name), logger.KV("destination", destination), logger.KV("err", err),
+			)
+		} else if removed > 0 {
+			logs.Info("removed unused blobs",
+				logger.KV("profile", profile.Name), logger.KV("destination", destination), logger.KV("blobs", removed),
+			)
+		}
+	}
+
+	if len(storages) == 1 {
+		// Whatever was already queued still finishes before the run
+		// fails, there is just no report for it
+		finished[0]()
+
+		if errs[0] != nil && errs[0] != errInterrupted {
+			report(profile.Name, nil, backup.Failed, errs[0])
+			return backup.ReportSummary{}, backup.Failed, errs[0]
+		}
+
+		summary := reportGenerators[0].Summary()
+		sweep(0, summary)
+
+		o := outcome(summary, errs[0])
+		report(profile.Name, reportGenerators[0], o, errs[0])
+
+		return summary, o, errs[0]
+	}
+
+	var total backup.ReportSummary
+	var firstErr error
+	interrupted := false
+	outcomes := make([]backup.Outcome, len(storages))
+
+	combined := reporter.NewCombinedReporter(reportOut, "destination")
+
+	for i, d := range profile.Destinations {
+		// Whatever was already queued for a failed destination still
+		// finishes, there is just no report for it
+		finished[i]()
+
+		if errs[i] == errInterrupted {
+			interrupted = true
+		} else if errs[i] != nil {
+			logs.Error("destination failed", logger.KV("destination", d.Name), logger.KV("err", errs[i]))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("destination '%s': %s", d.Name, errs[i])
+			}
+
+			combined.Add(d.Name, backup.ReportSummary{}, errs[i])
+			report(reportName(profile.Name, d.Name), nil, backup.Failed, errs[i])
+			outcomes[i] = backup.Failed
+			continue
+		}
+
+		summary := reportGenerators[i].Summary()
+		sweep(i, summary)
+
+		total = total.Add(summary)
+		combined.Add(d.Name, summary, errs[i])
+		outcomes[i] = outcome(summary, errs[i])
+		report(reportName(profile.Name, d.Name), reportGenerators[i], outcomes[i], errs[i])
+	}
+
+	if runReport == nil {
+		combined.Print()
+	}
+
+	// Any failures are logged above, stopping is what matters now
+	if interrupted {
+		return total, backup.CombineOutcomes(outcomes...), errInterrupted
+	}
+
+	return total, backup.CombineOutcomes(outcomes...), firstErr
+}
+
+// runReport has the report of every profile and destination with
+// --reportFormat html, without it the reports are printed as they are done
+var runReport *reporter.HTMLReport
+
+// report prints the report r of a profile or destination, with --reportFormat
+// html it is kept for the page instead. One that never got a report only
+// shows up on the page, with its error.
+func report(name string, r backup.Reporter, o backup.Outcome, err error) {
+	if runReport != nil {
+		runReport.Add(name, r, o, err)
+	} else if r != nil {
+		r.Print()
+	}
+}
+
+// reportName is what the report of a destination of profile is called on
+// the page, a profile run from flags alone has no name
+func reportName(profile, destination string) string {
+	if profile == "" {
+		return destination
+	}
+
+	return profile + " - " + destination
+}
+
+// writeReport writes the page to --reportFile with --reportFormat html. Not
+// being able to doesn't change how the run went.
+func writeReport() {
+	if runReport == nil {
+		return
+	}
+
+	path := viper.GetString("reportFile")
+	if err := runReport.WriteFile(path); err != nil {
+		logs.Error("unable to write the report", logger.KV("path", path), logger.KV("err", err))
+	} else {
+		logs.Info("wrote the report", logger.KV("path", path))
+	}
+}
+
+// planning is what the plan command plans, nil for any other command
+var planning *backup.Plan
+
+// writePlan writes the plan to --out with the plan command. A plan is only
+// written if every destination was compared to the end, one that is missing
+// some of them would have apply leave them alone.
+func writePlan(failed bool) {
+	if planning == nil {
+		return
+	}
+
+	path := viper.GetString("out")
+	if failed {
+		logs.Error("not writing the plan, not everything could be planned", logger.KV("path", path))
+		return
+	}
+
+	if err := planning.WriteFile(path); err != nil {
+		logs.Error("unable to write the plan", logger.KV("path", path), logger.KV("err", err))
+		return
+	}
+
+	logs.Info("wrote the plan", logger.KV("path", path))
+}
+
+// applying is the plan that apply applies, nil for any other command
+var applying *backup.Plan
+
+// readPlan reads the plan of apply <file>. The files in a plan are local
+// ones, it can only be applied on the host that it was made on.
+func readPlan(args []string, host string) (backup.Plan, error) {
+	if len(args) != 1 {
+		return backup.Plan{}, errors.New("it is apply <plan file>")
+	}
 
-	err = processor.Process()
+	plan, err := backup.ReadPlan(args[0])
 	if err != nil {
-		panic(err)
+		return backup.Plan{}, err
+	}
+
+	if plan.Host != host {
+		return backup.Plan{}, fmt.Errorf("the plan was made on '%s', it can only be applied there", plan.Host)
+	}
+
+	return plan, nil
+}
+
+// checkPlan makes sure that every destination in the plan for profile is
+// still one of its destinations
+func checkPlan(plan backup.Plan, profile config.Profile) error {
+	destinations, _ := plan.Destinations(profile.Name)
+
+	for _, p := range destinations {
+		found := false
+		for _, d := range profile.Destinations {
+			found = found || d.Name == p.Name
+		}
+
+		if !found {
+			return fmt.Errorf("the plan has destination '%s', the profile doesn't", p.Name)
+		}
+	}
+
+	return nil
+}
+
+// planned is what the plan that is applied has for destination of profile,
+// nothing at all if it isn't in the plan
+func planned(profile, destination string) []backup.RemoteAction {
+	destinations, _ := applying.Destinations(profile)
+	for _, d := range destinations {
+		if d.Name == destination {
+			return d.Actions
+		}
+	}
+
+	return nil
+}
+
+// outcome is how a destination went that has a report
+func outcome(summary backup.ReportSummary, err error) backup.Outcome {
+	if err == errInterrupted {
+		return backup.Aborted
+	}
+
+	return summary.Outcome()
+}
+
+// runID is the ID of a run that started at t
+func runID(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// newTagging is what every object of a run of profile is tagged with, the
+// run ID is when the run started
+func newTagging(profile config.Profile, host string) backup.Tagging {
+	return backup.Tagging{
+		Owned:    profile.TagObjects,
+		Host:     host,
+		Profile:  profile.Name,
+		RunID:    runID(time.Now()),
+		Sources:  profile.TargetDirs,
+		Tags:     profile.Tags,
+		Metadata: profile.Metadata,
+	}
+}
+
+// readConfig reads the config file named by --config or, failing that, the
+// first s3-personal-backup.yaml/.toml found in the usual places. Not having
+// a config file at all is fine, everything can still be given as flags.
+func readConfig() error {
+	if file := viper.GetString("config"); file != "" {
+		viper.SetConfigFile(file)
+		return viper.ReadInConfig()
+	}
+
+	viper.SetConfigName("s3-personal-backup")
+	viper.AddConfigPath("$HOME/.config/s3-personal-backup")
+	viper.AddConfigPath(".")
+
+	err := viper.ReadInConfig()
+	if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+		return nil
+	}
+
+	return err
+}
+
+// overridden is whether a setting was given as a flag or env variable,
+// those win over anything in a profile
+func overridden(key string) bool {
+	if flag.CommandLine.Changed(key) {
+		return true
 	}
 
-	workerWg.Wait()
-	reportGenerator.Print()
+	_, ok := os.LookupEnv("PERSONAL_BACKUP_" + strings.ToUpper(key))
+	return ok
 }
 
 func processVars() {
+	flag.String("config", "", "Config file with settings and named profiles, YAML or TOML.")
+	flag.String("profile", "", "Profile from the config file to run.")
+	flag.Bool("all", false, "Run every profile in the config file, one after the other.")
+	flag.String("lockFile", filepath.Join(os.TempDir(), "s3-personal-backup.lock"), "File that keeps two runs from backing up at the same time.")
+	flag.Bool("remoteLock", false, "Also lock every destination, for runs from several machines.")
+	flag.Duration("lockExpiry", 24*time.Hour, "Age at which a lock is taken to be left behind and taken over, 0 never.")
+	flag.Bool("force", false, "Have unlock remove locks that are still held.")
+	flag.String("out", "plan.json", "File that the plan command writes the plan to.")
+	flag.String("logLevel", "info", "Lowest level that is logged, debug, info, warn or error.")
+	flag.String("logFormat", logger.TextFormat, "How to log, text or json with an object per line.")
+	flag.String("logFile", "", "File to log to instead of stdout, the report is still printed to stdout.")
+	flag.String("reportFormat", reporter.TextFormat, "How to report, text printed to stdout or html written to --reportFile.")
+	flag.String("reportFile", "backup-report.html", "File the html report is written to, it replaces the one of the last run.")
+	flag.Int64("logMaxSize", 10, "Size in MB at which the log file is rotated, 0 never.")
+	flag.Int("logMaxFiles", 5, "Number of rotated log files that are kept.")
+	flag.Bool("quiet", false, "Only log warnings and errors.")
+	flag.Bool("verbose", false, "Log everything, down to debug.")
+	flag.String("metricsListen", "", "Address to serve Prometheus metrics on while running, like :9100.")
+	flag.String("metricsTextfile", "", "File to write Prometheus metrics to at the end, for the node_exporter textfile collector.")
+	flag.String("historyDir", defaultHistoryDir(), "Directory that every run is kept in, for the history command.")
+	flag.Bool("historyUpload", false, "Also keep every run in _meta/runs/ on each destination.")
+	flag.Int("historyLimit", 20, "Number of runs that the history command lists, 0 all of them.")
+	flag.String("smtpHost", "", "SMTP server to send notification mail through, as host:port.")
+	flag.String("smtpUsername", "", "User to log in to the SMTP server as, without one mail is sent without logging in.")
+	flag.String("smtpPassword", "", "Password for the SMTP server.")
+	flag.String("smtpFrom", "", "Address that notification mail is sent from.")
+	flag.String("traceFile", "", "File to append a trace of every run to, as OTLP JSON.")
+	flag.String("traceEndpoint", "", "OTLP/HTTP endpoint to send a trace of every run to, like http://localhost:4318.")
+	flag.Duration("shutdownTimeout", 30*time.Second, "How long transfers in flight get to finish once a run is stopped with SIGINT or SIGTERM.")
 	flag.String("targetDirs", "", "Local directories  to back up.")
+	flag.String("excludes", "", "Comma separated patterns of files and directories not to back up.")
+	flag.String("storage", "s3", "Where to back up to: s3 or local.")
+	flag.String("storageDir", "", "Directory to back up to, for local storage.")
 	flag.String("s3Host", "", "S3 host.")
 	flag.String("s3AccessKey", "", "S3 access key.")
 	flag.String("s3SecretKey", "", "S3 secret key.")
+	flag.String("s3SecretKeyFile", "", "File holding the S3 secret key, instead of giving the key itself.")
 	flag.String("s3BucketName", "", "S3 Bucket Name.")
+	flag.Bool("createBucket", false, "Create the S3 bucket, in --region, if it doesn't exist yet.")
+	flag.String("storageClass", "", "S3 storage class to upload files with, the bucket default when not given.")
+	flag.String("credentials", "static", "Where the S3 keys come from: static, file, process or chain.")
+	flag.String("credentialsFile", "", "AWS style shared credentials file, for the file and chain credentials.")
+	flag.String("credentialsProfile", "", "Profile in the shared credentials file.")
+	flag.String("credentialProcess", "", "Command that prints the S3 keys as JSON, for the process credentials.")
+	flag.Bool("insecureHTTP", false, "Talk plain HTTP to the S3 host.")
+	flag.String("caCertFile", "", "PEM file of CA certificates to trust on top of the system ones.")
+	flag.Bool("skipTLSVerify", false, "Trust whatever certificate the S3 host has.")
+	flag.String("region", "", "Region of the S3 bucket, for hosts that don't say.")
+	flag.String("bucketLookup", "auto", "How buckets are addressed: auto, path or dns.")
 	flag.Int("remoteWorkerCount", 5, "Number of workers performing actions against S3 host.")
+	flag.Int("gatherWorkerCount", 4, "Number of local directories and remote listings gathered at the same time.")
 	flag.Bool("dryRun", false, "Flag to indicate that this should be a dry run.")
+	flag.Bool("dedup", false, "Store identical files only once, under the hash of their content.")
+	flag.Bool("detectMoves", false, "Copy moved or renamed files on the remote instead of uploading them again.")
+	flag.Int64("packThreshold", 0, "Files smaller than this many bytes are packed together into tar objects, 0 disables packing.")
+	flag.Int64("packMaxSize", 64<<20, "Largest pack to build, in bytes.")
+	flag.Bool("packCompress", false, "Gzip packs.")
+	flag.Bool("tagObjects", false, "Tag objects with the host, profile, run and target dir they were backed up from, and never remove those of other hosts or profiles.")
+	flag.String("tags", "", "Comma separated key=value tags to put on every object.")
+	flag.String("metadata", "", "Comma separated key=value user metadata to put on every object.")
 	flag.Parse()
 
+	viper.BindPFlag("config", flag.CommandLine.Lookup("config"))
+	viper.BindPFlag("profile", flag.CommandLine.Lookup("profile"))
+	viper.BindPFlag("all", flag.CommandLine.Lookup("all"))
+	viper.BindPFlag("lockFile", flag.CommandLine.Lookup("lockFile"))
+	viper.BindPFlag("remoteLock", flag.CommandLine.Lookup("remoteLock"))
+	viper.BindPFlag("lockExpiry", flag.CommandLine.Lookup("lockExpiry"))
+	viper.BindPFlag("force", flag.CommandLine.Lookup("force"))
+	viper.BindPFlag("out", flag.CommandLine.Lookup("out"))
+	viper.BindPFlag("shutdownTimeout", flag.CommandLine.Lookup("shutdownTimeout"))
+	viper.BindPFlag("historyDir", flag.CommandLine.Lookup("historyDir"))
+	viper.BindPFlag("historyUpload", flag.CommandLine.Lookup("historyUpload"))
+	viper.BindPFlag("historyLimit", flag.CommandLine.Lookup("historyLimit"))
+	viper.BindPFlag("smtpHost", flag.CommandLine.Lookup("smtpHost"))
+	viper.BindPFlag("smtpUsername", flag.CommandLine.Lookup("smtpUsername"))
+	viper.BindPFlag("smtpPassword", flag.CommandLine.Lookup("smtpPassword"))
+	viper.BindPFlag("smtpFrom", flag.CommandLine.Lookup("smtpFrom"))
+	viper.BindPFlag("traceFile", flag.CommandLine.Lookup("traceFile"))
+	viper.BindPFlag("traceEndpoint", flag.CommandLine.Lookup("traceEndpoint"))
+	viper.BindPFlag("metricsListen", flag.CommandLine.Lookup("metricsListen"))
+	viper.BindPFlag("metricsTextfile", flag.CommandLine.Lookup("metricsTextfile"))
+	viper.BindPFlag("logLevel", flag.CommandLine.Lookup("logLevel"))
+	viper.BindPFlag("logFormat", flag.CommandLine.Lookup("logFormat"))
+	viper.BindPFlag("logFile", flag.CommandLine.Lookup("logFile"))
+	viper.BindPFlag("reportFormat", flag.CommandLine.Lookup("reportFormat"))
+	viper.BindPFlag("reportFile", flag.CommandLine.Lookup("reportFile"))
+	viper.BindPFlag("logMaxSize", flag.CommandLine.Lookup("logMaxSize"))
+	viper.BindPFlag("logMaxFiles", flag.CommandLine.Lookup("logMaxFiles"))
+	viper.BindPFlag("quiet", flag.CommandLine.Lookup("quiet"))
+	viper.BindPFlag("verbose", flag.CommandLine.Lookup("verbose"))
 	viper.BindPFlag("targetDirs", flag.CommandLine.Lookup("targetDirs"))
+	viper.BindPFlag("excludes", flag.CommandLine.Lookup("excludes"))
+	viper.BindPFlag("storage", flag.CommandLine.Lookup("storage"))
+	viper.BindPFlag("storageDir", flag.CommandLine.Lookup("storageDir"))
 	viper.BindPFlag("s3Host", flag.CommandLine.Lookup("s3Host"))
 	viper.BindPFlag("s3AccessKey", flag.CommandLine.Lookup("s3AccessKey"))
 	viper.BindPFlag("s3SecretKey", flag.CommandLine.Lookup("s3SecretKey"))
 	viper.BindPFlag("s3BucketName", flag.CommandLine.Lookup("s3BucketName"))
+	viper.BindPFlag("createBucket", flag.CommandLine.Lookup("createBucket"))
+	viper.BindPFlag("storageClass", flag.CommandLine.Lookup("storageClass"))
+	viper.BindPFlag("s3SecretKeyFile", flag.CommandLine.Lookup("s3SecretKeyFile"))
+	viper.BindPFlag("credentials", flag.CommandLine.Lookup("credentials"))
+	viper.BindPFlag("credentialsFile", flag.CommandLine.Lookup("credentialsFile"))
+	viper.BindPFlag("credentialsProfile", flag.CommandLine.Lookup("credentialsProfile"))
+	viper.BindPFlag("credentialProcess", flag.CommandLine.Lookup("credentialProcess"))
+	viper.BindPFlag("insecureHTTP", flag.CommandLine.Lookup("insecureHTTP"))
+	viper.BindPFlag("caCertFile", flag.CommandLine.Lookup("caCertFile"))
+	viper.BindPFlag("skipTLSVerify", flag.CommandLine.Lookup("skipTLSVerify"))
+	viper.BindPFlag("region", flag.CommandLine.Lookup("region"))
+	viper.BindPFlag("bucketLookup", flag.CommandLine.Lookup("bucketLookup"))
 	viper.BindPFlag("remoteWorkerCount", flag.CommandLine.Lookup("remoteWorkerCount"))
+	viper.BindPFlag("gatherWorkerCount", flag.CommandLine.Lookup("gatherWorkerCount"))
 	viper.BindPFlag("dryRun", flag.CommandLine.Lookup("dryRun"))
+	viper.BindPFlag("detectMoves", flag.CommandLine.Lookup("detectMoves"))
+	viper.BindPFlag("dedup", flag.CommandLine.Lookup("dedup"))
+	viper.BindPFlag("packThreshold", flag.CommandLine.Lookup("packThreshold"))
+	viper.BindPFlag("packMaxSize", flag.CommandLine.Lookup("packMaxSize"))
+	viper.BindPFlag("packCompress", flag.CommandLine.Lookup("packCompress"))
+	viper.BindPFlag("tagObjects", flag.CommandLine.Lookup("tagObjects"))
+	viper.BindPFlag("tags", flag.CommandLine.Lookup("tags"))
+	viper.BindPFlag("metadata", flag.CommandLine.Lookup("metadata"))
 
 	viper.AutomaticEnv()
 	viper.SetEnvPrefix("PERSONAL_BACKUP")
+	viper.BindEnv("config")
+	viper.BindEnv("profile")
+	viper.BindEnv("lockFile")
+	viper.BindEnv("remoteLock")
+	viper.BindEnv("lockExpiry")
+	viper.BindEnv("shutdownTimeout")
+	viper.BindEnv("historyDir")
+	viper.BindEnv("historyUpload")
+	viper.BindEnv("historyLimit")
+	viper.BindEnv("smtpHost")
+	viper.BindEnv("smtpUsername")
+	viper.BindEnv("smtpPassword")
+	viper.BindEnv("smtpFrom")
+	viper.BindEnv("traceFile")
+	viper.BindEnv("traceEndpoint")
+	viper.BindEnv("metricsListen")
+	viper.BindEnv("metricsTextfile")
+	viper.BindEnv("logLevel")
+	viper.BindEnv("logFormat")
+	viper.BindEnv("logFile")
+	viper.BindEnv("reportFormat")
+	viper.BindEnv("reportFile")
+	viper.BindEnv("logMaxSize")
+	viper.BindEnv("logMaxFiles")
+	viper.BindEnv("quiet")
+	viper.BindEnv("verbose")
 	viper.BindEnv("targetDirs")
+	viper.BindEnv("excludes")
+	viper.BindEnv("storage")
+	viper.BindEnv("storageDir")
 	viper.BindEnv("s3Host")
 	viper.BindEnv("s3AccessKey")
 	viper.BindEnv("s3SecretKey")
 	viper.BindEnv("s3BucketName")
+	viper.BindEnv("createBucket")
+	viper.BindEnv("storageClass")
+	viper.BindEnv("s3SecretKeyFile")
+	viper.BindEnv("credentials")
+	viper.BindEnv("credentialsFile")
+	viper.BindEnv("credentialsProfile")
+	viper.BindEnv("credentialProcess")
+	viper.BindEnv("insecureHTTP")
+	viper.BindEnv("caCertFile")
+	viper.BindEnv("skipTLSVerify")
+	viper.BindEnv("region")
+	viper.BindEnv("bucketLookup")
 	viper.BindEnv("remoteWorkerCount")
+	viper.BindEnv("gatherWorkerCount")
+	viper.BindEnv("detectMoves")
+	viper.BindEnv("dedup")
+	viper.BindEnv("packThreshold")
+	viper.BindEnv("packMaxSize")
+	viper.BindEnv("packCompress")
+	viper.BindEnv("tagObjects")
+	viper.BindEnv("tags")
+	viper.BindEnv("metadata")
 
 	viper.SetDefault("remoteWorkerCount", 5)
+	viper.SetDefault("gatherWorkerCount", 4)
+	viper.SetDefault("detectMoves", false)
+	viper.SetDefault("packMaxSize", 64<<20)
 }
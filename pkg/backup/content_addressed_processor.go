@@ -0,0 +1,279 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// These are the prefixes that the content addressed layout keeps its two
+// halves under
+const (
+	blobPrefix  = "_blobs/"
+	indexPrefix = "_index"
+)
+
+// The user metadata kept on every index entry
+const (
+	blobMeta = "blob"
+	sizeMeta = "size"
+	md5Meta  = "md5"
+)
+
+// ContentAddressedProcessor stores the content of every file exactly once,
+// under the SHA-256 of that content, no matter how many paths it is found at.
+// Each path gets an empty index entry whose metadata points at its blob.
+// Removing or moving a path only ever touches its index entry.
+type ContentAddressedProcessor struct {
+	storage Storage
+	classes StorageClasses
+	tagging Tagging
+}
+
+func NewContentAddressedProcessor(s Storage) (ContentAddressedProcessor, error) {
+	if s == nil {
+		return ContentAddressedProcessor{}, errors.New("'NewContentAddressedProcessor' error: storage cannot be missing")
+	}
+
+	return ContentAddressedProcessor{
+		storage: s,
+	}, nil
+}
+
+// WithStorageClasses has every blob uploaded with the storage class that
+// classes picks for the file it was first uploaded from. Index entries are
+// always left to the bucket default, they are empty anyway.
+func (p ContentAddressedProcessor) WithStorageClasses(classes StorageClasses) ContentAddressedProcessor {
+	p.classes = classes
+	return p
+}
+
+// WithTagging has every index entry put with the tags and metadata of t,
+// and leaves alone the entries t doesn't own. Blobs are shared by every
+// path with the same content, wherever it was backed up from, so they are
+// never tagged.
+func (p ContentAddressedProcessor) WithTagging(t Tagging) ContentAddressedProcessor {
+	p.tagging = t
+	return p
+}
+
+// Gather reads the index rather than the blobs. Not every storage can list
+// metadata, any index entry that comes back without it is looked up on its
+// own. Entries listed with the tags of another host or profile are marked
+// as not owned.
+func (p *ContentAddressedProcessor) Gather(ctx context.Context, prefix string, out chan<- File) error {
+	opts := ListOptions{Metadata: true, Tags: p.tagging.Owned}
+
+	return eachObject(ctx, p.storage, indexKey(prefix), opts, func(object Object) error {
+		meta := object.Metadata
+		if meta[sizeMeta] == "" {
+			info, err := p.storage.Stat(ctx, object.Key)
+			if err != nil {
+				return err
+			}
+
+			meta = info.Metadata
+		}
+
+		size, err := strconv.ParseInt(meta[sizeMeta], 10, 64)
+		if err != nil {
+			return errors.New("'gather' error: index entry '" + object.Key + "' has no valid size")
+		}
+
+		f := newFile(strings.TrimPrefix(object.Key, indexPrefix), size)
+		f.ETag = meta[md5Meta]
+		f.NotOwned = !p.tagging.owns(object.Tags)
+
+		return sendFile(ctx, out, f)
+	})
+}
+
+// Put only uploads the content of f if no other path has uploaded it
+// already, either way it then points the index entry for f at it. An index
+// entry that is owned by another host or profile is left alone.
+func (p *ContentAddressedProcessor) Put(ctx context.Context, f string) (result PutResult, err error) {
+	if f == "" {
+		err = errors.New("'put' error: target file cannot be missing")
+		return
+	}
+
+	if err = p.tagging.checkOwned(ctx, p.storage, indexKey(f)); err != nil {
+		return
+	}
+
+	sha, md5sum, size, info, err := hashFile(f)
+	if err != nil {
+		return
+	}
+
+	blob := blobPrefix + sha
+
+	_, err = p.storage.Stat(ctx, blob)
+	if err == nil {
+		result.Deduplicated = true
+	} else if !errors.Is(err, ErrNotFound) {
+		return
+	} else {
+		result.StorageClass = p.classes.For(f, info)
+
+		err = p.putBlob(ctx, blob, sha, f, PutOptions{StorageClass: result.StorageClass})
+		if err != nil {
+			return
+		}
+	}
+
+	err = p.storage.Put(ctx, indexKey(f), bytes.NewReader(nil), 0, p.tagging.options(f, PutOptions{
+		Metadata: map[string]string{
+			blobMeta: sha,
+			sizeMeta: strconv.FormatInt(size, 10),
+			md5Meta:  md5sum,
+		},
+	}))
+	return
+}
+
+// putBlob uploads the file at path as blob, which is named after sha. What
+// is uploaded is hashed on the way, a file that changed since it was hashed
+// would otherwise leave other content under the name. Such a blob is
+// removed again and nothing points at it.
+func (p *ContentAddressedProcessor) putBlob(ctx context.Context, blob, sha, path string, opts PutOptions) error {
+	uploaded := sha256.New()
+	if err := putFileThrough(ctx, p.storage, blob, path, opts, uploaded); err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(uploaded.Sum(nil)) == sha {
+		return nil
+	}
+
+	if err := p.storage.Remove(ctx, blob); err != nil {
+		return fmt.Errorf("'put' error: '%s' changed while it was being uploaded and removing it failed, err: %s", path, err)
+	}
+
+	return fmt.Errorf("'put' error: '%s' changed while it was being uploaded", path)
+}
+
+// Remove only removes the index entry, the blob may still be in use by other
+// paths. Blobs that nothing points at any more are left for Sweep.
+func (p *ContentAddressedProcessor) Remove(ctx context.Context, f string) error {
+	if err := p.tagging.checkOwned(ctx, p.storage, indexKey(f)); err != nil {
+		return err
+	}
+
+	return p.storage.Remove(ctx, indexKey(f))
+}
+
+// Copy copies the index entry, metadata and all, so that dst points at the
+// same blob as src
+func (p *ContentAddressedProcessor) Copy(ctx context.Context, src, dst string) error {
+	return p.storage.Copy(ctx, indexKey(src), indexKey(dst))
+}
+
+// Sweep removes the blobs that no index entry points at any more, and says
+// how many it removed. Entries of every host and profile count, blobs are
+// shared by all of them. The blobs are listed before the index, so a blob
+// that is uploaded meanwhile already has its entry by the time the index
+// is listed.
+func (p *ContentAddressedProcessor) Sweep(ctx context.Context) (int, error) {
+	unused := make(map[[sha256.Size]byte]string)
+
+	err := eachObject(ctx, p.storage, blobPrefix, ListOptions{}, func(object Object) error {
+		if sum, ok := blobSum(strings.TrimPrefix(object.Key, blobPrefix)); ok {
+			unused[sum] = object.Key
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = eachObject(ctx, p.storage, indexPrefix, ListOptions{Metadata: true}, func(object Object) error {
+		meta := object.Metadata
+		if meta[blobMeta] == "" {
+			info, err := p.storage.Stat(ctx, object.Key)
+			if err != nil {
+				return err
+			}
+
+			meta = info.Metadata
+		}
+
+		sum, ok := blobSum(meta[blobMeta])
+		if !ok {
+			return errors.New("'sweep' error: index entry '" + object.Key + "' has no valid blob")
+		}
+
+		delete(unused, sum)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	keys := make([]string, 0, len(unused))
+	for _, key := range unused {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if err := p.storage.Remove(ctx, key); err != nil {
+			return i, err
+		}
+	}
+
+	return len(keys), nil
+}
+
+// blobSum is the SHA-256 that a blob is named after, it is kept as bytes
+// since there is one for every blob in the bucket
+func blobSum(name string) (sum [sha256.Size]byte, ok bool) {
+	b, err := hex.DecodeString(name)
+	if err != nil || len(b) != sha256.Size {
+		return sum, false
+	}
+
+	copy(sum[:], b)
+	return sum, true
+}
+
+func indexKey(f string) string {
+	return indexPrefix + f
+}
+
+// hashFile reads the file once for both hashes. The SHA-256 names the blob
+// and the MD5 is what move detection compares ETags against. The size is
+// what was hashed, info is whatever the file was when it was opened.
+func hashFile(path string) (sha, md5sum string, size int64, info os.FileInfo, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	info, err = f.Stat()
+	if err != nil {
+		return
+	}
+
+	shaHash := sha256.New()
+	md5Hash := md5.New()
+
+	size, err = io.Copy(io.MultiWriter(shaHash, md5Hash), f)
+	if err != nil {
+		return
+	}
+
+	return hex.EncodeToString(shaHash.Sum(nil)), hex.EncodeToString(md5Hash.Sum(nil)), size, info, nil
+}
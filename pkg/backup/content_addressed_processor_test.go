@@ -0,0 +1,552 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestContentAddressedProcessorTestSuite(t *testing.T) {
+	suite.Run(t, new(ContentAddressedProcessorTestSuite))
+}
+
+type ContentAddressedProcessorTestSuite struct {
+	suite.Suite
+	storage *testStorage
+
+	file string
+}
+
+// The hashes of 'hello'
+const (
+	helloSha = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	helloMd5 = "5d41402abc4b2a76b9719d911017c592"
+)
+
+func (s *ContentAddressedProcessorTestSuite) SetupTest() {
+	s.storage = newTestStorage()
+
+	tmpFile, err := ioutil.TempFile("", "contentAddressed")
+	s.Require().NoError(err)
+	tmpFile.WriteString("hello")
+	tmpFile.Close()
+	s.file = tmpFile.Name()
+}
+
+func (s *ContentAddressedProcessorTestSuite) TearDownTest() {
+	os.Remove(s.file)
+}
+
+func (s *ContentAddressedProcessorTestSuite) processor() ContentAddressedProcessor {
+	p, err := NewContentAddressedProcessor(s.storage)
+	s.Require().NoError(err)
+
+	return p
+}
+
+func (s *ContentAddressedProcessorTestSuite) gather(prefix string) ([]File, error) {
+	out := make(chan File, 10)
+	p := s.processor()
+	err := p.Gather(context.Background(), prefix, out)
+	close(out)
+
+	files := make([]File, 0)
+	for f := range out {
+		files = append(files, f)
+	}
+
+	return files, err
+}
+
+// putting has blob and index puts go to their own funcs. A blob is read
+// in full, like any storage would.
+func (s *ContentAddressedProcessorTestSuite) putting(
+	blob func(string, io.Reader, int64) error,
+	index func(string, int64, PutOptions) error,
+) {
+	s.storage.put = func(_ context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+		if strings.HasPrefix(key, blobPrefix) {
+			body, err := ioutil.ReadAll(r)
+			s.Require().NoError(err)
+
+			return blob(key, bytes.NewReader(body), size)
+		}
+
+		return index(key, size, opts)
+	}
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_New_ErrorMissingStorage() {
+	_, err := NewContentAddressedProcessor(nil)
+
+	s.Equal(errors.New("'NewContentAddressedProcessor' error: storage cannot be missing"), err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_ListsIndexUnderPrefix() {
+	called := false
+	s.storage.list = func(_ context.Context, prefix string, opts ListOptions, _ chan<- Object) error {
+		s.Equal("_index/home/user/", prefix)
+		s.True(opts.Metadata)
+		called = true
+		return nil
+	}
+
+	_, err := s.gather("/home/user/")
+
+	s.NoError(err)
+	s.True(called)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_ReadsSizeFromListing() {
+	s.storage.listing(Object{
+		Key:      "_index/home/user/file",
+		Metadata: map[string]string{"blob": helloSha, "size": "5", "md5": helloMd5},
+	})
+
+	files, err := s.gather("/home/user/")
+
+	s.Require().NoError(err)
+	s.Equal([]File{{Name: "/home/user/file", Size: 5, ETag: helloMd5}}, files)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_StatsEntriesListedWithoutMetadata() {
+	s.storage.listing(Object{Key: "_index/home/user/file"})
+	s.storage.stat = func(_ context.Context, key string) (Object, error) {
+		s.Equal("_index/home/user/file", key)
+		return Object{
+			Metadata: map[string]string{"blob": helloSha, "size": "5", "md5": helloMd5},
+		}, nil
+	}
+
+	files, err := s.gather("/home/user/")
+
+	s.Require().NoError(err)
+	s.Equal([]File{{Name: "/home/user/file", Size: 5, ETag: helloMd5}}, files)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_ReturnsStatError() {
+	expectedErr := errors.New("asplode")
+	s.storage.listing(Object{Key: "_index/home/user/file"})
+	s.storage.stat = func(context.Context, string) (Object, error) {
+		return Object{}, expectedErr
+	}
+
+	_, err := s.gather("/home/user/")
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_ErrorsOnBadSize() {
+	s.storage.listing(Object{
+		Key:      "_index/home/user/file",
+		Metadata: map[string]string{"size": "lots"},
+	})
+
+	_, err := s.gather("/home/user/")
+
+	s.EqualError(err, "'gather' error: index entry '_index/home/user/file' has no valid size")
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_ReturnsListError() {
+	expectedErr := errors.New("asplode")
+	s.storage.list = func(context.Context, string, ListOptions, chan<- Object) error {
+		return expectedErr
+	}
+
+	_, err := s.gather("/home/user/")
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_StopsWhenCancelled() {
+	s.storage.listing(Object{
+		Key:      "_index/home/user/file",
+		Metadata: map[string]string{"size": "5"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p := s.processor()
+	err := p.Gather(ctx, "/home/user/", make(chan File))
+
+	s.Equal(context.Canceled, err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_UploadsNewContent() {
+	var blobUploaded string
+	indexed := false
+
+	s.putting(
+		func(key string, r io.Reader, size int64) error {
+			body, err := ioutil.ReadAll(r)
+			s.Require().NoError(err)
+			s.Equal("hello", string(body))
+			s.Equal(int64(5), size)
+
+			blobUploaded = key
+			return nil
+		},
+		func(key string, size int64, opts PutOptions) error {
+			s.Equal(indexKey(s.file), key)
+			s.Equal(int64(0), size)
+			s.Equal(map[string]string{"blob": helloSha, "size": "5", "md5": helloMd5}, opts.Metadata)
+
+			indexed = true
+			return nil
+		},
+	)
+
+	p := s.processor()
+	result, err := p.Put(context.Background(), s.file)
+
+	s.Require().NoError(err)
+	s.Equal(PutResult{}, result)
+	s.Equal("_blobs/"+helloSha, blobUploaded)
+	s.True(indexed)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_UsesStorageClassForBlob() {
+	s.storage.put = func(_ context.Context, key string, r io.Reader, _ int64, opts PutOptions) error {
+		if strings.HasPrefix(key, blobPrefix) {
+			ioutil.ReadAll(r)
+			s.Equal("DEEP_ARCHIVE", opts.StorageClass)
+		} else {
+			s.Equal("", opts.StorageClass)
+		}
+
+		return nil
+	}
+
+	p := s.processor().WithStorageClasses(NewStorageClasses("", StorageClassRule{MinSize: 5, Class: "DEEP_ARCHIVE"}))
+	result, err := p.Put(context.Background(), s.file)
+
+	s.Require().NoError(err)
+	s.Equal(PutResult{StorageClass: "DEEP_ARCHIVE"}, result)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_TagsOnlyIndexEntry() {
+	s.storage.put = func(_ context.Context, key string, r io.Reader, _ int64, opts PutOptions) error {
+		if strings.HasPrefix(key, blobPrefix) {
+			ioutil.ReadAll(r)
+			s.Nil(opts.Tags)
+			s.Nil(opts.Metadata)
+		} else {
+			s.Equal("home", opts.Tags[ProfileTag])
+			s.Equal(map[string]string{"owner": "me", "blob": helloSha, "size": "5", "md5": helloMd5}, opts.Metadata)
+		}
+
+		return nil
+	}
+
+	tagging := ownedTagging()
+	tagging.Metadata = map[string]string{"owner": "me", "blob": "overridden"}
+
+	p := s.processor().WithTagging(tagging)
+	_, err := p.Put(context.Background(), s.file)
+
+	s.Require().NoError(err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Gather_MarksWhatItDoesNotOwn() {
+	s.storage.listing(
+		Object{
+			Key:      "_index/home/user/a",
+			Metadata: map[string]string{"size": "5"},
+			Tags:     map[string]string{HostTag: "desktop"},
+		},
+		Object{
+			Key:      "_index/home/user/b",
+			Metadata: map[string]string{"size": "5"},
+			Tags:     map[string]string{HostTag: "laptop"},
+		},
+	)
+
+	out := make(chan File, 10)
+	p := s.processor().WithTagging(ownedTagging())
+	err := p.Gather(context.Background(), "/home/user/", out)
+	close(out)
+
+	s.Require().NoError(err)
+	s.Equal(File{Name: "/home/user/a", Size: 5, NotOwned: true}, <-out)
+	s.Equal(newFile("/home/user/b", 5), <-out)
+	s.Empty(out)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_SkipsContentAlreadyStored() {
+	s.storage.stat = func(_ context.Context, key string) (Object, error) {
+		s.Equal("_blobs/"+helloSha, key)
+		return Object{}, nil
+	}
+
+	indexed := false
+	s.putting(
+		func(string, io.Reader, int64) error {
+			s.Fail("the blob should not be uploaded again")
+			return nil
+		},
+		func(string, int64, PutOptions) error {
+			indexed = true
+			return nil
+		},
+	)
+
+	p := s.processor()
+	result, err := p.Put(context.Background(), s.file)
+
+	s.Require().NoError(err)
+	s.Equal(PutResult{Deduplicated: true}, result)
+	s.True(indexed)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_RemovesBlobThatChangedWhileUploading() {
+	// The file changes after it was hashed, before it is uploaded
+	s.storage.stat = func(context.Context, string) (Object, error) {
+		s.Require().NoError(ioutil.WriteFile(s.file, []byte("world"), 0644))
+		return Object{}, ErrNotFound
+	}
+
+	var uploaded string
+	s.putting(
+		func(key string, r io.Reader, _ int64) error {
+			body, err := ioutil.ReadAll(r)
+			uploaded = string(body)
+			return err
+		},
+		func(string, int64, PutOptions) error {
+			s.Fail("nothing may point at the blob")
+			return nil
+		},
+	)
+
+	var removed string
+	s.storage.remove = func(_ context.Context, key string) error {
+		removed = key
+		return nil
+	}
+
+	p := s.processor()
+	_, err := p.Put(context.Background(), s.file)
+
+	s.EqualError(err, "'put' error: '"+s.file+"' changed while it was being uploaded")
+	s.Equal("world", uploaded)
+	s.Equal("_blobs/"+helloSha, removed)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_ReturnsStatError() {
+	expectedErr := errors.New("asplode")
+	s.storage.stat = func(context.Context, string) (Object, error) {
+		return Object{}, expectedErr
+	}
+
+	p := s.processor()
+	_, err := p.Put(context.Background(), s.file)
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_ReturnsUploadError() {
+	expectedErr := errors.New("asplode")
+	s.putting(
+		func(string, io.Reader, int64) error {
+			return expectedErr
+		},
+		func(string, int64, PutOptions) error {
+			s.Fail("nothing should point at a blob that failed to upload")
+			return nil
+		},
+	)
+
+	p := s.processor()
+	_, err := p.Put(context.Background(), s.file)
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_ReturnsIndexError() {
+	expectedErr := errors.New("asplode")
+	s.putting(
+		func(string, io.Reader, int64) error {
+			return nil
+		},
+		func(string, int64, PutOptions) error {
+			return expectedErr
+		},
+	)
+
+	p := s.processor()
+	_, err := p.Put(context.Background(), s.file)
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_ReturnsErrorIfFileIsMissing() {
+	p := s.processor()
+
+	_, err := p.Put(context.Background(), "")
+	s.EqualError(err, "'put' error: target file cannot be missing")
+
+	_, err = p.Put(context.Background(), "/does/not/exist")
+	s.True(os.IsNotExist(err))
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_ReturnsReadError() {
+	dir, err := ioutil.TempDir("", "contentAddressed")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	p := s.processor()
+	_, err = p.Put(context.Background(), dir)
+
+	s.Error(err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Remove_OnlyRemovesIndexEntry() {
+	called := false
+	s.storage.remove = func(_ context.Context, key string) error {
+		s.Equal("_index/home/user/file", key)
+		called = true
+		return nil
+	}
+
+	p := s.processor()
+	err := p.Remove(context.Background(), "/home/user/file")
+
+	s.NoError(err)
+	s.True(called)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Remove_LeavesWhatItDoesNotOwn() {
+	s.storage.tags = func(_ context.Context, key string) (map[string]string, error) {
+		s.Equal("_index/home/user/file", key)
+		return map[string]string{ProfileTag: "work"}, nil
+	}
+	s.storage.remove = func(context.Context, string) error {
+		s.Fail("removed an index entry owned by another profile")
+		return nil
+	}
+
+	p := s.processor().WithTagging(ownedTagging())
+
+	s.Equal(ErrNotOwned, p.Remove(context.Background(), "/home/user/file"))
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Put_LeavesWhatItDoesNotOwn() {
+	s.storage.tags = func(_ context.Context, key string) (map[string]string, error) {
+		s.Equal("_index"+s.file, key)
+		return map[string]string{HostTag: "desktop"}, nil
+	}
+	s.storage.put = func(context.Context, string, io.Reader, int64, PutOptions) error {
+		s.Fail("overwrote an index entry owned by another host")
+		return nil
+	}
+
+	p := s.processor().WithTagging(ownedTagging())
+
+	_, err := p.Put(context.Background(), s.file)
+	s.Equal(ErrNotOwned, err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Copy_CopiesIndexEntry() {
+	called := false
+	s.storage.copy = func(_ context.Context, src, dst string) error {
+		s.Equal("_index/home/user/old", src)
+		s.Equal("_index/home/user/new", dst)
+		called = true
+		return nil
+	}
+
+	p := s.processor()
+	err := p.Copy(context.Background(), "/home/user/old", "/home/user/new")
+
+	s.NoError(err)
+	s.True(called)
+}
+
+// sweepListing has the storage list blobs and index entries by prefix
+func (s *ContentAddressedProcessorTestSuite) sweepListing(blobs []Object, index []Object) {
+	s.storage.list = func(ctx context.Context, prefix string, _ ListOptions, out chan<- Object) error {
+		objects := index
+		if prefix == "_blobs/" {
+			objects = blobs
+		}
+
+		for _, o := range objects {
+			if err := SendObject(ctx, out, o); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Sweep_RemovesUnreferencedBlobs() {
+	used := strings.Repeat("a", 64)
+	stated := strings.Repeat("b", 64)
+	unused := strings.Repeat("c", 64)
+
+	s.sweepListing(
+		[]Object{{Key: "_blobs/" + used}, {Key: "_blobs/" + stated}, {Key: "_blobs/" + unused}, {Key: "_blobs/junk"}},
+		[]Object{
+			{Key: "_index/home/user/a", Metadata: map[string]string{"blob": used}},
+			{Key: "_index/home/user/b"},
+		},
+	)
+	s.storage.stat = func(_ context.Context, key string) (Object, error) {
+		s.Equal("_index/home/user/b", key)
+		return Object{Key: key, Metadata: map[string]string{"blob": stated}}, nil
+	}
+
+	var removed []string
+	s.storage.remove = func(_ context.Context, key string) error {
+		removed = append(removed, key)
+		return nil
+	}
+
+	p := s.processor()
+	n, err := p.Sweep(context.Background())
+
+	s.NoError(err)
+	s.Equal(1, n)
+	s.Equal([]string{"_blobs/" + unused}, removed)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Sweep_ErrorsOnIndexEntryWithoutBlob() {
+	s.sweepListing(
+		[]Object{{Key: "_blobs/" + strings.Repeat("a", 64)}},
+		[]Object{{Key: "_index/home/user/a", Metadata: map[string]string{"size": "5"}}},
+	)
+	s.storage.stat = func(_ context.Context, key string) (Object, error) {
+		return Object{Key: key}, nil
+	}
+	s.storage.remove = func(context.Context, string) error {
+		s.Fail("removed a blob without knowing what is referenced")
+		return nil
+	}
+
+	p := s.processor()
+	_, err := p.Sweep(context.Background())
+
+	s.Error(err)
+}
+
+func (s *ContentAddressedProcessorTestSuite) Test_Sweep_ReturnsRemoveError() {
+	s.sweepListing([]Object{{Key: "_blobs/" + strings.Repeat("a", 64)}}, nil)
+	s.storage.remove = func(context.Context, string) error {
+		return errors.New("failed")
+	}
+
+	p := s.processor()
+	n, err := p.Sweep(context.Background())
+
+	s.Error(err)
+	s.Equal(0, n)
+}
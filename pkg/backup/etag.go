@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// These mirror minio-go, files at or over minPartSize are uploaded in parts
+// and the part size grows in steps of minPartSize to stay within maxParts
+const (
+	minPartSize = 16 << 20
+	maxParts    = 10000
+)
+
+// matchesETag reports whether the local file at path has the content that
+// S3 computed etag for. That is a plain MD5 for objects uploaded in one go
+// and an MD5 of the part MD5s, suffixed with the part count, for multipart
+// uploads. Any other ETag (server side encryption for example) never matches.
+func matchesETag(path string, size int64, etag string) (bool, error) {
+	etag = strings.Trim(etag, `"`)
+
+	sum, parts, isMultipart := strings.Cut(etag, "-")
+	if !isMultipart {
+		local, err := md5File(path)
+		if err != nil {
+			return false, err
+		}
+
+		return hex.EncodeToString(local) == sum, nil
+	}
+
+	partCount, err := strconv.Atoi(parts)
+	if err != nil {
+		return false, nil
+	}
+
+	partSize := multipartSize(size)
+	if int64(partCount) != partsFor(size, partSize) {
+		return false, nil
+	}
+
+	local, err := md5Parts(path, partSize)
+	if err != nil {
+		return false, err
+	}
+
+	combined := md5.New()
+	for _, part := range local {
+		combined.Write(part)
+	}
+
+	return fmt.Sprintf("%x-%d", combined.Sum(nil), len(local)) == etag, nil
+}
+
+func multipartSize(size int64) int64 {
+	perPart := size / maxParts
+	partSize := (perPart + minPartSize - 1) / minPartSize * minPartSize
+	if partSize < minPartSize {
+		return minPartSize
+	}
+
+	return partSize
+}
+
+func partsFor(size, partSize int64) int64 {
+	if size == 0 {
+		return 1
+	}
+
+	return (size + partSize - 1) / partSize
+}
+
+func md5File(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// md5Parts returns the MD5 of every partSize chunk of the file, always at
+// least one even for an empty file
+func md5Parts(path string, partSize int64) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make([][]byte, 0)
+	for {
+		h := md5.New()
+		n, err := io.CopyN(h, f, partSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if n > 0 || len(sums) == 0 {
+			sums = append(sums, h.Sum(nil))
+		}
+
+		if n < partSize {
+			return sums, nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTempFile(t *testing.T, content []byte) string {
+	dir, err := ioutil.TempDir("", "etag")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(path, content, 0600))
+
+	return path
+}
+
+func Test_matchesETag_SinglePart(t *testing.T) {
+	path := writeTempFile(t, []byte("hello"))
+
+	// md5 of 'hello'
+	same, err := matchesETag(path, 5, `"5d41402abc4b2a76b9719d911017c592"`)
+	assert.NoError(t, err)
+	assert.True(t, same)
+
+	same, err = matchesETag(path, 5, "00000000000000000000000000000000")
+	assert.NoError(t, err)
+	assert.False(t, same)
+}
+
+func Test_matchesETag_EmptyFile(t *testing.T) {
+	path := writeTempFile(t, []byte{})
+
+	same, err := matchesETag(path, 0, "d41d8cd98f00b204e9800998ecf8427e")
+	assert.NoError(t, err)
+	assert.True(t, same)
+}
+
+func Test_matchesETag_Multipart(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), minPartSize+10)
+	path := writeTempFile(t, content)
+
+	part1 := md5.Sum(content[:minPartSize])
+	part2 := md5.Sum(content[minPartSize:])
+	combined := md5.Sum(append(part1[:], part2[:]...))
+	etag := fmt.Sprintf("%x-2", combined)
+
+	same, err := matchesETag(path, int64(len(content)), etag)
+	assert.NoError(t, err)
+	assert.True(t, same)
+}
+
+func Test_matchesETag_MultipartExactPartSize(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), minPartSize)
+	path := writeTempFile(t, content)
+
+	part := md5.Sum(content)
+	combined := md5.Sum(part[:])
+	etag := fmt.Sprintf("%x-1", combined)
+
+	same, err := matchesETag(path, int64(len(content)), etag)
+	assert.NoError(t, err)
+	assert.True(t, same)
+}
+
+func Test_matchesETag_MultipartWrongPartCount(t *testing.T) {
+	path := writeTempFile(t, []byte("hello"))
+
+	same, err := matchesETag(path, 5, "5d41402abc4b2a76b9719d911017c592-3")
+	assert.NoError(t, err)
+	assert.False(t, same)
+}
+
+func Test_matchesETag_UnknownFormat(t *testing.T) {
+	path := writeTempFile(t, []byte("hello"))
+
+	same, err := matchesETag(path, 5, "5d41402abc4b2a76b9719d911017c592-abc")
+	assert.NoError(t, err)
+	assert.False(t, same)
+}
+
+func Test_matchesETag_MissingFile(t *testing.T) {
+	_, err := matchesETag("/does/not/exist", 5, "5d41402abc4b2a76b9719d911017c592")
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = matchesETag("/does/not/exist", 5, "5d41402abc4b2a76b9719d911017c592-1")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_matchesETag_UnreadableFile(t *testing.T) {
+	// Directories can be opened but not read
+	dir := filepath.Dir(writeTempFile(t, []byte("hello")))
+
+	_, err := matchesETag(dir, 5, "5d41402abc4b2a76b9719d911017c592")
+	assert.Error(t, err)
+
+	_, err = matchesETag(dir, 5, "5d41402abc4b2a76b9719d911017c592-1")
+	assert.Error(t, err)
+}
+
+func Test_multipartSize(t *testing.T) {
+	assert.Equal(t, int64(minPartSize), multipartSize(0))
+	assert.Equal(t, int64(minPartSize), multipartSize(100*minPartSize))
+
+	// Once there would be more than maxParts the part size grows
+	assert.Equal(t, int64(2*minPartSize), multipartSize(maxParts*minPartSize+maxParts))
+}
+
+func Test_partsFor(t *testing.T) {
+	assert.Equal(t, int64(1), partsFor(0, minPartSize))
+	assert.Equal(t, int64(1), partsFor(minPartSize, minPartSize))
+	assert.Equal(t, int64(2), partsFor(minPartSize+1, minPartSize))
+}
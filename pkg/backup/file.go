@@ -1,22 +1,41 @@
 package backup
 
 import (
+	"context"
 	"fmt"
 )
 
-type FileData map[Filename]File
-type Filename string
-
+// FileGatherer streams every file below its root to out in ascending key
+// order. It must not close out, the caller does that once Gather returns.
 type FileGatherer interface {
-	Gather() (FileData, error)
+	Root() string
+	Gather(ctx context.Context, out chan<- File) error
+}
+
+// PrefixGatherer streams every file whose key starts with prefix to out in
+// ascending key order. Like FileGatherer, it must not close out.
+type PrefixGatherer interface {
+	Gather(ctx context.Context, prefix string, out chan<- File) error
 }
 
 // These are the only two things that I am
 // verifying for differences at this time. I am thinking
 // about expanding it but for the time being this is enough.
 type File struct {
-	Name string
-	Size int64
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+
+	// ETag is only known for remote files. It is never compared by Equal,
+	// it is what lets a new local file be recognised as a moved remote one.
+	ETag string `json:"etag,omitempty"`
+
+	// Pack is set for remote files that are stored inside a pack rather
+	// than as an object of their own, it is the pack they are in
+	Pack string `json:"pack,omitempty"`
+
+	// NotOwned is set for remote files that another host or profile backed
+	// up. They are compared like any other, but nothing is ever done to them.
+	NotOwned bool `json:"notOwned,omitempty"`
 }
 
 func newFile(name string, size int64) File {
@@ -34,3 +53,13 @@ func (f File) Equal(otherFile File) bool {
 	return f.Name == otherFile.Name &&
 		f.Size == otherFile.Size
 }
+
+// sendFile hands f to out unless ctx is cancelled first
+func sendFile(ctx context.Context, out chan<- File, f File) error {
+	select {
+	case out <- f:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
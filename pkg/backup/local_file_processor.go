@@ -1,42 +1,109 @@
 package backup
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 type LocalFileProcessor struct {
 	targetDir string
-	fileData  FileData
+	excludes  []string
 }
 
 //FIXME This should return an error if the target is blank/missing
-func NewLocalFileProcessor(t string) LocalFileProcessor {
+func NewLocalFileProcessor(t string, excludes ...string) LocalFileProcessor {
 	return LocalFileProcessor{
-		targetDir: t,
-		fileData:  make(FileData),
+		targetDir: filepath.Clean(t),
+		excludes:  excludes,
 	}
 }
 
-func (p *LocalFileProcessor) Gather() (data FileData, err error) {
-	err = filepath.Walk(p.targetDir, p.processFile)
+func (p *LocalFileProcessor) Root() string {
+	return p.targetDir
+}
+
+func (p *LocalFileProcessor) Gather(ctx context.Context, out chan<- File) error {
+	fi, err := os.Stat(p.targetDir)
 	if err != nil {
-		return
+		return err
 	}
 
-	data = p.fileData
+	if !fi.IsDir() {
+		return sendFile(ctx, out, newFile(p.targetDir, fi.Size()))
+	}
 
-	return
+	return p.walk(ctx, p.targetDir, out)
 }
 
-func (p *LocalFileProcessor) processFile(filePath string, fi os.FileInfo, err error) (e error) {
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return err
+// walk does what filepath.Walk does except that it visits entries in the
+// same order that S3 lists keys, byte order of the full path. filepath.Walk
+// sorts by entry name which puts 'dir/file' before 'dir.txt' even though
+// '.' sorts before '/'. Comparing directories as if they had their trailing
+// separator fixes that.
+func (p *LocalFileProcessor) walk(ctx context.Context, dir string, out chan<- File) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		// Like filepath.Walk we skip directories we can't read, only a
+		// directory disappearing out from under us is an error
+		if os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
 	}
 
-	if !fi.IsDir() {
-		p.fileData[Filename(filePath)] = newFile(filePath, fi.Size())
+	sort.Slice(entries, func(i, j int) bool {
+		return sortName(entries[i]) < sortName(entries[j])
+	})
+
+	for _, entry := range entries {
+		filePath := filepath.Join(dir, entry.Name())
+
+		if p.excluded(filePath, entry.Name()) {
+			continue
+		}
+
+		if entry.IsDir() {
+			if err := p.walk(ctx, filePath, out); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := sendFile(ctx, out, newFile(filePath, entry.Size())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// excluded is whether a file or directory matches one of the exclude
+// patterns, by its name or by its full path. An excluded directory is not
+// walked at all.
+func (p *LocalFileProcessor) excluded(path, name string) bool {
+	for _, pattern := range p.excludes {
+		// Bad patterns are turned away before a processor is ever made
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortName(entry os.FileInfo) string {
+	if entry.IsDir() {
+		return entry.Name() + "/"
 	}
 
-	return
+	return entry.Name()
 }
@@ -1,8 +1,11 @@
 package backup
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -29,15 +32,38 @@ func (s *LocalProcessorTestSuite) TeardownTest() {
 
 func (s *LocalProcessorTestSuite) Test_Process_Error() {
 	processor := NewLocalFileProcessor("bad_file_path")
-	_, err := processor.Gather()
+	err := processor.Gather(context.Background(), make(chan File))
 
 	s.Require().Error(err)
 }
 
+func (s *LocalProcessorTestSuite) Test_Process_StopsWhenCancelled() {
+	s.createTempFile(s.rootDir, "TEST")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.processor.Gather(ctx, make(chan File))
+
+	s.Equal(context.Canceled, err)
+}
+
+func (s *LocalProcessorTestSuite) Test_Process_StopsWhenCancelledInNestedDir() {
+	innerTempDir := s.createTempDir(s.rootDir, "innerDir")
+	s.createTempFile(innerTempDir, "innerTestFile")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.processor.Gather(ctx, make(chan File))
+
+	s.Equal(context.Canceled, err)
+}
+
 func (s *LocalProcessorTestSuite) Test_Process_SingleDirSingleFile() {
 	tempFile := s.createTempFile(s.rootDir, "TEST")
 
-	localFileInfo, err := s.processor.Gather()
+	localFileInfo, err := s.gather()
 	s.Require().NoError(err)
 
 	s.compare(tempFile, localFileInfo)
@@ -48,7 +74,7 @@ func (s *LocalProcessorTestSuite) Test_Process_SingleDirMultipleFiles() {
 	tempFile2 := s.createTempFile(s.rootDir, "TEST2")
 	tempFile3 := s.createTempFile(s.rootDir, "TEST3")
 
-	localFileInfo, err := s.processor.Gather()
+	localFileInfo, err := s.gather()
 	s.Require().NoError(err)
 
 	s.compare(tempFile1, localFileInfo)
@@ -62,7 +88,7 @@ func (s *LocalProcessorTestSuite) Test_Process_TwoDirsSingleFile() {
 	innerTempDir := s.createTempDir(s.rootDir, "innerDir")
 	innerTempFile := s.createTempFile(innerTempDir, "innerTestFile")
 
-	localFileInfo, err := s.processor.Gather()
+	localFileInfo, err := s.gather()
 	s.Require().NoError(err)
 
 	s.compare(rootDirTempFile, localFileInfo)
@@ -79,7 +105,7 @@ func (s *LocalProcessorTestSuite) Test_Process_TwoDirsMultipleFiles() {
 	innerTempFile2 := s.createTempFile(innerTempDir, "innerTestFile2")
 	innerTempFile3 := s.createTempFile(innerTempDir, "innerTestFile3")
 
-	localFileInfo, err := s.processor.Gather()
+	localFileInfo, err := s.gather()
 	s.Require().NoError(err)
 
 	s.compare(rootDirTempFile1, localFileInfo)
@@ -125,7 +151,7 @@ func (s *LocalProcessorTestSuite) Test_Process_MultipleDirsMultipleFilesDifferen
 
 	s.createTempDir(nestedTempDir3, "nestedDir5")
 
-	localFileInfo, err := s.processor.Gather()
+	localFileInfo, err := s.gather()
 	s.Require().NoError(err)
 
 	s.compare(rootDirTempFile1, localFileInfo)
@@ -142,6 +168,97 @@ func (s *LocalProcessorTestSuite) Test_Process_MultipleDirsMultipleFilesDifferen
 	s.compare(nestedDir4TempFile1, localFileInfo)
 }
 
+func (s *LocalProcessorTestSuite) Test_Process_SortsLikeS3() {
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "b"), nil, 0600))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "a.txt"), nil, 0600))
+	s.Require().NoError(os.Mkdir(filepath.Join(s.rootDir, "a"), 0700))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "a", "z"), nil, 0600))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "a-b"), nil, 0600))
+
+	files, err := s.gatherInOrder()
+	s.Require().NoError(err)
+
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+
+	// '-' < '.' < '/' so the nested file has to come after both of its neighbours
+	s.Equal([]string{
+		filepath.Join(s.rootDir, "a-b"),
+		filepath.Join(s.rootDir, "a.txt"),
+		filepath.Join(s.rootDir, "a", "z"),
+		filepath.Join(s.rootDir, "b"),
+	}, names)
+	s.True(sort.StringsAreSorted(names))
+}
+
+func (s *LocalProcessorTestSuite) Test_Process_TargetIsAFile() {
+	tempFile := s.createTempFile(s.rootDir, "TEST")
+	s.processor = NewLocalFileProcessor(tempFile.Name())
+
+	localFileInfo, err := s.gather()
+	s.Require().NoError(err)
+
+	s.Len(localFileInfo, 1)
+	s.compare(tempFile, localFileInfo)
+}
+
+func (s *LocalProcessorTestSuite) Test_Process_SkipsUnreadableDirectories() {
+	if os.Geteuid() == 0 {
+		s.T().Skip("root can read any directory")
+	}
+
+	tempFile := s.createTempFile(s.rootDir, "TEST")
+	unreadable := s.createTempDir(s.rootDir, "unreadable")
+	s.createTempFile(unreadable, "hidden")
+	s.Require().NoError(os.Chmod(unreadable, 0))
+	defer os.Chmod(unreadable, 0700)
+
+	localFileInfo, err := s.gather()
+	s.Require().NoError(err)
+
+	s.Len(localFileInfo, 1)
+	s.compare(tempFile, localFileInfo)
+}
+
+func (s *LocalProcessorTestSuite) Test_walk_SkipsWhatCantBeRead() {
+	// Reading a file as a directory fails without it having disappeared
+	tempFile := s.createTempFile(s.rootDir, "TEST")
+
+	err := s.processor.walk(context.Background(), tempFile.Name(), make(chan File))
+
+	s.NoError(err)
+}
+
+func (s *LocalProcessorTestSuite) Test_walk_ErrorsIfDirectoryDisappears() {
+	err := s.processor.walk(context.Background(), filepath.Join(s.rootDir, "gone"), make(chan File))
+
+	s.True(os.IsNotExist(err))
+}
+
+func (s *LocalProcessorTestSuite) Test_Process_SkipsExcludes() {
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "keep"), nil, 0600))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "skip.tmp"), nil, 0600))
+	s.Require().NoError(os.Mkdir(filepath.Join(s.rootDir, "node_modules"), 0700))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "node_modules", "keep"), nil, 0600))
+	s.Require().NoError(os.Mkdir(filepath.Join(s.rootDir, "cache"), 0700))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.rootDir, "cache", "keep"), nil, 0600))
+
+	s.processor = NewLocalFileProcessor(s.rootDir, "*.tmp", "node_modules", filepath.Join(s.rootDir, "cache"))
+
+	files, err := s.gatherInOrder()
+	s.Require().NoError(err)
+
+	s.Equal([]File{newFile(filepath.Join(s.rootDir, "keep"), 0)}, files)
+}
+
+func (s *LocalProcessorTestSuite) Test_Root_IsCleaned() {
+	processor := NewLocalFileProcessor("/home/user/docs/")
+
+	s.Equal("/home/user/docs", processor.Root())
+}
+
 func (s *LocalProcessorTestSuite) createTempDir(directory, prefix string) string {
 	createdDir, err := ioutil.TempDir(directory, prefix)
 	if err != nil {
@@ -160,7 +277,31 @@ func (s *LocalProcessorTestSuite) createTempFile(directory, prefix string) *os.F
 	return tmpFile
 }
 
-func (s *LocalProcessorTestSuite) compare(tmpFile *os.File, data FileData) {
+func (s *LocalProcessorTestSuite) gather() (map[string]File, error) {
+	files, err := s.gatherInOrder()
+
+	data := make(map[string]File)
+	for _, f := range files {
+		data[f.Name] = f
+	}
+
+	return data, err
+}
+
+func (s *LocalProcessorTestSuite) gatherInOrder() ([]File, error) {
+	out := make(chan File, 100)
+	err := s.processor.Gather(context.Background(), out)
+	close(out)
+
+	files := make([]File, 0)
+	for f := range out {
+		files = append(files, f)
+	}
+
+	return files, err
+}
+
+func (s *LocalProcessorTestSuite) compare(tmpFile *os.File, data map[string]File) {
 	fi, err := tmpFile.Stat()
 	if err != nil {
 		s.T().Fatal(err)
@@ -168,7 +309,7 @@ func (s *LocalProcessorTestSuite) compare(tmpFile *os.File, data FileData) {
 
 	expected := newFile(tmpFile.Name(), fi.Size())
 
-	actual, found := data[Filename(tmpFile.Name())]
+	actual, found := data[tmpFile.Name()]
 	s.True(found)
 	s.Equal(expected, actual)
 }
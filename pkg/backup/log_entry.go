@@ -2,13 +2,44 @@ package backup
 
 import (
 	"fmt"
+	"time"
 )
 
+// The levels that entries are logged at
+const (
+	INFO  = "INFO"
+	ERROR = "ERROR"
+)
+
+// LogEntry is a single thing that happened during a run. Level is set by
+// the logger, an entry at ERROR is a failure.
 type LogEntry struct {
 	Message, File, Level string
 	ActionType           ActionType
+
+	// Size is the size of File, when there is one. Deduplicated means that
+	// the content was already on the remote and none of it was uploaded.
+	Size         int64
+	Deduplicated bool
+
+	// Skipped means that the action turned out not to be needed after all,
+	// like removing a file that another host backed up
+	Skipped bool
+
+	// StorageClass is what a pushed file was uploaded with, when it was
+	// given one
+	StorageClass string
+
+	// Duration is how long the action took. Attempt is which try at it this
+	// was, counting from 1, nothing is retried yet.
+	Duration time.Duration
+	Attempt  int
 }
 
 func (l LogEntry) String() string {
+	if l.StorageClass != "" {
+		return fmt.Sprintf("file: '%s' - action: '%v' - storage class: '%s' - message: '%s'", l.File, l.ActionType, l.StorageClass, l.Message)
+	}
+
 	return fmt.Sprintf("file: '%s' - action: '%v' - message: '%s'", l.File, l.ActionType, l.Message)
 }
@@ -27,3 +27,15 @@ func Test_LogEntry_String_Remove(t *testing.T) {
 
 	assert.Equal(t, "file: 'File' - action: 'remove' - message: 'Message'", entry.String())
 }
+
+func Test_LogEntry_String_StorageClass(t *testing.T) {
+	entry := LogEntry{
+		Message:      "Message",
+		File:         "File",
+		Level:        "Level",
+		ActionType:   PUSH,
+		StorageClass: "GLACIER",
+	}
+
+	assert.Equal(t, "file: 'File' - action: 'push' - storage class: 'GLACIER' - message: 'Message'", entry.String())
+}
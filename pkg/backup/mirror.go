@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
+)
+
+// Mirror backs the same targets up to several destinations at once. Each
+// destination is a processor of its own, with its own remote, workers and
+// report, but the local side is only ever walked once.
+type Mirror []processor
+
+// mirrorRun is the state of a single Mirror.Process
+type mirrorRun struct {
+	processors []processor
+
+	// errs holds the first error of each destination, done is closed once
+	// there is one so that anything still going for it stops
+	lock sync.Mutex
+	errs []error
+	done []chan struct{}
+}
+
+// Process is processor.Process for every destination at once, the targets
+// and the number of gather workers are taken from the first one. Every file
+// gathered locally is handed to each destination, which gathers and diffs
+// its own remote. A destination that fails is dropped and the others carry
+// on, a failure on the local side stops all of them. The error of each
+// destination is returned in the same order as the destinations, once ctx
+// is cancelled that is the context's error for every one still going.
+func (processors Mirror) Process(ctx context.Context) []error {
+	first := processors[0]
+
+	ctx, span := trace.Start(ctx, "process",
+		trace.Attr("destinations", len(processors)),
+		trace.Attr("target_dirs", len(first.localGatherers)),
+	)
+
+	m := &mirrorRun{
+		processors: processors,
+		errs:       make([]error, len(processors)),
+		done:       make([]chan struct{}, len(processors)),
+	}
+
+	for i := range m.done {
+		m.done[i] = make(chan struct{})
+	}
+
+	tasks := make([]func(context.Context) error, len(first.localGatherers))
+	for i, g := range first.localGatherers {
+		g := g
+		tasks[i] = func(ctx context.Context) error {
+			return m.processTarget(ctx, g)
+		}
+	}
+
+	err := runPool(ctx, first.gatherWorkers, tasks)
+	span.End(err)
+
+	if ctx.Err() != nil {
+		m.interrupt(ctx.Err())
+	} else if err != nil {
+		for i := range processors {
+			m.fail(i, err.(gatherError))
+		}
+	}
+
+	for i, p := range processors {
+		if m.errs[i] == nil {
+			m.errs[i] = p.resolve(ctx)
+		}
+	}
+
+	return m.errs
+}
+
+// processTarget walks local once and compares it against every destination
+// that hasn't failed yet. Only a local failure is returned, that is the one
+// that has to stop everything else.
+func (m *mirrorRun) processTarget(ctx context.Context, local FileGatherer) error {
+	live := m.live()
+	if len(live) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ctx, span := trace.Start(ctx, "target", trace.Attr("target_dir", local.Root()))
+
+	localFiles := startStream(ctx, "local", func(ctx context.Context, out chan<- File) error {
+		ctx, span := trace.Start(ctx, "walk", trace.Attr("target_dir", local.Root()))
+		err := local.Gather(ctx, out)
+		span.End(err)
+
+		return err
+	})
+
+	var wg sync.WaitGroup
+
+	outs := make([]chan File, len(live))
+	errcs := make([]chan error, len(live))
+	ctxs := make([]context.Context, len(live))
+
+	for j, i := range live {
+		dctx, dcancel := m.destinationContext(ctx, i)
+		defer dcancel()
+
+		outs[j] = make(chan File, streamBuffer)
+		errcs[j] = make(chan error, 1)
+		ctxs[j] = dctx
+
+		stream := &fileStream{source: "local", files: outs[j], errc: errcs[j]}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// A remote listing that was cancelled along with everything
+			// else didn't fail
+			err := m.processors[i].processTarget(dctx, local.Root(), stream)
+			if gErr, ok := err.(gatherError); ok && gErr.source == "remote" && ctx.Err() == nil {
+				m.fail(i, gErr)
+			}
+		}(i)
+	}
+
+	err := tee(localFiles, outs, ctxs)
+
+	// Every destination sees the end of the walk the same way it would
+	// if it had done the walk itself
+	var gatherErr error
+	if err != nil {
+		gatherErr = err.(gatherError).err
+	}
+
+	for j := range outs {
+		errcs[j] <- gatherErr
+		close(outs[j])
+	}
+
+	wg.Wait()
+
+	// The walk is left going when every destination gave up on it
+	cancel()
+	localFiles.stop()
+
+	span.End(err)
+
+	return err
+}
+
+// tee hands every file from local to each destination that is still going,
+// it gives up once none of them are
+func tee(local *fileStream, outs []chan File, ctxs []context.Context) error {
+	stopped := make([]bool, len(outs))
+	active := len(outs)
+
+	for {
+		if err := local.advance(); err != nil || !local.ok {
+			return err
+		}
+
+		for j, out := range outs {
+			if stopped[j] {
+				continue
+			}
+
+			// A destination that has failed isn't fed any more, even if
+			// there is still room in its buffer
+			if ctxs[j].Err() != nil || sendFile(ctxs[j], out, local.head) != nil {
+				stopped[j] = true
+				active--
+			}
+		}
+
+		if active == 0 {
+			return nil
+		}
+	}
+}
+
+// destinationContext is cancelled along with ctx or as soon as destination
+// i has failed
+func (m *mirrorRun) destinationContext(ctx context.Context, i int) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-m.done[i]:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+func (m *mirrorRun) live() []int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	live := make([]int, 0, len(m.errs))
+	for i, err := range m.errs {
+		if err == nil {
+			live = append(live, i)
+		}
+	}
+
+	return live
+}
+
+// interrupt records err for every destination that is still going, without
+// logging it, it is not their failure
+func (m *mirrorRun) interrupt(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i := range m.errs {
+		if m.errs[i] == nil {
+			m.errs[i] = err
+			close(m.done[i])
+		}
+	}
+}
+
+// fail records the first error of destination i and stops whatever is still
+// going for it
+func (m *mirrorRun) fail(i int, gErr gatherError) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.errs[i] != nil {
+		return
+	}
+
+	m.errs[i] = gErr.err
+	close(m.done[i])
+
+	m.processors[i].logger.Error(LogEntry{
+		Message: fmt.Sprintf("error returned while gathering %s files, err: %s", gErr.source, gErr.err),
+	})
+}
@@ -0,0 +1,263 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
+)
+
+func TestMirrorTestSuite(t *testing.T) {
+	suite.Run(t, new(MirrorTestSuite))
+}
+
+type MirrorTestSuite struct {
+	suite.Suite
+
+	localGatherCalled int32
+	localGatherers    []FileGatherer
+	remoteData        [][]File
+	remoteErrs        []error
+
+	logged  [][]LogEntry
+	errored chan struct{}
+	lock    sync.Mutex
+
+	// ctx is what the destinations are processed with
+	ctx context.Context
+}
+
+func (s *MirrorTestSuite) SetupTest() {
+	s.localGatherCalled = 0
+
+	gather := func(ctx context.Context, out chan<- File) error {
+		atomic.AddInt32(&s.localGatherCalled, 1)
+		return sendFiles(ctx, out, newFile("/local1/file1", 100), newFile("/local1/file2", 100))
+	}
+
+	s.localGatherers = []FileGatherer{testGatherer{root: "/local1", gather: gather}}
+
+	s.remoteData = [][]File{
+		{newFile("/local1/file1", 100)},
+		{newFile("/local1/file3", 100)},
+	}
+	s.remoteErrs = []error{nil, nil}
+	s.logged = [][]LogEntry{nil, nil}
+	s.errored = make(chan struct{}, 10)
+	s.ctx = context.Background()
+}
+
+// mirror runs every destination and returns the actions queued for each
+func (s *MirrorTestSuite) mirror() ([]error, [][]RemoteAction) {
+	processors := make(Mirror, len(s.remoteData))
+	channels := make([]chan RemoteAction, len(s.remoteData))
+
+	for i := range s.remoteData {
+		i, data, remoteErr := i, s.remoteData[i], s.remoteErrs[i]
+		channels[i] = make(chan RemoteAction, 10)
+
+		remote := testPrefixGatherer{gather: func(ctx context.Context, _ string, out chan<- File) error {
+			if err := sendFiles(ctx, out, data...); err != nil {
+				return err
+			}
+
+			return remoteErr
+		}}
+
+		log := func(e LogEntry) {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			s.logged[i] = append(s.logged[i], e)
+			s.errored <- struct{}{}
+		}
+
+		processors[i] = NewProcessor(s.localGatherers, remote, 1, false, testLogger{logInfo: log, logError: log}, &sync.WaitGroup{}, channels[i]).
+			WithDestination(fmt.Sprintf("destination%d", i+1))
+	}
+
+	errs := processors.Process(s.ctx)
+
+	actions := make([][]RemoteAction, len(channels))
+	for i, c := range channels {
+		close(c)
+		for action := range c {
+			actions[i] = append(actions[i], action)
+		}
+	}
+
+	return errs, actions
+}
+
+func (s *MirrorTestSuite) Test_Process_GathersLocalOnceAndDiffsEachDestination() {
+	errs, actions := s.mirror()
+
+	s.Equal([]error{nil, nil}, errs)
+	s.Equal(int32(1), s.localGatherCalled)
+
+	s.Equal([]RemoteAction{
+		{Type: PUSH, File: newFile("/local1/file2", 100)},
+	}, actions[0])
+
+	s.Equal([]RemoteAction{
+		{Type: PUSH, File: newFile("/local1/file1", 100)},
+		{Type: PUSH, File: newFile("/local1/file2", 100)},
+		{Type: REMOVE, File: newFile("/local1/file3", 100)},
+	}, actions[1])
+}
+
+func (s *MirrorTestSuite) Test_Process_FailedDestinationDoesNotStopTheOthers() {
+	expectedErr := errors.New("asplode!")
+	s.remoteData[0] = nil
+	s.remoteErrs[0] = expectedErr
+
+	s.localGatherers = append(s.localGatherers, testGatherer{root: "/local2", gather: func(ctx context.Context, out chan<- File) error {
+		return sendFiles(ctx, out, newFile("/local2/file1", 100))
+	}})
+
+	errs, actions := s.mirror()
+
+	s.Equal([]error{expectedErr, nil}, errs)
+	s.Equal([]LogEntry{{Message: "error returned while gathering remote files, err: asplode!"}}, s.logged[0])
+	s.Empty(s.logged[1])
+
+	s.contains(actions[1], RemoteAction{Type: PUSH, File: newFile("/local2/file1", 100)})
+	s.contains(actions[1], RemoteAction{Type: PUSH, File: newFile("/local1/file2", 100)})
+}
+
+func (s *MirrorTestSuite) Test_Process_EveryDestinationFailed() {
+	s.remoteData = [][]File{nil, nil}
+	s.remoteErrs = []error{errors.New("asplode!"), errors.New("kaboom!")}
+
+	var local2Called int32
+
+	s.localGatherers = []FileGatherer{
+		testGatherer{root: "/local1", gather: func(ctx context.Context, out chan<- File) error {
+			sendFiles(ctx, out, newFile("/local1/file1", 100))
+
+			<-s.errored
+			<-s.errored
+
+			// Pretty sure that the destinations sometimes lose in a race with
+			// the next file if we don't give them a moment to be cancelled
+			time.Sleep(20 * time.Millisecond)
+
+			return sendFiles(ctx, out, newFile("/local1/file2", 100))
+		}},
+		testGatherer{root: "/local2", gather: func(context.Context, chan<- File) error {
+			atomic.AddInt32(&local2Called, 1)
+			return nil
+		}},
+	}
+
+	errs, _ := s.mirror()
+
+	s.Equal([]error{errors.New("asplode!"), errors.New("kaboom!")}, errs)
+	s.Equal(int32(0), local2Called)
+}
+
+func (s *MirrorTestSuite) Test_Process_LocalFailureStopsEveryDestination() {
+	expectedErr := errors.New("asplode!")
+	s.localGatherers = []FileGatherer{testGatherer{root: "/local1", gather: func(ctx context.Context, out chan<- File) error {
+		sendFiles(ctx, out, newFile("/local1/file1", 100))
+		return expectedErr
+	}}}
+
+	errs, actions := s.mirror()
+
+	s.Equal([]error{expectedErr, expectedErr}, errs)
+	for i := range actions {
+		s.Equal([]LogEntry{{Message: "error returned while gathering local files, err: asplode!"}}, s.logged[i])
+
+		for _, action := range actions[i] {
+			s.NotEqual(REMOVE, action.Type)
+		}
+	}
+}
+
+func (s *MirrorTestSuite) Test_Process_LocalFailureAfterDestinationFailed() {
+	remoteErr := errors.New("asplode!")
+	localErr := errors.New("kaboom!")
+
+	s.remoteData[0] = nil
+	s.remoteErrs[0] = remoteErr
+
+	s.localGatherers = []FileGatherer{testGatherer{root: "/local1", gather: func(ctx context.Context, out chan<- File) error {
+		sendFiles(ctx, out, newFile("/local1/file1", 100))
+
+		<-s.errored
+
+		// Pretty sure that the destination sometimes loses in a race with
+		// the next file if we don't give it a moment to be cancelled
+		time.Sleep(20 * time.Millisecond)
+
+		sendFiles(ctx, out, newFile("/local1/file2", 100), newFile("/local1/file3", 100))
+		return localErr
+	}}}
+
+	errs, actions := s.mirror()
+
+	s.Equal([]error{remoteErr, localErr}, errs)
+	s.Len(s.logged[0], 1)
+
+	for _, action := range actions[1] {
+		s.NotEqual(REMOVE, action.Type)
+	}
+}
+
+func (s *MirrorTestSuite) contains(actions []RemoteAction, action RemoteAction) {
+	for _, a := range actions {
+		if a == action {
+			return
+		}
+	}
+
+	s.Failf("missing action", "%v not in %v", action, actions)
+}
+
+func (s *MirrorTestSuite) Test_Process_IsTraced() {
+	s.remoteErrs[1] = errors.New("asplode!")
+
+	exported := &exportedTraces{}
+	tracer := trace.NewTracer(nil, exported)
+
+	var root *trace.Span
+	s.ctx, root = tracer.Start(context.Background(), "profile")
+
+	s.mirror()
+	root.End(nil)
+
+	s.Empty(tracer.Export(context.Background()))
+	s.Require().Len(exported.requests, 1)
+	request := exported.requests[0]
+
+	for name, count := range map[string]int{"process": 1, "target": 1, "walk": 1, "compare": 2, "list": 2} {
+		s.Equal(count, strings.Count(request, `"name":"`+name+`"`), name)
+	}
+
+	s.Contains(request, `{"key":"destination","value":{"stringValue":"destination2"}},{"key":"prefix","value":{"stringValue":"/local1/"}}`)
+
+	// Both the listing and the comparison on the second destination failed
+	s.Equal(2, strings.Count(request, `"status":{"code":2,"message":"asplode!"}`))
+}
+
+// exportedTraces keeps every request that a tracer exports
+type exportedTraces struct {
+	requests []string
+}
+
+func (e *exportedTraces) Export(_ context.Context, request []byte) error {
+	e.requests = append(e.requests, string(request))
+	return nil
+}
+
+func (e *exportedTraces) String() string {
+	return "exported traces"
+}
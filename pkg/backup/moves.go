@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"sort"
+	"sync"
+)
+
+// moveLimit is how many new, or missing, files are held at most. Past that
+// the new files are paired up with what is held so far and pushed, or the
+// missing files are removed, so that memory stays flat on a first run or
+// when a large directory is added or deleted.
+const moveLimit = 100000
+
+// moveDetector holds on to the files that only exist on one side until
+// every target directory has been compared, or until there are too many of
+// them. A new local file that has the same content as a file that is about
+// to be removed from the remote is copied on the remote instead of being
+// uploaded again. Only changes are ever held here, never more than limit of
+// them on each side. Files are hashed without holding the lock, so that the
+// other gatherers don't wait on them.
+type moveDetector struct {
+	lock    sync.Mutex
+	limit   int
+	added   []File
+	removed map[int64][]File
+	held    int
+
+	sameContent func(local, remote File) (bool, error)
+}
+
+func newMoveDetector() *moveDetector {
+	return &moveDetector{
+		limit:   moveLimit,
+		added:   make([]File, 0),
+		removed: make(map[int64][]File),
+		sameContent: func(local, remote File) (bool, error) {
+			return matchesETag(local.Name, local.Size, remote.ETag)
+		},
+	}
+}
+
+// addLocal holds on to f, the actions it returns have to be queued right
+// away to make room
+func (d *moveDetector) addLocal(f File, onError func(File, error)) []RemoteAction {
+	d.lock.Lock()
+	d.added = append(d.added, f)
+	full := len(d.added) >= d.limit
+	d.lock.Unlock()
+
+	if !full {
+		return nil
+	}
+
+	return d.pair(onError)
+}
+
+// addRemote holds on to f, the actions it returns have to be queued right
+// away to make room. A file that another host or profile owns is neither
+// removed nor copied from, so it isn't held at all.
+func (d *moveDetector) addRemote(f File) []RemoteAction {
+	if f.NotOwned {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.removed[f.Size] = append(d.removed[f.Size], f)
+	d.held++
+	if d.held < d.limit {
+		return nil
+	}
+
+	return d.leftover()
+}
+
+// resolve pairs up the new local files with remote files of the same size
+// and content, and removes whatever is left of the remote files.
+func (d *moveDetector) resolve(onError func(File, error)) []RemoteAction {
+	actions := d.pair(onError)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append(actions, d.leftover()...)
+}
+
+// pair pairs up the new local files held with remote files of the same size
+// and content. Files are only hashed when there is a remote file of the
+// same size to compare against. A file that can't be hashed is pushed as
+// usual, the push will report whatever is wrong with it.
+func (d *moveDetector) pair(onError func(File, error)) []RemoteAction {
+	d.lock.Lock()
+	added := d.added
+	d.added = make([]File, 0)
+	d.lock.Unlock()
+
+	actions := make([]RemoteAction, 0, len(added))
+	for _, local := range added {
+		actions = append(actions, d.match(local, onError))
+	}
+
+	return actions
+}
+
+// match copies local from the first remote file of the same content that
+// is still held once it is hashed, or pushes it
+func (d *moveDetector) match(local File, onError func(File, error)) RemoteAction {
+	d.lock.Lock()
+	candidates := append([]File(nil), d.removed[local.Size]...)
+	d.lock.Unlock()
+
+	for _, remote := range candidates {
+		same, err := d.sameContent(local, remote)
+		if err != nil {
+			onError(local, err)
+			break
+		}
+
+		if same && d.claim(remote) {
+			return RemoteAction{Type: COPY, File: local, Source: remote}
+		}
+	}
+
+	return RemoteAction{Type: PUSH, File: local}
+}
+
+// claim stops holding remote, unless another new file got to it first or it
+// was removed in the meantime
+func (d *moveDetector) claim(remote File) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	candidates := d.removed[remote.Size]
+	for i, f := range candidates {
+		if f.Name == remote.Name {
+			d.removed[remote.Size] = append(candidates[:i:i], candidates[i+1:]...)
+			d.held--
+			return true
+		}
+	}
+
+	return false
+}
+
+// leftover removes every remote file held, in key order. The lock has to be
+// held.
+func (d *moveDetector) leftover() []RemoteAction {
+	leftover := make([]File, 0)
+	for _, files := range d.removed {
+		leftover = append(leftover, files...)
+	}
+
+	sort.Slice(leftover, func(i, j int) bool {
+		return leftover[i].Name < leftover[j].Name
+	})
+
+	actions := make([]RemoteAction, 0, len(leftover))
+	for _, remote := range leftover {
+		actions = append(actions, RemoteAction{Type: REMOVE, File: remote})
+	}
+
+	d.removed = make(map[int64][]File)
+	d.held = 0
+
+	return actions
+}
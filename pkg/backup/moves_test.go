@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_moveDetector_PairsOnlySameSize(t *testing.T) {
+	d := newMoveDetector()
+
+	hashed := make([]string, 0)
+	d.sameContent = func(local, remote File) (bool, error) {
+		hashed = append(hashed, local.Name+" vs "+remote.Name)
+		return true, nil
+	}
+
+	d.addLocal(newFile("new1", 100), nil)
+	d.addLocal(newFile("new2", 200), nil)
+	d.addRemote(newFile("old1", 100))
+	d.addRemote(newFile("old2", 300))
+
+	actions := d.resolve(func(File, error) { t.Error("no errors expected") })
+
+	assert.Equal(t, []string{"new1 vs old1"}, hashed)
+	assert.Equal(t, []RemoteAction{
+		{Type: COPY, File: newFile("new1", 100), Source: newFile("old1", 100)},
+		{Type: PUSH, File: newFile("new2", 200)},
+		{Type: REMOVE, File: newFile("old2", 300)},
+	}, actions)
+}
+
+func Test_moveDetector_EachRemoteFileIsOnlyUsedOnce(t *testing.T) {
+	d := newMoveDetector()
+	d.sameContent = func(local, remote File) (bool, error) {
+		return true, nil
+	}
+
+	d.addLocal(newFile("copy1", 100), nil)
+	d.addLocal(newFile("copy2", 100), nil)
+	d.addRemote(newFile("old", 100))
+
+	actions := d.resolve(func(File, error) {})
+
+	assert.Equal(t, []RemoteAction{
+		{Type: COPY, File: newFile("copy1", 100), Source: newFile("old", 100)},
+		{Type: PUSH, File: newFile("copy2", 100)},
+	}, actions)
+}
+
+func Test_moveDetector_TriesEveryCandidate(t *testing.T) {
+	d := newMoveDetector()
+	d.sameContent = func(local, remote File) (bool, error) {
+		return remote.Name == "old2", nil
+	}
+
+	d.addLocal(newFile("new", 100), nil)
+	d.addRemote(newFile("old1", 100))
+	d.addRemote(newFile("old2", 100))
+	d.addRemote(newFile("old3", 100))
+
+	actions := d.resolve(func(File, error) {})
+
+	assert.Equal(t, []RemoteAction{
+		{Type: COPY, File: newFile("new", 100), Source: newFile("old2", 100)},
+		{Type: REMOVE, File: newFile("old1", 100)},
+		{Type: REMOVE, File: newFile("old3", 100)},
+	}, actions)
+}
+
+func Test_moveDetector_PushesWhenContentCantBeChecked(t *testing.T) {
+	d := newMoveDetector()
+	d.sameContent = func(local, remote File) (bool, error) {
+		return false, errors.New("asplode")
+	}
+
+	d.addLocal(newFile("new", 100), nil)
+	d.addRemote(newFile("old", 100))
+
+	var failed File
+	actions := d.resolve(func(f File, err error) {
+		failed = f
+		assert.EqualError(t, err, "asplode")
+	})
+
+	assert.Equal(t, newFile("new", 100), failed)
+	assert.Equal(t, []RemoteAction{
+		{Type: PUSH, File: newFile("new", 100)},
+		{Type: REMOVE, File: newFile("old", 100)},
+	}, actions)
+}
+
+func Test_moveDetector_ComparesETags(t *testing.T) {
+	path := writeTempFile(t, []byte("hello"))
+
+	d := newMoveDetector()
+	d.addLocal(newFile(path, 5), nil)
+	d.addRemote(File{Name: "old", Size: 5, ETag: "5d41402abc4b2a76b9719d911017c592"})
+
+	actions := d.resolve(func(File, error) { t.Error("no errors expected") })
+
+	assert.Equal(t, COPY, string(actions[0].Type))
+}
+
+func Test_moveDetector_LetsGoPastTheLimit(t *testing.T) {
+	d := newMoveDetector()
+	d.limit = 2
+	d.sameContent = func(local, remote File) (bool, error) {
+		return true, nil
+	}
+
+	assert.Empty(t, d.addRemote(newFile("old", 100)))
+	assert.Empty(t, d.addLocal(newFile("new1", 100), nil))
+
+	// What is held so far is still paired up
+	assert.Equal(t, []RemoteAction{
+		{Type: COPY, File: newFile("new1", 100), Source: newFile("old", 100)},
+		{Type: PUSH, File: newFile("new2", 200)},
+	}, d.addLocal(newFile("new2", 200), nil))
+
+	assert.Empty(t, d.addRemote(newFile("old2", 300)))
+	assert.Equal(t, []RemoteAction{
+		{Type: REMOVE, File: newFile("old1", 300)},
+		{Type: REMOVE, File: newFile("old2", 300)},
+	}, d.addRemote(newFile("old1", 300)))
+
+	assert.Empty(t, d.resolve(func(File, error) { t.Error("no errors expected") }))
+}
+
+func Test_moveDetector_NeverCopiesFromWhatItDoesNotOwn(t *testing.T) {
+	d := newMoveDetector()
+	d.sameContent = func(local, remote File) (bool, error) {
+		return true, nil
+	}
+
+	theirs := newFile("old", 100)
+	theirs.NotOwned = true
+
+	assert.Empty(t, d.addRemote(theirs))
+	d.addLocal(newFile("new", 100), nil)
+
+	assert.Equal(t, []RemoteAction{
+		{Type: PUSH, File: newFile("new", 100)},
+	}, d.resolve(func(File, error) { t.Error("no errors expected") }))
+}
+
+func Test_moveDetector_HashesWithoutHoldingTheLock(t *testing.T) {
+	d := newMoveDetector()
+	d.limit = 2
+
+	// The other side fills up while the new file is hashed, which removes
+	// the file it was being compared to
+	var removed []RemoteAction
+	d.sameContent = func(local, remote File) (bool, error) {
+		removed = d.addRemote(newFile("old2", 300))
+		return true, nil
+	}
+
+	d.addRemote(newFile("old", 100))
+	d.addLocal(newFile("new", 100), nil)
+
+	actions := d.resolve(func(File, error) { t.Error("no errors expected") })
+
+	assert.Equal(t, []RemoteAction{
+		{Type: REMOVE, File: newFile("old", 100)},
+		{Type: REMOVE, File: newFile("old2", 300)},
+	}, removed)
+	assert.Equal(t, []RemoteAction{
+		{Type: PUSH, File: newFile("new", 100)},
+	}, actions)
+}
@@ -0,0 +1,233 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// packPrefix is where packs live, followed by the target directory they were
+// packed from
+const packPrefix = "_packs"
+
+// packIndex is stored next to every pack. Offsets are where each member's
+// content starts in the tar stream, before any compression.
+type packIndex struct {
+	Object  string       `json:"object"`
+	Members []packMember `json:"members"`
+}
+
+type packMember struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Offset int64  `json:"offset"`
+}
+
+// PackStore keeps small files in tar objects, one index object per pack
+// says where each file is. A pack is only ever written once, changing any of
+// its members means building a new one and removing the old one.
+type PackStore struct {
+	storage  Storage
+	compress bool
+}
+
+func NewPackStore(st Storage, compress bool) (PackStore, error) {
+	if st == nil {
+		return PackStore{}, errors.New("'NewPackStore' error: storage cannot be missing")
+	}
+
+	return PackStore{
+		storage:  st,
+		compress: compress,
+	}, nil
+}
+
+// Gather reads every pack index under prefix and sends their members on in
+// key order. Unlike the other gatherers this has to hold every packed file
+// under prefix in memory, there is no other way to sort them.
+func (s *PackStore) Gather(ctx context.Context, prefix string, out chan<- File) error {
+	files := make([]File, 0)
+
+	err := eachObject(ctx, s.storage, packPrefix+prefix, ListOptions{}, func(object Object) error {
+		if !strings.HasSuffix(object.Key, ".json") {
+			return nil
+		}
+
+		index, err := s.readIndex(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+
+		pack := strings.TrimSuffix(object.Key, ".json")
+		for _, m := range index.Members {
+			f := newFile(m.Name, m.Size)
+			f.Pack = pack
+			files = append(files, f)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	for _, f := range files {
+		if err := sendFile(ctx, out, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *PackStore) readIndex(ctx context.Context, key string) (index packIndex, err error) {
+	r, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	err = json.NewDecoder(r).Decode(&index)
+	return
+}
+
+// Pack streams members into a new tar object, straight from disk, and then
+// writes its index. A pack without an index is never read so a failure part
+// way through leaves nothing behind that matters.
+func (s *PackStore) Pack(ctx context.Context, pack File, members []File) error {
+	object := pack.Name + ".tar"
+	if s.compress {
+		object += ".gz"
+	}
+
+	r, w := io.Pipe()
+
+	var index []packMember
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var err error
+		index, err = writeTar(w, members, s.compress)
+		w.CloseWithError(err)
+	}()
+
+	err := s.storage.Put(ctx, object, r, -1, PutOptions{})
+
+	// Whatever happened to the upload, make sure the tar writer stops
+	r.CloseWithError(errors.New("pack upload finished"))
+	<-done
+
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(packIndex{Object: object, Members: index})
+	if err != nil {
+		return err
+	}
+
+	return s.storage.Put(ctx, pack.Name+".json", bytes.NewReader(body), int64(len(body)), PutOptions{
+		ContentType: "application/json",
+	})
+}
+
+// RemovePack removes the index first so that the pack is never half there
+func (s *PackStore) RemovePack(ctx context.Context, pack string) error {
+	for _, key := range []string{pack + ".json", pack + ".tar", pack + ".tar.gz"} {
+		if err := s.storage.Remove(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeTar writes every member to w as a tar, gzipped if asked to, and
+// returns where each one's content starts in the uncompressed stream
+func writeTar(w io.Writer, members []File, compress bool) ([]packMember, error) {
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	counter := &countingWriter{w: w}
+	tw := tar.NewWriter(counter)
+
+	index := make([]packMember, 0, len(members))
+	for _, m := range members {
+		entry, err := writeTarEntry(tw, counter, m)
+		if err != nil {
+			return nil, err
+		}
+
+		index = append(index, entry)
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return index, nil
+}
+
+func writeTarEntry(tw *tar.Writer, counter *countingWriter, m File) (packMember, error) {
+	f, err := os.Open(m.Name)
+	if err != nil {
+		return packMember{}, err
+	}
+	defer f.Close()
+
+	// The file may have changed since it was walked. If it shrank the pack
+	// fails, if it grew only what was walked is packed and the next run
+	// sees the difference.
+	err = tw.WriteHeader(&tar.Header{
+		Name: strings.TrimPrefix(m.Name, "/"),
+		Mode: 0644,
+		Size: m.Size,
+	})
+	if err != nil {
+		return packMember{}, err
+	}
+
+	entry := packMember{Name: m.Name, Size: m.Size, Offset: counter.n}
+
+	// A plain EOF would pass for the end of the pack on the other end of
+	// the pipe, a file that shrank must fail the upload
+	if _, err := io.CopyN(tw, f, m.Size); err == io.EOF {
+		return packMember{}, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return packMember{}, err
+	}
+
+	return entry, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,322 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPackStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(PackStoreTestSuite))
+}
+
+type PackStoreTestSuite struct {
+	suite.Suite
+	storage *testStorage
+
+	objects map[string][]byte
+}
+
+func (s *PackStoreTestSuite) SetupTest() {
+	s.storage = newTestStorage()
+	s.objects = make(map[string][]byte)
+
+	s.storage.get = func(_ context.Context, key string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(s.objects[key])), nil
+	}
+
+	// Like minio, read until the end before answering
+	s.storage.put = func(_ context.Context, key string, r io.Reader, _ int64, _ PutOptions) error {
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		s.objects[key] = body
+		return nil
+	}
+}
+
+func (s *PackStoreTestSuite) store(compress bool) PackStore {
+	store, err := NewPackStore(s.storage, compress)
+	s.Require().NoError(err)
+	return store
+}
+
+func (s *PackStoreTestSuite) listing(keys ...string) {
+	s.storage.list = func(ctx context.Context, prefix string, _ ListOptions, out chan<- Object) error {
+		s.Equal("_packs/home/", prefix)
+
+		for _, key := range keys {
+			if err := SendObject(ctx, out, Object{Key: key}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func (s *PackStoreTestSuite) gather(store PackStore) ([]File, error) {
+	out := make(chan File, 10)
+	err := store.Gather(context.Background(), "/home/", out)
+	close(out)
+
+	files := make([]File, 0)
+	for f := range out {
+		files = append(files, f)
+	}
+
+	return files, err
+}
+
+func (s *PackStoreTestSuite) Test_New_ErrorMissingStorage() {
+	_, err := NewPackStore(nil, false)
+	s.Equal(errors.New("'NewPackStore' error: storage cannot be missing"), err)
+}
+
+func (s *PackStoreTestSuite) Test_Gather_SendsMembersOfEveryPackInOrder() {
+	s.listing("_packs/home/p1.json", "_packs/home/p1.tar", "_packs/home/p2.json", "_packs/home/p2.tar.gz")
+	s.objects["_packs/home/p1.json"] = []byte(`{"object":"_packs/home/p1.tar","members":[{"name":"/home/b","size":2,"offset":512},{"name":"/home/d","size":4,"offset":1536}]}`)
+	s.objects["_packs/home/p2.json"] = []byte(`{"object":"_packs/home/p2.tar.gz","members":[{"name":"/home/a","size":1,"offset":512},{"name":"/home/c","size":3,"offset":1536}]}`)
+
+	files, err := s.gather(s.store(false))
+
+	s.Require().NoError(err)
+	s.Equal([]File{
+		{Name: "/home/a", Size: 1, Pack: "_packs/home/p2"},
+		{Name: "/home/b", Size: 2, Pack: "_packs/home/p1"},
+		{Name: "/home/c", Size: 3, Pack: "_packs/home/p2"},
+		{Name: "/home/d", Size: 4, Pack: "_packs/home/p1"},
+	}, files)
+}
+
+func (s *PackStoreTestSuite) Test_Gather_ReturnsListError() {
+	expectedErr := errors.New("asplode")
+	s.storage.list = func(context.Context, string, ListOptions, chan<- Object) error {
+		return expectedErr
+	}
+
+	_, err := s.gather(s.store(false))
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *PackStoreTestSuite) Test_Gather_ReturnsGetError() {
+	expectedErr := errors.New("asplode")
+	s.listing("_packs/home/p1.json")
+	s.storage.get = func(context.Context, string) (io.ReadCloser, error) {
+		return nil, expectedErr
+	}
+
+	_, err := s.gather(s.store(false))
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *PackStoreTestSuite) Test_Gather_ReturnsErrorForBrokenIndex() {
+	s.listing("_packs/home/p1.json")
+	s.objects["_packs/home/p1.json"] = []byte(`{"members":`)
+
+	_, err := s.gather(s.store(false))
+
+	s.Error(err)
+}
+
+func (s *PackStoreTestSuite) Test_Gather_StopsWhenCancelled() {
+	// The listing ignores the context so that it is the files being sent
+	// on that notice it, rather than whichever gets there first
+	s.storage.list = func(_ context.Context, _ string, _ ListOptions, out chan<- Object) error {
+		out <- Object{Key: "_packs/home/p1.json"}
+		return nil
+	}
+	s.objects["_packs/home/p1.json"] = []byte(`{"members":[{"name":"/home/a","size":1}]}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	store := s.store(false)
+	err := store.Gather(ctx, "/home/", make(chan File))
+
+	s.Equal(context.Canceled, err)
+}
+
+func (s *PackStoreTestSuite) Test_Pack_WritesTarAndIndex() {
+	a := writeTempFile(s.T(), []byte("hello"))
+	b := writeTempFile(s.T(), []byte("world!"))
+
+	store := s.store(false)
+	err := store.Pack(context.Background(), newFile("_packs/home/p1", 11), []File{newFile(a, 5), newFile(b, 6)})
+	s.Require().NoError(err)
+
+	s.Equal(
+		`{"object":"_packs/home/p1.tar","members":[`+
+			`{"name":"`+a+`","size":5,"offset":512},`+
+			`{"name":"`+b+`","size":6,"offset":1536}]}`,
+		string(s.objects["_packs/home/p1.json"]),
+	)
+
+	s.checkTar(s.objects["_packs/home/p1.tar"], map[string]string{
+		strings.TrimPrefix(a, "/"): "hello",
+		strings.TrimPrefix(b, "/"): "world!",
+	})
+
+	// The offsets point straight at the content
+	s.Equal("hello", string(s.objects["_packs/home/p1.tar"][512:517]))
+	s.Equal("world!", string(s.objects["_packs/home/p1.tar"][1536:1542]))
+}
+
+func (s *PackStoreTestSuite) Test_Pack_Compressed() {
+	a := writeTempFile(s.T(), []byte("hello"))
+
+	store := s.store(true)
+	err := store.Pack(context.Background(), newFile("_packs/home/p1", 5), []File{newFile(a, 5)})
+	s.Require().NoError(err)
+
+	s.Contains(string(s.objects["_packs/home/p1.json"]), `"object":"_packs/home/p1.tar.gz"`)
+
+	gz, err := gzip.NewReader(bytes.NewReader(s.objects["_packs/home/p1.tar.gz"]))
+	s.Require().NoError(err)
+	body, err := ioutil.ReadAll(gz)
+	s.Require().NoError(err)
+
+	s.checkTar(body, map[string]string{strings.TrimPrefix(a, "/"): "hello"})
+}
+
+func (s *PackStoreTestSuite) Test_Pack_ReturnsErrorForMissingMember() {
+	store := s.store(false)
+	err := store.Pack(context.Background(), newFile("_packs/home/p1", 5), []File{newFile("/does/not/exist", 5)})
+
+	s.Error(err)
+	s.NotContains(s.objects, "_packs/home/p1.json")
+}
+
+func (s *PackStoreTestSuite) Test_Pack_ReturnsErrorForShrunkMember() {
+	a := writeTempFile(s.T(), []byte("hello"))
+
+	store := s.store(false)
+	err := store.Pack(context.Background(), newFile("_packs/home/p1", 10), []File{newFile(a, 10)})
+
+	s.Equal(io.ErrUnexpectedEOF, err)
+	s.NotContains(s.objects, "_packs/home/p1.json")
+}
+
+func (s *PackStoreTestSuite) Test_Pack_ReturnsUploadError() {
+	a := writeTempFile(s.T(), []byte("hello"))
+	expectedErr := errors.New("asplode")
+
+	// Gives up without reading anything, the tar writer must still stop
+	s.storage.put = func(context.Context, string, io.Reader, int64, PutOptions) error {
+		return expectedErr
+	}
+
+	store := s.store(false)
+	err := store.Pack(context.Background(), newFile("_packs/home/p1", 5), []File{newFile(a, 5)})
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *PackStoreTestSuite) Test_Pack_ReturnsIndexUploadError() {
+	a := writeTempFile(s.T(), []byte("hello"))
+	expectedErr := errors.New("asplode")
+
+	put := s.storage.put
+	s.storage.put = func(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+		if strings.HasSuffix(key, ".json") {
+			s.Equal("application/json", opts.ContentType)
+			return expectedErr
+		}
+
+		s.Equal(int64(-1), size)
+		return put(ctx, key, r, size, opts)
+	}
+
+	store := s.store(false)
+	err := store.Pack(context.Background(), newFile("_packs/home/p1", 5), []File{newFile(a, 5)})
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *PackStoreTestSuite) Test_RemovePack_RemovesIndexFirst() {
+	removed := make([]string, 0)
+	s.storage.remove = func(_ context.Context, key string) error {
+		removed = append(removed, key)
+		return nil
+	}
+
+	store := s.store(false)
+	s.Require().NoError(store.RemovePack(context.Background(), "_packs/home/p1"))
+
+	s.Equal([]string{"_packs/home/p1.json", "_packs/home/p1.tar", "_packs/home/p1.tar.gz"}, removed)
+}
+
+func (s *PackStoreTestSuite) Test_RemovePack_ReturnsError() {
+	expectedErr := errors.New("asplode")
+	s.storage.remove = func(context.Context, string) error {
+		return expectedErr
+	}
+
+	store := s.store(false)
+	s.Equal(expectedErr, store.RemovePack(context.Background(), "_packs/home/p1"))
+}
+
+func (s *PackStoreTestSuite) Test_writeTar_ReturnsWriteErrors() {
+	a := writeTempFile(s.T(), []byte("hello"))
+
+	// The header, the content and then the end of the tar are each
+	// written in one go, the gzip header is 10 bytes
+	for _, tc := range []struct {
+		compress bool
+		limit    int
+	}{
+		{compress: false, limit: 0},
+		{compress: false, limit: 512},
+		{compress: false, limit: 1024},
+		{compress: true, limit: 10},
+	} {
+		_, err := writeTar(&limitedWriter{limit: tc.limit}, []File{newFile(a, 5)}, tc.compress)
+		s.Equal(errors.New("limit reached"), err, "limit %d", tc.limit)
+	}
+}
+
+func (s *PackStoreTestSuite) checkTar(body []byte, expected map[string]string) {
+	found := make(map[string]string)
+
+	tr := tar.NewReader(bytes.NewReader(body))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		s.Require().NoError(err)
+
+		content, err := ioutil.ReadAll(tr)
+		s.Require().NoError(err)
+
+		found[header.Name] = string(content)
+	}
+
+	s.Equal(expected, found)
+}
+
+type limitedWriter struct {
+	limit   int
+	written int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		return 0, errors.New("limit reached")
+	}
+
+	w.written += len(p)
+	return len(p), nil
+}
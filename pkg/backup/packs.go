@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"sort"
+	"sync"
+)
+
+// packPlanner decides which small files end up in which pack. Like the
+// moveDetector it only holds on to what changed, packs that are untouched
+// are forgotten about as soon as they are known to be untouched.
+type packPlanner struct {
+	lock      sync.Mutex
+	threshold int64
+	maxSize   int64
+
+	packs map[string]*plannedPack
+	fresh map[string][]File
+
+	newPackID func() string
+}
+
+// plannedPack is an existing pack. It is only rebuilt when dirty, which is
+// when at least one of its members changed or went away.
+type plannedPack struct {
+	members []File
+	dropped []File
+	dirty   bool
+}
+
+func newPackPlanner(threshold, maxSize int64) *packPlanner {
+	return &packPlanner{
+		threshold: threshold,
+		maxSize:   maxSize,
+		packs:     make(map[string]*plannedPack),
+		fresh:     make(map[string][]File),
+		newPackID: randomID,
+	}
+}
+
+func randomID() string {
+	id := make([]byte, 8)
+
+	// crypto/rand only fails when the OS has no randomness to give, at
+	// which point there are bigger problems than a name
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// wants is whether f is small enough to be packed
+func (p *packPlanner) wants(f File) bool {
+	return f.Size < p.threshold
+}
+
+// add queues a new local file to be packed with the others from root
+func (p *packPlanner) add(root string, f File) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.fresh[root] = append(p.fresh[root], f)
+}
+
+// keep records that a packed file is unchanged
+func (p *packPlanner) keep(local File, pack string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pp := p.pack(pack)
+	pp.members = append(pp.members, local)
+}
+
+// change records that a packed file is still small enough to be packed but
+// differs from what is in its pack
+func (p *packPlanner) change(local File, pack string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pp := p.pack(pack)
+	pp.members = append(pp.members, local)
+	pp.dirty = true
+}
+
+// drop records that a packed file has to leave its pack
+func (p *packPlanner) drop(remote File) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	pp := p.pack(remote.Pack)
+	pp.dropped = append(pp.dropped, remote)
+	pp.dirty = true
+}
+
+func (p *packPlanner) pack(name string) *plannedPack {
+	pp, ok := p.packs[name]
+	if !ok {
+		pp = &plannedPack{}
+		p.packs[name] = pp
+	}
+
+	return pp
+}
+
+// resolve turns everything recorded into PACK actions. Every dirty pack is
+// replaced by a new one next to it, with its remaining members. New files
+// are packed per target directory, in name order, into packs of no more
+// than maxSize unless a single file is bigger than that on its own.
+func (p *packPlanner) resolve() []RemoteAction {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	actions := make([]RemoteAction, 0)
+
+	names := make([]string, 0, len(p.packs))
+	for name, pp := range p.packs {
+		if pp.dirty {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pp := p.packs[name]
+		actions = append(actions, p.action(path.Dir(name)+"/", pp.members, []string{name}, pp.dropped))
+	}
+
+	roots := make([]string, 0, len(p.fresh))
+	for root := range p.fresh {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	for _, root := range roots {
+		files := p.fresh[root]
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Name < files[j].Name
+		})
+
+		var (
+			chunk []File
+			size  int64
+		)
+
+		for _, f := range files {
+			if len(chunk) > 0 && size+f.Size > p.maxSize {
+				actions = append(actions, p.action(packPrefix+remotePrefix(root), chunk, nil, nil))
+				chunk, size = nil, 0
+			}
+
+			chunk = append(chunk, f)
+			size += f.Size
+		}
+
+		actions = append(actions, p.action(packPrefix+remotePrefix(root), chunk, nil, nil))
+	}
+
+	return actions
+}
+
+func (p *packPlanner) action(prefix string, members []File, replaces []string, dropped []File) RemoteAction {
+	var size int64
+	for _, m := range members {
+		size += m.Size
+	}
+
+	return RemoteAction{
+		Type: PACK,
+		File: newFile(prefix+p.newPackID(), size),
+		Pack: &Pack{
+			Members:  members,
+			Replaces: replaces,
+			Dropped:  dropped,
+		},
+	}
+}
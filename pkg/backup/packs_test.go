@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_packPlanner_Wants(t *testing.T) {
+	p := newPackPlanner(100, 1000)
+
+	assert.True(t, p.wants(newFile("small", 99)))
+	assert.False(t, p.wants(newFile("big", 100)))
+}
+
+func Test_packPlanner_FileBiggerThanMaxSizeGetsItsOwnPack(t *testing.T) {
+	p := newPackPlanner(100, 50)
+	p.newPackID = func() string { return "id" }
+
+	p.add("/root", newFile("/root/b", 20))
+	p.add("/root", newFile("/root/a", 80))
+	p.add("/other/", newFile("/other/c", 10))
+
+	assert.Equal(t, []RemoteAction{
+		{Type: PACK, File: newFile("_packs/other/id", 10), Pack: &Pack{Members: []File{newFile("/other/c", 10)}}},
+		{Type: PACK, File: newFile("_packs/root/id", 80), Pack: &Pack{Members: []File{newFile("/root/a", 80)}}},
+		{Type: PACK, File: newFile("_packs/root/id", 20), Pack: &Pack{Members: []File{newFile("/root/b", 20)}}},
+	}, p.resolve())
+}
+
+func Test_packPlanner_NothingToDo(t *testing.T) {
+	p := newPackPlanner(100, 50)
+	p.keep(newFile("/root/a", 10), "_packs/root/old")
+
+	assert.Empty(t, p.resolve())
+}
+
+func Test_randomID(t *testing.T) {
+	id := randomID()
+
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, randomID())
+}
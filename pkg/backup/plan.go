@@ -0,0 +1,268 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// planVersion is the version of the plan file, a plan of any other version
+// is refused
+const planVersion = 1
+
+// Plan is every action that a dry run decided on, for each destination of
+// each profile, to be applied later on exactly as it was decided. Every
+// action has the local and remote files it was based on in it, those are
+// what apply checks are still the same.
+type Plan struct {
+	Version  int              `json:"version"`
+	Created  time.Time        `json:"created"`
+	Host     string           `json:"host"`
+	Profiles []PlannedProfile `json:"profiles"`
+}
+
+type PlannedProfile struct {
+	Name         string               `json:"name"`
+	Destinations []PlannedDestination `json:"destinations"`
+}
+
+type PlannedDestination struct {
+	Name    string         `json:"name"`
+	Actions []RemoteAction `json:"actions"`
+}
+
+func NewPlan(host string, created time.Time) Plan {
+	return Plan{
+		Version:  planVersion,
+		Created:  created,
+		Host:     host,
+		Profiles: make([]PlannedProfile, 0),
+	}
+}
+
+// Add plans actions for destination of profile
+func (p *Plan) Add(profile, destination string, actions []RemoteAction) {
+	d := PlannedDestination{Name: destination, Actions: actions}
+
+	for i := range p.Profiles {
+		if p.Profiles[i].Name == profile {
+			p.Profiles[i].Destinations = append(p.Profiles[i].Destinations, d)
+			return
+		}
+	}
+
+	p.Profiles = append(p.Profiles, PlannedProfile{Name: profile, Destinations: []PlannedDestination{d}})
+}
+
+// Destinations are the destinations of profile that are in the plan, ok is
+// only set if the profile is in it at all
+func (p Plan) Destinations(profile string) (destinations []PlannedDestination, ok bool) {
+	for _, planned := range p.Profiles {
+		if planned.Name == profile {
+			return planned.Destinations, true
+		}
+	}
+
+	return nil, false
+}
+
+// WriteFile writes the plan to path, a plan that is half written is never
+// left there
+func (p Plan) WriteFile(path string) error {
+	// Nothing in a plan fails to marshal
+	b, _ := json.MarshalIndent(p, "", "  ")
+
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	defer os.Remove(tmp)
+
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ReadPlan reads the plan that WriteFile wrote to path
+func ReadPlan(path string) (Plan, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	var p Plan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return Plan{}, fmt.Errorf("'ReadPlan' error: '%s' is not a plan, err: %s", path, err)
+	}
+
+	if p.Version != planVersion {
+		return Plan{}, fmt.Errorf("'ReadPlan' error: '%s' is a plan of version %d, only version %d can be applied", path, p.Version, planVersion)
+	}
+
+	return p, nil
+}
+
+// actionJSON is how an action is written in a plan, without the files
+// that an action of its type doesn't have
+type actionJSON struct {
+	Type   ActionType `json:"type"`
+	File   File       `json:"file"`
+	Source *File      `json:"source,omitempty"`
+	Remote *File      `json:"remote,omitempty"`
+	Pack   *Pack      `json:"pack,omitempty"`
+}
+
+func (a RemoteAction) MarshalJSON() ([]byte, error) {
+	j := actionJSON{Type: a.Type, File: a.File, Pack: a.Pack}
+
+	if a.Source != (File{}) {
+		j.Source = &a.Source
+	}
+
+	if a.Remote != (File{}) {
+		j.Remote = &a.Remote
+	}
+
+	return json.Marshal(j)
+}
+
+func (a *RemoteAction) UnmarshalJSON(b []byte) error {
+	var j actionJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	*a = RemoteAction{Type: j.Type, File: j.File, Pack: j.Pack}
+
+	if j.Source != nil {
+		a.Source = *j.Source
+	}
+
+	if j.Remote != nil {
+		a.Remote = *j.Remote
+	}
+
+	return nil
+}
+
+// Recorder keeps every action that a processor queues, for a plan. It is
+// safe to share between the goroutines of a processor.
+type Recorder struct {
+	lock    sync.Mutex
+	actions []RemoteAction
+}
+
+func (r *Recorder) record(action RemoteAction) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.actions = append(r.actions, action)
+}
+
+// Actions are the actions recorded so far, by file. They are queued in
+// whatever order the target dirs are done in.
+func (r *Recorder) Actions() []RemoteAction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	actions := make([]RemoteAction, len(r.actions))
+	copy(actions, r.actions)
+
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].File.Name < actions[j].File.Name
+	})
+
+	return actions
+}
+
+// Applier only lets through the actions of a plan. The comparison is done
+// all over again and an action is only applied if it comes out of it just
+// the way it was planned, based on the same local and remote files. Any
+// other action is left alone.
+type Applier struct {
+	lock sync.Mutex
+
+	planned []RemoteAction
+	applied []bool
+	byKey   map[string][]int
+
+	ignored int
+}
+
+func NewApplier(planned []RemoteAction) *Applier {
+	a := &Applier{
+		planned: planned,
+		applied: make([]bool, len(planned)),
+		byKey:   make(map[string][]int),
+	}
+
+	for i, action := range planned {
+		key := planKey(action)
+		a.byKey[key] = append(a.byKey[key], i)
+	}
+
+	return a
+}
+
+// planKey is what an action is matched on, all of it but the name of a
+// new pack, which is random
+func planKey(action RemoteAction) string {
+	if action.Type == PACK {
+		action.File.Name = ""
+	}
+
+	// Nothing in an action fails to marshal
+	b, _ := json.Marshal(action)
+	return string(b)
+}
+
+// take is the planned action that action is, if it is in the plan and
+// hasn't been applied yet
+func (a *Applier) take(action RemoteAction) (RemoteAction, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := planKey(action)
+	for _, i := range a.byKey[key] {
+		if !a.applied[i] {
+			a.applied[i] = true
+			return a.planned[i], true
+		}
+	}
+
+	a.ignored++
+	return RemoteAction{}, false
+}
+
+// Refused are the planned actions that didn't come out of the comparison
+// the way they were planned, in the order of the plan. The local or remote
+// files that they were based on changed since.
+func (a *Applier) Refused() []RemoteAction {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	refused := make([]RemoteAction, 0)
+	for i, applied := range a.applied {
+		if !applied {
+			refused = append(refused, a.planned[i])
+		}
+	}
+
+	return refused
+}
+
+// Ignored is how many actions came out of the comparison that weren't in
+// the plan, they are left for the next run
+func (a *Applier) Ignored() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.ignored
+}
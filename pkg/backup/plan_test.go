@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tempPlanPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "plan")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "plan.json")
+}
+
+func Test_Plan_WriteFileThenReadPlan(t *testing.T) {
+	path := tempPlanPath(t)
+
+	p := NewPlan("host1", time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+	p.Add("home", "nas", []RemoteAction{
+		{Type: PUSH, File: newFile("/home/a", 10), Remote: File{Name: "/home/a", Size: 5, ETag: "etag"}},
+		{Type: COPY, File: newFile("/home/b", 20), Source: File{Name: "/home/old", Size: 20, ETag: "etag"}},
+	})
+	p.Add("home", "offsite", []RemoteAction{
+		{Type: PACK, File: newFile("_packs/home/pack1", 30), Pack: &Pack{
+			Members:  []File{newFile("/home/c", 30)},
+			Replaces: []string{"_packs/home/old"},
+			Dropped:  []File{{Name: "/home/gone", Size: 5, Pack: "_packs/home/old"}},
+		}},
+	})
+	p.Add("work", "", []RemoteAction{{Type: REMOVE, File: File{Name: "/work/a", Size: 5, ETag: "etag"}}})
+
+	require.NoError(t, p.WriteFile(path))
+
+	read, err := ReadPlan(path)
+	require.NoError(t, err)
+	assert.Equal(t, p, read)
+
+	// Nothing is left behind next to it
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	assert.Len(t, files, 1)
+
+	destinations, ok := read.Destinations("home")
+	assert.True(t, ok)
+	assert.Len(t, destinations, 2)
+
+	_, ok = read.Destinations("elsewhere")
+	assert.False(t, ok)
+
+	assert.Error(t, p.WriteFile(filepath.Join(path, "missing", "plan.json")))
+}
+
+func Test_RemoteAction_JSON(t *testing.T) {
+	b, err := json.Marshal(RemoteAction{Type: PUSH, File: newFile("/home/a", 10)})
+	require.NoError(t, err)
+
+	// An action only has the files that go with its type
+	assert.JSONEq(t, `{"type":"push","file":{"name":"/home/a","size":10}}`, string(b))
+
+	var a RemoteAction
+	assert.Error(t, json.Unmarshal([]byte(`{"type":1}`), &a))
+}
+
+func Test_ReadPlan_Errors(t *testing.T) {
+	path := tempPlanPath(t)
+
+	_, err := ReadPlan(path)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a plan"), 0644))
+	_, err = ReadPlan(path)
+	assert.EqualError(t, err, "'ReadPlan' error: '"+path+"' is not a plan, err: invalid character 'o' in literal null (expecting 'u')")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"version":2}`), 0644))
+	_, err = ReadPlan(path)
+	assert.EqualError(t, err, "'ReadPlan' error: '"+path+"' is a plan of version 2, only version 1 can be applied")
+}
+
+func Test_Recorder(t *testing.T) {
+	var nothing *Recorder
+	nothing.record(RemoteAction{Type: PUSH, File: newFile("b", 1)})
+
+	r := &Recorder{}
+	r.record(RemoteAction{Type: PUSH, File: newFile("b", 1)})
+	r.record(RemoteAction{Type: REMOVE, File: newFile("a", 1)})
+
+	assert.Equal(t, []RemoteAction{
+		{Type: REMOVE, File: newFile("a", 1)},
+		{Type: PUSH, File: newFile("b", 1)},
+	}, r.Actions())
+}
+
+func Test_Applier(t *testing.T) {
+	pack := func(name string) RemoteAction {
+		return RemoteAction{Type: PACK, File: newFile(name, 10), Pack: &Pack{Members: []File{newFile("/home/a", 10)}}}
+	}
+
+	a := NewApplier([]RemoteAction{
+		pack("_packs/home/planned"),
+		{Type: PUSH, File: newFile("/home/b", 10)},
+		{Type: PUSH, File: newFile("/home/b", 10)},
+		{Type: REMOVE, File: newFile("/home/c", 10)},
+	})
+
+	// A new pack is only ever named once it is planned
+	planned, ok := a.take(pack("_packs/home/other"))
+	assert.True(t, ok)
+	assert.Equal(t, pack("_packs/home/planned"), planned)
+
+	_, ok = a.take(RemoteAction{Type: PUSH, File: newFile("/home/b", 10)})
+	assert.True(t, ok)
+
+	// The same action can only be applied as often as it was planned
+	_, ok = a.take(RemoteAction{Type: PUSH, File: newFile("/home/b", 10)})
+	assert.True(t, ok)
+	_, ok = a.take(RemoteAction{Type: PUSH, File: newFile("/home/b", 10)})
+	assert.False(t, ok)
+
+	_, ok = a.take(RemoteAction{Type: REMOVE, File: newFile("/home/c", 11)})
+	assert.False(t, ok)
+
+	assert.Equal(t, []RemoteAction{{Type: REMOVE, File: newFile("/home/c", 10)}}, a.Refused())
+	assert.Equal(t, 2, a.Ignored())
+}
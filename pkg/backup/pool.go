@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"context"
+	"sync"
+)
+
+// runPool runs every task with at most size of them in flight at once. The
+// first task to fail cancels the context handed to the others and its error
+// is the one returned, once every started task has returned.
+func runPool(ctx context.Context, size int, tasks []func(context.Context) error) error {
+	if size < 1 {
+		size = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	slots := make(chan struct{}, size)
+
+	for _, task := range tasks {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		// Don't bother starting anything else once a task has failed
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(task func(context.Context) error) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := task(ctx); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(task)
+	}
+
+	wg.Wait()
+
+	if firstErr == nil {
+		return ctx.Err()
+	}
+
+	return firstErr
+}
@@ -0,0 +1,65 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_runPool_RunsEveryTask(t *testing.T) {
+	var called int32
+
+	task := func(context.Context) error {
+		atomic.AddInt32(&called, 1)
+		return nil
+	}
+
+	err := runPool(context.Background(), 2, []func(context.Context) error{task, task, task})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), called)
+}
+
+func Test_runPool_SizeBelowOneRunsOneAtATime(t *testing.T) {
+	var called int32
+
+	task := func(context.Context) error {
+		atomic.AddInt32(&called, 1)
+		return nil
+	}
+
+	err := runPool(context.Background(), 0, []func(context.Context) error{task, task})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), called)
+}
+
+func Test_runPool_StopsStartingTasksAfterFailure(t *testing.T) {
+	expectedErr := errors.New("asplode")
+	called := false
+
+	err := runPool(context.Background(), 1, []func(context.Context) error{
+		func(context.Context) error { return expectedErr },
+		func(context.Context) error {
+			called = true
+			return nil
+		},
+	})
+
+	assert.Equal(t, expectedErr, err)
+	assert.False(t, called)
+}
+
+func Test_runPool_ReturnsParentContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := runPool(ctx, 1, []func(context.Context) error{
+		func(context.Context) error { return nil },
+	})
+
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,43 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// probePrefix is where Preflight writes its probe objects
+const probePrefix = "_preflight/"
+
+// Preflight checks that s can be written to, listed and deleted from by
+// doing all three with a small probe object. It is meant to be run before
+// anything is backed up, a bucket with the wrong permissions would
+// otherwise fail every single action on its own.
+func Preflight(ctx context.Context, s Storage) error {
+	key := probePrefix + randomID()
+	body := "s3-personal-backup preflight probe"
+
+	if err := s.Put(ctx, key, strings.NewReader(body), int64(len(body)), PutOptions{ContentType: "text/plain"}); err != nil {
+		return fmt.Errorf("'Preflight' error: unable to write to storage, err: %s", err)
+	}
+
+	listed := false
+	err := eachObject(ctx, s, key, ListOptions{}, func(o Object) error {
+		listed = listed || o.Key == key
+		return nil
+	})
+
+	if err != nil {
+		err = fmt.Errorf("'Preflight' error: unable to list storage, err: %s", err)
+	} else if !listed {
+		err = fmt.Errorf("'Preflight' error: probe object '%s' was written but is not listed", key)
+	}
+
+	// The probe is removed no matter what, it shouldn't be left behind
+	// because the listing failed
+	if rErr := s.Remove(ctx, key); rErr != nil && err == nil {
+		err = fmt.Errorf("'Preflight' error: unable to delete from storage, err: %s", rErr)
+	}
+
+	return err
+}
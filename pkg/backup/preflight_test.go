@@ -0,0 +1,95 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// probeStorage remembers what was put and lists it back, like a bucket
+// with every permission would
+func probeStorage() (*testStorage, *[]string) {
+	s := newTestStorage()
+	removed := make([]string, 0)
+
+	var stored Object
+	s.put = func(_ context.Context, key string, r io.Reader, size int64, _ PutOptions) error {
+		body, _ := ioutil.ReadAll(r)
+		stored = Object{Key: key, Size: int64(len(body))}
+		return nil
+	}
+	s.list = func(ctx context.Context, prefix string, _ ListOptions, out chan<- Object) error {
+		if strings.HasPrefix(stored.Key, prefix) {
+			return SendObject(ctx, out, stored)
+		}
+		return nil
+	}
+	s.remove = func(_ context.Context, key string) error {
+		removed = append(removed, key)
+		return nil
+	}
+
+	return s, &removed
+}
+
+func Test_Preflight_Happy(t *testing.T) {
+	s, removed := probeStorage()
+
+	require.NoError(t, Preflight(context.Background(), s))
+
+	require.Len(t, *removed, 1)
+	assert.True(t, strings.HasPrefix((*removed)[0], probePrefix))
+}
+
+func Test_Preflight_ErrorOnPut(t *testing.T) {
+	s, removed := probeStorage()
+	s.put = func(context.Context, string, io.Reader, int64, PutOptions) error {
+		return errors.New("access denied")
+	}
+
+	err := Preflight(context.Background(), s)
+
+	assert.Equal(t, errors.New("'Preflight' error: unable to write to storage, err: access denied"), err)
+	assert.Empty(t, *removed)
+}
+
+func Test_Preflight_ErrorOnList(t *testing.T) {
+	s, removed := probeStorage()
+	s.list = func(context.Context, string, ListOptions, chan<- Object) error {
+		return errors.New("access denied")
+	}
+
+	err := Preflight(context.Background(), s)
+
+	assert.Equal(t, errors.New("'Preflight' error: unable to list storage, err: access denied"), err)
+	assert.Len(t, *removed, 1)
+}
+
+func Test_Preflight_ErrorProbeNotListed(t *testing.T) {
+	s, removed := probeStorage()
+	s.list = func(context.Context, string, ListOptions, chan<- Object) error {
+		return nil
+	}
+
+	err := Preflight(context.Background(), s)
+
+	require.Error(t, err)
+	assert.Equal(t, "'Preflight' error: probe object '"+(*removed)[0]+"' was written but is not listed", err.Error())
+}
+
+func Test_Preflight_ErrorOnRemove(t *testing.T) {
+	s, _ := probeStorage()
+	s.remove = func(context.Context, string) error {
+		return errors.New("access denied")
+	}
+
+	err := Preflight(context.Background(), s)
+
+	assert.Equal(t, errors.New("'Preflight' error: unable to delete from storage, err: access denied"), err)
+}
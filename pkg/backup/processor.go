@@ -1,119 +1,463 @@
 package backup
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"sync"
+
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
 )
 
+// streamBuffer is how far a gatherer can get ahead of the comparison. It is
+// what keeps memory flat no matter how many files are being backed up.
+const streamBuffer = 1000
+
 type processor struct {
 	localGatherers []FileGatherer
-	remoteGatherer FileGatherer
+	remoteGatherer PrefixGatherer
+	gatherWorkers  int
+	moves          *moveDetector
+	packStore      PrefixGatherer
+	packs          *packPlanner
 	logger         backupLogger
 	wg             *sync.WaitGroup
 	remoteActions  chan<- RemoteAction
+
+	// destination is what the spans of this processor are traced with
+	destination string
+
+	// recorder records every action that is queued, for a plan. applier
+	// only lets through the actions of a plan that is being applied.
+	recorder *Recorder
+	applier  *Applier
 }
 
 func NewProcessor(
 	localGatherers []FileGatherer,
-	remoteGatherer FileGatherer,
+	remoteGatherer PrefixGatherer,
+	gatherWorkers int,
+	detectMoves bool,
 	log backupLogger,
 	wg *sync.WaitGroup,
 	rac chan<- RemoteAction,
 ) processor {
+	var moves *moveDetector
+	if detectMoves {
+		moves = newMoveDetector()
+	}
+
 	return processor{
 		localGatherers: localGatherers,
 		remoteGatherer: remoteGatherer,
+		gatherWorkers:  gatherWorkers,
+		moves:          moves,
 		logger:         log,
 		wg:             wg,
 		remoteActions:  rac,
 	}
 }
 
-func (p processor) Process() (err error) {
-	//TODO should I do both gathering in separate goroutines here, like I do with the section below?
-	localFiles, remoteFiles, err := p.runGatherers()
-	if err != nil {
-		return err
+// WithPacks has files smaller than threshold packed together, into packs
+// of up to maxSize, instead of being pushed one by one. The files already
+// packed are gathered from store.
+func (p processor) WithPacks(store PrefixGatherer, threshold, maxSize int64) processor {
+	p.packStore = store
+	p.packs = newPackPlanner(threshold, maxSize)
+	return p
+}
+
+// WithDestination has the spans of the processor say which destination
+// they are for, a run can have several
+func (p processor) WithDestination(name string) processor {
+	p.destination = name
+	return p
+}
+
+// WithRecorder has every action that is queued recorded in r as well, to
+// make a plan of them
+func (p processor) WithRecorder(r *Recorder) processor {
+	p.recorder = r
+	return p
+}
+
+// WithPlan only queues the actions that a is applying, exactly the way they
+// were planned. The planned actions that aren't come across are logged as
+// failed once the comparison is done.
+func (p processor) WithPlan(a *Applier) processor {
+	p.applier = a
+	return p
+}
+
+// gatherError remembers which side of the comparison a failed
+// gatherer was on so that the log message can say so
+type gatherError struct {
+	source string
+	err    error
+}
+
+func (e gatherError) Error() string {
+	return e.err.Error()
+}
+
+// Process compares each target directory against the matching prefix on the
+// remote, with no more than gatherWorkers directories in flight at once.
+// Actions are queued as soon as a file has been decided on so uploading
+// starts while the walks are still going. The first failure stops every
+// other directory and is returned once they have all wound down.
+//
+// When moves are being detected, new and missing files are held back until
+// every directory is done, or there are too many of them, so that they can
+// be paired up with each other.
+// The same goes for small files when packing, which are packed at the end.
+//
+// Cancelling ctx stops the comparison and nothing more is queued, not even
+// what was held back. Whatever was already queued is up to the workers.
+func (p processor) Process(ctx context.Context) error {
+	return Mirror{p}.Process(ctx)[0]
+}
+
+// resolve queues whatever was held back until every directory was done.
+// Anything of a plan that didn't come up by then never will.
+func (p processor) resolve(ctx context.Context) error {
+	if p.moves != nil {
+		if err := p.queue(ctx, p.moves.resolve(p.logUnresolved)); err != nil {
+			return err
+		}
 	}
 
-	p.wg.Add(2)
-	go p.processLocalVsRemote(localFiles, remoteFiles)
-	go p.processRemoteVsLocal(localFiles, remoteFiles)
+	if p.packs != nil {
+		if err := p.queue(ctx, p.packs.resolve()); err != nil {
+			return err
+		}
+	}
 
-	return
+	if p.applier != nil {
+		p.logRefused()
+	}
+
+	return nil
 }
 
-func (p processor) runGatherers() (localFiles, remoteFiles FileData, err error) {
-	//TODO These should be run in parallel. Only return when both are done without errors
-	localFiles, err = p.runLocalGatherers(p.localGatherers)
-	if err != nil {
+// logRefused logs every action of the plan that wasn't applied, what it was
+// based on changed since it was planned
+func (p processor) logRefused() {
+	for _, action := range p.applier.Refused() {
 		p.logger.Error(LogEntry{
-			Message: fmt.Sprintf("error returned while gathering local files, err: %s", err),
+			Message:    "not applied, the local or remote file changed since it was planned",
+			File:       action.File.Name,
+			ActionType: action.Type,
 		})
+	}
+}
 
-		return
+func (p processor) queue(ctx context.Context, actions []RemoteAction) error {
+	for _, action := range actions {
+		if err := p.send(ctx, action); err != nil {
+			return err
+		}
 	}
 
-	remoteFiles, err = p.remoteGatherer.Gather()
-	if err != nil {
-		p.logger.Error(LogEntry{
-			Message: fmt.Sprintf("error returned while gathering remote files, err: %s", err),
-		})
+	return nil
+}
+
+func (p processor) logUnresolved(f File, err error) {
+	p.logger.Error(LogEntry{
+		Message: fmt.Sprintf("unable to check whether %s was moved, err: %s", f, err),
+		File:    f.Name,
+	})
+}
+
+// processTarget compares the files gathered from root, which are streamed
+// in by the caller, against the remote
+func (p processor) processTarget(ctx context.Context, root string, localFiles *fileStream) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		return
+	prefix := remotePrefix(root)
+	destination := trace.Attr("destination", p.destination)
+
+	ctx, span := trace.Start(ctx, "compare", destination, trace.Attr("target_dir", root))
+
+	gatherRemote := func(ctx context.Context, out chan<- File) error {
+		ctx, span := trace.Start(ctx, "list", destination, trace.Attr("prefix", prefix))
+		err := p.remoteGatherer.Gather(ctx, prefix, out)
+		span.End(err)
+
+		return err
 	}
 
-	return
+	if p.packs != nil {
+		gatherRemote = mergeGather(gatherRemote, func(ctx context.Context, out chan<- File) error {
+			return p.packStore.Gather(ctx, prefix, out)
+		})
+	}
+
+	remoteFiles := startStream(ctx, "remote", gatherRemote)
+
+	err := p.compare(ctx, root, localFiles, remoteFiles)
+
+	// A comparison that gave up early leaves the listing going
+	cancel()
+	remoteFiles.stop()
+
+	span.End(err)
+
+	return err
 }
 
-func (p processor) runLocalGatherers(localGatherers []FileGatherer) (FileData, error) {
-	results := make([]map[Filename]File, 0)
+// compare walks both sorted streams side by side. A key that is only
+// on the local side, or differs, is pushed. A key that is only on the
+// remote side is removed. Neither side is ever held in memory.
+func (p processor) compare(ctx context.Context, root string, local, remote *fileStream) error {
+	if err := local.advance(); err != nil {
+		return err
+	}
+
+	if err := remote.advance(); err != nil {
+		return err
+	}
+
+	for local.ok || remote.ok {
+		var err error
+
+		switch {
+		case !remote.ok || (local.ok && local.head.Name < remote.head.Name):
+			err = p.pushNew(ctx, root, local.head)
+			if err == nil {
+				err = local.advance()
+			}
+		case !local.ok || remote.head.Name < local.head.Name:
+			err = p.removeMissing(ctx, remote.head)
+			if err == nil {
+				err = remote.advance()
+			}
+		default:
+			err = p.update(ctx, local.head, remote.head)
+
+			if err == nil {
+				err = local.advance()
+			}
+
+			if err == nil {
+				err = remote.advance()
+			}
+		}
 
-	for _, g := range localGatherers {
-		localFiles, err := g.Gather()
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
 
-		results = append(results, localFiles)
+	return nil
+}
+
+// update handles a file that is on both sides. A packed file stays packed
+// for as long as it is small enough, a file that isn't packed stays that way.
+func (p processor) update(ctx context.Context, local, remote File) error {
+	if remote.NotOwned {
+		p.skipNotOwned(remote, PUSH)
+		return nil
 	}
 
-	combinedResults := make(FileData)
-	for _, r := range results {
-		for k, v := range r {
-			combinedResults[k] = v
+	if remote.Pack == "" {
+		if local.Equal(remote) {
+			return nil
 		}
+
+		return p.send(ctx, RemoteAction{Type: PUSH, File: local, Remote: remote})
 	}
 
-	return combinedResults, nil
+	switch {
+	case local.Equal(remote):
+		p.packs.keep(local, remote.Pack)
+		return nil
+	case p.packs.wants(local):
+		p.packs.change(local, remote.Pack)
+		return nil
+	default:
+		p.packs.drop(remote)
+		return p.send(ctx, RemoteAction{Type: PUSH, File: local, Remote: remote})
+	}
 }
 
-func (p processor) processLocalVsRemote(local, remote FileData) {
-	defer p.wg.Done()
+func (p processor) pushNew(ctx context.Context, root string, f File) error {
+	if p.packs != nil && p.packs.wants(f) {
+		p.packs.add(root, f)
+		return nil
+	}
 
-	for lkey, lfile := range local {
-		rfile, found := remote[lkey]
-		if !found || !lfile.Equal(rfile) {
-			p.wg.Add(1)
-			p.remoteActions <- RemoteAction{
-				Type: PUSH,
-				File: lfile,
-			}
+	if p.moves != nil {
+		return p.queue(ctx, p.moves.addLocal(f, p.logUnresolved))
+	}
+
+	return p.send(ctx, RemoteAction{Type: PUSH, File: f})
+}
+
+func (p processor) removeMissing(ctx context.Context, f File) error {
+	if f.NotOwned {
+		p.skipNotOwned(f, REMOVE)
+		return nil
+	}
+
+	if f.Pack != "" {
+		p.packs.drop(f)
+		return nil
+	}
+
+	if p.moves != nil {
+		return p.queue(ctx, p.moves.addRemote(f))
+	}
+
+	return p.send(ctx, RemoteAction{Type: REMOVE, File: f})
+}
+
+// skipNotOwned logs that f is left alone, instead of what would have been
+// done to it if it were owned
+func (p processor) skipNotOwned(f File, actionType ActionType) {
+	p.logger.Info(LogEntry{
+		Message:    fmt.Sprintf("%s left alone on remote, it is %s", f, ErrNotOwned),
+		File:       f.Name,
+		ActionType: actionType,
+		Size:       f.Size,
+		Skipped:    true,
+	})
+}
+
+func (p processor) send(ctx context.Context, action RemoteAction) error {
+	if p.applier != nil {
+		planned, ok := p.applier.take(action)
+		if !ok {
+			return nil
 		}
+
+		action = planned
+	}
+
+	p.recorder.record(action)
+	p.wg.Add(1)
+
+	select {
+	case p.remoteActions <- action:
+		return nil
+	case <-ctx.Done():
+		p.wg.Done()
+		return ctx.Err()
 	}
 }
 
-func (p processor) processRemoteVsLocal(local, remote FileData) {
-	defer p.wg.Done()
+// remotePrefix is the prefix that every key backed up from root starts with
+func remotePrefix(root string) string {
+	return strings.TrimSuffix(root, "/") + "/"
+}
+
+// mergeGather gathers from a and b at the same time and sends on the files
+// from both in key order. A key that is in both is sent twice, the one from
+// b first, so that the comparison treats the other as not being local.
+func mergeGather(a, b func(context.Context, chan<- File) error) func(context.Context, chan<- File) error {
+	return func(ctx context.Context, out chan<- File) error {
+		ctx, cancel := context.WithCancel(ctx)
+
+		as := startStream(ctx, "", a)
+		bs := startStream(ctx, "", b)
+
+		defer func() {
+			cancel()
+			as.stop()
+			bs.stop()
+		}()
 
-	for rkey, rfile := range remote {
-		_, found := local[rkey]
-		if !found {
-			p.wg.Add(1)
-			p.remoteActions <- RemoteAction{
-				Type: REMOVE,
-				File: rfile,
+		next := func(s *fileStream) error {
+			if err := s.advance(); err != nil {
+				return err.(gatherError).err
 			}
+
+			return nil
+		}
+
+		if err := next(as); err != nil {
+			return err
 		}
+
+		if err := next(bs); err != nil {
+			return err
+		}
+
+		for as.ok || bs.ok {
+			s := as
+			if !as.ok || (bs.ok && bs.head.Name <= as.head.Name) {
+				s = bs
+			}
+
+			if err := sendFile(ctx, out, s.head); err != nil {
+				return err
+			}
+
+			if err := next(s); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// fileStream is one side of the comparison. The gatherer runs in its own
+// goroutine and head holds the next file, if ok is set.
+type fileStream struct {
+	source string
+	files  <-chan File
+	errc   <-chan error
+
+	head File
+	ok   bool
+
+	// finished is set once the gatherer's error has been taken
+	finished bool
+}
+
+func startStream(ctx context.Context, source string, gather func(context.Context, chan<- File) error) *fileStream {
+	files := make(chan File, streamBuffer)
+	errc := make(chan error, 1)
+
+	go func() {
+		err := gather(ctx, files)
+		close(files)
+		errc <- err
+	}()
+
+	return &fileStream{
+		source: source,
+		files:  files,
+		errc:   errc,
+	}
+}
+
+// advance moves on to the next file. When the stream runs dry it makes sure
+// the gatherer actually finished, a half finished local walk must never be
+// mistaken for files having been deleted.
+func (s *fileStream) advance() error {
+	s.head, s.ok = <-s.files
+	if s.ok {
+		return nil
+	}
+
+	s.finished = true
+	if err := <-s.errc; err != nil {
+		return gatherError{source: s.source, err: err}
+	}
+
+	return nil
+}
+
+// stop waits for the gatherer to be done, whatever it still sends is
+// dropped. Its context has to be cancelled first unless it ran dry.
+func (s *fileStream) stop() {
+	for range s.files {
+	}
+
+	if !s.finished {
+		s.finished = true
+		<-s.errc
 	}
 }
@@ -1,9 +1,13 @@
 package backup
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -15,47 +19,52 @@ func TestProcessorTestSuite(t *testing.T) {
 type ProcessorTestSuite struct {
 	suite.Suite
 
-	localGatherCalled  int
-	remoteGatherCalled bool
+	localGatherCalled  int32
+	remoteGatherCalled int32
+	gatherWorkers      int
+	detectMoves        bool
 
 	localGatherers []FileGatherer
-	remoteGatherer FileGatherer
+	remoteGatherer PrefixGatherer
 
-	localGatherFunc  func() (FileData, error)
-	remoteGatherFunc func() (FileData, error)
+	localGatherFunc  func(context.Context, chan<- File) error
+	remoteGatherFunc func(context.Context, string, chan<- File) error
 
-	localData, remoteData FileData
+	localData, remoteData []File
 
 	logInfoCalled, logErrorCalled bool
 	logger                        testLogger
 
 	wg           *sync.WaitGroup
 	remoteAction chan RemoteAction
+
+	actionsLock sync.Mutex
+	actions     []RemoteAction
 }
 
 func (s *ProcessorTestSuite) SetupTest() {
 	s.localGatherCalled = 0
-	s.remoteGatherCalled = false
-
-	s.localData = make(FileData)
-	s.localData["local1"] = newFile("local1", 100)
+	s.remoteGatherCalled = 0
+	s.gatherWorkers = 5
+	s.detectMoves = false
 
-	s.remoteData = make(FileData)
-	s.remoteData["remote1"] = newFile("remote1", 100)
+	s.localData = []File{newFile("/local1/file1", 100)}
+	s.remoteData = []File{newFile("/local1/file2", 100)}
 
-	s.localGatherFunc = func() (FileData, error) {
-		s.localGatherCalled++
-		return s.localData, nil
+	s.localGatherFunc = func(ctx context.Context, out chan<- File) error {
+		atomic.AddInt32(&s.localGatherCalled, 1)
+		return sendFiles(ctx, out, s.localData...)
 	}
 
-	s.localGatherers = make([]FileGatherer, 0)
-	s.localGatherers = append(s.localGatherers, testGatherer{gather: s.localGatherFunc})
+	s.localGatherers = []FileGatherer{
+		testGatherer{root: "/local1", gather: s.localGatherFunc},
+	}
 
-	s.remoteGatherFunc = func() (FileData, error) {
-		s.remoteGatherCalled = true
-		return s.remoteData, nil
+	s.remoteGatherFunc = func(ctx context.Context, _ string, out chan<- File) error {
+		atomic.AddInt32(&s.remoteGatherCalled, 1)
+		return sendFiles(ctx, out, s.remoteData...)
 	}
-	s.remoteGatherer = testGatherer{gather: s.remoteGatherFunc}
+	s.remoteGatherer = testPrefixGatherer{gather: s.remoteGatherFunc}
 
 	s.logInfoCalled = false
 	s.logErrorCalled = false
@@ -71,294 +80,947 @@ func (s *ProcessorTestSuite) SetupTest() {
 
 	s.wg = &sync.WaitGroup{}
 	s.remoteAction = make(chan RemoteAction, 5)
+	s.actions = nil
 }
 
-func (s ProcessorTestSuite) processor() processor {
-	return NewProcessor(s.localGatherers, s.remoteGatherer, s.logger, s.wg, s.remoteAction)
+func (s *ProcessorTestSuite) processor() processor {
+	return NewProcessor(s.localGatherers, s.remoteGatherer, s.gatherWorkers, s.detectMoves, s.logger, s.wg, s.remoteAction)
 }
 
-func (s *ProcessorTestSuite) Test_Process_CallsLocalGather_OneLocalGather() {
+// collectActions stands in for the workers, remembering every action queued
+func (s *ProcessorTestSuite) collectActions() {
+	// The next test replaces both, this one's are kept
+	remoteAction, wg := s.remoteAction, s.wg
+
 	go func() {
-		for {
-			<-s.remoteAction
-			s.wg.Done()
+		for action := range remoteAction {
+			s.actionsLock.Lock()
+			s.actions = append(s.actions, action)
+			s.actionsLock.Unlock()
+			wg.Done()
 		}
 	}()
+}
+
+func (s *ProcessorTestSuite) countActions(actionType ActionType) int {
+	s.actionsLock.Lock()
+	defer s.actionsLock.Unlock()
+
+	count := 0
+	for _, a := range s.actions {
+		if a.Type == actionType {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (s *ProcessorTestSuite) Test_Process_CallsLocalGather_OneLocalGather() {
+	s.collectActions()
 
-	s.processor().Process()
-	s.Equal(1, s.localGatherCalled)
+	s.Require().NoError(s.processor().Process(context.Background()))
 	s.wg.Wait()
+
+	s.Equal(int32(1), s.localGatherCalled)
 }
 
 func (s *ProcessorTestSuite) Test_Process_CallsLocalGather_MultipleLocalGathers() {
-	go func() {
-		for {
-			<-s.remoteAction
-			s.wg.Done()
-		}
-	}()
+	s.collectActions()
 
 	s.localGatherers = []FileGatherer{
-		testGatherer{
-			gather: s.localGatherFunc,
-		},
-		testGatherer{
-			gather: s.localGatherFunc,
+		testGatherer{root: "/local1", gather: s.localGatherFunc},
+		testGatherer{root: "/local2", gather: s.localGatherFunc},
+	}
+
+	s.Require().NoError(s.processor().Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal(int32(2), s.localGatherCalled)
+	s.Equal(int32(2), s.remoteGatherCalled)
+}
+
+func (s *ProcessorTestSuite) Test_Process_CallsRemoteGather_WithTargetPrefix() {
+	s.collectActions()
+
+	prefixes := make(chan string, 2)
+	s.remoteGatherer = testPrefixGatherer{
+		gather: func(_ context.Context, prefix string, _ chan<- File) error {
+			prefixes <- prefix
+			return nil
 		},
 	}
 
-	s.processor().Process()
-	s.Equal(2, s.localGatherCalled)
+	s.localGatherers = []FileGatherer{
+		testGatherer{root: "/local1", gather: s.localGatherFunc},
+	}
+
+	s.Require().NoError(s.processor().Process(context.Background()))
 	s.wg.Wait()
+
+	s.Equal("/local1/", <-prefixes)
 }
 
 func (s *ProcessorTestSuite) Test_Process_ReturnsErrorFromLocalGather() {
 	expectedErr := errors.New("asplode!")
-	s.localGatherFunc = func() (FileData, error) {
-		s.localGatherCalled++
-		return nil, expectedErr
+	s.localGatherers = []FileGatherer{
+		testGatherer{
+			root: "/local1",
+			gather: func(context.Context, chan<- File) error {
+				atomic.AddInt32(&s.localGatherCalled, 1)
+				return expectedErr
+			},
+		},
 	}
 
-	s.localGatherers = make([]FileGatherer, 0)
-	s.localGatherers = append(s.localGatherers, testGatherer{gather: s.localGatherFunc})
-
 	s.logger.logError = func(i LogEntry) {
 		s.logErrorCalled = true
 		s.Equal(LogEntry{Message: "error returned while gathering local files, err: asplode!"}, i)
 	}
 
-	err := s.processor().Process()
+	err := s.processor().Process(context.Background())
 
-	s.Require().Equal(1, s.localGatherCalled)
+	s.Require().Equal(int32(1), s.localGatherCalled)
 	s.Require().Error(err)
 	s.Equal(expectedErr, err)
 	s.True(s.logErrorCalled)
 	s.False(s.logInfoCalled)
 }
 
-func (s *ProcessorTestSuite) Test_Process_CallsRatherGather() {
-	go func() {
-		for {
-			<-s.remoteAction
-			s.wg.Done()
-		}
-	}()
-
-	s.processor().Process()
-	s.True(s.remoteGatherCalled)
-	s.wg.Wait()
-}
-
 func (s *ProcessorTestSuite) Test_Process_ReturnsErrorFromRemoteGather() {
 	expectedErr := errors.New("asplode!")
-	s.remoteGatherFunc = func() (FileData, error) {
-		s.remoteGatherCalled = true
-		return nil, expectedErr
+	s.remoteGatherer = testPrefixGatherer{
+		gather: func(context.Context, string, chan<- File) error {
+			atomic.AddInt32(&s.remoteGatherCalled, 1)
+			return expectedErr
+		},
 	}
 
-	s.remoteGatherer = testGatherer{gather: s.remoteGatherFunc}
-
 	s.logger.logError = func(i LogEntry) {
 		s.logErrorCalled = true
 		s.Equal(LogEntry{Message: "error returned while gathering remote files, err: asplode!"}, i)
 	}
 
-	err := s.processor().Process()
+	err := s.processor().Process(context.Background())
 
 	s.Error(err)
-	s.True(s.remoteGatherCalled)
+	s.Equal(int32(1), s.remoteGatherCalled)
 	s.Equal(expectedErr, err)
 	s.True(s.logErrorCalled)
 	s.False(s.logInfoCalled)
 }
 
-func (s *ProcessorTestSuite) Test_processLocalVsRemote_InBoth_Equal() {
-	local := FileData{"file": newFile("file", 100)}
-	remote := FileData{"file": newFile("file", 100)}
+func (s *ProcessorTestSuite) Test_Process_FailedLocalGatherNeverRemoves() {
+	s.collectActions()
 
-	s.wg.Add(1)
-	s.processor().processLocalVsRemote(local, remote)
+	s.localGatherers = []FileGatherer{
+		testGatherer{
+			root: "/local1",
+			gather: func(ctx context.Context, out chan<- File) error {
+				sendFiles(ctx, out, newFile("/local1/file1", 100))
+				return errors.New("asplode!")
+			},
+		},
+	}
 
-	s.False(s.logErrorCalled)
-	s.False(s.logInfoCalled)
+	s.remoteData = []File{
+		newFile("/local1/file1", 100),
+		newFile("/local1/file2", 100),
+		newFile("/local1/file3", 100),
+	}
+
+	err := s.processor().Process(context.Background())
+	s.wg.Wait()
+
+	s.Error(err)
+	s.Equal(0, s.countActions(REMOVE))
 }
 
-func (s *ProcessorTestSuite) Test_processLocalVsRemote_InBoth_NotEqual() {
-	local := FileData{"file": newFile("file", 100)}
-	remote := FileData{"file": newFile("file", 101)}
+func (s *ProcessorTestSuite) Test_Process_QueuesActionsBeforeGatheringFinishes() {
+	s.remoteAction = make(chan RemoteAction)
+	queued := make(chan struct{})
 
 	go func() {
-		action := <-s.remoteAction
-		s.Equal(ActionType(PUSH), action.Type)
-		s.Equal("file", action.File.Name)
+		<-s.remoteAction
+		close(queued)
+		s.wg.Done()
+
+		// The trailing remove is only decided once the walk is over
+		<-s.remoteAction
 		s.wg.Done()
 	}()
 
-	s.wg.Add(1)
-	s.processor().processLocalVsRemote(local, remote)
+	s.localGatherers = []FileGatherer{
+		testGatherer{
+			root: "/local1",
+			gather: func(ctx context.Context, out chan<- File) error {
+				sendFiles(ctx, out, newFile("/local1/file1", 100))
+
+				// The walk can't finish until the first push is already queued
+				select {
+				case <-queued:
+					return nil
+				case <-time.After(time.Second):
+					return errors.New("push was not queued while still gathering")
+				}
+			},
+		},
+	}
+
+	s.remoteData = []File{newFile("/local1/file2", 100)}
+
+	s.NoError(s.processor().Process(context.Background()))
 	s.wg.Wait()
 }
 
-func (s *ProcessorTestSuite) Test_processLocalVsRemote_InLocal_NotInRemote() {
-	local := FileData{"file": newFile("file", 100)}
-	remote := FileData{}
+func (s *ProcessorTestSuite) Test_Process_ErrorCancelsOtherTargets() {
+	s.collectActions()
 
-	go func() {
-		action := <-s.remoteAction
-		s.Equal(ActionType(PUSH), action.Type)
-		s.Equal("file", action.File.Name)
-		s.wg.Done()
-	}()
+	expectedErr := errors.New("asplode!")
+	cancelled := false
 
-	s.wg.Add(1)
-	s.processor().processLocalVsRemote(local, remote)
-	s.wg.Wait()
+	s.localGatherers = []FileGatherer{
+		testGatherer{
+			root: "/local1",
+			gather: func(ctx context.Context, out chan<- File) error {
+				<-ctx.Done()
+				cancelled = true
+				return ctx.Err()
+			},
+		},
+		testGatherer{
+			root: "/local2",
+			gather: func(context.Context, chan<- File) error {
+				return expectedErr
+			},
+		},
+	}
+
+	s.logger.logError = func(i LogEntry) {
+		s.logErrorCalled = true
+		s.Equal(LogEntry{Message: "error returned while gathering local files, err: asplode!"}, i)
+	}
+
+	err := s.processor().Process(context.Background())
+
+	s.Equal(expectedErr, err)
+	s.True(cancelled)
+	s.True(s.logErrorCalled)
+}
+
+func (s *ProcessorTestSuite) Test_Process_GatherWorkersBoundsConcurrency() {
+	s.collectActions()
+
+	var running, maxRunning int32
+
+	track := func(context.Context, chan<- File) error {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if now <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, now) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}
+
+	s.gatherWorkers = 2
+	s.localGatherers = []FileGatherer{
+		testGatherer{root: "/local1", gather: track},
+		testGatherer{root: "/local2", gather: track},
+		testGatherer{root: "/local3", gather: track},
+		testGatherer{root: "/local4", gather: track},
+	}
+	s.remoteData = nil
+
+	s.Require().NoError(s.processor().Process(context.Background()))
+
+	s.Equal(int32(2), maxRunning)
 }
 
-func (s *ProcessorTestSuite) Test_processRemoteVsLocal_InBoth() {
-	local := FileData{"file": newFile("file", 100)}
-	remote := FileData{"file": newFile("file", 100)}
+func (s *ProcessorTestSuite) Test_gatherError_Error() {
+	err := gatherError{source: "local", err: errors.New("asplode!")}
+	s.Equal("asplode!", err.Error())
+}
 
-	s.wg.Add(1)
-	s.processor().processRemoteVsLocal(local, remote)
+func (s *ProcessorTestSuite) Test_remotePrefix() {
+	s.Equal("/home/user/docs/", remotePrefix("/home/user/docs"))
+	s.Equal("/", remotePrefix("/"))
 }
 
-func (s *ProcessorTestSuite) Test_processRemoteVsLocal_InRemote_NotInLocal() {
-	local := FileData{}
-	remote := FileData{"file": newFile("file", 100)}
+func (s *ProcessorTestSuite) Test_send_StopsWhenCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	go func() {
-		action := <-s.remoteAction
-		s.Equal(ActionType(REMOVE), action.Type)
-		s.Equal("file", action.File.Name)
-		s.wg.Done()
-	}()
+	s.remoteAction = make(chan RemoteAction)
+
+	err := s.processor().send(ctx, RemoteAction{Type: PUSH, File: newFile("file", 100)})
 
-	s.wg.Add(1)
-	s.processor().processRemoteVsLocal(local, remote)
+	s.Equal(context.Canceled, err)
 	s.wg.Wait()
 }
 
-func (s *ProcessorTestSuite) Test_Process_MultipleDifferences_SingleLocal() {
-	local := FileData{
-		"file1": newFile("file1", 100),
-		"file2": newFile("file2", 200),
-		"file3": newFile("file3", 300),
-		"file4": newFile("file4", 400),
-		"file5": newFile("file5", 500),
+func (s *ProcessorTestSuite) Test_compare_InBoth_Equal() {
+	s.localData = []File{newFile("file", 100)}
+	s.remoteData = []File{newFile("file", 100)}
+
+	s.Require().NoError(s.compare())
+
+	s.Empty(s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_compare_InBoth_NotEqual() {
+	s.localData = []File{newFile("file", 100)}
+	s.remoteData = []File{newFile("file", 101)}
+
+	s.Require().NoError(s.compare())
+
+	s.Require().Len(s.actions, 1)
+	s.Equal(ActionType(PUSH), s.actions[0].Type)
+	s.Equal(newFile("file", 100), s.actions[0].File)
+}
+
+func (s *ProcessorTestSuite) Test_compare_InLocal_NotInRemote() {
+	s.localData = []File{newFile("file", 100)}
+	s.remoteData = nil
+
+	s.Require().NoError(s.compare())
+
+	s.Require().Len(s.actions, 1)
+	s.Equal(ActionType(PUSH), s.actions[0].Type)
+	s.Equal("file", s.actions[0].File.Name)
+}
+
+func (s *ProcessorTestSuite) Test_compare_InRemote_NotInLocal() {
+	s.localData = nil
+	s.remoteData = []File{newFile("file", 100)}
+
+	s.Require().NoError(s.compare())
+
+	s.Require().Len(s.actions, 1)
+	s.Equal(ActionType(REMOVE), s.actions[0].Type)
+	s.Equal("file", s.actions[0].File.Name)
+}
+
+func (s *ProcessorTestSuite) Test_compare_Interleaved() {
+	s.localData = []File{
+		newFile("a", 100),
+		newFile("c", 100),
+		newFile("d", 100),
+		newFile("f", 100),
+	}
+	s.remoteData = []File{
+		newFile("b", 100),
+		newFile("c", 100),
+		newFile("d", 101),
+		newFile("e", 100),
 	}
 
-	remote := FileData{
-		"file1": newFile("file1", 100),
-		"file2": newFile("file2", 201),
-		"file3": newFile("file3", 300),
-		"file4": newFile("file4", 400),
-		"file6": newFile("file6", 600),
+	s.Require().NoError(s.compare())
+
+	s.Equal([]RemoteAction{
+		{Type: PUSH, File: newFile("a", 100)},
+		{Type: REMOVE, File: newFile("b", 100)},
+		{Type: PUSH, File: newFile("d", 100), Remote: newFile("d", 101)},
+		{Type: REMOVE, File: newFile("e", 100)},
+		{Type: PUSH, File: newFile("f", 100)},
+	}, s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_compare_LeavesAloneWhatItDoesNotOwn() {
+	storage := &testStorage{
+		list: func(ctx context.Context, _ string, _ ListOptions, out chan<- Object) error {
+			for _, o := range []Object{
+				{Key: "/local1/a", Size: 100, Tags: map[string]string{HostTag: "desktop"}},
+				{Key: "/local1/b", Size: 100, Tags: map[string]string{HostTag: "desktop"}},
+				{Key: "/local1/c", Size: 100, Tags: map[string]string{HostTag: "laptop"}},
+			} {
+				if err := SendObject(ctx, out, o); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
 	}
 
-	s.localGatherFunc = func() (FileData, error) {
-		return local, nil
+	remote, err := NewRemoteFileProcessor(storage)
+	s.Require().NoError(err)
+	remote = remote.WithTagging(ownedTagging())
+
+	s.remoteGatherFunc = remote.Gather
+
+	var skipped []LogEntry
+	s.logger.logInfo = func(e LogEntry) {
+		skipped = append(skipped, e)
 	}
 
-	s.localGatherers = make([]FileGatherer, 0)
-	s.localGatherers = append(s.localGatherers, testGatherer{gather: s.localGatherFunc})
+	// Another host backed up a, which differs here, and b, which isn't here
+	s.localData = []File{newFile("/local1/a", 200)}
+
+	s.Require().NoError(s.compare())
+
+	// c is the only one that is ours
+	s.Equal([]RemoteAction{{Type: REMOVE, File: newFile("/local1/c", 100)}}, s.actions)
+	s.Require().Len(skipped, 2)
+	s.Equal(LogEntry{
+		Message:    "name: '/local1/a' - size: '100' left alone on remote, it is owned by another host or profile",
+		File:       "/local1/a",
+		ActionType: PUSH,
+		Size:       100,
+		Skipped:    true,
+	}, skipped[0])
+	s.Equal(ActionType(REMOVE), skipped[1].ActionType)
+	s.Equal("/local1/b", skipped[1].File)
+	s.True(skipped[1].Skipped)
+}
 
-	s.remoteGatherFunc = func() (FileData, error) {
-		return remote, nil
+func (s *ProcessorTestSuite) Test_compare_ReturnsFirstRemoteError() {
+	expectedErr := errors.New("asplode!")
+	s.remoteGatherFunc = func(context.Context, string, chan<- File) error {
+		return expectedErr
 	}
 
-	s.remoteGatherer = testGatherer{gather: s.remoteGatherFunc}
+	err := s.compare()
 
-	putCalledCnt := 0
-	removeCalledCnt := 0
-	go func() {
-		for {
-			action := <-s.remoteAction
-			if action.Type == PUSH {
-				putCalledCnt++
-			} else if action.Type == REMOVE {
-				removeCalledCnt++
-			} else {
-				s.T().Errorf("Unknown action type")
+	s.Equal(gatherError{source: "remote", err: expectedErr}, err)
+}
+
+func (s *ProcessorTestSuite) Test_compare_StopsWhenSendFails() {
+	s.localData = []File{newFile("a", 100), newFile("c", 100)}
+	s.remoteData = []File{newFile("b", 100), newFile("c", 101)}
+
+	for _, cancelAfter := range []int{0, 1, 2} {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.remoteAction = make(chan RemoteAction)
+
+		go func(n int) {
+			for i := 0; i < n; i++ {
+				<-s.remoteAction
+				s.wg.Done()
 			}
-			s.wg.Done()
-		}
-	}()
+			cancel()
+		}(cancelAfter)
+
+		// Only the comparison is cancelled, the streams are left to run dry
+		err := s.processor().compare(
+			ctx,
+			"/local1",
+			startStream(context.Background(), "local", s.sliceGatherer(s.localData)),
+			startStream(context.Background(), "remote", s.sliceGatherer(s.remoteData)),
+		)
+
+		s.Equal(context.Canceled, err)
+		s.wg.Wait()
+	}
+}
 
-	s.processor().Process()
+func (s *ProcessorTestSuite) Test_Process_MultipleDifferences_SingleLocal() {
+	s.localData = []File{
+		newFile("/local1/file1", 100),
+		newFile("/local1/file2", 200),
+		newFile("/local1/file3", 300),
+		newFile("/local1/file4", 400),
+		newFile("/local1/file5", 500),
+	}
+
+	s.remoteData = []File{
+		newFile("/local1/file1", 100),
+		newFile("/local1/file2", 201),
+		newFile("/local1/file3", 300),
+		newFile("/local1/file4", 400),
+		newFile("/local1/file6", 600),
+	}
+
+	s.collectActions()
 
+	s.Require().NoError(s.processor().Process(context.Background()))
 	s.wg.Wait()
 
-	s.Equal(2, putCalledCnt, "putCalledCnt does not match")
-	s.Equal(1, removeCalledCnt, "removeCalledCnt does not match")
+	s.Equal(2, s.countActions(PUSH), "push count does not match")
+	s.Equal(1, s.countActions(REMOVE), "remove count does not match")
 }
 
 func (s *ProcessorTestSuite) Test_Process_MultipleDifferences_MultipleLocal() {
-	local1 := FileData{
-		"local1/file1": newFile("local1/file1", 100),
-		"local1/file2": newFile("local1/file2", 200),
-		"local1/file3": newFile("local1/file3", 300),
-		"local1/file4": newFile("local1/file4", 400),
-		"local1/file5": newFile("local1/file5", 500),
+	local1 := []File{
+		newFile("/local1/file1", 100),
+		newFile("/local1/file2", 200),
+		newFile("/local1/file3", 300),
+		newFile("/local1/file4", 400),
+		newFile("/local1/file5", 500),
 	}
 
-	local2 := FileData{
-		"local2/file1": newFile("local2/file1", 100),
-		"local2/file2": newFile("local2/file2", 200),
+	local2 := []File{
+		newFile("/local2/file1", 100),
+		newFile("/local2/file2", 200),
 	}
 
-	remote := FileData{
-		"local1/file1": newFile("local1/file1", 100),
-		"local1/file2": newFile("local1/file2", 201),
-		"local1/file3": newFile("local1/file3", 300),
-		"local1/file4": newFile("local1/file4", 400),
-		"local1/file6": newFile("local1/file6", 600),
-		"local2/file1": newFile("local2/file1", 100),
-		"local2/file2": newFile("local2/file2", 201),
-		"local2/file3": newFile("local2/file3", 300),
+	remote := map[string][]File{
+		"/local1/": {
+			newFile("/local1/file1", 100),
+			newFile("/local1/file2", 201),
+			newFile("/local1/file3", 300),
+			newFile("/local1/file4", 400),
+			newFile("/local1/file6", 600),
+		},
+		"/local2/": {
+			newFile("/local2/file1", 100),
+			newFile("/local2/file2", 201),
+			newFile("/local2/file3", 300),
+		},
 	}
 
 	s.localGatherers = []FileGatherer{
-		testGatherer{
-			gather: func() (FileData, error) {
-				return local1, nil
-			},
+		testGatherer{root: "/local1", gather: s.sliceGatherer(local1)},
+		testGatherer{root: "/local2", gather: s.sliceGatherer(local2)},
+	}
+
+	s.remoteGatherer = testPrefixGatherer{
+		gather: func(ctx context.Context, prefix string, out chan<- File) error {
+			return sendFiles(ctx, out, remote[prefix]...)
 		},
+	}
+
+	s.collectActions()
+
+	s.Require().NoError(s.processor().Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal(3, s.countActions(PUSH), "push count does not match")
+	s.Equal(2, s.countActions(REMOVE), "remove count does not match")
+}
+
+func (s *ProcessorTestSuite) Test_Process_DetectMoves_CopiesMovedFiles() {
+	s.detectMoves = true
+
+	s.localGatherers = []FileGatherer{
+		testGatherer{root: "/local1", gather: s.sliceGatherer([]File{
+			newFile("/local1/new/photo1", 100),
+			newFile("/local1/new/photo2", 200),
+			newFile("/local1/other", 300),
+		})},
+	}
+
+	s.remoteData = []File{
+		{Name: "/local1/old/photo1", Size: 100, ETag: "etag1"},
+		{Name: "/local1/old/photo2", Size: 200, ETag: "etag2"},
+		{Name: "/local1/stale", Size: 300, ETag: "etag3"},
+	}
+
+	s.collectActions()
+
+	p := s.processor()
+	p.moves.sameContent = func(local, remote File) (bool, error) {
+		return local.Name != "/local1/other", nil
+	}
+
+	s.Require().NoError(p.Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal([]RemoteAction{
+		{Type: COPY, File: newFile("/local1/new/photo1", 100), Source: File{Name: "/local1/old/photo1", Size: 100, ETag: "etag1"}},
+		{Type: COPY, File: newFile("/local1/new/photo2", 200), Source: File{Name: "/local1/old/photo2", Size: 200, ETag: "etag2"}},
+		{Type: PUSH, File: newFile("/local1/other", 300)},
+		{Type: REMOVE, File: File{Name: "/local1/stale", Size: 300, ETag: "etag3"}},
+	}, s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_DetectMoves_LogsFilesThatCantBeChecked() {
+	s.detectMoves = true
+	s.localData = []File{newFile("/local1/new", 100)}
+	s.remoteData = []File{newFile("/local1/old", 100)}
+
+	s.logger.logError = func(i LogEntry) {
+		s.logErrorCalled = true
+		s.Equal("/local1/new", i.File)
+		s.Contains(i.Message, "unable to check whether name: '/local1/new' - size: '100' was moved, err: asplode!")
+	}
+
+	s.collectActions()
+
+	p := s.processor()
+	p.moves.sameContent = func(local, remote File) (bool, error) {
+		return false, errors.New("asplode!")
+	}
+
+	s.Require().NoError(p.Process(context.Background()))
+	s.wg.Wait()
+
+	s.True(s.logErrorCalled)
+	s.Equal(1, s.countActions(PUSH))
+	s.Equal(1, s.countActions(REMOVE))
+}
+
+func (s *ProcessorTestSuite) Test_Process_DetectMoves_NothingQueuedOnFailure() {
+	s.detectMoves = true
+	s.localGatherers = []FileGatherer{
+		testGatherer{root: "/local1", gather: s.sliceGatherer([]File{newFile("/local1/new", 100)})},
 		testGatherer{
-			gather: func() (FileData, error) {
-				return local2, nil
+			root: "/local2",
+			gather: func(context.Context, chan<- File) error {
+				return errors.New("asplode!")
 			},
 		},
 	}
 
-	s.remoteGatherFunc = func() (FileData, error) {
-		return remote, nil
+	s.collectActions()
+
+	s.Error(s.processor().Process(context.Background()))
+	s.wg.Wait()
+
+	s.Empty(s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_NothingQueuedOnceCancelled() {
+	s.detectMoves = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.collectActions()
+
+	s.Equal(context.Canceled, s.processor().Process(ctx))
+	s.wg.Wait()
+
+	s.Empty(s.actions)
+	s.False(s.logErrorCalled, "being cancelled isn't an error of the destination")
+}
+
+func (s *ProcessorTestSuite) Test_Resolve_StopsWhenCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nothing takes the actions, they can only ever wait
+	s.remoteAction = make(chan RemoteAction)
+
+	s.detectMoves = true
+	p := s.processor()
+	p.moves.addLocal(newFile("/local1/new", 100), nil)
+
+	s.Equal(context.Canceled, p.resolve(ctx))
+
+	p = s.packs()
+	p.packs.add("/local1", newFile("/local1/small", 10))
+
+	s.Equal(context.Canceled, p.resolve(ctx))
+}
+
+func (s *ProcessorTestSuite) packs(packData ...File) processor {
+	p := s.processor().WithPacks(testPrefixGatherer{
+		gather: func(ctx context.Context, prefix string, out chan<- File) error {
+			s.Equal("/local1/", prefix)
+			return sendFiles(ctx, out, packData...)
+		},
+	}, 50, 100)
+
+	id := 0
+	p.packs.newPackID = func() string {
+		id++
+		return fmt.Sprintf("pack%d", id)
+	}
+
+	return p
+}
+
+func (s *ProcessorTestSuite) Test_Process_Packs_PacksSmallNewFiles() {
+	s.localData = []File{
+		newFile("/local1/a", 40),
+		newFile("/local1/b", 40),
+		newFile("/local1/big", 500),
+		newFile("/local1/c", 40),
+	}
+	s.remoteData = nil
+
+	s.collectActions()
+
+	s.Require().NoError(s.packs().Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal([]RemoteAction{
+		{Type: PUSH, File: newFile("/local1/big", 500)},
+		{Type: PACK, File: newFile("_packs/local1/pack1", 80), Pack: &Pack{
+			Members: []File{newFile("/local1/a", 40), newFile("/local1/b", 40)},
+		}},
+		{Type: PACK, File: newFile("_packs/local1/pack2", 40), Pack: &Pack{
+			Members: []File{newFile("/local1/c", 40)},
+		}},
+	}, s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_Packs_LeavesUntouchedPacksAlone() {
+	s.localData = []File{newFile("/local1/a", 10), newFile("/local1/b", 20)}
+	s.remoteData = nil
+
+	s.collectActions()
+
+	p := s.packs(
+		File{Name: "/local1/a", Size: 10, Pack: "_packs/local1/old"},
+		File{Name: "/local1/b", Size: 20, Pack: "_packs/local1/old"},
+	)
+
+	s.Require().NoError(p.Process(context.Background()))
+	s.wg.Wait()
+
+	s.Empty(s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_Packs_RebuildsChangedPacks() {
+	s.localData = []File{
+		newFile("/local1/a", 10),
+		newFile("/local1/b", 25),
+		newFile("/local1/c", 30),
+		newFile("/local1/grown", 80),
+	}
+	s.remoteData = nil
+
+	s.collectActions()
+
+	p := s.packs(
+		File{Name: "/local1/a", Size: 10, Pack: "_packs/local1/old"},
+		File{Name: "/local1/b", Size: 20, Pack: "_packs/local1/old"},
+		File{Name: "/local1/c", Size: 30, Pack: "_packs/local1/other"},
+		File{Name: "/local1/gone", Size: 5, Pack: "_packs/local1/other"},
+		File{Name: "/local1/grown", Size: 8, Pack: "_packs/local1/third"},
+	)
+
+	s.Require().NoError(p.Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal([]RemoteAction{
+		{Type: PUSH, File: newFile("/local1/grown", 80), Remote: File{Name: "/local1/grown", Size: 8, Pack: "_packs/local1/third"}},
+		{Type: PACK, File: newFile("_packs/local1/pack1", 35), Pack: &Pack{
+			Members:  []File{newFile("/local1/a", 10), newFile("/local1/b", 25)},
+			Replaces: []string{"_packs/local1/old"},
+		}},
+		{Type: PACK, File: newFile("_packs/local1/pack2", 30), Pack: &Pack{
+			Members:  []File{newFile("/local1/c", 30)},
+			Replaces: []string{"_packs/local1/other"},
+			Dropped:  []File{{Name: "/local1/gone", Size: 5, Pack: "_packs/local1/other"}},
+		}},
+		{Type: PACK, File: newFile("_packs/local1/pack3", 0), Pack: &Pack{
+			Replaces: []string{"_packs/local1/third"},
+			Dropped:  []File{{Name: "/local1/grown", Size: 8, Pack: "_packs/local1/third"}},
+		}},
+	}, s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_Packs_ChangedPlainFilesStayPlain() {
+	s.localData = []File{newFile("/local1/a", 10)}
+	s.remoteData = []File{newFile("/local1/a", 20)}
+
+	s.collectActions()
+
+	s.Require().NoError(s.packs().Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal([]RemoteAction{{Type: PUSH, File: newFile("/local1/a", 10), Remote: newFile("/local1/a", 20)}}, s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_Packs_RemovesPlainCopyOfPackedFile() {
+	s.detectMoves = true
+	s.localData = []File{newFile("/local1/a", 10)}
+	s.remoteData = []File{newFile("/local1/a", 10)}
+
+	s.collectActions()
+
+	p := s.packs(File{Name: "/local1/a", Size: 10, Pack: "_packs/local1/old"})
+
+	s.Require().NoError(p.Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal([]RemoteAction{{Type: REMOVE, File: newFile("/local1/a", 10)}}, s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_Packs_ReturnsErrorFromPackGather() {
+	s.collectActions()
+
+	p := s.processor().WithPacks(testPrefixGatherer{
+		gather: func(context.Context, string, chan<- File) error {
+			return errors.New("asplode!")
+		},
+	}, 50, 100)
+
+	s.Equal(errors.New("asplode!"), p.Process(context.Background()))
+	s.wg.Wait()
+
+	s.Empty(s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_Plan_RecordsEveryAction() {
+	s.localData = []File{newFile("/local1/b", 100), newFile("/local1/c", 100)}
+	s.remoteData = []File{newFile("/local1/a", 100), newFile("/local1/c", 50)}
+
+	s.collectActions()
+
+	r := &Recorder{}
+	s.Require().NoError(s.processor().WithRecorder(r).Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal([]RemoteAction{
+		{Type: REMOVE, File: newFile("/local1/a", 100)},
+		{Type: PUSH, File: newFile("/local1/b", 100)},
+		{Type: PUSH, File: newFile("/local1/c", 100), Remote: newFile("/local1/c", 50)},
+	}, r.Actions())
+	s.Equal(r.Actions(), s.actions)
+}
+
+func (s *ProcessorTestSuite) Test_Process_Plan_AppliesOnlyWhatIsPlanned() {
+	s.localData = []File{newFile("/local1/b", 100), newFile("/local1/c", 100), newFile("/local1/new", 10)}
+	s.remoteData = []File{newFile("/local1/a", 100), newFile("/local1/c", 60)}
+
+	var refused []LogEntry
+	s.logger.logError = func(e LogEntry) {
+		refused = append(refused, e)
+	}
+
+	s.collectActions()
+
+	a := NewApplier([]RemoteAction{
+		{Type: REMOVE, File: newFile("/local1/a", 100)},
+		{Type: PUSH, File: newFile("/local1/b", 100)},
+
+		// The remote file changed since, as has the local one that is gone
+		{Type: PUSH, File: newFile("/local1/c", 100), Remote: newFile("/local1/c", 50)},
+		{Type: PUSH, File: newFile("/local1/gone", 10)},
+	})
+
+	s.Require().NoError(s.processor().WithPlan(a).Process(context.Background()))
+	s.wg.Wait()
+
+	s.Equal([]RemoteAction{
+		{Type: REMOVE, File: newFile("/local1/a", 100)},
+		{Type: PUSH, File: newFile("/local1/b", 100)},
+	}, s.actions)
+
+	s.Equal([]LogEntry{
+		{Message: "not applied, the local or remote file changed since it was planned", File: "/local1/c", ActionType: PUSH},
+		{Message: "not applied, the local or remote file changed since it was planned", File: "/local1/gone", ActionType: PUSH},
+	}, refused)
+	s.Equal(2, a.Ignored())
+}
+
+func (s *ProcessorTestSuite) Test_Process_Plan_NothingRefusedOnFailure() {
+	s.remoteGatherFunc = func(ctx context.Context, _ string, out chan<- File) error {
+		return errors.New("asplode")
+	}
+	s.remoteGatherer = testPrefixGatherer{gather: s.remoteGatherFunc}
+
+	var logged []LogEntry
+	s.logger.logError = func(e LogEntry) {
+		logged = append(logged, e)
+	}
+
+	a := NewApplier([]RemoteAction{{Type: PUSH, File: newFile("/local1/file1", 100)}})
+
+	s.Error(s.processor().WithPlan(a).Process(context.Background()))
+	s.Len(logged, 1)
+	s.Contains(logged[0].Message, "error returned while gathering remote files")
+}
+
+func (s *ProcessorTestSuite) Test_mergeGather_SendsBothInOrder() {
+	merged := mergeGather(
+		s.sliceGatherer([]File{newFile("a", 1), newFile("c", 1), newFile("d", 1)}),
+		s.sliceGatherer([]File{newFile("b", 2), newFile("c", 2)}),
+	)
+
+	out := make(chan File, 10)
+	s.Require().NoError(merged(context.Background(), out))
+	close(out)
+
+	files := make([]File, 0)
+	for f := range out {
+		files = append(files, f)
 	}
 
-	s.remoteGatherer = testGatherer{gather: s.remoteGatherFunc}
+	s.Equal([]File{
+		newFile("a", 1),
+		newFile("b", 2),
+		newFile("c", 2),
+		newFile("c", 1),
+		newFile("d", 1),
+	}, files)
+}
+
+func (s *ProcessorTestSuite) Test_mergeGather_ReturnsErrors() {
+	failing := func(context.Context, chan<- File) error {
+		return errors.New("asplode!")
+	}
+	failingLater := func(ctx context.Context, out chan<- File) error {
+		sendFiles(ctx, out, newFile("a", 1))
+		return errors.New("asplode!")
+	}
+	files := s.sliceGatherer([]File{newFile("a", 1), newFile("b", 1)})
+
+	for _, merged := range []func(context.Context, chan<- File) error{
+		mergeGather(failing, files),
+		mergeGather(files, failing),
+		mergeGather(failingLater, files),
+	} {
+		s.Equal(errors.New("asplode!"), merged(context.Background(), make(chan File, 10)))
+	}
+}
+
+func (s *ProcessorTestSuite) Test_mergeGather_StopsWhenCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	merged := mergeGather(
+		s.sliceGatherer([]File{newFile("a", 1)}),
+		s.sliceGatherer(nil),
+	)
 
-	putCalledCnt := 0
-	removeCalledCnt := 0
+	out := make(chan File)
+	errc := make(chan error)
 	go func() {
-		for {
-			action := <-s.remoteAction
-			if action.Type == PUSH {
-				putCalledCnt++
-			} else if action.Type == REMOVE {
-				removeCalledCnt++
-			} else {
-				s.T().Errorf("Unknown action type")
-			}
-			s.wg.Done()
-		}
+		errc <- merged(ctx, out)
 	}()
 
-	s.processor().Process()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
 
-	s.wg.Wait()
+	s.Equal(context.Canceled, <-errc)
+}
+
+// compare runs a single comparison of localData against remoteData
+func (s *ProcessorTestSuite) compare() error {
+	s.remoteAction = make(chan RemoteAction, 10)
+	ctx := context.Background()
+
+	err := s.processor().compare(
+		ctx,
+		"/local1",
+		startStream(ctx, "local", s.sliceGatherer(s.localData)),
+		startStream(ctx, "remote", func(ctx context.Context, out chan<- File) error {
+			return s.remoteGatherFunc(ctx, "", out)
+		}),
+	)
+
+	close(s.remoteAction)
+	for action := range s.remoteAction {
+		s.actions = append(s.actions, action)
+	}
 
-	s.Equal(3, putCalledCnt, "putCalledCnt does not match")
-	s.Equal(2, removeCalledCnt, "removeCalledCnt does not match")
+	return err
+}
+
+func (s *ProcessorTestSuite) sliceGatherer(files []File) func(context.Context, chan<- File) error {
+	return func(ctx context.Context, out chan<- File) error {
+		return sendFiles(ctx, out, files...)
+	}
+}
+
+func sendFiles(ctx context.Context, out chan<- File, files ...File) error {
+	for _, f := range files {
+		if err := sendFile(ctx, out, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 type testLogger struct {
@@ -375,9 +1037,22 @@ func (l testLogger) Error(i LogEntry) {
 }
 
 type testGatherer struct {
-	gather func() (FileData, error)
+	root   string
+	gather func(context.Context, chan<- File) error
+}
+
+func (g testGatherer) Root() string {
+	return g.root
+}
+
+func (g testGatherer) Gather(ctx context.Context, out chan<- File) error {
+	return g.gather(ctx, out)
+}
+
+type testPrefixGatherer struct {
+	gather func(context.Context, string, chan<- File) error
 }
 
-func (g testGatherer) Gather() (FileData, error) {
-	return g.gather()
+func (g testPrefixGatherer) Gather(ctx context.Context, prefix string, out chan<- File) error {
+	return g.gather(ctx, prefix, out)
 }
@@ -1,13 +1,57 @@
 package backup
 
+import "context"
+
 type ActionType string
 
 const (
 	PUSH   = "push"
 	REMOVE = "remove"
+	COPY   = "copy"
+	PACK   = "pack"
 )
 
 type RemoteAction struct {
 	Type ActionType
 	File File
+
+	// Source is only set for a COPY, it is the remote file that is copied
+	// to File and then removed
+	Source File
+
+	// Remote is only set for a PUSH of a file that is on the remote already,
+	// it is what is there now and is being replaced
+	Remote File
+
+	// Pack is only set for a PACK, File is then the pack being built
+	Pack *Pack
+}
+
+// Pack describes a pack to (re)build. Members go into the new pack, once
+// it is uploaded the Replaces packs are removed. Dropped are the members of
+// those that no longer exist locally and are left out.
+type Pack struct {
+	Members  []File   `json:"members"`
+	Replaces []string `json:"replaces,omitempty"`
+	Dropped  []File   `json:"dropped,omitempty"`
+}
+
+// PutResult is how a pushed file ended up on the remote
+type PutResult struct {
+	// Deduplicated means that the content was already on the remote and
+	// none of it was uploaded
+	Deduplicated bool
+
+	// StorageClass is what the content was uploaded with, empty when it is
+	// whatever the bucket defaults to
+	StorageClass string
+}
+
+// Remote is everything that the processor and the workers need from the
+// remote host
+type Remote interface {
+	PrefixGatherer
+	Put(context.Context, string) (PutResult, error)
+	Remove(context.Context, string) error
+	Copy(context.Context, string, string) error
 }
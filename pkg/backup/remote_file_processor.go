@@ -3,63 +3,87 @@ package backup
 import (
 	"context"
 	"errors"
-
-	minio "github.com/minio/minio-go/v7"
+	"os"
 )
 
+// RemoteFileProcessor keeps every backed up file as an object of its own,
+// under its absolute path
 type RemoteFileProcessor struct {
-	bucket   string
-	fileData FileData
-
-	list   func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo
-	remove func(context.Context, string, string, minio.RemoveObjectOptions) error
-	put    func(context.Context, string, string, string, minio.PutObjectOptions) (minio.UploadInfo, error)
+	storage Storage
+	classes StorageClasses
+	tagging Tagging
 }
 
-func NewRemoteFileProcessor(
-	b string,
-	l func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo,
-	r func(context.Context, string, string, minio.RemoveObjectOptions) error,
-	p func(context.Context, string, string, string, minio.PutObjectOptions) (minio.UploadInfo, error),
-) (RemoteFileProcessor, error) {
-	if b == "" {
-		return RemoteFileProcessor{}, errors.New("'NewRemoteFileProcessor' error: bucket cannot be missing")
+func NewRemoteFileProcessor(s Storage) (RemoteFileProcessor, error) {
+	if s == nil {
+		return RemoteFileProcessor{}, errors.New("'NewRemoteFileProcessor' error: storage cannot be missing")
 	}
 
 	return RemoteFileProcessor{
-		bucket:   b,
-		list:     l,
-		remove:   r,
-		put:      p,
-		fileData: make(FileData, 0),
+		storage: s,
 	}, nil
 }
 
-func (p *RemoteFileProcessor) Gather() (data FileData, err error) {
-	for object := range p.list(context.Background(), p.bucket, minio.ListObjectsOptions{Prefix: "", Recursive: true}) {
-		if object.Err != nil {
-			return nil, object.Err
-		}
+// WithStorageClasses has every file uploaded with the storage class that
+// classes picks for it
+func (p RemoteFileProcessor) WithStorageClasses(classes StorageClasses) RemoteFileProcessor {
+	p.classes = classes
+	return p
+}
 
-		p.fileData[Filename(object.Key)] = newFile(object.Key, object.Size)
-	}
+// WithTagging has every file uploaded with the tags and metadata of t, and
+// leaves alone what t doesn't own
+func (p RemoteFileProcessor) WithTagging(t Tagging) RemoteFileProcessor {
+	p.tagging = t
+	return p
+}
 
-	return p.fileData, nil
+// Gather relies on the storage listing in key order, as S3 does. Objects
+// that are listed with the tags of another host or profile are marked as
+// not owned.
+func (p *RemoteFileProcessor) Gather(ctx context.Context, prefix string, out chan<- File) error {
+	return eachObject(ctx, p.storage, prefix, ListOptions{Tags: p.tagging.Owned}, func(object Object) error {
+		f := newFile(object.Key, object.Size)
+		f.ETag = object.ETag
+		f.NotOwned = !p.tagging.owns(object.Tags)
+
+		return sendFile(ctx, out, f)
+	})
 }
 
-func (p *RemoteFileProcessor) Remove(f string) error {
-	return p.remove(context.Background(), p.bucket, f, minio.RemoveObjectOptions{})
+// Remove checks who owns f first, the listing may not have had its tags
+func (p *RemoteFileProcessor) Remove(ctx context.Context, f string) error {
+	if err := p.tagging.checkOwned(ctx, p.storage, f); err != nil {
+		return err
+	}
+
+	return p.storage.Remove(ctx, f)
 }
 
-func (p *RemoteFileProcessor) Put(f string) (err error) {
+// Put always uploads the whole file, so it never reports it as deduplicated.
+// Like Remove, it checks who owns f first.
+func (p *RemoteFileProcessor) Put(ctx context.Context, f string) (result PutResult, err error) {
 	if f == "" {
 		err = errors.New("'put' error: target file cannot be missing")
 		return
 	}
 
-	// We ignore the return file info, we don't need it for now
-	_, err = p.put(context.Background(), p.bucket, f, f, minio.PutObjectOptions{
-		ContentType: "", // A blank will cause the type to be auto-detected by the lib
-	})
+	if err = p.tagging.checkOwned(ctx, p.storage, f); err != nil {
+		return
+	}
+
+	info, err := os.Stat(f)
+	if err != nil {
+		return
+	}
+
+	result.StorageClass = p.classes.For(f, info)
+
+	err = putFile(ctx, p.storage, f, f, p.tagging.options(f, PutOptions{StorageClass: result.StorageClass}))
 	return
 }
+
+// Copy copies src to dst without the data leaving the remote
+func (p *RemoteFileProcessor) Copy(ctx context.Context, src, dst string) error {
+	return p.storage.Copy(ctx, src, dst)
+}
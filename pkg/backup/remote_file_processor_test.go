@@ -3,9 +3,11 @@ package backup
 import (
 	"context"
 	"errors"
+	"io"
+	"io/ioutil"
+	"os"
 	"testing"
 
-	minio "github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -15,220 +17,338 @@ func TestRemoteProcessorTestSuite(t *testing.T) {
 
 type RemoteProcessorTestSuite struct {
 	suite.Suite
-	bucket     string
-	listFunc   func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo
-	removeFunc func(context.Context, string, string, minio.RemoveObjectOptions) error
-	putFunc    func(context.Context, string, string, string, minio.PutObjectOptions) (minio.UploadInfo, error)
+	storage *testStorage
 }
 
 func (s *RemoteProcessorTestSuite) SetupTest() {
-	s.bucket = "testBucket"
+	s.storage = newTestStorage()
+}
 
-	s.listFunc = func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo {
-		objectCh := make(chan minio.ObjectInfo)
-		defer close(objectCh)
-		return objectCh
-	}
+func (s *RemoteProcessorTestSuite) processor() RemoteFileProcessor {
+	processor, err := NewRemoteFileProcessor(s.storage)
+	s.Require().NoError(err)
+	return processor
+}
 
-	s.removeFunc = func(context.Context, string, string, minio.RemoveObjectOptions) error { return nil }
-	s.putFunc = func(context.Context, string, string, string, minio.PutObjectOptions) (minio.UploadInfo, error) {
-		return minio.UploadInfo{}, nil
+func (s *RemoteProcessorTestSuite) gather(processor RemoteFileProcessor, prefix string) ([]File, error) {
+	out := make(chan File, 10)
+	err := processor.Gather(context.Background(), prefix, out)
+	close(out)
+
+	files := make([]File, 0)
+	for f := range out {
+		files = append(files, f)
 	}
+
+	return files, err
 }
 
-func (s *RemoteProcessorTestSuite) Test_Gather_CallsListRemoteObjects() {
+func (s *RemoteProcessorTestSuite) Test_Gather_ListsPrefix() {
 	called := false
 
-	listFunc := func(_ context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
-		objectCh := make(chan minio.ObjectInfo, 1)
-		defer close(objectCh)
-
-		s.Equal(s.bucket, bucket)
-		s.Equal("", opts.Prefix)
-		s.Equal(true, opts.Recursive)
+	s.storage.list = func(_ context.Context, prefix string, opts ListOptions, _ chan<- Object) error {
+		s.Equal("/home/user/", prefix)
+		s.False(opts.Metadata)
 
 		called = true
-
-		return objectCh
+		return nil
 	}
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, listFunc, s.removeFunc, s.putFunc)
-	_, err := processor.Gather()
+	_, err := s.gather(s.processor(), "/home/user/")
 
 	s.Require().NoError(err)
 	s.True(called)
 }
 
-func (s *RemoteProcessorTestSuite) Test_Gather_ReturnsErrorOnlyForFailedObjects() {
-	called := false
+func (s *RemoteProcessorTestSuite) Test_Gather_ReturnsListError() {
 	expectedErr := errors.New("asplode")
 
-	listFunc := func(_ context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
-		objectCh := make(chan minio.ObjectInfo, 1)
-		defer close(objectCh)
-
-		objectCh <- minio.ObjectInfo{
-			Err: expectedErr,
-		}
-
-		called = true
-		return objectCh
+	s.storage.list = func(context.Context, string, ListOptions, chan<- Object) error {
+		return expectedErr
 	}
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, listFunc, s.removeFunc, s.putFunc)
-	_, err := processor.Gather()
+	_, err := s.gather(s.processor(), "")
 
 	s.Require().Error(err)
-	s.True(called)
 	s.Equal(expectedErr, err)
 }
 
-func (s *RemoteProcessorTestSuite) Test_New_ErrorBlankBucketName() {
-	_, err := NewRemoteFileProcessor("", s.listFunc, s.removeFunc, s.putFunc)
+func (s *RemoteProcessorTestSuite) Test_New_ErrorMissingStorage() {
+	_, err := NewRemoteFileProcessor(nil)
 	s.Error(err)
-	s.Equal(errors.New("'NewRemoteFileProcessor' error: bucket cannot be missing"), err)
+	s.Equal(errors.New("'NewRemoteFileProcessor' error: storage cannot be missing"), err)
 }
 
 func (s *RemoteProcessorTestSuite) Test_Gather_SingleFile() {
-	listFunc := func(_ context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
-		objectCh := make(chan minio.ObjectInfo, 1)
-		defer close(objectCh)
+	s.storage.listing(Object{Key: "test", Size: 100, ETag: "etag"})
 
-		objectCh <- minio.ObjectInfo{
-			Key:  "test",
-			Size: 100,
-		}
-
-		return objectCh
-	}
-
-	processor, _ := NewRemoteFileProcessor(s.bucket, listFunc, s.removeFunc, s.putFunc)
-	data, err := processor.Gather()
+	data, err := s.gather(s.processor(), "")
 
 	s.Require().NoError(err)
-	s.Equal(newFile("test", 100), data["test"])
+	s.Equal([]File{{Name: "test", Size: 100, ETag: "etag"}}, data)
 }
 
 func (s *RemoteProcessorTestSuite) Test_Gather_MultipleFiles() {
-	listFunc := func(_ context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
-		objectCh := make(chan minio.ObjectInfo, 3)
-		defer close(objectCh)
+	s.storage.listing(
+		Object{Key: "test1", Size: 100},
+		Object{Key: "test2", Size: 500},
+		Object{Key: "test3", Size: 1000},
+	)
 
-		objectCh <- minio.ObjectInfo{
-			Key:  "test1",
-			Size: 100,
-		}
+	data, err := s.gather(s.processor(), "")
 
-		objectCh <- minio.ObjectInfo{
-			Key:  "test2",
-			Size: 500,
-		}
+	s.Require().NoError(err)
+	s.Equal([]File{
+		newFile("test1", 100),
+		newFile("test2", 500),
+		newFile("test3", 1000),
+	}, data)
+}
 
-		objectCh <- minio.ObjectInfo{
-			Key:  "test3",
-			Size: 1000,
-		}
+func (s *RemoteProcessorTestSuite) Test_Gather_StopsWhenCancelled() {
+	s.storage.listing(Object{Key: "test", Size: 100})
 
-		return objectCh
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, listFunc, s.removeFunc, s.putFunc)
-	data, err := processor.Gather()
+	processor := s.processor()
+	err := processor.Gather(ctx, "", make(chan File))
 
-	s.Require().NoError(err)
-	s.Equal(newFile("test1", 100), data["test1"])
-	s.Equal(newFile("test2", 500), data["test2"])
-	s.Equal(newFile("test3", 1000), data["test3"])
+	s.Equal(context.Canceled, err)
 }
 
 func (s *RemoteProcessorTestSuite) Test_Remove_Happy() {
 	called := false
-	removeFunc := func(_ context.Context, bucket, file string, _ minio.RemoveObjectOptions) error {
-		s.Equal(s.bucket, bucket)
-		s.Equal("test", file)
+	s.storage.remove = func(_ context.Context, key string) error {
+		s.Equal("test", key)
 		called = true
 		return nil
 	}
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, s.listFunc, removeFunc, s.putFunc)
-	err := processor.Remove("test")
+	processor := s.processor()
+	err := processor.Remove(context.Background(), "test")
 
 	s.Require().NoError(err)
 	s.True(called)
 }
 
 func (s *RemoteProcessorTestSuite) Test_Remove_Error() {
-	called := false
 	expectedErr := errors.New("asplode")
-	removeFunc := func(_ context.Context, bucket, file string, _ minio.RemoveObjectOptions) error {
-		called = true
+	s.storage.remove = func(context.Context, string) error {
 		return expectedErr
 	}
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, s.listFunc, removeFunc, s.putFunc)
-	err := processor.Remove("test")
+	processor := s.processor()
+	err := processor.Remove(context.Background(), "test")
 
 	s.Error(err)
-	s.True(called)
 	s.Equal(expectedErr, err)
 }
 
+func (s *RemoteProcessorTestSuite) Test_Remove_LeavesWhatItDoesNotOwn() {
+	s.storage.tags = func(context.Context, string) (map[string]string, error) {
+		return map[string]string{HostTag: "desktop"}, nil
+	}
+	s.storage.remove = func(context.Context, string) error {
+		s.Fail("removed an object owned by another host")
+		return nil
+	}
+
+	processor := s.processor().WithTagging(ownedTagging())
+
+	s.Equal(ErrNotOwned, processor.Remove(context.Background(), "test"))
+}
+
+func (s *RemoteProcessorTestSuite) Test_Gather_MarksWhatItDoesNotOwn() {
+	s.storage.list = func(ctx context.Context, _ string, opts ListOptions, out chan<- Object) error {
+		s.True(opts.Tags)
+
+		for _, o := range []Object{
+			{Key: "/home/user/a", Size: 1, Tags: map[string]string{HostTag: "desktop"}},
+			{Key: "/home/user/b", Size: 2, Tags: map[string]string{HostTag: "laptop"}},
+			{Key: "/home/user/c", Size: 3},
+		} {
+			if err := SendObject(ctx, out, o); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	files, err := s.gather(s.processor().WithTagging(ownedTagging()), "/home/user/")
+
+	s.Require().NoError(err)
+	s.Equal([]File{
+		{Name: "/home/user/a", Size: 1, NotOwned: true},
+		newFile("/home/user/b", 2),
+		newFile("/home/user/c", 3),
+	}, files)
+}
+
+func (s *RemoteProcessorTestSuite) tmpFile() string {
+	f, err := ioutil.TempFile("", "remoteProcessor")
+	s.Require().NoError(err)
+	f.WriteString("hello")
+	f.Close()
+
+	return f.Name()
+}
+
 func (s *RemoteProcessorTestSuite) Test_Put_Happy() {
 	called := false
-	expectedFile := "/tmp/test"
+	expectedFile := s.tmpFile()
+	defer os.Remove(expectedFile)
+
+	s.storage.put = func(_ context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+		body, err := ioutil.ReadAll(r)
+		s.Require().NoError(err)
 
-	putFunc := func(_ context.Context, bucket, fileName, filePath string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
-		s.Equal(s.bucket, bucket)
-		s.Equal(expectedFile, fileName)
-		s.Equal(expectedFile, filePath)
+		s.Equal(expectedFile, key)
+		s.Equal("hello", string(body))
+		s.Equal(int64(5), size)
 		s.Equal("", opts.ContentType)
 
 		called = true
-		return minio.UploadInfo{}, nil
+		return nil
 	}
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, s.listFunc, s.removeFunc, putFunc)
+	processor := s.processor()
 
-	err := processor.Put(expectedFile)
+	result, err := processor.Put(context.Background(), expectedFile)
 
 	s.Require().NoError(err)
 	s.True(called)
+	s.Equal(PutResult{}, result)
+}
+
+func (s *RemoteProcessorTestSuite) Test_Put_LeavesWhatItDoesNotOwn() {
+	expectedFile := s.tmpFile()
+	defer os.Remove(expectedFile)
+
+	s.storage.tags = func(_ context.Context, key string) (map[string]string, error) {
+		s.Equal(expectedFile, key)
+		return map[string]string{HostTag: "desktop"}, nil
+	}
+	s.storage.put = func(context.Context, string, io.Reader, int64, PutOptions) error {
+		s.Fail("overwrote an object owned by another host")
+		return nil
+	}
+
+	processor := s.processor().WithTagging(ownedTagging())
+
+	_, err := processor.Put(context.Background(), expectedFile)
+	s.Equal(ErrNotOwned, err)
+}
+
+func (s *RemoteProcessorTestSuite) Test_Put_UsesStorageClass() {
+	expectedFile := s.tmpFile()
+	defer os.Remove(expectedFile)
+
+	s.storage.put = func(_ context.Context, _ string, _ io.Reader, _ int64, opts PutOptions) error {
+		s.Equal("GLACIER", opts.StorageClass)
+		return nil
+	}
+
+	processor := s.processor().WithStorageClasses(NewStorageClasses("STANDARD", StorageClassRule{Pattern: "remoteProcessor*", Class: "GLACIER"}))
+
+	result, err := processor.Put(context.Background(), expectedFile)
+
+	s.Require().NoError(err)
+	s.Equal(PutResult{StorageClass: "GLACIER"}, result)
+}
+
+func (s *RemoteProcessorTestSuite) Test_Put_UsesTagging() {
+	expectedFile := s.tmpFile()
+	defer os.Remove(expectedFile)
+
+	s.storage.put = func(_ context.Context, _ string, _ io.Reader, _ int64, opts PutOptions) error {
+		s.Equal("laptop", opts.Tags[HostTag])
+		s.Equal(map[string]string{"owner": "me"}, opts.Metadata)
+		return nil
+	}
+
+	tagging := ownedTagging()
+	tagging.Metadata = map[string]string{"owner": "me"}
+
+	processor := s.processor().WithTagging(tagging)
+
+	_, err := processor.Put(context.Background(), expectedFile)
+	s.Require().NoError(err)
+}
+
+func (s *RemoteProcessorTestSuite) Test_Put_ReturnsErrorForMissingFile() {
+	processor := s.processor()
+
+	_, err := processor.Put(context.Background(), "/does/not/exist")
+
+	s.True(os.IsNotExist(err))
 }
 
 func (s *RemoteProcessorTestSuite) Test_Put_ReturnsErrorOnFailure() {
-	called := false
-	expectedFile := "/tmp/test"
+	expectedFile := s.tmpFile()
+	defer os.Remove(expectedFile)
 	expectedErr := errors.New("asplode")
 
-	putFunc := func(_ context.Context, bucket, fileName, filePath string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
-		called = true
-		return minio.UploadInfo{}, expectedErr
+	s.storage.put = func(context.Context, string, io.Reader, int64, PutOptions) error {
+		return expectedErr
 	}
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, s.listFunc, s.removeFunc, putFunc)
+	processor := s.processor()
 
-	err := processor.Put(expectedFile)
+	_, err := processor.Put(context.Background(), expectedFile)
 
 	s.Error(err)
-	s.True(called)
 	s.Equal(expectedErr, err)
 }
 
 func (s *RemoteProcessorTestSuite) Test_Put_ReturnsErrorIfFileIsMissing() {
 	called := false
-	expectedFile := ""
 	expectedErr := errors.New("'put' error: target file cannot be missing")
 
-	putFunc := func(_ context.Context, bucket, fileName, filePath string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	s.storage.put = func(context.Context, string, io.Reader, int64, PutOptions) error {
 		called = true
-		return minio.UploadInfo{}, nil
+		return nil
 	}
 
-	processor, _ := NewRemoteFileProcessor(s.bucket, s.listFunc, s.removeFunc, putFunc)
+	processor := s.processor()
 
-	err := processor.Put(expectedFile)
+	_, err := processor.Put(context.Background(), "")
 
 	s.Error(err)
 	s.False(called)
 	s.Equal(expectedErr, err)
 }
+
+func (s *RemoteProcessorTestSuite) Test_Copy_Happy() {
+	called := false
+
+	s.storage.copy = func(_ context.Context, src, dst string) error {
+		s.Equal("/tmp/old", src)
+		s.Equal("/tmp/new", dst)
+
+		called = true
+		return nil
+	}
+
+	processor := s.processor()
+
+	err := processor.Copy(context.Background(), "/tmp/old", "/tmp/new")
+
+	s.Require().NoError(err)
+	s.True(called)
+}
+
+func (s *RemoteProcessorTestSuite) Test_Copy_ReturnsErrorOnFailure() {
+	expectedErr := errors.New("asplode")
+
+	s.storage.copy = func(context.Context, string, string) error {
+		return expectedErr
+	}
+
+	processor := s.processor()
+
+	err := processor.Copy(context.Background(), "/tmp/old", "/tmp/new")
+
+	s.Equal(expectedErr, err)
+}
@@ -1,6 +1,133 @@
 package backup
 
+import "fmt"
+
 type Reporter interface {
 	Run()
 	Print()
+	Summary() ReportSummary
+}
+
+// ReportSummary is the totals of one report, it is what a combined report
+// over several profiles is made from
+type ReportSummary struct {
+	Files   int `json:"files"`
+	Pushed  int `json:"pushed"`
+	Removed int `json:"removed"`
+	Moved   int `json:"moved"`
+
+	// Skipped is how many of the files needed nothing done after all
+	Skipped int `json:"skipped"`
+
+	// Failed is how many of the files failed, they are in Files as well
+	Failed int `json:"failed"`
+
+	DeduplicatedBytes int64 `json:"deduplicatedBytes"`
+
+	// PushedBytes were uploaded and RemovedBytes removed, by the actions
+	// that succeeded
+	PushedBytes  int64 `json:"pushedBytes"`
+	RemovedBytes int64 `json:"removedBytes"`
+}
+
+// Add is the totals of both reports together
+func (s ReportSummary) Add(other ReportSummary) ReportSummary {
+	return ReportSummary{
+		Files:             s.Files + other.Files,
+		Pushed:            s.Pushed + other.Pushed,
+		Removed:           s.Removed + other.Removed,
+		Moved:             s.Moved + other.Moved,
+		Skipped:           s.Skipped + other.Skipped,
+		Failed:            s.Failed + other.Failed,
+		DeduplicatedBytes: s.DeduplicatedBytes + other.DeduplicatedBytes,
+		PushedBytes:       s.PushedBytes + other.PushedBytes,
+		RemovedBytes:      s.RemovedBytes + other.RemovedBytes,
+	}
+}
+
+// Outcome is how a report went. Nothing to do at all is a success.
+func (s ReportSummary) Outcome() Outcome {
+	switch {
+	case s.Failed == 0:
+		return Succeeded
+	case s.Failed == s.Files:
+		return Failed
+	default:
+		return PartlyFailed
+	}
+}
+
+// Outcome is how a run went as a whole
+type Outcome int
+
+const (
+	Succeeded Outcome = iota
+	PartlyFailed
+	Failed
+	Aborted
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Succeeded:
+		return "succeeded"
+	case PartlyFailed:
+		return "partly failed"
+	case Failed:
+		return "failed"
+	default:
+		return "aborted"
+	}
+}
+
+// MarshalText has an outcome show up as its name in JSON
+func (o Outcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+// UnmarshalText reads an outcome back from its name
+func (o *Outcome) UnmarshalText(text []byte) error {
+	for _, known := range []Outcome{Succeeded, PartlyFailed, Failed, Aborted} {
+		if string(text) == known.String() {
+			*o = known
+			return nil
+		}
+	}
+
+	return fmt.Errorf("'UnmarshalText' error: unknown outcome '%s'", text)
+}
+
+// ExitCode is what the process exits with for o. Every outcome has one of
+// its own so that cron or systemd can tell them apart, aborted is what a
+// shell gives a process stopped with Ctrl-C.
+func (o Outcome) ExitCode() int {
+	switch o {
+	case Succeeded:
+		return 0
+	case PartlyFailed:
+		return 2
+	case Failed:
+		return 1
+	default:
+		return 130
+	}
+}
+
+// CombineOutcomes is how a run went that is made up of others, like the
+// destinations of a profile or the profiles of --all. Anything aborted
+// aborts the whole run, a mix of anything else partly failed.
+func CombineOutcomes(outcomes ...Outcome) Outcome {
+	combined := Succeeded
+	for i, o := range outcomes {
+		switch {
+		case o == Aborted || combined == Aborted:
+			combined = Aborted
+		case i == 0 || o == combined:
+			combined = o
+		default:
+			combined = PartlyFailed
+		}
+	}
+
+	return combined
 }
@@ -0,0 +1,60 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReportSummary_Add(t *testing.T) {
+	a := ReportSummary{Files: 3, Pushed: 2, Removed: 1, Failed: 1, DeduplicatedBytes: 100, PushedBytes: 10, RemovedBytes: 5}
+	b := ReportSummary{Files: 3, Pushed: 1, Moved: 1, Skipped: 1, Failed: 1, DeduplicatedBytes: 50, PushedBytes: 20}
+
+	assert.Equal(t, ReportSummary{Files: 6, Pushed: 3, Removed: 1, Moved: 1, Skipped: 1, Failed: 2, DeduplicatedBytes: 150, PushedBytes: 30, RemovedBytes: 5}, a.Add(b))
+}
+
+func Test_ReportSummary_Outcome(t *testing.T) {
+	assert.Equal(t, Succeeded, ReportSummary{}.Outcome())
+	assert.Equal(t, Succeeded, ReportSummary{Files: 2}.Outcome())
+	assert.Equal(t, PartlyFailed, ReportSummary{Files: 2, Failed: 1}.Outcome())
+	assert.Equal(t, Failed, ReportSummary{Files: 2, Failed: 2}.Outcome())
+}
+
+func Test_Outcome(t *testing.T) {
+	for _, tc := range []struct {
+		outcome  Outcome
+		name     string
+		exitCode int
+	}{
+		{Succeeded, "succeeded", 0},
+		{PartlyFailed, "partly failed", 2},
+		{Failed, "failed", 1},
+		{Aborted, "aborted", 130},
+	} {
+		assert.Equal(t, tc.name, tc.outcome.String())
+		assert.Equal(t, tc.exitCode, tc.outcome.ExitCode())
+
+		text, err := tc.outcome.MarshalText()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.name, string(text))
+
+		var read Outcome
+		assert.NoError(t, read.UnmarshalText(text))
+		assert.Equal(t, tc.outcome, read)
+	}
+}
+
+func Test_Outcome_UnmarshalTextUnknown(t *testing.T) {
+	var o Outcome
+	assert.EqualError(t, o.UnmarshalText([]byte("exploded")), "'UnmarshalText' error: unknown outcome 'exploded'")
+}
+
+func Test_CombineOutcomes(t *testing.T) {
+	assert.Equal(t, Succeeded, CombineOutcomes())
+	assert.Equal(t, Succeeded, CombineOutcomes(Succeeded, Succeeded))
+	assert.Equal(t, Failed, CombineOutcomes(Failed, Failed))
+	assert.Equal(t, PartlyFailed, CombineOutcomes(Succeeded, Failed))
+	assert.Equal(t, PartlyFailed, CombineOutcomes(PartlyFailed, Succeeded))
+	assert.Equal(t, Aborted, CombineOutcomes(Succeeded, Aborted, Failed))
+	assert.Equal(t, Aborted, CombineOutcomes(Aborted, Failed))
+}
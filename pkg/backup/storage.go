@@ -0,0 +1,144 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotFound is what a Storage returns for a key that isn't there
+var ErrNotFound = errors.New("not found")
+
+// Object is a single stored object. Metadata keys are always lower case.
+type Object struct {
+	Key  string
+	Size int64
+
+	// ETag is the MD5 of the content for anything put in one go, see
+	// matchesETag for what S3 does with bigger objects
+	ETag string
+
+	Metadata map[string]string
+
+	// Tags is nil when there are none, or when the backend can't list them
+	Tags map[string]string
+}
+
+// ListOptions are for listings that would be slow without some backend
+// specific help
+type ListOptions struct {
+	// Metadata asks for the metadata of every object, if the backend can
+	// list it. Objects may still come back without it.
+	Metadata bool
+
+	// Tags asks for the tags of every object, the same way
+	Tags bool
+}
+
+type PutOptions struct {
+	// ContentType is left for the backend to guess when it is empty
+	ContentType string
+	Metadata    map[string]string
+
+	// StorageClass is left for the backend to pick when it is empty,
+	// backends without storage classes ignore it
+	StorageClass string
+
+	Tags map[string]string
+}
+
+// Storage is somewhere that backed up files are kept, an S3 bucket or a
+// directory on a mounted drive. Keys are slash separated paths, the ones
+// for backed up files are the absolute path of the file. Everything else
+// that is kept, like blobs, packs, locks and run history, is under a key
+// that starts with an underscore instead, so the two never clash.
+type Storage interface {
+	// List streams every object whose key starts with prefix to out in
+	// ascending byte order of the key. It must not close out.
+	List(ctx context.Context, prefix string, opts ListOptions, out chan<- Object) error
+
+	// Put stores everything read from r under key. A size of -1 means it
+	// isn't known up front. An object is either stored whole or not at all.
+	Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error
+
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Remove is fine with key not being there
+	Remove(ctx context.Context, key string) error
+
+	Stat(ctx context.Context, key string) (Object, error)
+
+	Tags(ctx context.Context, key string) (map[string]string, error)
+
+	// Copy copies src and its metadata to dst without the content going
+	// anywhere near this machine
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// putFile stores the local file at path under key. Like minio, the content
+// type is guessed from the extension.
+func putFile(ctx context.Context, s Storage, key, path string, opts PutOptions) error {
+	return putFileThrough(ctx, s, key, path, opts, nil)
+}
+
+// putFileThrough is putFile with everything that is uploaded also written
+// to w, unless it is nil. The file is only ever read once.
+func putFileThrough(ctx context.Context, s Storage, key, path string, opts PutOptions, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if opts.ContentType == "" {
+		opts.ContentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+
+	var r io.Reader = f
+	if w != nil {
+		r = io.TeeReader(f, w)
+	}
+
+	return s.Put(ctx, key, r, fi.Size(), opts)
+}
+
+// eachObject calls fn for every object that s lists under prefix, in key
+// order, and stops at the first error from either
+func eachObject(ctx context.Context, s Storage, prefix string, opts ListOptions, fn func(Object) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := make(chan Object)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.List(ctx, prefix, opts, objects)
+		close(objects)
+	}()
+
+	for o := range objects {
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+
+	return <-errc
+}
+
+// SendObject hands o to out unless ctx is cancelled first, it is for
+// Storage implementations to list with
+func SendObject(ctx context.Context, out chan<- Object, o Object) error {
+	select {
+	case out <- o:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
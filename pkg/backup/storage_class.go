@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StorageClassRule picks the storage class for files that match every one
+// of its conditions, a condition left at its zero value always matches
+type StorageClassRule struct {
+	// Pattern is matched against both the name and the full path of a
+	// file, the same as the exclude patterns. A pattern that ends in '/' or
+	// '/**' matches every file below the directories it matches instead,
+	// however deep.
+	Pattern string
+
+	// OlderThan is how long ago a file has to have last been modified
+	OlderThan time.Duration
+
+	MinSize int64
+
+	Class string
+}
+
+// StorageClasses picks the storage class that each file is uploaded with.
+// The first rule that matches wins, a file that matches none gets Default.
+// An empty class leaves it up to the bucket.
+type StorageClasses struct {
+	Default string
+	Rules   []StorageClassRule
+
+	now func() time.Time
+}
+
+func NewStorageClasses(def string, rules ...StorageClassRule) StorageClasses {
+	return StorageClasses{
+		Default: def,
+		Rules:   rules,
+		now:     time.Now,
+	}
+}
+
+// For is the storage class of the local file at path
+func (c StorageClasses) For(path string, info os.FileInfo) string {
+	for _, rule := range c.Rules {
+		if rule.matches(path, info, c.now()) {
+			return rule.Class
+		}
+	}
+
+	return c.Default
+}
+
+func (r StorageClassRule) matches(path string, info os.FileInfo, now time.Time) bool {
+	if r.Pattern != "" && !r.matchesPath(path, info) {
+		return false
+	}
+
+	if now.Sub(info.ModTime()) < r.OlderThan {
+		return false
+	}
+
+	return info.Size() >= r.MinSize
+}
+
+func (r StorageClassRule) matchesPath(path string, info os.FileInfo) bool {
+	if dir := strings.TrimSuffix(r.Pattern, "**"); strings.HasSuffix(dir, "/") {
+		return below(strings.TrimSuffix(dir, "/"), path)
+	}
+
+	byName, _ := filepath.Match(r.Pattern, info.Name())
+	byPath, _ := filepath.Match(r.Pattern, path)
+
+	return byName || byPath
+}
+
+// below is whether any of the directories that path is in matches pattern
+func below(pattern, path string) bool {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if ok, _ := filepath.Match(pattern, dir); ok {
+			return true
+		}
+
+		if dir == filepath.Dir(dir) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fileInfo is just enough of an os.FileInfo to pick a storage class with
+type fileInfo struct {
+	os.FileInfo
+
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (f fileInfo) Name() string       { return f.name }
+func (f fileInfo) Size() int64        { return f.size }
+func (f fileInfo) ModTime() time.Time { return f.modTime }
+
+func Test_StorageClasses_For(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	classes := NewStorageClasses(
+		"STANDARD",
+		StorageClassRule{Pattern: "/home/user/photos/*", OlderThan: 365 * 24 * time.Hour, Class: "GLACIER"},
+		StorageClassRule{Pattern: "*.iso", Class: "STANDARD_IA"},
+		StorageClassRule{MinSize: 1 << 30, Class: "STANDARD_IA"},
+	)
+	classes.now = func() time.Time { return now }
+
+	oldPhoto := fileInfo{name: "a.jpg", size: 100, modTime: now.AddDate(-2, 0, 0)}
+	newPhoto := fileInfo{name: "b.jpg", size: 100, modTime: now.AddDate(0, -1, 0)}
+
+	assert.Equal(t, "GLACIER", classes.For("/home/user/photos/a.jpg", oldPhoto))
+	assert.Equal(t, "STANDARD", classes.For("/home/user/photos/b.jpg", newPhoto))
+	assert.Equal(t, "STANDARD_IA", classes.For("/home/user/disk.iso", fileInfo{name: "disk.iso", modTime: now}))
+	assert.Equal(t, "STANDARD_IA", classes.For("/home/user/video", fileInfo{name: "video", size: 2 << 30, modTime: now}))
+	assert.Equal(t, "STANDARD", classes.For("/home/user/notes", fileInfo{name: "notes", size: 10, modTime: now}))
+}
+
+func Test_StorageClasses_NoRules(t *testing.T) {
+	assert.Equal(t, "", StorageClasses{}.For("/home/user/notes", fileInfo{name: "notes"}))
+}
+
+func Test_StorageClasses_ForDirectories(t *testing.T) {
+	classes := NewStorageClasses(
+		"STANDARD",
+		StorageClassRule{Pattern: "/home/user/photos/", Class: "GLACIER"},
+		StorageClassRule{Pattern: "/home/*/mail/**", Class: "STANDARD_IA"},
+	)
+
+	photo := fileInfo{name: "a.jpg"}
+
+	assert.Equal(t, "GLACIER", classes.For("/home/user/photos/a.jpg", photo))
+	assert.Equal(t, "GLACIER", classes.For("/home/user/photos/2010/summer/a.jpg", photo))
+	assert.Equal(t, "STANDARD", classes.For("/home/user/photos.jpg", fileInfo{name: "photos.jpg"}))
+	assert.Equal(t, "STANDARD", classes.For("/home/user/a.jpg", photo))
+	assert.Equal(t, "STANDARD_IA", classes.For("/home/me/mail/inbox/cur/1", fileInfo{name: "1"}))
+	assert.Equal(t, "STANDARD", classes.For("/home/mail", fileInfo{name: "mail"}))
+}
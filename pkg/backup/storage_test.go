@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// testStorage is a Storage whose every method can be swapped out, left
+// alone it behaves like an empty bucket
+type testStorage struct {
+	list   func(context.Context, string, ListOptions, chan<- Object) error
+	put    func(context.Context, string, io.Reader, int64, PutOptions) error
+	get    func(context.Context, string) (io.ReadCloser, error)
+	remove func(context.Context, string) error
+	stat   func(context.Context, string) (Object, error)
+	tags   func(context.Context, string) (map[string]string, error)
+	copy   func(context.Context, string, string) error
+}
+
+func newTestStorage() *testStorage {
+	return &testStorage{
+		list: func(context.Context, string, ListOptions, chan<- Object) error { return nil },
+		put: func(_ context.Context, _ string, r io.Reader, _ int64, _ PutOptions) error {
+			_, err := io.Copy(ioutil.Discard, r)
+			return err
+		},
+		get:    func(context.Context, string) (io.ReadCloser, error) { return nil, ErrNotFound },
+		remove: func(context.Context, string) error { return nil },
+		stat:   func(context.Context, string) (Object, error) { return Object{}, ErrNotFound },
+		tags:   func(context.Context, string) (map[string]string, error) { return nil, ErrNotFound },
+		copy:   func(context.Context, string, string) error { return nil },
+	}
+}
+
+// listing has the storage list objects, whatever the prefix
+func (t *testStorage) listing(objects ...Object) {
+	t.list = func(ctx context.Context, _ string, _ ListOptions, out chan<- Object) error {
+		for _, o := range objects {
+			if err := SendObject(ctx, out, o); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func (t *testStorage) List(ctx context.Context, prefix string, opts ListOptions, out chan<- Object) error {
+	return t.list(ctx, prefix, opts, out)
+}
+
+func (t *testStorage) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+	return t.put(ctx, key, r, size, opts)
+}
+
+func (t *testStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return t.get(ctx, key)
+}
+
+func (t *testStorage) Remove(ctx context.Context, key string) error {
+	return t.remove(ctx, key)
+}
+
+func (t *testStorage) Stat(ctx context.Context, key string) (Object, error) {
+	return t.stat(ctx, key)
+}
+
+func (t *testStorage) Tags(ctx context.Context, key string) (map[string]string, error) {
+	return t.tags(ctx, key)
+}
+
+func (t *testStorage) Copy(ctx context.Context, src, dst string) error {
+	return t.copy(ctx, src, dst)
+}
+
+func TestStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(StorageTestSuite))
+}
+
+type StorageTestSuite struct {
+	suite.Suite
+	storage *testStorage
+}
+
+func (s *StorageTestSuite) SetupTest() {
+	s.storage = newTestStorage()
+}
+
+func (s *StorageTestSuite) Test_PutFile_GuessesContentType() {
+	dir, err := ioutil.TempDir("", "putFile")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := dir + "/page.html"
+	s.Require().NoError(ioutil.WriteFile(path, []byte("hello"), 0644))
+
+	called := false
+	s.storage.put = func(_ context.Context, key string, r io.Reader, size int64, opts PutOptions) error {
+		body, err := ioutil.ReadAll(r)
+		s.Require().NoError(err)
+
+		s.Equal("key", key)
+		s.Equal("hello", string(body))
+		s.Equal(int64(5), size)
+		s.Equal("text/html; charset=utf-8", opts.ContentType)
+
+		called = true
+		return nil
+	}
+
+	s.Require().NoError(putFile(context.Background(), s.storage, "key", path, PutOptions{}))
+	s.True(called)
+}
+
+func (s *StorageTestSuite) Test_PutFile_KeepsGivenContentType() {
+	file, err := ioutil.TempFile("", "putFile")
+	s.Require().NoError(err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	s.storage.put = func(_ context.Context, _ string, _ io.Reader, _ int64, opts PutOptions) error {
+		s.Equal("application/json", opts.ContentType)
+		return nil
+	}
+
+	s.Require().NoError(putFile(context.Background(), s.storage, "key", file.Name(), PutOptions{ContentType: "application/json"}))
+}
+
+func (s *StorageTestSuite) Test_PutFile_ErrorsForMissingFile() {
+	err := putFile(context.Background(), s.storage, "key", "/does/not/exist", PutOptions{})
+	s.True(os.IsNotExist(err))
+}
+
+func (s *StorageTestSuite) Test_SendObject_StopsWhenCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.Equal(context.Canceled, SendObject(ctx, make(chan Object), Object{Key: "a"}))
+}
+
+func (s *StorageTestSuite) Test_EachObject_StopsAtFirstError() {
+	s.storage.listing(Object{Key: "a"}, Object{Key: "b"}, Object{Key: "c"})
+
+	expectedErr := errors.New("asplode")
+	seen := make([]string, 0)
+
+	err := eachObject(context.Background(), s.storage, "", ListOptions{}, func(o Object) error {
+		seen = append(seen, o.Key)
+		if o.Key == "b" {
+			return expectedErr
+		}
+
+		return nil
+	})
+
+	s.Equal(expectedErr, err)
+	s.Equal([]string{"a", "b"}, seen)
+}
+
+func (s *StorageTestSuite) Test_EachObject_ReturnsListError() {
+	expectedErr := errors.New("asplode")
+	s.storage.list = func(_ context.Context, prefix string, opts ListOptions, _ chan<- Object) error {
+		s.Equal("prefix/", prefix)
+		s.True(opts.Metadata)
+		return expectedErr
+	}
+
+	err := eachObject(context.Background(), s.storage, "prefix/", ListOptions{Metadata: true}, func(Object) error {
+		return nil
+	})
+
+	s.Equal(expectedErr, err)
+}
@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// The tags that objects are given when they are tagged with where they were
+// backed up from
+const (
+	HostTag    = "backup-host"
+	ProfileTag = "backup-profile"
+	RunTag     = "backup-run"
+	SourceTag  = "backup-source"
+)
+
+// ErrNotOwned is what putting or removing an object that was backed up from
+// another host or profile returns, the object is left as it is
+var ErrNotOwned = errors.New("owned by another host or profile")
+
+// Tagging is what objects are tagged with, and given as user metadata, when
+// they are put
+type Tagging struct {
+	// Owned tags every object with the host, profile, run and source dir
+	// it was backed up from. Objects tagged with another host or profile
+	// are then never removed, untagged ones are always taken as owned.
+	Owned   bool
+	Host    string
+	Profile string
+	RunID   string
+
+	// Sources are the target dirs, the source of a file is the one it is in
+	Sources []string
+
+	Tags     map[string]string
+	Metadata map[string]string
+}
+
+// options adds the tags and metadata for the file at path to opts. Extra
+// metadata never replaces what is already there.
+func (t Tagging) options(path string, opts PutOptions) PutOptions {
+	tags := make(map[string]string, len(t.Tags)+4)
+	for k, v := range t.Tags {
+		tags[k] = v
+	}
+
+	if t.Owned {
+		tags[HostTag] = t.Host
+		tags[ProfileTag] = t.Profile
+		tags[RunTag] = t.RunID
+		tags[SourceTag] = t.source(path)
+	}
+
+	if len(tags) > 0 {
+		opts.Tags = tags
+	}
+
+	if len(t.Metadata) > 0 {
+		metadata := make(map[string]string, len(t.Metadata)+len(opts.Metadata))
+		for k, v := range t.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range opts.Metadata {
+			metadata[k] = v
+		}
+
+		opts.Metadata = metadata
+	}
+
+	return opts
+}
+
+// source is the longest of the sources that path is in
+func (t Tagging) source(path string) string {
+	source := ""
+	for _, s := range t.Sources {
+		dir := strings.TrimSuffix(s, "/")
+		if (path == dir || strings.HasPrefix(path, dir+"/")) && len(s) > len(source) {
+			source = s
+		}
+	}
+
+	return source
+}
+
+// owns is whether tags are of an object this host and profile can put or
+// remove
+func (t Tagging) owns(tags map[string]string) bool {
+	if !t.Owned {
+		return true
+	}
+
+	if host, ok := tags[HostTag]; ok && host != t.Host {
+		return false
+	}
+
+	if profile, ok := tags[ProfileTag]; ok && profile != t.Profile {
+		return false
+	}
+
+	return true
+}
+
+// checkOwned looks up the tags of key, for when the listing didn't have
+// them. A key that is already gone is left for removing to sort out.
+func (t Tagging) checkOwned(ctx context.Context, s Storage, key string) error {
+	if !t.Owned {
+		return nil
+	}
+
+	tags, err := s.Tags(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !t.owns(tags) {
+		return ErrNotOwned
+	}
+
+	return nil
+}
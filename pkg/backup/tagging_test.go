@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ownedTagging() Tagging {
+	return Tagging{
+		Owned:   true,
+		Host:    "laptop",
+		Profile: "home",
+		RunID:   "run",
+		Sources: []string{"/home/user/", "/home/user/photos", "/srv"},
+	}
+}
+
+func Test_Tagging_Options(t *testing.T) {
+	tagging := ownedTagging()
+	tagging.Tags = map[string]string{"team": "infra", HostTag: "overridden"}
+	tagging.Metadata = map[string]string{"owner": "me", "size": "overridden"}
+
+	opts := tagging.options("/home/user/photos/a.jpg", PutOptions{
+		StorageClass: "GLACIER",
+		Metadata:     map[string]string{"size": "5"},
+	})
+
+	assert.Equal(t, PutOptions{
+		StorageClass: "GLACIER",
+		Metadata:     map[string]string{"owner": "me", "size": "5"},
+		Tags: map[string]string{
+			"team":     "infra",
+			HostTag:    "laptop",
+			ProfileTag: "home",
+			RunTag:     "run",
+			SourceTag:  "/home/user/photos",
+		},
+	}, opts)
+
+	assert.Equal(t, "/home/user/", tagging.source("/home/user/doc.txt"))
+	assert.Equal(t, "/srv", tagging.source("/srv"))
+	assert.Equal(t, "", tagging.source("/srv2/a"))
+}
+
+func Test_Tagging_OptionsWithoutTagging(t *testing.T) {
+	opts := PutOptions{StorageClass: "GLACIER"}
+	assert.Equal(t, opts, Tagging{}.options("/a", opts))
+
+	tagging := Tagging{Tags: map[string]string{"team": "infra"}}
+	assert.Equal(t, map[string]string{"team": "infra"}, tagging.options("/a", opts).Tags)
+}
+
+func Test_Tagging_Owns(t *testing.T) {
+	tagging := ownedTagging()
+
+	assert.True(t, tagging.owns(nil))
+	assert.True(t, tagging.owns(map[string]string{"team": "infra"}))
+	assert.True(t, tagging.owns(map[string]string{HostTag: "laptop", ProfileTag: "home"}))
+	assert.False(t, tagging.owns(map[string]string{HostTag: "desktop", ProfileTag: "home"}))
+	assert.False(t, tagging.owns(map[string]string{HostTag: "laptop", ProfileTag: "work"}))
+
+	assert.True(t, Tagging{}.owns(map[string]string{HostTag: "desktop"}))
+}
+
+func Test_Tagging_CheckOwned(t *testing.T) {
+	storage := newTestStorage()
+	tagging := ownedTagging()
+	ctx := context.Background()
+
+	storage.tags = func(_ context.Context, key string) (map[string]string, error) {
+		switch key {
+		case "/mine":
+			return map[string]string{HostTag: "laptop"}, nil
+		case "/theirs":
+			return map[string]string{HostTag: "desktop"}, nil
+		case "/gone":
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.New("asplode")
+	}
+
+	require.NoError(t, tagging.checkOwned(ctx, storage, "/mine"))
+	require.NoError(t, tagging.checkOwned(ctx, storage, "/gone"))
+	assert.Equal(t, ErrNotOwned, tagging.checkOwned(ctx, storage, "/theirs"))
+	assert.EqualError(t, tagging.checkOwned(ctx, storage, "/broken"), "asplode")
+
+	// Without tagging the storage isn't even asked
+	require.NoError(t, Tagging{}.checkOwned(ctx, storage, "/broken"))
+}
@@ -0,0 +1,476 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/notify"
+)
+
+// Profile is everything that one backup run needs to know
+type Profile struct {
+	Name string
+
+	TargetDirs []string
+	Excludes   []string
+
+	// Destinations are backed up to side by side, there is always at least
+	// one. Without any destinations in the config file there is a single
+	// unnamed one, made from the settings of the profile.
+	Destinations []Destination
+
+	RemoteWorkerCount int
+	GatherWorkerCount int
+
+	DryRun      bool
+	DetectMoves bool
+	Dedup       bool
+
+	PackThreshold int64
+	PackMaxSize   int64
+	PackCompress  bool
+
+	// TagObjects tags every object with where it was backed up from, see
+	// backup.Tagging. Tags and Metadata are put on every object as well.
+	TagObjects bool
+	Tags       map[string]string
+	Metadata   map[string]string
+}
+
+// Destination is somewhere that a profile is backed up to
+type Destination struct {
+	Name string
+
+	// Storage is "s3", the default, or "local" to back up to StorageDir
+	Storage    string
+	StorageDir string
+
+	S3Host       string
+	S3AccessKey  string
+	S3SecretKey  string
+	S3BucketName string
+
+	// CreateBucket has a missing bucket created, in Region, rather than
+	// the run stopping
+	CreateBucket bool
+
+	// StorageClass is what files are uploaded with unless one of the
+	// StorageClassRules says otherwise, empty leaves it to the bucket
+	StorageClass      string
+	StorageClassRules []backup.StorageClassRule
+
+	// Credentials says where the keys come from, see the credential package
+	Credentials        string
+	S3SecretKeyFile    string
+	CredentialsFile    string
+	CredentialsProfile string
+	CredentialProcess  string
+
+	// How to reach the host, see the s3 package
+	InsecureHTTP  bool
+	CACertFile    string
+	SkipTLSVerify bool
+	Region        string
+	BucketLookup  string
+}
+
+// Names are the profiles in the config file, in name order. Like every
+// other key in viper they are lower case no matter how they were written.
+func Names(v *viper.Viper) []string {
+	names := make([]string, 0)
+	for name := range v.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Load returns the settings for the named profile. A flag or env variable
+// that was actually given wins, then what the profile says, then whatever
+// is at the top level of the config file and finally the default. An empty
+// name only uses the top level, that is how things worked before profiles.
+//
+// Destinations are read from the profile or, if it has none, the top level.
+// Whatever a destination says wins over everything else, anything it leaves
+// out is looked up as for the profile.
+func Load(v *viper.Viper, name string, overridden func(key string) bool) (Profile, error) {
+	name = strings.ToLower(name)
+
+	if name != "" && v.Get("profiles."+name) == nil {
+		return Profile{}, fmt.Errorf("'Load' error: no profile named '%s'", name)
+	}
+
+	s := settings{v: v, profile: name, overridden: overridden}
+
+	p := Profile{
+		Name:              name,
+		TargetDirs:        s.list("targetDirs"),
+		Excludes:          s.list("excludes"),
+		RemoteWorkerCount: cast.ToInt(s.get("remoteWorkerCount")),
+		GatherWorkerCount: cast.ToInt(s.get("gatherWorkerCount")),
+		DryRun:            cast.ToBool(s.get("dryRun")),
+		DetectMoves:       cast.ToBool(s.get("detectMoves")),
+		Dedup:             cast.ToBool(s.get("dedup")),
+		PackThreshold:     cast.ToInt64(s.get("packThreshold")),
+		PackMaxSize:       cast.ToInt64(s.get("packMaxSize")),
+		PackCompress:      cast.ToBool(s.get("packCompress")),
+		TagObjects:        cast.ToBool(s.get("tagObjects")),
+	}
+
+	var err error
+	if p.Tags, err = s.stringMap("tags"); err != nil {
+		return Profile{}, err
+	}
+
+	if p.Metadata, err = s.stringMap("metadata"); err != nil {
+		return Profile{}, err
+	}
+
+	tagCount := len(p.Tags)
+	if p.TagObjects {
+		tagCount += 4
+	}
+
+	if tagCount > maxTags {
+		return Profile{}, fmt.Errorf("'Load' error: objects can have at most %d tags but %d would be set", maxTags, tagCount)
+	}
+
+	if len(p.TargetDirs) == 0 {
+		return Profile{}, errors.New("'Load' error: target dirs cannot be missing")
+	}
+
+	// Keys are the paths of the files, a relative target dir would have
+	// them clash with the keys that aren't backed up files
+	for _, dir := range p.TargetDirs {
+		if !filepath.IsAbs(dir) {
+			return Profile{}, fmt.Errorf("'Load' error: target dir '%s' has to be a full path", dir)
+		}
+	}
+
+	for _, pattern := range p.Excludes {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return Profile{}, fmt.Errorf("'Load' error: bad exclude pattern '%s'", pattern)
+		}
+	}
+
+	base := "destinations"
+	if name != "" && v.Get("profiles."+name+".destinations") != nil {
+		base = "profiles." + name + ".destinations"
+	}
+
+	names := make([]string, 0)
+	for dest := range v.GetStringMap(base) {
+		names = append(names, dest)
+	}
+
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		names = append(names, "")
+	}
+
+	for _, dest := range names {
+		d, err := loadDestination(s, base, dest)
+		if err != nil {
+			return Profile{}, err
+		}
+
+		p.Destinations = append(p.Destinations, d)
+	}
+
+	return p, nil
+}
+
+func loadDestination(s settings, base, name string) (Destination, error) {
+	get := s.get
+	if name != "" {
+		get = func(key string) interface{} {
+			if val := s.v.Get(base + "." + name + "." + key); val != nil {
+				return val
+			}
+
+			return s.get(key)
+		}
+	}
+
+	d := Destination{
+		Name:               name,
+		Storage:            cast.ToString(get("storage")),
+		StorageDir:         cast.ToString(get("storageDir")),
+		S3Host:             cast.ToString(get("s3Host")),
+		S3AccessKey:        cast.ToString(get("s3AccessKey")),
+		S3SecretKey:        cast.ToString(get("s3SecretKey")),
+		S3BucketName:       cast.ToString(get("s3BucketName")),
+		CreateBucket:       cast.ToBool(get("createBucket")),
+		StorageClass:       cast.ToString(get("storageClass")),
+		Credentials:        cast.ToString(get("credentials")),
+		S3SecretKeyFile:    cast.ToString(get("s3SecretKeyFile")),
+		CredentialsFile:    cast.ToString(get("credentialsFile")),
+		CredentialsProfile: cast.ToString(get("credentialsProfile")),
+		CredentialProcess:  cast.ToString(get("credentialProcess")),
+		InsecureHTTP:       cast.ToBool(get("insecureHTTP")),
+		CACertFile:         cast.ToString(get("caCertFile")),
+		SkipTLSVerify:      cast.ToBool(get("skipTLSVerify")),
+		Region:             cast.ToString(get("region")),
+		BucketLookup:       cast.ToString(get("bucketLookup")),
+	}
+
+	rules, err := storageClassRules(get("storageClassRules"))
+	if err != nil {
+		return Destination{}, err
+	}
+	d.StorageClassRules = rules
+
+	switch d.Storage {
+	case "", "s3", "local":
+	default:
+		if name != "" {
+			return Destination{}, fmt.Errorf("'Load' error: storage '%s' of destination '%s' is not one of s3 or local", d.Storage, name)
+		}
+
+		return Destination{}, fmt.Errorf("'Load' error: storage '%s' is not one of s3 or local", d.Storage)
+	}
+
+	return d, nil
+}
+
+// storageClassRules reads a list of rules that each have a class and any of
+// a pattern, an olderThan age and a minSize
+func storageClassRules(raw interface{}) ([]backup.StorageClassRule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	items, err := cast.ToSliceE(raw)
+	if err != nil {
+		return nil, errors.New("'Load' error: storage class rules have to be a list")
+	}
+
+	rules := make([]backup.StorageClassRule, len(items))
+	for i, item := range items {
+		fields, err := cast.ToStringMapE(item)
+		if err != nil {
+			return nil, fmt.Errorf("'Load' error: storage class rule %d is not a map", i+1)
+		}
+
+		// Keys inside of a list aren't lower cased for us
+		rule := make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			rule[strings.ToLower(k)] = v
+		}
+
+		r := backup.StorageClassRule{
+			Pattern: cast.ToString(rule["pattern"]),
+			Class:   cast.ToString(rule["class"]),
+		}
+
+		if r.Class == "" {
+			return nil, fmt.Errorf("'Load' error: storage class rule %d has no class", i+1)
+		}
+
+		if _, err := filepath.Match(r.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("'Load' error: bad pattern '%s' in storage class rule %d", r.Pattern, i+1)
+		}
+
+		if r.OlderThan, err = age(cast.ToString(rule["olderthan"])); err != nil {
+			return nil, fmt.Errorf("'Load' error: bad olderThan '%s' in storage class rule %d", rule["olderthan"], i+1)
+		}
+
+		if r.MinSize, err = cast.ToInt64E(rule["minsize"]); err != nil {
+			return nil, fmt.Errorf("'Load' error: bad minSize '%v' in storage class rule %d", rule["minsize"], i+1)
+		}
+
+		rules[i] = r
+	}
+
+	return rules, nil
+}
+
+// age is a duration that can also be given in days, like 365d
+func age(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+
+	return time.ParseDuration(value)
+}
+
+// Notifier is somewhere that is told how a run went, it has one of a
+// Webhook, Email addresses or a Command
+type Notifier struct {
+	Webhook string
+	Email   []string
+	Command string
+
+	Rule notify.Rule
+}
+
+// Notifiers reads the notifiers list at the top of the config file. They are
+// about a whole run, with --all too, so profiles can't have any of their
+// own. A notifier is told about every run unless it says otherwise, with
+// when rather than on, which YAML takes to be true.
+func Notifiers(v *viper.Viper) ([]Notifier, error) {
+	raw := v.Get("notifiers")
+	if raw == nil {
+		return nil, nil
+	}
+
+	items, err := cast.ToSliceE(raw)
+	if err != nil {
+		return nil, errors.New("'Notifiers' error: notifiers have to be a list")
+	}
+
+	notifiers := make([]Notifier, len(items))
+	for i, item := range items {
+		fields, err := cast.ToStringMapE(item)
+		if err != nil {
+			return nil, fmt.Errorf("'Notifiers' error: notifier %d is not a map", i+1)
+		}
+
+		// Keys inside of a list aren't lower cased for us
+		settings := make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			settings[strings.ToLower(k)] = v
+		}
+
+		n := Notifier{
+			Webhook: cast.ToString(settings["webhook"]),
+			Command: cast.ToString(settings["command"]),
+			Rule:    notify.Rule{When: cast.ToString(settings["when"])},
+		}
+
+		// Addresses are a list or, like every other list, a comma separated
+		// string
+		var email []string
+		if str, ok := settings["email"].(string); ok {
+			email = strings.Split(str, ",")
+		} else {
+			email = cast.ToStringSlice(settings["email"])
+		}
+
+		for _, address := range email {
+			if address = strings.TrimSpace(address); address != "" {
+				n.Email = append(n.Email, address)
+			}
+		}
+
+		kinds := 0
+		for _, set := range []bool{n.Webhook != "", len(n.Email) > 0, n.Command != ""} {
+			if set {
+				kinds++
+			}
+		}
+
+		if kinds != 1 {
+			return nil, fmt.Errorf("'Notifiers' error: notifier %d has to have one of a webhook, email or command", i+1)
+		}
+
+		switch n.Rule.When {
+		case "":
+			n.Rule.When = notify.Always
+		case notify.Always, notify.OnFailure, notify.Never:
+		default:
+			return nil, fmt.Errorf("'Notifiers' error: when '%s' of notifier %d is not one of always, failure or never", n.Rule.When, i+1)
+		}
+
+		if n.Rule.RemovedOver, err = cast.ToIntE(settings["removedover"]); err != nil || n.Rule.RemovedOver < 0 {
+			return nil, fmt.Errorf("'Notifiers' error: bad removedOver '%v' of notifier %d", settings["removedover"], i+1)
+		}
+
+		notifiers[i] = n
+	}
+
+	return notifiers, nil
+}
+
+// maxTags is as many tags as S3 allows on an object
+const maxTags = 10
+
+type settings struct {
+	v          *viper.Viper
+	profile    string
+	overridden func(key string) bool
+}
+
+func (s settings) get(key string) interface{} {
+	if s.profile != "" && !s.overridden(key) {
+		if val := s.v.Get("profiles." + s.profile + "." + key); val != nil {
+			return val
+		}
+	}
+
+	return s.v.Get(key)
+}
+
+// stringMap reads a setting that is either a map or, as flags and env
+// variables have it, a comma separated string of key=value pairs
+func (s settings) stringMap(key string) (map[string]string, error) {
+	raw := s.get(key)
+	if raw == nil {
+		return nil, nil
+	}
+
+	str, ok := raw.(string)
+	if !ok {
+		m, err := cast.ToStringMapStringE(raw)
+		if err != nil {
+			return nil, fmt.Errorf("'Load' error: '%s' has to be a map", key)
+		}
+
+		return m, nil
+	}
+
+	var m map[string]string
+	for _, pair := range strings.Split(str, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("'Load' error: bad pair '%s' in '%s', it has to be key=value", pair, key)
+		}
+
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[kv[0]] = kv[1]
+	}
+
+	return m, nil
+}
+
+// list reads a setting that is either a list or, as flags and env
+// variables have it, a comma separated string
+func (s settings) list(key string) []string {
+	var values []string
+	if str, ok := s.get(key).(string); ok {
+		values = strings.Split(str, ",")
+	} else {
+		values = cast.ToStringSlice(s.get(key))
+	}
+
+	list := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+
+	return list
+}
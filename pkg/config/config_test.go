@@ -0,0 +1,475 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/notify"
+)
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
+
+type ConfigTestSuite struct {
+	suite.Suite
+
+	v          *viper.Viper
+	overridden map[string]bool
+}
+
+const yamlConfig = `
+s3Host: s3.example.com
+s3BucketName: default-bucket
+remoteWorkerCount: 3
+
+profiles:
+  Home:
+    targetDirs:
+      - /home/user/docs
+      - /home/user/photos
+    excludes: ["*.tmp", node_modules]
+    s3BucketName: home-bucket
+    dedup: true
+  mail:
+    targetDirs: /var/mail,/var/spool
+    remoteWorkerCount: 8
+    packThreshold: 4096
+    credentials: file
+    credentialsProfile: mail
+    insecureHTTP: true
+    bucketLookup: path
+    createBucket: true
+`
+
+func (s *ConfigTestSuite) SetupTest() {
+	s.v = viper.New()
+	s.overridden = make(map[string]bool)
+
+	s.v.SetDefault("remoteWorkerCount", 5)
+	s.v.SetDefault("gatherWorkerCount", 4)
+	s.v.SetDefault("detectMoves", true)
+	s.v.SetDefault("packMaxSize", 1024)
+}
+
+func (s *ConfigTestSuite) read(configType, config string) {
+	s.v.SetConfigType(configType)
+	s.Require().NoError(s.v.ReadConfig(strings.NewReader(config)))
+}
+
+func (s *ConfigTestSuite) load(name string) (Profile, error) {
+	return Load(s.v, name, func(key string) bool {
+		return s.overridden[key]
+	})
+}
+
+func (s *ConfigTestSuite) Test_Names_AreSorted() {
+	s.read("yaml", yamlConfig)
+
+	s.Equal([]string{"home", "mail"}, Names(s.v))
+}
+
+func (s *ConfigTestSuite) Test_Names_NoProfiles() {
+	s.Empty(Names(s.v))
+}
+
+func (s *ConfigTestSuite) Test_Load_ProfileOverTopLevelOverDefaults() {
+	s.read("yaml", yamlConfig)
+
+	p, err := s.load("Home")
+	s.Require().NoError(err)
+
+	s.Equal(Profile{
+		Name:       "home",
+		TargetDirs: []string{"/home/user/docs", "/home/user/photos"},
+		Excludes:   []string{"*.tmp", "node_modules"},
+		Destinations: []Destination{{
+			S3Host:       "s3.example.com",
+			S3BucketName: "home-bucket",
+		}},
+		RemoteWorkerCount: 3,
+		GatherWorkerCount: 4,
+		DetectMoves:       true,
+		Dedup:             true,
+		PackMaxSize:       1024,
+	}, p)
+}
+
+func (s *ConfigTestSuite) Test_Load_CommaSeparatedLists() {
+	s.read("yaml", yamlConfig)
+
+	p, err := s.load("mail")
+	s.Require().NoError(err)
+
+	s.Equal([]string{"/var/mail", "/var/spool"}, p.TargetDirs)
+	s.Empty(p.Excludes)
+	s.Equal(8, p.RemoteWorkerCount)
+	s.Equal(int64(4096), p.PackThreshold)
+	s.Equal("default-bucket", p.Destinations[0].S3BucketName)
+	s.Equal("file", p.Destinations[0].Credentials)
+	s.Equal("mail", p.Destinations[0].CredentialsProfile)
+	s.True(p.Destinations[0].InsecureHTTP)
+	s.Equal("path", p.Destinations[0].BucketLookup)
+	s.True(p.Destinations[0].CreateBucket)
+}
+
+func (s *ConfigTestSuite) Test_Load_OverriddenSettingsBeatTheProfile() {
+	s.read("yaml", yamlConfig)
+	s.v.Set("s3BucketName", "flag-bucket")
+	s.overridden["s3BucketName"] = true
+
+	p, err := s.load("home")
+	s.Require().NoError(err)
+
+	s.Equal("flag-bucket", p.Destinations[0].S3BucketName)
+}
+
+func (s *ConfigTestSuite) Test_Load_NoProfile() {
+	s.v.Set("targetDirs", "/home/user, /etc")
+
+	p, err := s.load("")
+	s.Require().NoError(err)
+
+	s.Equal("", p.Name)
+	s.Equal([]string{"/home/user", "/etc"}, p.TargetDirs)
+	s.Equal(5, p.RemoteWorkerCount)
+}
+
+func (s *ConfigTestSuite) Test_Load_Toml() {
+	s.read("toml", `
+s3Host = "s3.example.com"
+
+[profiles.work]
+targetDirs = ["/work"]
+s3BucketName = "work-bucket"
+packCompress = true
+`)
+
+	p, err := s.load("work")
+	s.Require().NoError(err)
+
+	s.Equal([]string{"/work"}, p.TargetDirs)
+	s.Equal("s3.example.com", p.Destinations[0].S3Host)
+	s.Equal("work-bucket", p.Destinations[0].S3BucketName)
+	s.True(p.PackCompress)
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorUnknownProfile() {
+	s.read("yaml", yamlConfig)
+
+	_, err := s.load("nope")
+
+	s.Equal(errors.New("'Load' error: no profile named 'nope'"), err)
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorRelativeTargetDir() {
+	s.v.Set("targetDirs", "/home/user/docs/,photos")
+
+	_, err := s.load("")
+	s.Equal(errors.New("'Load' error: target dir 'photos' has to be a full path"), err)
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorMissingTargetDirs() {
+	_, err := s.load("")
+
+	s.Equal(errors.New("'Load' error: target dirs cannot be missing"), err)
+}
+
+func (s *ConfigTestSuite) Test_Load_LocalStorage() {
+	s.v.Set("targetDirs", "/home/user")
+	s.v.Set("storage", "local")
+	s.v.Set("storageDir", "/mnt/backup")
+
+	p, err := s.load("")
+	s.Require().NoError(err)
+
+	s.Equal("local", p.Destinations[0].Storage)
+	s.Equal("/mnt/backup", p.Destinations[0].StorageDir)
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorUnknownStorage() {
+	s.v.Set("targetDirs", "/home/user")
+	s.v.Set("storage", "tape")
+
+	_, err := s.load("")
+
+	s.Equal(errors.New("'Load' error: storage 'tape' is not one of s3 or local"), err)
+}
+
+const destinationsConfig = `
+targetDirs: /home/user
+s3Host: s3.example.com
+s3BucketName: default-bucket
+
+destinations:
+  nas:
+    storage: local
+    storageDir: /mnt/nas
+  offsite:
+    s3BucketName: offsite-bucket
+
+profiles:
+  work:
+    targetDirs: /work
+    destinations:
+      Cloud:
+        s3Host: s3.work.example.com
+  home:
+    targetDirs: /home/user
+`
+
+func (s *ConfigTestSuite) Test_Load_Destinations() {
+	s.read("yaml", destinationsConfig)
+	s.v.Set("s3BucketName", "flag-bucket")
+	s.overridden["s3BucketName"] = true
+
+	p, err := s.load("")
+	s.Require().NoError(err)
+
+	s.Equal([]Destination{
+		{
+			Name:         "nas",
+			Storage:      "local",
+			StorageDir:   "/mnt/nas",
+			S3Host:       "s3.example.com",
+			S3BucketName: "flag-bucket",
+		},
+		{
+			Name:         "offsite",
+			S3Host:       "s3.example.com",
+			S3BucketName: "offsite-bucket",
+		},
+	}, p.Destinations)
+}
+
+func (s *ConfigTestSuite) Test_Load_ProfileDestinationsReplaceTopLevel() {
+	s.read("yaml", destinationsConfig)
+
+	p, err := s.load("work")
+	s.Require().NoError(err)
+
+	s.Equal([]Destination{{
+		Name:         "cloud",
+		S3Host:       "s3.work.example.com",
+		S3BucketName: "default-bucket",
+	}}, p.Destinations)
+
+	p, err = s.load("home")
+	s.Require().NoError(err)
+
+	s.Len(p.Destinations, 2)
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorUnknownDestinationStorage() {
+	s.read("yaml", destinationsConfig)
+	s.v.Set("destinations.nas.storage", "tape")
+
+	_, err := s.load("")
+
+	s.Equal(errors.New("'Load' error: storage 'tape' of destination 'nas' is not one of s3 or local"), err)
+}
+
+func (s *ConfigTestSuite) Test_Load_StorageClassRules() {
+	s.read("yaml", `
+targetDirs: /home/user
+storageClass: STANDARD
+storageClassRules:
+  - pattern: /home/user/photos/*
+    olderThan: 365d
+    class: GLACIER
+  - minSize: 1073741824
+    class: STANDARD_IA
+  - OlderThan: 48h
+    Class: ONEZONE_IA
+`)
+
+	p, err := s.load("")
+	s.Require().NoError(err)
+
+	s.Equal("STANDARD", p.Destinations[0].StorageClass)
+	s.Equal([]backup.StorageClassRule{
+		{Pattern: "/home/user/photos/*", OlderThan: 365 * 24 * time.Hour, Class: "GLACIER"},
+		{MinSize: 1 << 30, Class: "STANDARD_IA"},
+		{OlderThan: 48 * time.Hour, Class: "ONEZONE_IA"},
+	}, p.Destinations[0].StorageClassRules)
+}
+
+func (s *ConfigTestSuite) Test_Load_StorageClassRulesToml() {
+	s.read("toml", `
+targetDirs = ["/home/user"]
+
+[[storageClassRules]]
+pattern = "*.iso"
+class = "STANDARD_IA"
+`)
+
+	p, err := s.load("")
+	s.Require().NoError(err)
+
+	s.Equal([]backup.StorageClassRule{{Pattern: "*.iso", Class: "STANDARD_IA"}}, p.Destinations[0].StorageClassRules)
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorBadStorageClassRules() {
+	s.v.Set("targetDirs", "/home/user")
+
+	rule := func(fields ...string) []interface{} {
+		m := make(map[string]interface{})
+		for i := 0; i < len(fields); i += 2 {
+			m[fields[i]] = fields[i+1]
+		}
+		return []interface{}{m}
+	}
+
+	for _, t := range []struct {
+		rules    interface{}
+		expected string
+	}{
+		{"GLACIER", "'Load' error: storage class rules have to be a list"},
+		{[]interface{}{"GLACIER"}, "'Load' error: storage class rule 1 is not a map"},
+		{rule("pattern", "*"), "'Load' error: storage class rule 1 has no class"},
+		{rule("pattern", "[a-", "class", "GLACIER"), "'Load' error: bad pattern '[a-' in storage class rule 1"},
+		{rule("olderThan", "old", "class", "GLACIER"), "'Load' error: bad olderThan 'old' in storage class rule 1"},
+		{rule("minSize", "big", "class", "GLACIER"), "'Load' error: bad minSize 'big' in storage class rule 1"},
+	} {
+		s.v.Set("storageClassRules", t.rules)
+
+		_, err := s.load("")
+
+		s.Equal(errors.New(t.expected), err)
+	}
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorBadExclude() {
+	s.v.Set("targetDirs", "/home/user")
+	s.v.Set("excludes", "[a-")
+
+	_, err := s.load("")
+
+	s.Equal(errors.New("'Load' error: bad exclude pattern '[a-'"), err)
+}
+
+func (s *ConfigTestSuite) Test_Load_TagsAndMetadata() {
+	s.read("yaml", `
+targetDirs: /home/user
+tags:
+  team: infra
+profiles:
+  home:
+    tagObjects: true
+    metadata:
+      owner: me
+`)
+	s.v.Set("metadata", "owner=nobody,site=home")
+
+	p, err := s.load("home")
+	s.Require().NoError(err)
+
+	s.True(p.TagObjects)
+	s.Equal(map[string]string{"team": "infra"}, p.Tags)
+	s.Equal(map[string]string{"owner": "me"}, p.Metadata)
+
+	p, err = s.load("")
+	s.Require().NoError(err)
+
+	s.False(p.TagObjects)
+	s.Equal(map[string]string{"owner": "nobody", "site": "home"}, p.Metadata)
+
+	s.v.Set("metadata", " , ")
+
+	p, err = s.load("")
+	s.Require().NoError(err)
+	s.Nil(p.Metadata)
+}
+
+func (s *ConfigTestSuite) Test_Load_ErrorBadTags() {
+	tooMany := make(map[string]interface{})
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		tooMany[k] = "x"
+	}
+
+	for _, t := range []struct {
+		key      string
+		value    interface{}
+		expected string
+	}{
+		{"tags", []interface{}{"a"}, "'Load' error: 'tags' has to be a map"},
+		{"metadata", "owner", "'Load' error: bad pair 'owner' in 'metadata', it has to be key=value"},
+		{"tags", "=infra", "'Load' error: bad pair '=infra' in 'tags', it has to be key=value"},
+		{"tags", tooMany, "'Load' error: objects can have at most 10 tags but 11 would be set"},
+	} {
+		s.SetupTest()
+		s.v.Set("targetDirs", "/home/user")
+		s.v.Set("tagObjects", true)
+		s.v.Set(t.key, t.value)
+
+		_, err := s.load("")
+
+		s.Equal(errors.New(t.expected), err)
+	}
+}
+
+func (s *ConfigTestSuite) Test_Notifiers() {
+	s.read("yaml", `
+notifiers:
+  - webhook: https://hooks.example.com/backup
+    when: failure
+  - email: me@example.com, you@example.com
+    removedOver: 100
+  - Email: [me@example.com]
+    When: never
+    RemovedOver: 10
+  - command: /usr/local/bin/backup-done
+`)
+
+	notifiers, err := Notifiers(s.v)
+	s.Require().NoError(err)
+
+	s.Equal([]Notifier{
+		{Webhook: "https://hooks.example.com/backup", Rule: notify.Rule{When: notify.OnFailure}},
+		{Email: []string{"me@example.com", "you@example.com"}, Rule: notify.Rule{When: notify.Always, RemovedOver: 100}},
+		{Email: []string{"me@example.com"}, Rule: notify.Rule{When: notify.Never, RemovedOver: 10}},
+		{Command: "/usr/local/bin/backup-done", Rule: notify.Rule{When: notify.Always}},
+	}, notifiers)
+}
+
+func (s *ConfigTestSuite) Test_Notifiers_None() {
+	notifiers, err := Notifiers(s.v)
+
+	s.NoError(err)
+	s.Empty(notifiers)
+}
+
+func (s *ConfigTestSuite) Test_Notifiers_Errors() {
+	notifier := func(fields ...string) []interface{} {
+		m := make(map[string]interface{})
+		for i := 0; i < len(fields); i += 2 {
+			m[fields[i]] = fields[i+1]
+		}
+		return []interface{}{m}
+	}
+
+	for _, t := range []struct {
+		notifiers interface{}
+		expected  string
+	}{
+		{"https://hooks.example.com", "'Notifiers' error: notifiers have to be a list"},
+		{[]interface{}{"https://hooks.example.com"}, "'Notifiers' error: notifier 1 is not a map"},
+		{notifier("when", "failure"), "'Notifiers' error: notifier 1 has to have one of a webhook, email or command"},
+		{notifier("webhook", "https://hooks.example.com", "command", "true"), "'Notifiers' error: notifier 1 has to have one of a webhook, email or command"},
+		{notifier("command", "true", "when", "sometimes"), "'Notifiers' error: when 'sometimes' of notifier 1 is not one of always, failure or never"},
+		{notifier("command", "true", "removedOver", "lots"), "'Notifiers' error: bad removedOver 'lots' of notifier 1"},
+		{notifier("command", "true", "removedOver", "-1"), "'Notifiers' error: bad removedOver '-1' of notifier 1"},
+	} {
+		s.v.Set("notifiers", t.notifiers)
+
+		_, err := Notifiers(s.v)
+
+		s.Equal(errors.New(t.expected), err)
+	}
+}
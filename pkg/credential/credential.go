@@ -0,0 +1,77 @@
+package credential
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// The places credentials can come from
+const (
+	STATIC  = "static"
+	FILE    = "file"
+	PROCESS = "process"
+	CHAIN   = "chain"
+)
+
+type Options struct {
+	// Source is one of the constants above, empty means STATIC
+	Source string
+
+	AccessKey     string
+	SecretKey     string
+	SecretKeyFile string
+
+	// File and FileProfile pick a profile out of an AWS style shared
+	// credentials file, empty means the same defaults the AWS tools use
+	File        string
+	FileProfile string
+
+	// Process is a command that prints credentials as JSON, the way the
+	// AWS tools' credential_process does
+	Process string
+}
+
+// New builds the credentials described by o. Apart from a secret key file,
+// which is read straight away so that a missing file is noticed before
+// anything else happens, nothing is read until the credentials are used.
+func New(o Options) (*credentials.Credentials, error) {
+	switch o.Source {
+	case "", STATIC:
+		secret := o.SecretKey
+		if o.SecretKeyFile != "" {
+			b, err := ioutil.ReadFile(o.SecretKeyFile)
+			if err != nil {
+				return nil, err
+			}
+
+			secret = strings.TrimSpace(string(b))
+		}
+
+		return credentials.NewStaticV4(o.AccessKey, secret, ""), nil
+	case FILE:
+		return credentials.New(&sharedFile{filename: o.File, profile: o.FileProfile}), nil
+	case PROCESS:
+		if o.Process == "" {
+			return nil, errors.New("'New' error: credential process cannot be missing")
+		}
+
+		return credentials.New(&process{command: o.Process}), nil
+	case CHAIN:
+		// The same order the AWS tools look in, with minio's own places
+		// after the AWS ones
+		return credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&sharedFile{filename: o.File, profile: o.FileProfile},
+			&credentials.FileMinioClient{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		}), nil
+	default:
+		return nil, fmt.Errorf("'New' error: unknown credential source '%s'", o.Source)
+	}
+}
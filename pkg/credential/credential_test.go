@@ -0,0 +1,234 @@
+package credential
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCredentialTestSuite(t *testing.T) {
+	suite.Run(t, new(CredentialTestSuite))
+}
+
+type CredentialTestSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (s *CredentialTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "credential")
+	s.Require().NoError(err)
+	s.dir = dir
+
+	// Nothing from the environment running the tests may leak in
+	for _, env := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY", "AWS_SESSION_TOKEN",
+		"MINIO_ACCESS_KEY", "MINIO_SECRET_KEY", "MINIO_ROOT_USER", "MINIO_ROOT_PASSWORD",
+		"AWS_SHARED_CREDENTIALS_FILE", "AWS_PROFILE",
+	} {
+		s.T().Setenv(env, "")
+	}
+}
+
+func (s *CredentialTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *CredentialTestSuite) write(name, content string) string {
+	path := filepath.Join(s.dir, name)
+	s.Require().NoError(ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func (s *CredentialTestSuite) get(o Options) (credentials.Value, error) {
+	creds, err := New(o)
+	s.Require().NoError(err)
+
+	return creds.Get()
+}
+
+const sharedCredentials = `
+[default]
+aws_access_key_id = defaultKey
+aws_secret_access_key = defaultSecret
+
+[backup]
+aws_access_key_id = backupKey
+aws_secret_access_key = backupSecret
+
+[process]
+credential_process = echo '{"Version": 1, "AccessKeyId": "processKey", "SecretAccessKey": "processSecret"}'
+`
+
+func (s *CredentialTestSuite) Test_Static() {
+	value, err := s.get(Options{AccessKey: "key", SecretKey: "secret"})
+
+	s.Require().NoError(err)
+	s.Equal("key", value.AccessKeyID)
+	s.Equal("secret", value.SecretAccessKey)
+	s.Equal(credentials.SignatureV4, value.SignerType)
+}
+
+func (s *CredentialTestSuite) Test_Static_SecretKeyFile() {
+	file := s.write("secret", "fromFile\n")
+
+	value, err := s.get(Options{Source: STATIC, AccessKey: "key", SecretKey: "ignored", SecretKeyFile: file})
+
+	s.Require().NoError(err)
+	s.Equal("fromFile", value.SecretAccessKey)
+}
+
+func (s *CredentialTestSuite) Test_Static_MissingSecretKeyFile() {
+	_, err := New(Options{AccessKey: "key", SecretKeyFile: filepath.Join(s.dir, "missing")})
+
+	s.True(os.IsNotExist(err))
+}
+
+func (s *CredentialTestSuite) Test_File_Profile() {
+	file := s.write("credentials", sharedCredentials)
+
+	value, err := s.get(Options{Source: FILE, File: file, FileProfile: "backup"})
+
+	s.Require().NoError(err)
+	s.Equal("backupKey", value.AccessKeyID)
+	s.Equal("backupSecret", value.SecretAccessKey)
+}
+
+func (s *CredentialTestSuite) Test_File_Defaults() {
+	home := filepath.Join(s.dir, "home")
+	s.Require().NoError(os.MkdirAll(filepath.Join(home, ".aws"), 0700))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(home, ".aws", "credentials"), []byte(sharedCredentials), 0600))
+	s.T().Setenv("HOME", home)
+
+	value, err := s.get(Options{Source: FILE})
+
+	s.Require().NoError(err)
+	s.Equal("defaultKey", value.AccessKeyID)
+}
+
+func (s *CredentialTestSuite) Test_File_AwsEnvironment() {
+	s.T().Setenv("AWS_SHARED_CREDENTIALS_FILE", s.write("credentials", sharedCredentials))
+	s.T().Setenv("AWS_PROFILE", "backup")
+
+	value, err := s.get(Options{Source: FILE})
+
+	s.Require().NoError(err)
+	s.Equal("backupKey", value.AccessKeyID)
+}
+
+func (s *CredentialTestSuite) Test_File_CredentialProcess() {
+	file := s.write("credentials", sharedCredentials)
+
+	value, err := s.get(Options{Source: FILE, File: file, FileProfile: "process"})
+
+	s.Require().NoError(err)
+	s.Equal("processKey", value.AccessKeyID)
+	s.Equal("processSecret", value.SecretAccessKey)
+}
+
+func (s *CredentialTestSuite) Test_File_Errors() {
+	file := s.write("credentials", sharedCredentials)
+
+	_, err := s.get(Options{Source: FILE, File: filepath.Join(s.dir, "missing")})
+	s.Error(err)
+
+	_, err = s.get(Options{Source: FILE, File: file, FileProfile: "missing"})
+	s.Error(err)
+
+	s.T().Setenv("HOME", "")
+	_, err = s.get(Options{Source: FILE})
+	s.Error(err)
+}
+
+func (s *CredentialTestSuite) Test_Process() {
+	value, err := s.get(Options{
+		Source:  PROCESS,
+		Process: `echo '{"Version": 1, "AccessKeyId": "key", "SecretAccessKey": "secret", "SessionToken": "token"}'`,
+	})
+
+	s.Require().NoError(err)
+	s.Equal(credentials.Value{
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		SignerType:      credentials.SignatureV4,
+	}, value)
+}
+
+func (s *CredentialTestSuite) Test_Process_RunsAgainOnceExpired() {
+	counter := s.write("counter", "")
+	expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	p := &process{command: `echo run >> ` + counter + `; echo '{"Version": 1, "AccessKeyId": "key", "Expiration": "` + expiration + `"}'`}
+	creds := credentials.New(p)
+
+	_, err := creds.Get()
+	s.Require().NoError(err)
+	_, err = creds.Get()
+	s.Require().NoError(err)
+
+	p.CurrentTime = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = creds.Get()
+	s.Require().NoError(err)
+
+	runs, err := ioutil.ReadFile(counter)
+	s.Require().NoError(err)
+	s.Equal("run\nrun\n", string(runs))
+}
+
+func (s *CredentialTestSuite) Test_Process_Errors() {
+	for _, command := range []string{
+		"exit 1",
+		"echo nope",
+		`echo '{"Version": 2}'`,
+	} {
+		_, err := s.get(Options{Source: PROCESS, Process: command})
+		s.Error(err, command)
+	}
+}
+
+func (s *CredentialTestSuite) Test_Process_ErrorMissingCommand() {
+	_, err := New(Options{Source: PROCESS})
+
+	s.Equal(errors.New("'New' error: credential process cannot be missing"), err)
+}
+
+func (s *CredentialTestSuite) Test_Chain_PrefersTheEnvironment() {
+	s.T().Setenv("AWS_ACCESS_KEY_ID", "envKey")
+	s.T().Setenv("AWS_SECRET_ACCESS_KEY", "envSecret")
+
+	value, err := s.get(Options{Source: CHAIN, File: s.write("credentials", sharedCredentials)})
+
+	s.Require().NoError(err)
+	s.Equal("envKey", value.AccessKeyID)
+}
+
+func (s *CredentialTestSuite) Test_Chain_FallsBackToTheSharedFile() {
+	value, err := s.get(Options{Source: CHAIN, File: s.write("credentials", sharedCredentials), FileProfile: "backup"})
+
+	s.Require().NoError(err)
+	s.Equal("backupKey", value.AccessKeyID)
+}
+
+func (s *CredentialTestSuite) Test_New_ErrorUnknownSource() {
+	_, err := New(Options{Source: "nope"})
+
+	s.Equal(errors.New("'New' error: unknown credential source 'nope'"), err)
+}
+
+func (s *CredentialTestSuite) Test_sharedFile_IsExpiredUntilRead() {
+	f := &sharedFile{filename: s.write("credentials", sharedCredentials)}
+	s.True(f.IsExpired())
+
+	_, err := f.Retrieve()
+	s.Require().NoError(err)
+
+	s.False(f.IsExpired())
+}
@@ -0,0 +1,67 @@
+package credential
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// process runs a command for its credentials, it runs it again once they
+// are about to expire. Credentials without an expiration are kept for good.
+type process struct {
+	credentials.Expiry
+
+	command string
+}
+
+// never is how long credentials without an expiration are good for
+const never = 100 * 365 * 24 * time.Hour
+
+// processOutput is what credential_process commands print, see
+// https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html
+type processOutput struct {
+	Version         int
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      *time.Time
+}
+
+func (p *process) Retrieve() (credentials.Value, error) {
+	cmd := exec.Command("sh", "-c", p.command)
+
+	// Whatever the command has to say about failing goes straight to the
+	// user, the same as with the AWS tools
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("credential process failed: %s", err)
+	}
+
+	var o processOutput
+	if err := json.Unmarshal(out, &o); err != nil {
+		return credentials.Value{}, fmt.Errorf("credential process printed something that isn't credentials: %s", err)
+	}
+
+	if o.Version != 1 {
+		return credentials.Value{}, fmt.Errorf("credential process printed version %d, only version 1 is understood", o.Version)
+	}
+
+	if o.Expiration == nil {
+		p.SetExpiration(time.Now().Add(never), 0)
+	} else {
+		p.SetExpiration(*o.Expiration, credentials.DefaultExpiryWindow)
+	}
+
+	return credentials.Value{
+		AccessKeyID:     o.AccessKeyID,
+		SecretAccessKey: o.SecretAccessKey,
+		SessionToken:    o.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package credential
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	ini "gopkg.in/ini.v1"
+)
+
+// sharedFile reads a profile from an AWS style shared credentials file.
+// Unlike minio's own version it understands credential_process too.
+type sharedFile struct {
+	filename string
+	profile  string
+
+	// current is whatever the profile turned out to need
+	current credentials.Provider
+}
+
+func (f *sharedFile) Retrieve() (credentials.Value, error) {
+	filename := f.filename
+	if filename == "" {
+		filename = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return credentials.Value{}, err
+		}
+
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := f.profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := ini.Load(filename)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	section, err := file.GetSection(profile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	if command := section.Key("credential_process").String(); command != "" {
+		f.current = &process{command: command}
+	} else {
+		f.current = &credentials.FileAWSCredentials{Filename: filename, Profile: profile}
+	}
+
+	return f.current.Retrieve()
+}
+
+func (f *sharedFile) IsExpired() bool {
+	return f.current == nil || f.current.IsExpired()
+}
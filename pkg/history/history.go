@@ -0,0 +1,224 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/notify"
+)
+
+// Prefix is where runs are uploaded to on a destination
+const Prefix = "_meta/runs/"
+
+// Run is everything about a run that is kept. The summary is the one that
+// notifiers get, the entries are what was logged about every file.
+type Run struct {
+	ID string `json:"id"`
+
+	notify.Summary
+
+	Entries []Entry `json:"entries,omitempty"`
+}
+
+// Entry is what happened to a file, or an error that wasn't about one, on
+// a destination of a profile
+type Entry struct {
+	Profile     string `json:"profile,omitempty"`
+	Destination string `json:"destination,omitempty"`
+
+	Level        string `json:"level"`
+	File         string `json:"file,omitempty"`
+	Action       string `json:"action,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+	Skipped      bool   `json:"skipped,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	Message      string `json:"message"`
+
+	// Duration is in seconds
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// Recorder collects the entries of a run as they are logged, it is safe to
+// share between goroutines
+type Recorder struct {
+	lock    sync.Mutex
+	entries []Entry
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Observe records an entry that was logged for destination of profile
+func (r *Recorder) Observe(profile, destination string, e backup.LogEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.entries = append(r.entries, Entry{
+		Profile:      profile,
+		Destination:  destination,
+		Level:        e.Level,
+		File:         e.File,
+		Action:       string(e.ActionType),
+		Size:         e.Size,
+		Deduplicated: e.Deduplicated,
+		Skipped:      e.Skipped,
+		StorageClass: e.StorageClass,
+		Message:      e.Message,
+		Duration:     e.Duration.Seconds(),
+	})
+}
+
+// Run is the run with id and summary s, with everything recorded so far in
+// the order that it was logged
+func (r *Recorder) Run(id string, s notify.Summary) Run {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entries := make([]Entry, len(r.entries))
+	copy(entries, r.entries)
+
+	return Run{ID: id, Summary: s, Entries: entries}
+}
+
+// Store keeps runs in a directory. Every run is a line in runs.jsonl, which
+// is all that listing them reads, and its entries are in a file of its own.
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) Store {
+	return Store{dir: dir}
+}
+
+const index = "runs.jsonl"
+
+// Save writes the whole run and then adds it to the index, a run that is
+// listed can always be shown
+func (s Store) Save(r Run) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path(r.ID) + ".tmp"
+	defer os.Remove(tmp)
+
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, s.path(r.ID)); err != nil {
+		return err
+	}
+
+	r.Entries = nil
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// List is the last runs, newest first and without their entries, 0 is all
+// of them. No history yet is no runs.
+func (s Store) List(last int) ([]Run, error) {
+	f, err := os.Open(filepath.Join(s.dir, index))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	runs := make([]Run, 0)
+
+	lines := bufio.NewScanner(f)
+	lines.Buffer(nil, 1<<20)
+	for n := 1; lines.Scan(); n++ {
+		var r Run
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("'List' error: broken line %d in '%s', err: %s", n, f.Name(), err)
+		}
+
+		runs = append(runs, r)
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+
+	if last > 0 && len(runs) > last {
+		runs = runs[:last]
+	}
+
+	return runs, nil
+}
+
+// Get is the run with id, with its entries
+func (s Store) Get(id string) (Run, error) {
+	// An id is never a path, whatever is asked for
+	if filepath.Base(id) != id {
+		return Run{}, fmt.Errorf("'Get' error: no run '%s'", id)
+	}
+
+	body, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return Run{}, fmt.Errorf("'Get' error: no run '%s'", id)
+	} else if err != nil {
+		return Run{}, err
+	}
+
+	var r Run
+	if err := json.Unmarshal(body, &r); err != nil {
+		return Run{}, fmt.Errorf("'Get' error: broken run '%s', err: %s", id, err)
+	}
+
+	return r, nil
+}
+
+func (s Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Upload puts the whole run in Prefix on storage, it is there for whoever
+// wants to know what happened to a destination without the machine that
+// backed it up
+func Upload(ctx context.Context, storage backup.Storage, r Run) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return storage.Put(ctx, Prefix+r.ID+".json", bytes.NewReader(body), int64(len(body)), backup.PutOptions{
+		ContentType: "application/json",
+	})
+}
@@ -0,0 +1,194 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/notify"
+)
+
+func TestHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(HistoryTestSuite))
+}
+
+type HistoryTestSuite struct {
+	suite.Suite
+
+	dir   string
+	store Store
+}
+
+func (s *HistoryTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "history")
+	s.Require().NoError(err)
+
+	s.dir = dir
+	s.store = NewStore(filepath.Join(dir, "history"))
+}
+
+func (s *HistoryTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+// run is a run that started at hour, pushed some files and failed one
+func (s *HistoryTestSuite) run(id string, hour int) Run {
+	r := NewRecorder()
+	r.Observe("home", "nas", backup.LogEntry{
+		Level: backup.INFO, File: "/home/a", ActionType: backup.PUSH, Size: 5,
+		StorageClass: "GLACIER", Duration: 1500 * time.Millisecond, Message: "pushed",
+	})
+	r.Observe("home", "nas", backup.LogEntry{Level: backup.ERROR, File: "/home/b", ActionType: backup.REMOVE, Message: "asplode"})
+
+	started := time.Date(2020, 6, 1, hour, 0, 0, 0, time.UTC)
+	return r.Run(id, notify.Summary{
+		Host:          "laptop",
+		Outcome:       backup.PartlyFailed,
+		ExitCode:      2,
+		Started:       started,
+		Finished:      started.Add(time.Minute),
+		Duration:      60,
+		ReportSummary: backup.ReportSummary{Files: 2, Pushed: 1, Failed: 1},
+		Profiles: []notify.Profile{
+			{Name: "home", Outcome: backup.PartlyFailed, ReportSummary: backup.ReportSummary{Files: 2, Pushed: 1, Failed: 1}},
+		},
+	})
+}
+
+func (s *HistoryTestSuite) Test_Recorder() {
+	r := s.run("first", 1)
+
+	s.Equal([]Entry{
+		{Profile: "home", Destination: "nas", Level: backup.INFO, File: "/home/a", Action: "push", Size: 5, StorageClass: "GLACIER", Message: "pushed", Duration: 1.5},
+		{Profile: "home", Destination: "nas", Level: backup.ERROR, File: "/home/b", Action: "remove", Message: "asplode"},
+	}, r.Entries)
+}
+
+func (s *HistoryTestSuite) Test_SaveListAndGet() {
+	runs, err := s.store.List(0)
+	s.Require().NoError(err)
+	s.Empty(runs)
+
+	first, second, third := s.run("first", 1), s.run("second", 2), s.run("third", 3)
+	for _, r := range []Run{first, second, third} {
+		s.Require().NoError(s.store.Save(r))
+	}
+
+	runs, err = s.store.List(0)
+	s.Require().NoError(err)
+	s.Len(runs, 3)
+	s.Equal([]string{"third", "second", "first"}, []string{runs[0].ID, runs[1].ID, runs[2].ID})
+
+	// Listing leaves the entries out
+	s.Nil(runs[0].Entries)
+	third.Entries = nil
+	s.Equal(third, runs[0])
+
+	runs, err = s.store.List(2)
+	s.Require().NoError(err)
+	s.Len(runs, 2)
+	s.Equal("third", runs[0].ID)
+
+	got, err := s.store.Get("second")
+	s.Require().NoError(err)
+	s.Equal(second, got)
+}
+
+func (s *HistoryTestSuite) Test_Get_Errors() {
+	_, err := s.store.Get("missing")
+	s.EqualError(err, "'Get' error: no run 'missing'")
+
+	_, err = s.store.Get("../history/runs")
+	s.EqualError(err, "'Get' error: no run '../history/runs'")
+
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.dir, "history", "dir.json", "in", "the", "way"), 0755))
+	_, err = s.store.Get("dir")
+	s.Error(err)
+
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dir, "history", "broken.json"), []byte("{"), 0644))
+	_, err = s.store.Get("broken")
+	s.EqualError(err, "'Get' error: broken run 'broken', err: unexpected end of JSON input")
+}
+
+func (s *HistoryTestSuite) Test_List_Errors() {
+	s.Require().NoError(s.store.Save(s.run("first", 1)))
+
+	index := filepath.Join(s.dir, "history", "runs.jsonl")
+	f, err := os.OpenFile(index, os.O_WRONLY|os.O_APPEND, 0644)
+	s.Require().NoError(err)
+	f.WriteString("{\n")
+	f.Close()
+
+	_, err = s.store.List(0)
+	s.EqualError(err, "'List' error: broken line 2 in '"+index+"', err: unexpected end of JSON input")
+
+	// A directory opens fine but can't be read
+	s.Require().NoError(os.Remove(index))
+	s.Require().NoError(os.Mkdir(index, 0755))
+	_, err = s.store.List(0)
+	s.Error(err)
+
+	// A file where the directory has to be
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dir, "file"), nil, 0644))
+	_, err = NewStore(filepath.Join(s.dir, "file")).List(0)
+	s.Error(err)
+}
+
+func (s *HistoryTestSuite) Test_Save_Errors() {
+	r := s.run("first", 1)
+
+	// A file where the directory has to go
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dir, "file"), nil, 0644))
+	s.Error(NewStore(filepath.Join(s.dir, "file")).Save(r))
+
+	// Directories where each of the files has to go
+	for _, name := range []string{"first.json.tmp", "first.json", "runs.jsonl"} {
+		way := filepath.Join(s.dir, "history", name)
+		s.Require().NoError(os.MkdirAll(filepath.Join(way, "in", "the", "way"), 0755))
+
+		s.Error(s.store.Save(r), name)
+
+		s.Require().NoError(os.RemoveAll(way))
+	}
+}
+
+// putStorage only keeps what was put last
+type putStorage struct {
+	backup.Storage
+
+	key  string
+	body []byte
+	opts backup.PutOptions
+	err  error
+}
+
+func (p *putStorage) Put(_ context.Context, key string, r io.Reader, _ int64, opts backup.PutOptions) error {
+	p.key, p.opts = key, opts
+	p.body, _ = ioutil.ReadAll(r)
+	return p.err
+}
+
+func (s *HistoryTestSuite) Test_Upload() {
+	storage := &putStorage{}
+	r := s.run("first", 1)
+
+	s.Require().NoError(Upload(context.Background(), storage, r))
+	s.Equal("_meta/runs/first.json", storage.key)
+	s.Equal("application/json", storage.opts.ContentType)
+
+	var uploaded Run
+	s.Require().NoError(json.Unmarshal(storage.body, &uploaded))
+	s.Equal(r, uploaded)
+
+	storage.err = errors.New("asplode")
+	s.Equal(storage.err, Upload(context.Background(), storage, r))
+}
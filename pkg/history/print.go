@@ -0,0 +1,100 @@
+package history
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// PrintList prints a line for every run, in the order given
+func PrintList(w io.Writer, runs []Run) error {
+	t := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(t, "ID\tSTARTED\tDURATION\tOUTCOME\tFILES\tPUSHED\tREMOVED\tMOVED\tFAILED")
+	for _, r := range runs {
+		fmt.Fprintf(t, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\n",
+			r.ID, r.Started.Format("2006-01-02 15:04:05"), duration(r.Duration), r.Outcome,
+			r.Files, r.Pushed, r.Removed, r.Moved, r.Failed,
+		)
+	}
+
+	return t.Flush()
+}
+
+// PrintRun prints the whole run the way the reports are, its totals, every
+// profile and then every entry
+func PrintRun(w io.Writer, r Run) error {
+	p := &printer{w: w}
+
+	p.line("Run %s", r.ID)
+	p.line("-------------------------------")
+	p.line("Host: %s", r.Host)
+	p.line("Outcome: %s", r.Outcome)
+	p.line("Started: %s", r.Started.Format("2006-01-02 15:04:05 MST"))
+	p.line("Duration: %s", duration(r.Duration))
+	p.line("Total files processed: %d", r.Files)
+	p.line("Files added to remote: %d", r.Pushed)
+	p.line("Files removed from remote: %d", r.Removed)
+	p.line("Files moved on remote: %d", r.Moved)
+	p.line("Files skipped: %d", r.Skipped)
+	p.line("Files failed: %d", r.Failed)
+	p.line("Bytes saved by deduplication: %d", r.DeduplicatedBytes)
+	p.line("Bytes added to remote: %d", r.PushedBytes)
+	p.line("Bytes removed from remote: %d", r.RemovedBytes)
+	p.line("")
+	p.line("Profile Details")
+	p.line("-------------------------------")
+
+	for _, profile := range r.Profiles {
+		dryRun := ""
+		if profile.DryRun {
+			dryRun = " - dry run"
+		}
+
+		if profile.Error != "" {
+			p.line("profile: '%s'%s - %s: '%s'", profile.Name, dryRun, profile.Outcome, profile.Error)
+			continue
+		}
+
+		p.line(
+			"profile: '%s'%s - %s - processed: %d - added: %d - removed: %d - moved: %d - skipped: %d - failed: %d",
+			profile.Name, dryRun, profile.Outcome, profile.Files, profile.Pushed, profile.Removed, profile.Moved, profile.Skipped, profile.Failed,
+		)
+	}
+
+	p.line("")
+	p.line("File Details")
+	p.line("-------------------------------")
+
+	for _, e := range r.Entries {
+		where := ""
+		if e.Profile != "" {
+			where += fmt.Sprintf(" - profile: '%s'", e.Profile)
+		}
+		if e.Destination != "" {
+			where += fmt.Sprintf(" - destination: '%s'", e.Destination)
+		}
+
+		p.line("%s%s - file: '%s' - action: '%s' - message: '%s'", e.Level, where, e.File, e.Action, e.Message)
+	}
+
+	return p.err
+}
+
+// printer keeps the first error, printing goes on but nothing more is
+// written
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) line(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format+"\n", args...)
+	}
+}
+
+func duration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+}
@@ -0,0 +1,90 @@
+package history
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/notify"
+)
+
+func Test_PrintList(t *testing.T) {
+	var b bytes.Buffer
+	err := PrintList(&b, []Run{
+		{ID: "20200601T140000Z", Summary: notify.Summary{
+			Outcome: backup.Succeeded, Started: time.Date(2020, 6, 1, 14, 0, 0, 0, time.UTC), Duration: 62.5,
+			ReportSummary: backup.ReportSummary{Files: 1200, Pushed: 3, Removed: 1},
+		}},
+		{ID: "20200601T130000Z", Summary: notify.Summary{
+			Outcome: backup.PartlyFailed, Started: time.Date(2020, 6, 1, 13, 0, 0, 0, time.UTC), Duration: 0.25,
+			ReportSummary: backup.ReportSummary{Files: 2, Pushed: 1, Failed: 1},
+		}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, ""+
+		"ID                STARTED              DURATION  OUTCOME        FILES  PUSHED  REMOVED  MOVED  FAILED\n"+
+		"20200601T140000Z  2020-06-01 14:00:00  1m2.5s    succeeded      1200   3       1        0      0\n"+
+		"20200601T130000Z  2020-06-01 13:00:00  250ms     partly failed  2      1       0        0      1\n", b.String())
+}
+
+func Test_PrintRun(t *testing.T) {
+	var b bytes.Buffer
+	err := PrintRun(&b, Run{
+		ID: "20200601T130000Z",
+		Summary: notify.Summary{
+			Host: "laptop", Outcome: backup.Failed, Started: time.Date(2020, 6, 1, 13, 0, 0, 0, time.UTC), Duration: 2,
+			ReportSummary: backup.ReportSummary{Files: 2, Pushed: 1, Failed: 1, DeduplicatedBytes: 10, PushedBytes: 20},
+			Profiles: []notify.Profile{
+				{Name: "home", Outcome: backup.PartlyFailed, DryRun: true, ReportSummary: backup.ReportSummary{Files: 2, Pushed: 1, Failed: 1}},
+				{Name: "mail", Outcome: backup.Failed, Error: "bucket is gone"},
+			},
+		},
+		Entries: []Entry{
+			{Profile: "home", Destination: "nas", Level: backup.INFO, File: "/home/a", Action: "push", Message: "pushed"},
+			{Level: backup.ERROR, Message: "listing failed"},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `Run 20200601T130000Z
+-------------------------------
+Host: laptop
+Outcome: failed
+Started: 2020-06-01 13:00:00 UTC
+Duration: 2s
+Total files processed: 2
+Files added to remote: 1
+Files removed from remote: 0
+Files moved on remote: 0
+Files skipped: 0
+Files failed: 1
+Bytes saved by deduplication: 10
+Bytes added to remote: 20
+Bytes removed from remote: 0
+
+Profile Details
+-------------------------------
+profile: 'home' - dry run - partly failed - processed: 2 - added: 1 - removed: 0 - moved: 0 - skipped: 0 - failed: 1
+profile: 'mail' - failed: 'bucket is gone'
+
+File Details
+-------------------------------
+INFO - profile: 'home' - destination: 'nas' - file: '/home/a' - action: 'push' - message: 'pushed'
+ERROR - file: '' - action: '' - message: 'listing failed'
+`, b.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("asplode")
+}
+
+func Test_PrintRun_WriteFails(t *testing.T) {
+	assert.EqualError(t, PrintRun(failingWriter{}, Run{}), "asplode")
+}
@@ -0,0 +1,355 @@
+package localdir
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// The content of every object is kept under the root at its key. Keys that
+// are absolute paths, the backed up files, go under files so they can be
+// browsed and copied back like any other file. Every other key, like the
+// content addressed layout and packs, goes under objects. Everything else
+// about an object is in a JSON file under metadata, at the same path plus
+// '.json'. Both are written to tmp first so nothing is ever half written.
+const (
+	filesDir    = "files"
+	objectsDir  = "objects"
+	metadataDir = "metadata"
+	tmpDir      = "tmp"
+)
+
+// meta is what S3 would keep alongside an object. The ETag is worked out
+// when the object is written, it is never a multipart one.
+type meta struct {
+	ETag        string            `json:"etag"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// Storage keeps objects in a local directory, like a mounted external drive
+// or NAS share, laid out so that the same diffing works as against S3
+type Storage struct {
+	root string
+}
+
+func NewStorage(root string) (Storage, error) {
+	if root == "" {
+		return Storage{}, errors.New("'NewStorage' error: directory cannot be missing")
+	}
+
+	fi, err := os.Stat(root)
+	if err != nil {
+		return Storage{}, fmt.Errorf("'NewStorage' error: unable to use directory '%s', err: %s", root, err)
+	}
+
+	if !fi.IsDir() {
+		return Storage{}, fmt.Errorf("'NewStorage' error: '%s' is not a directory", root)
+	}
+
+	// Making tmp up front also finds out about a read only drive before
+	// anything is backed up
+	if err := os.MkdirAll(filepath.Join(root, tmpDir), 0755); err != nil {
+		return Storage{}, fmt.Errorf("'NewStorage' error: unable to write to directory '%s', err: %s", root, err)
+	}
+
+	return Storage{
+		root: filepath.Clean(root),
+	}, nil
+}
+
+// List walks the directory of the prefix, rather than the whole tree, in
+// the same order as S3 lists keys
+func (s *Storage) List(ctx context.Context, prefix string, _ backup.ListOptions, out chan<- backup.Object) error {
+	keyDir := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	return s.walk(ctx, keyDir, prefix, out)
+}
+
+// entry is a file or directory found while listing, directory keys end in
+// a slash which makes them sort the way S3 would, see the
+// LocalFileProcessor for why that matters
+type entry struct {
+	key  string
+	dir  bool
+	size int64
+}
+
+func (s *Storage) walk(ctx context.Context, keyDir, prefix string, out chan<- backup.Object) error {
+	entries, err := s.readDir(keyDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		// Only the first directory can hold keys that don't match, those
+		// under it all start with the prefix
+		if !strings.HasPrefix(e.key, prefix) {
+			continue
+		}
+
+		if e.dir {
+			if err := s.walk(ctx, e.key, prefix, out); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		m, err := s.readMeta(e.key)
+		if err != nil {
+			return err
+		}
+
+		if err := backup.SendObject(ctx, out, object(e.key, e.size, m)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readDir reads the directory for keyDir in key order. At the very top
+// the files directory is where every key starting with a slash is.
+func (s *Storage) readDir(keyDir string) ([]entry, error) {
+	entries := make([]entry, 0)
+	if keyDir == "" {
+		entries = append(entries, entry{key: "/", dir: true})
+	}
+
+	// Like S3, a prefix that isn't there, or is a file, has nothing in it
+	infos, err := ioutil.ReadDir(s.objectPath(keyDir))
+	if err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+		return nil, err
+	}
+
+	for _, info := range infos {
+		if info.IsDir() {
+			entries = append(entries, entry{key: keyDir + info.Name() + "/", dir: true})
+		} else {
+			entries = append(entries, entry{key: keyDir + info.Name(), size: info.Size()})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	return entries, nil
+}
+
+// Put works out the ETag while writing, like S3 would. Cancelling ctx stops
+// it between reads, nothing is stored then.
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, size int64, opts backup.PutOptions) error {
+	h := md5.New()
+
+	tmp, written, err := s.writeTmp(io.TeeReader(ctxReader{ctx: ctx, r: r}, h))
+	defer os.Remove(tmp)
+	if err != nil {
+		return err
+	}
+
+	if size >= 0 && written != size {
+		return fmt.Errorf("'Put' error: expected %d bytes for '%s' but got %d", size, key, written)
+	}
+
+	metadata := make(map[string]string, len(opts.Metadata))
+	for k, v := range opts.Metadata {
+		metadata[strings.ToLower(k)] = v
+	}
+
+	return s.store(key, tmp, meta{
+		ETag:        hex.EncodeToString(h.Sum(nil)),
+		ContentType: opts.ContentType,
+		Metadata:    metadata,
+		Tags:        opts.Tags,
+	})
+}
+
+func (s *Storage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.objectPath(key))
+	if os.IsNotExist(err) {
+		return nil, backup.ErrNotFound
+	}
+
+	return f, err
+}
+
+func (s *Storage) Remove(_ context.Context, key string) error {
+	for _, p := range []string{s.objectPath(key), s.metaPath(key)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) Stat(_ context.Context, key string) (backup.Object, error) {
+	fi, err := os.Stat(s.objectPath(key))
+	if os.IsNotExist(err) {
+		return backup.Object{}, backup.ErrNotFound
+	} else if err != nil {
+		return backup.Object{}, err
+	}
+
+	m, err := s.readMeta(key)
+	if err != nil {
+		return backup.Object{}, err
+	}
+
+	return object(key, fi.Size(), m), nil
+}
+
+func (s *Storage) Tags(ctx context.Context, key string) (map[string]string, error) {
+	o, err := s.Stat(ctx, key)
+	return o.Tags, err
+}
+
+// Copy has to copy the content, on the same drive that is no worse than
+// what S3 does on its side. Like a put it stops once ctx is cancelled.
+func (s *Storage) Copy(ctx context.Context, src, dst string) error {
+	r, err := s.Get(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	m, err := s.readMeta(src)
+	if err != nil {
+		return err
+	}
+
+	tmp, _, err := s.writeTmp(ctxReader{ctx: ctx, r: r})
+	defer os.Remove(tmp)
+	if err != nil {
+		return err
+	}
+
+	return s.store(dst, tmp, m)
+}
+
+// ctxReader stops reading from r once ctx is cancelled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.r.Read(p)
+}
+
+// writeTmp writes everything read from r to a new file in the tmp
+// directory, it is up to the caller to remove it
+func (s *Storage) writeTmp(r io.Reader) (name string, size int64, err error) {
+	f, err := ioutil.TempFile(filepath.Join(s.root, tmpDir), "object")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	name = f.Name()
+	size, err = io.Copy(f, r)
+	return
+}
+
+// store moves a written object into place. The old metadata is removed
+// first, an object that is caught in between is left without an ETag and
+// can't be mistaken for what it was before.
+func (s *Storage) store(key, tmp string, m meta) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	metaTmp, _, err := s.writeTmp(bytes.NewReader(body))
+	defer os.Remove(metaTmp)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.metaPath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := rename(tmp, s.objectPath(key)); err != nil {
+		return err
+	}
+
+	return rename(metaTmp, s.metaPath(key))
+}
+
+// readMeta reads the metadata of an object, an object without any, like
+// one copied in by hand, simply has none
+func (s *Storage) readMeta(key string) (meta, error) {
+	var m meta
+
+	body, err := ioutil.ReadFile(s.metaPath(key))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return m, err
+	}
+
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, fmt.Errorf("'readMeta' error: broken metadata for '%s', err: %s", key, err)
+	}
+
+	return m, nil
+}
+
+// objectPath is where the content of key is. Keys are cleaned as if they
+// were absolute so that none of them can ever point outside of the root.
+func (s *Storage) objectPath(key string) string {
+	return filepath.Join(s.root, s.keyPath(key))
+}
+
+func (s *Storage) metaPath(key string) string {
+	return filepath.Join(s.root, metadataDir, s.keyPath(key)) + ".json"
+}
+
+func (s *Storage) keyPath(key string) string {
+	dir := objectsDir
+	if strings.HasPrefix(key, "/") {
+		dir = filesDir
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func object(key string, size int64, m meta) backup.Object {
+	return backup.Object{
+		Key:      key,
+		Size:     size,
+		ETag:     m.ETag,
+		Metadata: m.Metadata,
+		Tags:     m.Tags,
+	}
+}
+
+func rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(from, to)
+}
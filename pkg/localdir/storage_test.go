@@ -0,0 +1,437 @@
+package localdir
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func TestStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(StorageTestSuite))
+}
+
+type StorageTestSuite struct {
+	suite.Suite
+
+	dir     string
+	storage Storage
+	ctx     context.Context
+}
+
+// The MD5 of 'hello'
+const helloMd5 = "5d41402abc4b2a76b9719d911017c592"
+
+func (s *StorageTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "localdir")
+	s.Require().NoError(err)
+	s.dir = dir
+
+	s.storage, err = NewStorage(dir)
+	s.Require().NoError(err)
+
+	s.ctx = context.Background()
+}
+
+func (s *StorageTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *StorageTestSuite) put(key, content string) {
+	s.Require().NoError(s.storage.Put(s.ctx, key, strings.NewReader(content), int64(len(content)), backup.PutOptions{}))
+}
+
+func (s *StorageTestSuite) list(prefix string) ([]string, error) {
+	out := make(chan backup.Object, 20)
+	err := s.storage.List(s.ctx, prefix, backup.ListOptions{}, out)
+	close(out)
+
+	keys := make([]string, 0)
+	for o := range out {
+		keys = append(keys, o.Key)
+	}
+
+	return keys, err
+}
+
+func (s *StorageTestSuite) read(key string) string {
+	r, err := s.storage.Get(s.ctx, key)
+	s.Require().NoError(err)
+	defer r.Close()
+
+	body, err := ioutil.ReadAll(r)
+	s.Require().NoError(err)
+
+	return string(body)
+}
+
+// mkdir makes a directory under the root, a quick way of getting in the
+// way of what the storage is about to do
+func (s *StorageTestSuite) mkdir(parts ...string) {
+	s.Require().NoError(os.MkdirAll(filepath.Join(append([]string{s.dir}, parts...)...), 0755))
+}
+
+// metadataInTheWay swaps the metadata of a backed up file for a directory
+func (s *StorageTestSuite) metadataInTheWay(name string) {
+	s.Require().NoError(os.Remove(filepath.Join(s.dir, "metadata", "files", name+".json")))
+	s.mkdir("metadata", "files", name+".json")
+}
+
+func (s *StorageTestSuite) Test_NewStorage_Errors() {
+	_, err := NewStorage("")
+	s.EqualError(err, "'NewStorage' error: directory cannot be missing")
+
+	_, err = NewStorage("/does/not/exist")
+	s.EqualError(err, "'NewStorage' error: unable to use directory '/does/not/exist', err: stat /does/not/exist: no such file or directory")
+
+	file := filepath.Join(s.dir, "file")
+	s.Require().NoError(ioutil.WriteFile(file, nil, 0644))
+
+	_, err = NewStorage(file)
+	s.EqualError(err, "'NewStorage' error: '"+file+"' is not a directory")
+
+	root := filepath.Join(s.dir, "root")
+	s.mkdir("root")
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(root, "tmp"), nil, 0644))
+
+	_, err = NewStorage(root)
+	s.Error(err)
+	s.Contains(err.Error(), "'NewStorage' error: unable to write to directory '"+root+"'")
+}
+
+func (s *StorageTestSuite) Test_Put_KeepsBackedUpFilesBrowsable() {
+	s.put("/home/user/doc.txt", "hello")
+	s.put("_index/home/user/doc.txt", "")
+
+	body, err := ioutil.ReadFile(filepath.Join(s.dir, "files", "home", "user", "doc.txt"))
+	s.Require().NoError(err)
+	s.Equal("hello", string(body))
+
+	_, err = os.Stat(filepath.Join(s.dir, "objects", "_index", "home", "user", "doc.txt"))
+	s.NoError(err)
+}
+
+func (s *StorageTestSuite) Test_Put_StatReturnsETagAndMetadata() {
+	err := s.storage.Put(s.ctx, "/home/user/doc.txt", strings.NewReader("hello"), -1, backup.PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Blob": "abc"},
+	})
+	s.Require().NoError(err)
+
+	object, err := s.storage.Stat(s.ctx, "/home/user/doc.txt")
+
+	s.Require().NoError(err)
+	s.Equal(backup.Object{
+		Key:      "/home/user/doc.txt",
+		Size:     5,
+		ETag:     helloMd5,
+		Metadata: map[string]string{"blob": "abc"},
+	}, object)
+}
+
+func (s *StorageTestSuite) Test_Put_KeepsTags() {
+	tags := map[string]string{"backup-host": "laptop"}
+	s.Require().NoError(s.storage.Put(s.ctx, "/a", strings.NewReader("hello"), 5, backup.PutOptions{Tags: tags}))
+	s.put("/b", "hi")
+
+	got, err := s.storage.Tags(s.ctx, "/a")
+	s.Require().NoError(err)
+	s.Equal(tags, got)
+
+	got, err = s.storage.Tags(s.ctx, "/b")
+	s.Require().NoError(err)
+	s.Empty(got)
+
+	s.Require().NoError(s.storage.Copy(s.ctx, "/a", "/c"))
+
+	out := make(chan backup.Object, 3)
+	s.Require().NoError(s.storage.List(s.ctx, "/", backup.ListOptions{Tags: true}, out))
+	close(out)
+
+	s.Equal(tags, (<-out).Tags)
+	s.Nil((<-out).Tags)
+	s.Equal(tags, (<-out).Tags)
+}
+
+func (s *StorageTestSuite) Test_Tags_NotFound() {
+	_, err := s.storage.Tags(s.ctx, "/a")
+	s.Equal(backup.ErrNotFound, err)
+}
+
+func (s *StorageTestSuite) Test_Put_Overwrites() {
+	s.Require().NoError(s.storage.Put(s.ctx, "/a", strings.NewReader("first"), 5, backup.PutOptions{
+		Metadata: map[string]string{"old": "yes"},
+	}))
+	s.put("/a", "hello")
+
+	object, err := s.storage.Stat(s.ctx, "/a")
+
+	s.Require().NoError(err)
+	s.Equal(backup.Object{Key: "/a", Size: 5, ETag: helloMd5}, object)
+	s.Equal("hello", s.read("/a"))
+}
+
+func (s *StorageTestSuite) Test_Put_ErrorsOnWrongSize() {
+	err := s.storage.Put(s.ctx, "/a", strings.NewReader("hello"), 10, backup.PutOptions{})
+
+	s.EqualError(err, "'Put' error: expected 10 bytes for '/a' but got 5")
+	s.assertNotStored("/a")
+}
+
+func (s *StorageTestSuite) Test_Put_ReturnsReadError() {
+	expectedErr := errors.New("asplode")
+
+	err := s.storage.Put(s.ctx, "/a", io.MultiReader(strings.NewReader("hel"), readerFunc(func([]byte) (int, error) {
+		return 0, expectedErr
+	})), 5, backup.PutOptions{})
+
+	s.Equal(expectedErr, err)
+	s.assertNotStored("/a")
+}
+
+func (s *StorageTestSuite) Test_Put_StopsWhenCancelled() {
+	ctx, cancel := context.WithCancel(s.ctx)
+
+	err := s.storage.Put(ctx, "/a", io.MultiReader(strings.NewReader("hel"), readerFunc(func(p []byte) (int, error) {
+		cancel()
+		return copy(p, "lo"), nil
+	})), 5, backup.PutOptions{})
+
+	s.Equal(context.Canceled, err)
+	s.assertNotStored("/a")
+}
+
+func (s *StorageTestSuite) Test_Put_ErrorsWhenTmpIsGone() {
+	s.Require().NoError(os.RemoveAll(filepath.Join(s.dir, "tmp")))
+
+	s.Error(s.storage.Put(s.ctx, "/a", strings.NewReader("hello"), 5, backup.PutOptions{}))
+}
+
+func (s *StorageTestSuite) Test_Put_ErrorsWhenTmpGoesAwayHalfWay() {
+	// The content is written by then, but the metadata isn't
+	r := io.MultiReader(strings.NewReader("hello"), readerFunc(func([]byte) (int, error) {
+		s.Require().NoError(os.RemoveAll(filepath.Join(s.dir, "tmp")))
+		return 0, io.EOF
+	}))
+
+	s.Error(s.storage.Put(s.ctx, "/a", r, 5, backup.PutOptions{}))
+
+	_, err := s.storage.Stat(s.ctx, "/a")
+	s.Equal(backup.ErrNotFound, err)
+}
+
+func (s *StorageTestSuite) Test_Put_ErrorsWhenOldMetadataCantBeRemoved() {
+	s.mkdir("metadata", "files", "a.json", "in-the-way")
+
+	s.Error(s.storage.Put(s.ctx, "/a", strings.NewReader("hello"), 5, backup.PutOptions{}))
+}
+
+func (s *StorageTestSuite) Test_Put_ErrorsWhenKeyIsUnderAFile() {
+	s.put("/a", "hello")
+
+	s.Error(s.storage.Put(s.ctx, "/a/b", strings.NewReader("hello"), 5, backup.PutOptions{}))
+}
+
+func (s *StorageTestSuite) Test_Keys_CantEscapeTheRoot() {
+	s.put("../../outside", "hello")
+
+	_, err := os.Stat(filepath.Join(s.dir, "objects", "outside"))
+	s.NoError(err)
+}
+
+func (s *StorageTestSuite) Test_List_InKeyOrder() {
+	// Sorting by name per directory would put dir/file before dir.txt
+	for _, key := range []string{"/home/dir/file", "/home/dir.txt", "/home/a", "/home/dir/a/b", "/other/x"} {
+		s.put(key, "hello")
+	}
+
+	keys, err := s.list("/home/")
+
+	s.Require().NoError(err)
+	s.Equal([]string{"/home/a", "/home/dir.txt", "/home/dir/a/b", "/home/dir/file"}, keys)
+}
+
+func (s *StorageTestSuite) Test_List_PrefixInTheMiddleOfAName() {
+	for _, key := range []string{"_packs/home/p1.json", "_packs/home/p1.tar", "_packs/home/p2.json", "_packs/home/p10/x"} {
+		s.put(key, "hello")
+	}
+
+	keys, err := s.list("_packs/home/p1")
+
+	s.Require().NoError(err)
+	s.Equal([]string{"_packs/home/p1.json", "_packs/home/p1.tar", "_packs/home/p10/x"}, keys)
+}
+
+func (s *StorageTestSuite) Test_List_EverythingFromTheTop() {
+	for _, key := range []string{"_index/home/a", "/home/a", "-first"} {
+		s.put(key, "hello")
+	}
+
+	keys, err := s.list("")
+
+	s.Require().NoError(err)
+	s.Equal([]string{"-first", "/home/a", "_index/home/a"}, keys)
+}
+
+func (s *StorageTestSuite) Test_List_SendsSizeAndMetadata() {
+	s.Require().NoError(s.storage.Put(s.ctx, "/a", strings.NewReader("hello"), 5, backup.PutOptions{
+		Metadata: map[string]string{"size": "5"},
+	}))
+
+	// Copied in by hand, there is nothing known about it
+	s.mkdir("files")
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dir, "files", "b"), []byte("hi"), 0644))
+
+	out := make(chan backup.Object, 2)
+	err := s.storage.List(s.ctx, "/", backup.ListOptions{Metadata: true}, out)
+	close(out)
+
+	s.Require().NoError(err)
+	s.Equal(backup.Object{Key: "/a", Size: 5, ETag: helloMd5, Metadata: map[string]string{"size": "5"}}, <-out)
+	s.Equal(backup.Object{Key: "/b", Size: 2}, <-out)
+}
+
+func (s *StorageTestSuite) Test_List_NothingUnderMissingOrFilePrefix() {
+	s.put("/home/file", "hello")
+
+	keys, err := s.list("/nothing/")
+	s.Require().NoError(err)
+	s.Empty(keys)
+
+	keys, err = s.list("/home/file/")
+	s.Require().NoError(err)
+	s.Empty(keys)
+}
+
+func (s *StorageTestSuite) Test_List_ReturnsReadDirError() {
+	s.mkdir("files")
+	s.Require().NoError(os.Symlink("loop", filepath.Join(s.dir, "files", "loop")))
+
+	_, err := s.list("/loop/")
+
+	s.Error(err)
+}
+
+func (s *StorageTestSuite) Test_List_ReturnsBrokenMetadataError() {
+	s.put("/a", "hello")
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dir, "metadata", "files", "a.json"), []byte("{"), 0644))
+
+	_, err := s.list("/")
+
+	s.EqualError(err, "'readMeta' error: broken metadata for '/a', err: unexpected end of JSON input")
+}
+
+func (s *StorageTestSuite) Test_List_StopsWhenCancelled() {
+	s.put("/home/dir/file", "hello")
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	cancel()
+
+	err := s.storage.List(ctx, "/home/", backup.ListOptions{}, make(chan backup.Object))
+
+	s.Equal(context.Canceled, err)
+}
+
+func (s *StorageTestSuite) Test_Get_NotFound() {
+	_, err := s.storage.Get(s.ctx, "/a")
+	s.Equal(backup.ErrNotFound, err)
+}
+
+func (s *StorageTestSuite) Test_Stat_Errors() {
+	_, err := s.storage.Stat(s.ctx, "/a")
+	s.Equal(backup.ErrNotFound, err)
+
+	s.mkdir("files")
+	s.Require().NoError(os.Symlink("loop", filepath.Join(s.dir, "files", "loop")))
+
+	_, err = s.storage.Stat(s.ctx, "/loop")
+	s.Error(err)
+	s.NotEqual(backup.ErrNotFound, err)
+
+	s.put("/b", "hello")
+	s.metadataInTheWay("b")
+
+	_, err = s.storage.Stat(s.ctx, "/b")
+	s.Error(err)
+}
+
+func (s *StorageTestSuite) Test_Remove_RemovesContentAndMetadata() {
+	s.put("/a", "hello")
+
+	s.Require().NoError(s.storage.Remove(s.ctx, "/a"))
+
+	s.assertNotStored("/a")
+	_, err := os.Stat(filepath.Join(s.dir, "metadata", "files", "a.json"))
+	s.True(os.IsNotExist(err))
+
+	// Just like S3, removing what isn't there is fine
+	s.NoError(s.storage.Remove(s.ctx, "/a"))
+}
+
+func (s *StorageTestSuite) Test_Remove_ReturnsError() {
+	s.mkdir("files", "a", "b")
+
+	s.Error(s.storage.Remove(s.ctx, "/a"))
+}
+
+func (s *StorageTestSuite) Test_Copy_CopiesContentAndMetadata() {
+	s.Require().NoError(s.storage.Put(s.ctx, "_index/old", strings.NewReader("hello"), 5, backup.PutOptions{
+		Metadata: map[string]string{"blob": "abc"},
+	}))
+
+	s.Require().NoError(s.storage.Copy(s.ctx, "_index/old", "_index/new"))
+
+	object, err := s.storage.Stat(s.ctx, "_index/new")
+	s.Require().NoError(err)
+	s.Equal(backup.Object{Key: "_index/new", Size: 5, ETag: helloMd5, Metadata: map[string]string{"blob": "abc"}}, object)
+	s.Equal("hello", s.read("_index/new"))
+
+	// The source stays
+	s.Equal("hello", s.read("_index/old"))
+}
+
+func (s *StorageTestSuite) Test_Copy_StopsWhenCancelled() {
+	s.put("/a", "hello")
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	cancel()
+
+	s.Equal(context.Canceled, s.storage.Copy(ctx, "/a", "/new"))
+	s.assertNotStored("/new")
+}
+
+func (s *StorageTestSuite) Test_Copy_Errors() {
+	s.Equal(backup.ErrNotFound, s.storage.Copy(s.ctx, "/missing", "/new"))
+
+	s.put("/a", "hello")
+	s.metadataInTheWay("a")
+	s.Error(s.storage.Copy(s.ctx, "/a", "/new"))
+
+	s.Require().NoError(os.RemoveAll(filepath.Join(s.dir, "metadata", "files", "a.json")))
+	s.Require().NoError(os.RemoveAll(filepath.Join(s.dir, "tmp")))
+	s.Error(s.storage.Copy(s.ctx, "/a", "/new"))
+}
+
+func (s *StorageTestSuite) assertNotStored(key string) {
+	_, err := s.storage.Stat(s.ctx, key)
+	s.Equal(backup.ErrNotFound, err)
+
+	// Nothing is left behind in tmp either
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, "tmp"))
+	s.Require().NoError(err)
+	s.Empty(entries)
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
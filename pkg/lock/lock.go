@@ -0,0 +1,354 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// RemoteKey is where the remote lock is kept
+const RemoteKey = "_lock"
+
+// Holder is whoever holds a lock
+type Holder struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// Self is this process on host, as of now
+func Self(host string) Holder {
+	return Holder{
+		Host:    host,
+		PID:     os.Getpid(),
+		Started: time.Now().UTC(),
+	}
+}
+
+// HeldError is what taking a lock that is held by someone else returns
+type HeldError struct {
+	Where string
+	By    Holder
+}
+
+func (e HeldError) Error() string {
+	return fmt.Sprintf("'%s' is locked by pid %d on '%s' since %s", e.Where, e.By.PID, e.By.Host, e.By.Started.Format(time.RFC3339))
+}
+
+// store is where a lock is kept
+type store interface {
+	// read returns false if there is no lock
+	read() (Holder, bool, error)
+	// create takes the lock for h unless there is one already, and says
+	// whether it did
+	create(Holder) (bool, error)
+	// removeIf removes the lock only if h still holds it, and says
+	// whether it did
+	removeIf(h Holder) (bool, error)
+	where() string
+}
+
+// Lock keeps two runs from backing up at the same time. It is stale, and
+// taken over, once it is older than the expiry or if the process that took
+// it on this host is gone. An expiry of 0 never expires.
+//
+// A lock file is only ever created if it isn't there yet. An object can't
+// be, not with S3 anyway. So a remote lock is read back after it is
+// written and whoever wrote it last has it.
+type Lock struct {
+	store  store
+	self   Holder
+	expiry time.Duration
+
+	now   func() time.Time
+	alive func(pid int) bool
+}
+
+// NewFile is a lock in the file at path
+func NewFile(path string, self Holder, expiry time.Duration) Lock {
+	return newLock(fileStore{path: path}, self, expiry)
+}
+
+// NewRemote is a lock in the RemoteKey object of s
+func NewRemote(s backup.Storage, self Holder, expiry time.Duration) Lock {
+	return newLock(remoteStore{storage: s}, self, expiry)
+}
+
+func newLock(s store, self Holder, expiry time.Duration) Lock {
+	return Lock{
+		store:  s,
+		self:   self,
+		expiry: expiry,
+		now:    time.Now,
+		alive:  alive,
+	}
+}
+
+// Acquire takes the lock unless someone else holds it. Taking a lock that
+// this process already holds is fine, a stale one is removed and taken.
+// Two runs that find the same stale lock can't both take it over, the
+// second one finds that it is not the stale one any more.
+func (l Lock) Acquire() error {
+	for {
+		created, err := l.store.create(l.self)
+		if err != nil || created {
+			return err
+		}
+
+		holder, ok, err := l.store.read()
+		if err != nil {
+			return err
+		}
+
+		// Released since, so try again
+		if !ok {
+			continue
+		}
+
+		if l.mine(holder) {
+			return nil
+		}
+
+		if !l.stale(holder) {
+			return HeldError{Where: l.store.where(), By: holder}
+		}
+
+		if _, err := l.store.removeIf(holder); err != nil {
+			return err
+		}
+	}
+}
+
+// Release only removes the lock if this process holds it
+func (l Lock) Release() error {
+	holder, ok, err := l.store.read()
+	if err != nil || !ok || !l.mine(holder) {
+		return err
+	}
+
+	_, err = l.store.removeIf(holder)
+	return err
+}
+
+// Break removes the lock if it is stale, or whoever holds it with force,
+// and says whether there was one. If someone else takes the lock meanwhile
+// it is them that are looked at.
+func (l Lock) Break(force bool) (bool, error) {
+	for {
+		holder, ok, err := l.store.read()
+		if err != nil || !ok {
+			return false, err
+		}
+
+		if !force && !l.mine(holder) && !l.stale(holder) {
+			return false, HeldError{Where: l.store.where(), By: holder}
+		}
+
+		removed, err := l.store.removeIf(holder)
+		if err != nil || removed {
+			return removed, err
+		}
+	}
+}
+
+func (l Lock) mine(h Holder) bool {
+	return h.Host == l.self.Host && h.PID == l.self.PID
+}
+
+// same is whether a and b are the very same hold of a lock, a process that
+// took it again since is another one
+func same(a, b Holder) bool {
+	return a.Host == b.Host && a.PID == b.PID && a.Started.Equal(b.Started)
+}
+
+func (l Lock) stale(h Holder) bool {
+	if l.expiry > 0 && l.now().Sub(h.Started) > l.expiry {
+		return true
+	}
+
+	return h.Host == l.self.Host && !l.alive(h.PID)
+}
+
+// alive is whether there is a process with pid, a process of another user
+// still counts
+func alive(pid int) bool {
+	// Finding a process never fails on unix, signalling it does
+	p, _ := os.FindProcess(pid)
+
+	err := p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func decode(where string, body []byte) (Holder, bool, error) {
+	var h Holder
+	if err := json.Unmarshal(body, &h); err != nil {
+		return Holder{}, false, fmt.Errorf("'read' error: broken lock '%s', err: %s", where, err)
+	}
+
+	return h, true, nil
+}
+
+type fileStore struct {
+	path string
+}
+
+func (s fileStore) read() (Holder, bool, error) {
+	body, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return Holder{}, false, nil
+	} else if err != nil {
+		return Holder{}, false, err
+	}
+
+	return decode(s.path, body)
+}
+
+// create writes to a file of its own first and links it into place. The
+// link fails if there is a lock file already, and the lock file is never
+// half written.
+func (s fileStore) create(h Holder) (bool, error) {
+	body, err := json.Marshal(h)
+	if err != nil {
+		return false, err
+	}
+
+	tmp := fmt.Sprintf("%s.%d", s.path, h.PID)
+	defer os.Remove(tmp)
+
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return false, err
+	}
+
+	err = os.Link(tmp, s.path)
+	if os.IsExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// removeIf moves the lock file to a name of its own before it reads it, so
+// what it reads is what it removes. A lock that turns out to be someone
+// else's is put back, which fails if yet another run took the lock in the
+// meantime.
+func (s fileStore) removeIf(h Holder) (bool, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".")
+	if err != nil {
+		return false, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := os.Rename(s.path, tmp.Name()); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	var holder Holder
+	body, err := ioutil.ReadFile(tmp.Name())
+	if err == nil {
+		holder, _, err = decode(s.path, body)
+	}
+
+	if err == nil && same(holder, h) {
+		return true, nil
+	}
+
+	if linkErr := os.Link(tmp.Name(), s.path); os.IsExist(linkErr) {
+		return false, fmt.Errorf("'removeIf' error: '%s' was locked again while it was being checked", s.path)
+	} else if linkErr != nil {
+		return false, linkErr
+	}
+
+	return false, err
+}
+
+func (s fileStore) where() string {
+	return s.path
+}
+
+type remoteStore struct {
+	storage backup.Storage
+}
+
+// read stats the lock first, minio only says that an object isn't there
+// once it is read
+func (s remoteStore) read() (Holder, bool, error) {
+	_, err := s.storage.Stat(context.Background(), RemoteKey)
+	if errors.Is(err, backup.ErrNotFound) {
+		return Holder{}, false, nil
+	} else if err != nil {
+		return Holder{}, false, err
+	}
+
+	r, err := s.storage.Get(context.Background(), RemoteKey)
+	if err != nil {
+		return Holder{}, false, err
+	}
+	defer r.Close()
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Holder{}, false, err
+	}
+
+	return decode(RemoteKey, body)
+}
+
+// create only writes the lock if there is none, then reads it back to see
+// whether another run wrote it in the meantime
+func (s remoteStore) create(h Holder) (bool, error) {
+	_, ok, err := s.read()
+	if err != nil || ok {
+		return false, err
+	}
+
+	body, err := json.Marshal(h)
+	if err != nil {
+		return false, err
+	}
+
+	err = s.storage.Put(context.Background(), RemoteKey, bytes.NewReader(body), int64(len(body)), backup.PutOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return false, err
+	}
+
+	holder, ok, err := s.read()
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, fmt.Errorf("'create' error: '%s' went away while it was being locked", RemoteKey)
+	}
+
+	return holder.Host == h.Host && holder.PID == h.PID, nil
+}
+
+// removeIf reads the lock right before it removes it. An object can't be
+// removed only if it is unchanged, so that leaves a run that takes the lock
+// in between the two requests.
+func (s remoteStore) removeIf(h Holder) (bool, error) {
+	holder, ok, err := s.read()
+	if err != nil || !ok || !same(holder, h) {
+		return false, err
+	}
+
+	return true, s.storage.Remove(context.Background(), RemoteKey)
+}
+
+func (s remoteStore) where() string {
+	return RemoteKey
+}
@@ -0,0 +1,444 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// memStorage keeps objects in memory, every method can be made to fail
+type memStorage struct {
+	objects map[string][]byte
+
+	statErr, getErr, putErr, readErr error
+}
+
+func (m *memStorage) List(context.Context, string, backup.ListOptions, chan<- backup.Object) error {
+	return nil
+}
+
+func (m *memStorage) Put(_ context.Context, key string, r io.Reader, _ int64, _ backup.PutOptions) error {
+	if m.putErr != nil {
+		return m.putErr
+	}
+
+	body, err := ioutil.ReadAll(r)
+	m.objects[key] = body
+	return err
+}
+
+func (m *memStorage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+
+	if m.readErr != nil {
+		return ioutil.NopCloser(errReader{m.readErr}), nil
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(m.objects[key])), nil
+}
+
+func (m *memStorage) Remove(_ context.Context, key string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memStorage) Stat(_ context.Context, key string) (backup.Object, error) {
+	if m.statErr != nil {
+		return backup.Object{}, m.statErr
+	}
+
+	body, ok := m.objects[key]
+	if !ok {
+		return backup.Object{}, backup.ErrNotFound
+	}
+
+	return backup.Object{Key: key, Size: int64(len(body))}, nil
+}
+
+func (m *memStorage) Tags(context.Context, string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (m *memStorage) Copy(context.Context, string, string) error {
+	return nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestLockTestSuite(t *testing.T) {
+	suite.Run(t, new(LockTestSuite))
+}
+
+type LockTestSuite struct {
+	suite.Suite
+
+	dir     string
+	path    string
+	storage *memStorage
+
+	now  time.Time
+	self Holder
+}
+
+func (s *LockTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "lock")
+	s.Require().NoError(err)
+
+	s.dir = dir
+	s.path = filepath.Join(dir, "run.lock")
+	s.storage = &memStorage{objects: make(map[string][]byte)}
+
+	s.now = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.self = Holder{Host: "laptop", PID: 100, Started: s.now}
+}
+
+func (s *LockTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+// locks are a file and a remote lock held by h, that see every pid but
+// 200 as alive
+func (s *LockTestSuite) locks(h Holder) []Lock {
+	locks := []Lock{NewFile(s.path, h, time.Hour), NewRemote(s.storage, h, time.Hour)}
+	for i := range locks {
+		locks[i].now = func() time.Time { return s.now }
+		locks[i].alive = func(pid int) bool { return pid != 200 }
+	}
+
+	return locks
+}
+
+func (s *LockTestSuite) Test_Self() {
+	self := Self("laptop")
+
+	s.Equal(os.Getpid(), self.PID)
+	s.Equal("laptop", self.Host)
+}
+
+func (s *LockTestSuite) Test_Acquire_ThenRelease() {
+	for _, l := range s.locks(s.self) {
+		s.Require().NoError(l.Acquire())
+
+		// Again is fine, this process already has it
+		s.Require().NoError(l.Acquire())
+
+		holder, ok, err := l.store.read()
+		s.Require().NoError(err)
+		s.True(ok)
+		s.Equal(s.self, holder)
+
+		s.Require().NoError(l.Release())
+
+		_, ok, err = l.store.read()
+		s.Require().NoError(err)
+		s.False(ok)
+
+		// Nothing to release is fine as well
+		s.NoError(l.Release())
+	}
+}
+
+func (s *LockTestSuite) Test_Acquire_HeldBySomeoneElse() {
+	other := Holder{Host: "desktop", PID: 200, Started: s.now.Add(-time.Minute)}
+
+	mine := s.locks(s.self)
+	for i, l := range s.locks(other) {
+		s.Require().NoError(l.Acquire())
+
+		err := mine[i].Acquire()
+		s.Equal(HeldError{Where: l.store.where(), By: other}, err)
+
+		// Releasing what someone else holds leaves it alone
+		s.Require().NoError(mine[i].Release())
+		_, ok, _ := l.store.read()
+		s.True(ok)
+	}
+
+	s.Equal("'_lock' is locked by pid 200 on 'desktop' since 2020-06-01T11:59:00Z", HeldError{Where: RemoteKey, By: other}.Error())
+}
+
+func (s *LockTestSuite) Test_Acquire_TakesOverStaleLocks() {
+	for _, holder := range []Holder{
+		// Older than the expiry
+		{Host: "desktop", PID: 300, Started: s.now.Add(-2 * time.Hour)},
+		// Its process is gone
+		{Host: "laptop", PID: 200, Started: s.now},
+	} {
+		mine := s.locks(s.self)
+		for i, l := range s.locks(holder) {
+			s.Require().NoError(l.Acquire())
+			s.Require().NoError(mine[i].Acquire())
+
+			got, _, _ := l.store.read()
+			s.Equal(s.self, got)
+
+			s.Require().NoError(mine[i].Release())
+		}
+	}
+}
+
+func (s *LockTestSuite) Test_Acquire_LosesWhenOverwritten() {
+	other := Holder{Host: "desktop", PID: 300, Started: s.now}
+
+	l := s.locks(s.self)[1]
+
+	l.store = remoteStore{racingStorage{s.storage, func() { s.storage.objects[RemoteKey], _ = json.Marshal(other) }}}
+	s.Equal(HeldError{Where: RemoteKey, By: other}, l.Acquire())
+
+	delete(s.storage.objects, RemoteKey)
+
+	l.store = remoteStore{racingStorage{s.storage, func() { delete(s.storage.objects, RemoteKey) }}}
+	s.EqualError(l.Acquire(), "'create' error: '_lock' went away while it was being locked")
+
+	expectedErr := errors.New("asplode")
+	l.store = remoteStore{racingStorage{s.storage, func() { s.storage.statErr = expectedErr }}}
+	s.Equal(expectedErr, l.Acquire())
+}
+
+func (s *LockTestSuite) Test_Acquire_FileInterleaved() {
+	other := Holder{Host: "laptop", PID: 300, Started: s.now}
+
+	mine := s.locks(s.self)[0]
+	theirs := s.locks(other)[0]
+
+	// The other run takes the lock while this one is in the middle of
+	// taking it, this one has to lose
+	var theirErr error
+	mine.store = racingFileStore{mine.store, func() { theirErr = theirs.Acquire() }}
+
+	s.Equal(HeldError{Where: s.path, By: other}, mine.Acquire())
+	s.Require().NoError(theirErr)
+
+	holder, _, _ := theirs.store.read()
+	s.Equal(other, holder)
+}
+
+func (s *LockTestSuite) Test_Acquire_FileOnlyOneWins() {
+	const runs = 20
+
+	errs := make(chan error, runs)
+	for i := 0; i < runs; i++ {
+		l := s.locks(Holder{Host: "laptop", PID: 1000 + i, Started: s.now})[0]
+		go func() { errs <- l.Acquire() }()
+	}
+
+	won := 0
+	for i := 0; i < runs; i++ {
+		if err := <-errs; err == nil {
+			won++
+		} else {
+			s.IsType(HeldError{}, err)
+		}
+	}
+
+	s.Equal(1, won)
+}
+
+func (s *LockTestSuite) Test_Acquire_FileStaleTakenOverOnce() {
+	stale := Holder{Host: "laptop", PID: 200, Started: s.now}
+
+	for run := 0; run < 50; run++ {
+		s.Require().NoError(s.locks(stale)[0].Acquire())
+
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			l := s.locks(Holder{Host: "laptop", PID: 1000 + i, Started: s.now})[0]
+			go func() { errs <- l.Acquire() }()
+		}
+
+		won := 0
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err == nil {
+				won++
+			} else {
+				s.IsType(HeldError{}, err)
+			}
+		}
+
+		s.Require().Equal(1, won)
+		s.Require().NoError(os.Remove(s.path))
+	}
+}
+
+func (s *LockTestSuite) Test_Acquire_StaleTakenOverMeanwhile() {
+	stale := Holder{Host: "laptop", PID: 200, Started: s.now}
+	other := Holder{Host: "laptop", PID: 300, Started: s.now}
+
+	mine := s.locks(s.self)
+	theirs := s.locks(other)
+	for i, l := range s.locks(stale) {
+		s.Require().NoError(l.Acquire())
+
+		// The other run takes over the stale lock after this one found it
+		// stale, this one has to leave it to them
+		var theirErr error
+		mine[i].store = racingRemoveStore{mine[i].store, func() { theirErr = theirs[i].Acquire() }}
+
+		s.Equal(HeldError{Where: l.store.where(), By: other}, mine[i].Acquire())
+		s.Require().NoError(theirErr)
+
+		holder, _, _ := l.store.read()
+		s.Equal(other, holder)
+
+		s.Require().NoError(theirs[i].Release())
+	}
+}
+
+func (s *LockTestSuite) Test_RemoveIf_LeavesLockTakenSince() {
+	stale := Holder{Host: "laptop", PID: 200, Started: s.now}
+	other := Holder{Host: "laptop", PID: 300, Started: s.now}
+
+	for _, l := range s.locks(other) {
+		s.Require().NoError(l.Acquire())
+
+		removed, err := l.store.removeIf(stale)
+		s.Require().NoError(err)
+		s.False(removed)
+
+		holder, _, err := l.store.read()
+		s.Require().NoError(err)
+		s.Equal(other, holder)
+
+		removed, err = l.store.removeIf(other)
+		s.Require().NoError(err)
+		s.True(removed)
+
+		removed, err = l.store.removeIf(other)
+		s.Require().NoError(err)
+		s.False(removed)
+	}
+
+	// Only the lock itself is left behind in the directory, if anything
+	files, err := ioutil.ReadDir(s.dir)
+	s.Require().NoError(err)
+	s.Empty(files)
+}
+
+func (s *LockTestSuite) Test_Break() {
+	other := Holder{Host: "desktop", PID: 300, Started: s.now}
+
+	mine := s.locks(s.self)
+	for i, l := range s.locks(other) {
+		removed, err := mine[i].Break(false)
+		s.Require().NoError(err)
+		s.False(removed)
+
+		s.Require().NoError(l.Acquire())
+
+		_, err = mine[i].Break(false)
+		s.Equal(HeldError{Where: l.store.where(), By: other}, err)
+
+		removed, err = mine[i].Break(true)
+		s.Require().NoError(err)
+		s.True(removed)
+
+		_, ok, _ := l.store.read()
+		s.False(ok)
+	}
+}
+
+func (s *LockTestSuite) Test_FileErrors() {
+	l := s.locks(s.self)[0]
+
+	s.Require().NoError(ioutil.WriteFile(s.path, []byte("{"), 0644))
+	s.EqualError(l.Acquire(), "'read' error: broken lock '"+s.path+"', err: unexpected end of JSON input")
+
+	// A directory in the way can't be read, written over or removed
+	s.Require().NoError(os.Remove(s.path))
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.path, "in", "the", "way"), 0755))
+
+	s.Error(l.Acquire())
+	_, err := l.store.removeIf(s.self)
+	s.Error(err)
+
+	l = NewFile(filepath.Join(s.dir, "missing", "run.lock"), s.self, 0)
+	s.Error(l.Acquire())
+}
+
+func (s *LockTestSuite) Test_RemoteErrors() {
+	l := s.locks(s.self)[1]
+	expectedErr := errors.New("asplode")
+
+	s.storage.putErr = expectedErr
+	s.Equal(expectedErr, l.Acquire())
+	s.storage.putErr = nil
+
+	s.Require().NoError(l.Acquire())
+
+	for _, set := range []func(error){
+		func(err error) { s.storage.statErr = err },
+		func(err error) { s.storage.getErr = err },
+		func(err error) { s.storage.readErr = err },
+	} {
+		set(expectedErr)
+
+		s.Equal(expectedErr, l.Acquire())
+		s.Equal(expectedErr, l.Release())
+
+		_, err := l.Break(true)
+		s.Equal(expectedErr, err)
+
+		set(nil)
+	}
+}
+
+func (s *LockTestSuite) Test_Alive() {
+	s.True(alive(os.Getpid()))
+	s.False(alive(1 << 30))
+}
+
+// racingStorage is storage that something happens to right after this
+// process writes to it
+type racingStorage struct {
+	*memStorage
+	after func()
+}
+
+func (r racingStorage) Put(ctx context.Context, key string, rd io.Reader, size int64, opts backup.PutOptions) error {
+	err := r.memStorage.Put(ctx, key, rd, size, opts)
+	r.after()
+	return err
+}
+
+// racingFileStore is a store that something happens to right before this
+// process creates the lock
+type racingFileStore struct {
+	store
+	before func()
+}
+
+func (r racingFileStore) create(h Holder) (bool, error) {
+	r.before()
+	return r.store.create(h)
+}
+
+// racingRemoveStore is a store that something happens to right before this
+// process removes the lock
+type racingRemoveStore struct {
+	store
+	before func()
+}
+
+func (r racingRemoveStore) removeIf(h Holder) (bool, error) {
+	r.before()
+	return r.store.removeIf(h)
+}
@@ -1,50 +1,100 @@
 package logger
 
 import (
-	"io"
-	"log"
 	"sync"
 
 	"github.com/ppeble/s3-personal-backup/pkg/backup"
 )
 
 const (
-	INFO  = "INFO"
-	ERROR = "ERROR"
+	INFO  = backup.INFO
+	ERROR = backup.ERROR
 )
 
-func NewLogger(out io.Writer, report chan<- backup.LogEntry, wg *sync.WaitGroup) backupLogger {
+// NewLogger logs entries to out and hands those at INFO and above to the
+// report, wg counts the entries that are on their way there
+func NewLogger(out Output, report chan<- backup.LogEntry, wg *sync.WaitGroup) backupLogger {
 	return backupLogger{
-		infoLog:  log.New(out, INFO+": ", log.Ldate|log.Ltime|log.LUTC),
-		errorLog: log.New(out, ERROR+": ", log.Ldate|log.Ltime|log.LUTC),
-		report:   report,
-		wg:       wg,
+		out:    out,
+		report: report,
+		wg:     wg,
 	}
 }
 
 type backupLogger struct {
-	infoLog  *log.Logger
-	errorLog *log.Logger
-	report   chan<- backup.LogEntry
-	wg       *sync.WaitGroup
+	out    Output
+	report chan<- backup.LogEntry
+	wg     *sync.WaitGroup
+
+	// observe sees every entry that is reported, like the metrics do
+	observe func(backup.LogEntry)
+}
+
+// WithObserver has observe called with every entry that is reported, once
+// its level is set
+func (l backupLogger) WithObserver(observe func(backup.LogEntry)) backupLogger {
+	l.observe = observe
+	return l
+}
+
+// Debug is only logged, it is about something that is going to happen and
+// has no place in the report
+func (l backupLogger) Debug(i backup.LogEntry) {
+	l.out.Debug(i.Message, fields(i)...)
 }
 
-//FIXME Can't we just print the log entry? Why not? Why do it again here?
 func (l backupLogger) Info(i backup.LogEntry) {
-	l.infoLog.Println("file: '" + i.File + "' - message: '" + i.Message + "'")
+	l.out.Info(i.Message, fields(i)...)
+	i.Level = INFO
 	l.sendToReporter(i)
 }
 
-//FIXME Can't we just print the log entry? Why not? Why do it again here?
 func (l backupLogger) Error(i backup.LogEntry) {
-	l.errorLog.Println("file: '" + i.File + "' - message: '" + i.Message + "'")
+	l.out.Error(i.Message, fields(i)...)
+	i.Level = ERROR
 	l.sendToReporter(i)
 }
 
 func (l backupLogger) sendToReporter(i backup.LogEntry) {
+	if l.observe != nil {
+		l.observe(i)
+	}
+
 	l.wg.Add(1)
 	go func() {
 		l.report <- i
 		l.wg.Done()
 	}()
 }
+
+// fields are whichever of the fields of i are set
+func fields(i backup.LogEntry) []Field {
+	fields := make([]Field, 0, 8)
+
+	if i.File != "" {
+		fields = append(fields, KV("file", i.File))
+	}
+	if i.ActionType != "" {
+		fields = append(fields, KV("action", string(i.ActionType)))
+	}
+	if i.Size > 0 {
+		fields = append(fields, KV("bytes", i.Size))
+	}
+	if i.Deduplicated {
+		fields = append(fields, KV("deduplicated", true))
+	}
+	if i.Skipped {
+		fields = append(fields, KV("skipped", true))
+	}
+	if i.StorageClass != "" {
+		fields = append(fields, KV("storage_class", i.StorageClass))
+	}
+	if i.Duration > 0 {
+		fields = append(fields, KV("duration", i.Duration))
+	}
+	if i.Attempt > 0 {
+		fields = append(fields, KV("attempt", i.Attempt))
+	}
+
+	return fields
+}
@@ -3,6 +3,7 @@ package logger
 import (
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
@@ -41,7 +42,9 @@ func (s *LoggerTestSuite) SetupTest() {
 	}
 	s.report = make(chan backup.LogEntry)
 	s.wg = &sync.WaitGroup{}
-	s.logger = NewLogger(s.sliceLogger, s.report, s.wg)
+	out, err := NewOutput(s.sliceLogger, DebugLevel, TextFormat)
+	s.Require().NoError(err)
+	s.logger = NewLogger(out, s.report, s.wg)
 
 	s.wg.Add(1)
 	go func() {
@@ -65,6 +68,7 @@ func (s *LoggerTestSuite) Test_Info_SendsEntryToReportChannel() {
 	s.logger.Info(entry)
 
 	s.wg.Wait()
+	entry.Level = INFO
 	s.Equal(entry, s.reportMsg)
 }
 
@@ -83,5 +87,50 @@ func (s *LoggerTestSuite) Test_Error_SendsEntryToReportChannel() {
 	s.logger.Error(entry)
 
 	s.wg.Wait()
+	entry.Level = ERROR
 	s.Equal(entry, s.reportMsg)
 }
+
+func (s *LoggerTestSuite) Test_Debug_OnlyLogs() {
+	s.logger.Debug(backup.LogEntry{Message: "pushing", File: "testFile", ActionType: backup.PUSH})
+
+	s.Len(s.sliceLogger.messages, 1)
+	s.Contains(s.sliceLogger.messages[0], "DEBUG: ")
+	s.Contains(s.sliceLogger.messages[0], "pushing - file: 'testFile' - action: 'push'")
+
+	// Release the reader that SetupTest started, nothing was reported
+	s.report <- backup.LogEntry{}
+	s.wg.Wait()
+	s.Equal(backup.LogEntry{}, s.reportMsg)
+}
+
+func (s *LoggerTestSuite) Test_Info_LogsEveryFieldThatIsSet() {
+	s.logger.Info(backup.LogEntry{
+		Message:      "pushed",
+		File:         "testFile",
+		ActionType:   backup.PUSH,
+		Size:         100,
+		Deduplicated: true,
+		Skipped:      true,
+		StorageClass: "GLACIER",
+		Duration:     1500 * time.Millisecond,
+		Attempt:      1,
+	})
+
+	s.wg.Wait()
+	s.Contains(
+		s.sliceLogger.messages[0],
+		"pushed - file: 'testFile' - action: 'push' - bytes: 100 - deduplicated: true - skipped: true - storage_class: 'GLACIER' - duration: 1.5s - attempt: 1\n",
+	)
+}
+
+func (s *LoggerTestSuite) Test_WithObserver_SeesEveryReportedEntry() {
+	var observed []backup.LogEntry
+	s.logger = s.logger.WithObserver(func(e backup.LogEntry) { observed = append(observed, e) })
+
+	s.logger.Debug(backup.LogEntry{Message: "pushing"})
+	s.logger.Error(backup.LogEntry{Message: "failed"})
+
+	s.wg.Wait()
+	s.Equal([]backup.LogEntry{{Message: "failed", Level: ERROR}}, observed)
+}
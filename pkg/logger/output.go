@@ -0,0 +1,177 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how much a log line matters, lines below the level of an Output
+// are left out
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel is the level called name, whatever its case
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("'ParseLevel' error: unknown log level '%s', it has to be one of debug, info, warn or error", name)
+}
+
+// The formats that an Output can write
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// Field is a key and value that a log line is about
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// KV is the field key with value
+func KV(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Output writes log lines of at least its level to out, as text or as one
+// JSON object per line. It is safe to share between goroutines, every line
+// is written in one go.
+type Output struct {
+	out    io.Writer
+	level  Level
+	format string
+
+	lock *sync.Mutex
+	now  func() time.Time
+}
+
+func NewOutput(out io.Writer, level Level, format string) (Output, error) {
+	if format != TextFormat && format != JSONFormat {
+		return Output{}, fmt.Errorf("'NewOutput' error: unknown log format '%s', it has to be text or json", format)
+	}
+
+	return Output{
+		out:    out,
+		level:  level,
+		format: format,
+		lock:   &sync.Mutex{},
+		now:    time.Now,
+	}, nil
+}
+
+func (o Output) Debug(msg string, fields ...Field) {
+	o.Log(DebugLevel, msg, fields...)
+}
+
+func (o Output) Info(msg string, fields ...Field) {
+	o.Log(InfoLevel, msg, fields...)
+}
+
+func (o Output) Warn(msg string, fields ...Field) {
+	o.Log(WarnLevel, msg, fields...)
+}
+
+func (o Output) Error(msg string, fields ...Field) {
+	o.Log(ErrorLevel, msg, fields...)
+}
+
+// Log writes msg and its fields at level, unless that is below the level
+// of the output. Errors writing it have nowhere to go and are dropped.
+func (o Output) Log(level Level, msg string, fields ...Field) {
+	if level < o.level {
+		return
+	}
+
+	var line string
+	if o.format == JSONFormat {
+		line = o.json(level, msg, fields)
+	} else {
+		line = o.text(level, msg, fields)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	io.WriteString(o.out, line)
+}
+
+// text is the way this has always logged, the level, the time and then
+// the message, with every field after it
+func (o Output) text(level Level, msg string, fields []Field) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s %s", level, o.now().UTC().Format("2006/01/02 15:04:05"), msg)
+
+	for _, f := range fields {
+		switch v := f.Value.(type) {
+		case string:
+			fmt.Fprintf(&b, " - %s: '%s'", f.Key, v)
+		case error:
+			fmt.Fprintf(&b, " - %s: '%s'", f.Key, v)
+		default:
+			fmt.Fprintf(&b, " - %s: %v", f.Key, v)
+		}
+	}
+
+	b.WriteString("\n")
+	return b.String()
+}
+
+// json keeps the time, level and message first and the fields in the order
+// they were given. Durations are in seconds and errors are their message.
+func (o Output) json(level Level, msg string, fields []Field) string {
+	var b strings.Builder
+	b.WriteString("{")
+	writeJSON(&b, "time", o.now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(",")
+	writeJSON(&b, "level", level.String())
+	b.WriteString(",")
+	writeJSON(&b, "message", msg)
+
+	for _, f := range fields {
+		b.WriteString(",")
+
+		switch v := f.Value.(type) {
+		case time.Duration:
+			writeJSON(&b, f.Key, v.Seconds())
+		case error:
+			writeJSON(&b, f.Key, v.Error())
+		default:
+			writeJSON(&b, f.Key, v)
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func writeJSON(b *strings.Builder, key string, value interface{}) {
+	// A key is a string and every value logged is a string, number or bool,
+	// none of which fail to marshal
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+
+	b.Write(k)
+	b.WriteString(":")
+	b.Write(v)
+}
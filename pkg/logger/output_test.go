@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestOutputTestSuite(t *testing.T) {
+	suite.Run(t, new(OutputTestSuite))
+}
+
+type OutputTestSuite struct {
+	suite.Suite
+
+	buf *bytes.Buffer
+}
+
+func (s *OutputTestSuite) SetupTest() {
+	s.buf = &bytes.Buffer{}
+}
+
+func (s *OutputTestSuite) output(level Level, format string) Output {
+	o, err := NewOutput(s.buf, level, format)
+	s.Require().NoError(err)
+
+	o.now = func() time.Time { return time.Date(2020, 6, 1, 12, 0, 0, 500, time.UTC) }
+	return o
+}
+
+func (s *OutputTestSuite) Test_Text() {
+	o := s.output(DebugLevel, TextFormat)
+
+	o.Debug("starting")
+	o.Info("pushed", KV("file", "/a"), KV("bytes", int64(5)))
+	o.Warn("slow", KV("duration", 2*time.Second))
+	o.Error("failed", KV("err", errors.New("asplode")))
+
+	s.Equal(""+
+		"DEBUG: 2020/06/01 12:00:00 starting\n"+
+		"INFO: 2020/06/01 12:00:00 pushed - file: '/a' - bytes: 5\n"+
+		"WARN: 2020/06/01 12:00:00 slow - duration: 2s\n"+
+		"ERROR: 2020/06/01 12:00:00 failed - err: 'asplode'\n",
+		s.buf.String(),
+	)
+}
+
+func (s *OutputTestSuite) Test_JSON() {
+	o := s.output(DebugLevel, JSONFormat)
+
+	o.Info("pushed", KV("file", "/a"), KV("bytes", int64(5)), KV("duration", 1500*time.Millisecond), KV("err", errors.New("asplode")))
+
+	s.Equal(
+		`{"time":"2020-06-01T12:00:00.0000005Z","level":"INFO","message":"pushed","file":"/a","bytes":5,"duration":1.5,"err":"asplode"}`+"\n",
+		s.buf.String(),
+	)
+}
+
+func (s *OutputTestSuite) Test_LeavesOutLowerLevels() {
+	o := s.output(WarnLevel, TextFormat)
+
+	o.Debug("a")
+	o.Info("b")
+	o.Warn("c")
+	o.Log(ErrorLevel, "d")
+
+	s.Equal(""+
+		"WARN: 2020/06/01 12:00:00 c\n"+
+		"ERROR: 2020/06/01 12:00:00 d\n",
+		s.buf.String(),
+	)
+}
+
+func (s *OutputTestSuite) Test_NewOutput_UnknownFormat() {
+	_, err := NewOutput(s.buf, InfoLevel, "xml")
+	s.EqualError(err, "'NewOutput' error: unknown log format 'xml', it has to be text or json")
+}
+
+func (s *OutputTestSuite) Test_ParseLevel() {
+	for name, expected := range map[string]Level{"debug": DebugLevel, "INFO": InfoLevel, "Warn": WarnLevel, "error": ErrorLevel} {
+		level, err := ParseLevel(name)
+		s.Require().NoError(err)
+		s.Equal(expected, level)
+	}
+
+	_, err := ParseLevel("loud")
+	s.EqualError(err, "'ParseLevel' error: unknown log level 'loud', it has to be one of debug, info, warn or error")
+}
@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+)
+
+// RotatingFile is a log file that is rotated once writing to it would take
+// it past maxSize. The old ones are path.1, the newest, up to path.<keep>,
+// anything older is removed. A maxSize of 0 never rotates.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	keep    int
+
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile appends to the file at path, creating it if it isn't
+// there yet
+func OpenRotatingFile(path string, maxSize int64, keep int) (*RotatingFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		keep:    keep,
+		file:    f,
+		size:    fi.Size(),
+	}, nil
+}
+
+// Write never splits p over two files, a line that is bigger than maxSize
+// on its own still goes in one
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	return r.file.Close()
+}
+
+// rotate only lets go of the file once the new one is open, whatever goes
+// wrong before that the file is still there to write to
+func (r *RotatingFile) rotate() error {
+	// Files that aren't there yet have nothing to move out of the way
+	if err := os.Remove(r.old(r.keep)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := r.keep - 1; i > 0; i-- {
+		if err := os.Rename(r.old(i), r.old(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if r.keep > 0 {
+		if err := os.Rename(r.path, r.old(1)); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	r.file.Close()
+	r.file = f
+	r.size = 0
+
+	return nil
+}
+
+func (r *RotatingFile) old(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRotatingFileTestSuite(t *testing.T) {
+	suite.Run(t, new(RotatingFileTestSuite))
+}
+
+type RotatingFileTestSuite struct {
+	suite.Suite
+
+	dir  string
+	path string
+}
+
+func (s *RotatingFileTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "rotate")
+	s.Require().NoError(err)
+
+	s.dir = dir
+	s.path = filepath.Join(dir, "backup.log")
+}
+
+func (s *RotatingFileTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *RotatingFileTestSuite) read(path string) string {
+	body, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	return string(body)
+}
+
+func (s *RotatingFileTestSuite) write(r *RotatingFile, lines ...string) {
+	for _, l := range lines {
+		_, err := r.Write([]byte(l))
+		s.Require().NoError(err)
+	}
+}
+
+func (s *RotatingFileTestSuite) Test_Write_RotatesAndKeepsTheNewest() {
+	s.Require().NoError(ioutil.WriteFile(s.path, []byte("old\n"), 0644))
+
+	r, err := OpenRotatingFile(s.path, 8, 2)
+	s.Require().NoError(err)
+	defer r.Close()
+
+	// Appends to what is there, up to the max size
+	s.write(r, "abc\n")
+	s.Equal("old\nabc\n", s.read(s.path))
+
+	s.write(r, "def\n", "ghi\n", "jkl\n")
+
+	s.Equal("jkl\n", s.read(s.path))
+	s.Equal("def\nghi\n", s.read(s.path+".1"))
+	s.Equal("old\nabc\n", s.read(s.path+".2"))
+
+	s.write(r, "mno\n", "pqr\n")
+
+	s.Equal("pqr\n", s.read(s.path))
+	s.Equal("jkl\nmno\n", s.read(s.path+".1"))
+	s.Equal("def\nghi\n", s.read(s.path+".2"))
+	s.NoFileExists(s.path + ".3")
+}
+
+func (s *RotatingFileTestSuite) Test_Write_LinesBiggerThanTheMaxSize() {
+	r, err := OpenRotatingFile(s.path, 2, 1)
+	s.Require().NoError(err)
+	defer r.Close()
+
+	s.write(r, "abc\n", "def\n")
+
+	s.Equal("def\n", s.read(s.path))
+	s.Equal("abc\n", s.read(s.path+".1"))
+}
+
+func (s *RotatingFileTestSuite) Test_Write_NoMaxSizeNeverRotates() {
+	r, err := OpenRotatingFile(s.path, 0, 1)
+	s.Require().NoError(err)
+	defer r.Close()
+
+	s.write(r, "abc\n", "def\n")
+
+	s.Equal("abc\ndef\n", s.read(s.path))
+	s.NoFileExists(s.path + ".1")
+}
+
+func (s *RotatingFileTestSuite) Test_Write_KeepingNoneTruncates() {
+	r, err := OpenRotatingFile(s.path, 4, 0)
+	s.Require().NoError(err)
+	defer r.Close()
+
+	s.write(r, "abc\n", "def\n")
+
+	s.Equal("def\n", s.read(s.path))
+	s.NoFileExists(s.path + ".1")
+}
+
+func (s *RotatingFileTestSuite) Test_Write_RotateErrors() {
+	r, err := OpenRotatingFile(s.path, 4, 1)
+	s.Require().NoError(err)
+	s.write(r, "abc\n")
+
+	// The file went away, there is nothing to rename
+	s.Require().NoError(os.Remove(s.path))
+	_, err = r.Write([]byte("def\n"))
+	s.Error(err)
+
+	r, err = OpenRotatingFile(s.path, 4, 0)
+	s.Require().NoError(err)
+	s.write(r, "abc\n")
+
+	// A directory where the new file should go
+	s.Require().NoError(os.Remove(s.path))
+	s.Require().NoError(os.Mkdir(s.path, 0755))
+	_, err = r.Write([]byte("def\n"))
+	s.Error(err)
+
+	// The file it had is still open, and it rotates once it can
+	s.Require().NoError(os.Remove(s.path))
+	s.write(r, "def\n")
+	s.Equal("def\n", s.read(s.path))
+	s.NoError(r.Close())
+}
+
+func (s *RotatingFileTestSuite) Test_Write_ErrorWhenOldFilesCantBeMoved() {
+	r, err := OpenRotatingFile(s.path, 4, 2)
+	s.Require().NoError(err)
+	defer r.Close()
+
+	// The oldest is a directory with something in it, it can't be removed
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.path+".2", "in", "the", "way"), 0755))
+
+	s.write(r, "abc\n")
+	_, err = r.Write([]byte("def\n"))
+	s.Error(err)
+
+	// Nothing was moved and the file is still written to
+	s.NoFileExists(s.path + ".1")
+	s.Require().NoError(os.RemoveAll(s.path + ".2"))
+	s.write(r, "ghi\n")
+	s.Equal("abc\n", s.read(s.path+".1"))
+	s.Equal("ghi\n", s.read(s.path))
+}
+
+func (s *RotatingFileTestSuite) Test_Open_Errors() {
+	_, err := OpenRotatingFile(filepath.Join(s.dir, "missing", "backup.log"), 0, 0)
+	s.Error(err)
+}
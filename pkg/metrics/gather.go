@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// TimeLocal has observe called with how long every walk of g took
+func TimeLocal(g backup.FileGatherer, observe func(time.Duration)) backup.FileGatherer {
+	return timedLocal{FileGatherer: g, observe: observe}
+}
+
+type timedLocal struct {
+	backup.FileGatherer
+	observe func(time.Duration)
+}
+
+func (t timedLocal) Gather(ctx context.Context, out chan<- backup.File) error {
+	start := time.Now()
+	defer func() { t.observe(time.Since(start)) }()
+
+	return t.FileGatherer.Gather(ctx, out)
+}
+
+// TimeRemote has observe called with how long every listing of g took
+func TimeRemote(g backup.PrefixGatherer, observe func(time.Duration)) backup.PrefixGatherer {
+	return timedRemote{PrefixGatherer: g, observe: observe}
+}
+
+type timedRemote struct {
+	backup.PrefixGatherer
+	observe func(time.Duration)
+}
+
+func (t timedRemote) Gather(ctx context.Context, prefix string, out chan<- backup.File) error {
+	start := time.Now()
+	defer func() { t.observe(time.Since(start)) }()
+
+	return t.PrefixGatherer.Gather(ctx, prefix, out)
+}
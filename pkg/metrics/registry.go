@@ -0,0 +1,218 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format.
+// There is only as much of Prometheus here as a backup run needs, metrics
+// are never removed and every one of them has a fixed set of labels.
+type Registry struct {
+	lock     sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type family struct {
+	name, help, kind string
+	labels           []string
+
+	// buckets are the upper bounds of a histogram, without +Inf
+	buckets []float64
+
+	series map[string]*series
+}
+
+// series is a single set of label values of a family
+type series struct {
+	values []string
+
+	// value is that of a counter or gauge, a histogram has a count for
+	// every bucket and a sum and count of everything observed
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+// Gauge is a value that is set
+type Gauge struct {
+	r *Registry
+	f *family
+}
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) Counter {
+	return Counter{r, r.add(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) Gauge {
+	return Gauge{r, r.add(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// Histogram has buckets with the given upper bounds, in ascending order
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) Histogram {
+	return Histogram{r, r.add(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+func (r *Registry) add(f *family) *family {
+	f.series = make(map[string]*series)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.families = append(r.families, f)
+	return f
+}
+
+// Add adds v to the series with the label values, in the order the labels
+// were given
+func (c Counter) Add(v float64, values ...string) {
+	c.r.lock.Lock()
+	defer c.r.lock.Unlock()
+
+	c.f.get(values).value += v
+}
+
+func (g Gauge) Set(v float64, values ...string) {
+	g.r.lock.Lock()
+	defer g.r.lock.Unlock()
+
+	g.f.get(values).value = v
+}
+
+func (h Histogram) Observe(v float64, values ...string) {
+	h.r.lock.Lock()
+	defer h.r.lock.Unlock()
+
+	s := h.f.get(values)
+	for i, upper := range h.f.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// get is the series for values, created on first use. The registry has to
+// be locked.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Errorf("'%s' has labels %v but got %d values", f.name, f.labels, len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: values, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// Write writes every metric that has a series in the text format, the
+// families in the order they were added and their series sorted by their
+// label values
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	b := bufio.NewWriter(w)
+
+	for _, f := range r.families {
+		if len(f.series) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(b, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+		for _, s := range f.sorted() {
+			if f.kind != "histogram" {
+				fmt.Fprintf(b, "%s%s %s\n", f.name, labels(f.labels, s.values, "", ""), number(s.value))
+				continue
+			}
+
+			for i, upper := range f.buckets {
+				fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labels(f.labels, s.values, "le", number(upper)), s.counts[i])
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, labels(f.labels, s.values, "le", "+Inf"), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", f.name, labels(f.labels, s.values, "", ""), number(s.sum))
+			fmt.Fprintf(b, "%s_count%s %d\n", f.name, labels(f.labels, s.values, "", ""), s.count)
+		}
+	}
+
+	return b.Flush()
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	// An error writing the response means that the scrape went away, there
+	// is nobody left to tell
+	r.Write(w)
+}
+
+func (f *family) sorted() []*series {
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].values, "\xff") < strings.Join(all[j].values, "\xff")
+	})
+
+	return all
+}
+
+// labelEscaper escapes what the text format has label values escape, and
+// nothing else, a value is UTF-8 as it is
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels is the label set of a series, with an extra label when extra is
+// set, like the le of a histogram bucket
+func labels(names, values []string, extra, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(values[i])))
+	}
+
+	if extra != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra, labelEscaper.Replace(extraValue)))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func number(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
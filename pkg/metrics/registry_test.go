@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Registry_Write(t *testing.T) {
+	r := NewRegistry()
+
+	files := r.Counter("files_total", "Files.", "action", "result")
+	last := r.Gauge("last_seconds", "Last.")
+	r.Counter("unused_total", "Never used, never written.")
+	took := r.Histogram("took_seconds", "Took.", []float64{0.5, 1}, "action")
+
+	files.Add(1, "push", "succeeded")
+	files.Add(2, "push", "succeeded")
+	files.Add(1, "del", "failed")
+	last.Set(1590000000)
+	took.Observe(0.25, "push")
+	took.Observe(0.75, "push")
+	took.Observe(3, "push")
+
+	var b bytes.Buffer
+	assert.NoError(t, r.Write(&b))
+
+	assert.Equal(t, `# HELP files_total Files.
+# TYPE files_total counter
+files_total{action="del",result="failed"} 1
+files_total{action="push",result="succeeded"} 3
+# HELP last_seconds Last.
+# TYPE last_seconds gauge
+last_seconds 1.59e+09
+# HELP took_seconds Took.
+# TYPE took_seconds histogram
+took_seconds_bucket{action="push",le="0.5"} 1
+took_seconds_bucket{action="push",le="1"} 2
+took_seconds_bucket{action="push",le="+Inf"} 3
+took_seconds_sum{action="push"} 4
+took_seconds_count{action="push"} 3
+`, b.String())
+}
+
+func Test_Registry_QuotesLabelValues(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("g", "G.", "profile").Set(1, `say "hi"`+"\n")
+	r.Gauge("h", "H.", "dir").Set(1, `C:\Users\jürgen`+"\t")
+
+	var b bytes.Buffer
+	r.Write(&b)
+
+	assert.Contains(t, b.String(), `g{profile="say \"hi\"\n"} 1`)
+
+	// Only backslashes, quotes and newlines are escaped
+	assert.Contains(t, b.String(), `h{dir="C:\\Users\\jürgen`+"\t"+`"} 1`)
+}
+
+func Test_Registry_PanicsOnTheWrongLabels(t *testing.T) {
+	c := NewRegistry().Counter("c", "C.", "action")
+
+	assert.PanicsWithError(t, "'c' has labels [action] but got 2 values", func() { c.Add(1, "push", "extra") })
+}
+
+func Test_Registry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("g", "G.").Set(2)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "g 2\n")
+}
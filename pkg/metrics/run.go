@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// durationBuckets go from a few milliseconds, a small file on a local disk,
+// to several minutes, a big one over a slow line or a long listing
+var durationBuckets = []float64{0.005, 0.025, 0.1, 0.5, 1, 5, 30, 120, 600}
+
+// Run is everything that is measured about a run, over every profile and
+// destination in it
+type Run struct {
+	*Registry
+
+	files        Counter
+	errors       Counter
+	pushedBytes  Counter
+	dedupedBytes Counter
+	actions      Histogram
+	gathers      Histogram
+
+	lastRun     Gauge
+	lastOutcome Gauge
+	lastSuccess Gauge
+
+	now func() time.Time
+}
+
+func NewRun() *Run {
+	r := NewRegistry()
+
+	return &Run{
+		Registry: r,
+
+		files: r.Counter("backup_files_total",
+			"Files acted on, by action and whether that succeeded or failed.",
+			"profile", "destination", "action", "result"),
+		errors: r.Counter("backup_errors_total",
+			"Errors that weren't about a single file, like a failed listing.",
+			"profile", "destination"),
+		pushedBytes: r.Counter("backup_pushed_bytes_total",
+			"Bytes of the files that were uploaded.",
+			"profile", "destination"),
+		dedupedBytes: r.Counter("backup_deduplicated_bytes_total",
+			"Bytes of the files that didn't have to be uploaded, their content was already there.",
+			"profile", "destination"),
+		actions: r.Histogram("backup_action_duration_seconds",
+			"How long each action on a file took.",
+			durationBuckets, "profile", "destination", "action"),
+		gathers: r.Histogram("backup_gather_duration_seconds",
+			"How long walking a target directory, or listing it on a destination, took.",
+			durationBuckets, "profile", "destination", "source"),
+
+		lastRun: r.Gauge("backup_last_run_timestamp_seconds",
+			"When a profile last finished a run.",
+			"profile"),
+		lastOutcome: r.Gauge("backup_last_run_exit_code",
+			"Exit code for how the last run of a profile went, 0 is a success.",
+			"profile"),
+		lastSuccess: r.Gauge("backup_last_success_timestamp_seconds",
+			"When a profile last finished a run that succeeded.",
+			"profile"),
+
+		now: time.Now,
+	}
+}
+
+// Observe counts an entry that was logged for destination of profile
+func (m *Run) Observe(profile, destination string, e backup.LogEntry) {
+	failed := e.Level == backup.ERROR
+
+	if e.ActionType == "" {
+		if failed {
+			m.errors.Add(1, profile, destination)
+		}
+		return
+	}
+
+	result := "succeeded"
+	if failed {
+		result = "failed"
+	} else if e.Skipped {
+		result = "skipped"
+	}
+	m.files.Add(1, profile, destination, string(e.ActionType), result)
+
+	if !failed && !e.Skipped && e.ActionType == backup.PUSH {
+		if e.Deduplicated {
+			m.dedupedBytes.Add(float64(e.Size), profile, destination)
+		} else {
+			m.pushedBytes.Add(float64(e.Size), profile, destination)
+		}
+	}
+
+	if e.Duration > 0 {
+		m.actions.Observe(e.Duration.Seconds(), profile, destination, string(e.ActionType))
+	}
+}
+
+// Gathered records how long a walk of a target dir, the source is local
+// and there is no destination, or a listing of a destination took
+func (m *Run) Gathered(profile, destination, source string, d time.Duration) {
+	m.gathers.Observe(d.Seconds(), profile, destination, source)
+}
+
+// Finished records how the run of profile went, as of now
+func (m *Run) Finished(profile string, outcome backup.Outcome) {
+	now := float64(m.now().Unix())
+
+	m.lastRun.Set(now, profile)
+	m.lastOutcome.Set(float64(outcome.ExitCode()), profile)
+
+	if outcome == backup.Succeeded {
+		m.lastSuccess.Set(now, profile)
+	}
+}
+
+var lastSuccessLine = regexp.MustCompile(`^backup_last_success_timestamp_seconds\{profile=("(?:[^"\\]|\\.)*")\} (\S+)$`)
+
+// LoadLastSuccess carries over when each profile last succeeded from the
+// textfile that an earlier run wrote, a run that fails mustn't lose that.
+// Not having a textfile yet is fine.
+func (m *Run) LoadLastSuccess(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		match := lastSuccessLine.FindStringSubmatch(lines.Text())
+		if match == nil {
+			continue
+		}
+
+		// The expression only matches quoted strings
+		profile, _ := strconv.Unquote(match[1])
+
+		at, err := strconv.ParseFloat(match[2], 64)
+		if err != nil {
+			return fmt.Errorf("'LoadLastSuccess' error: bad timestamp '%s' in '%s'", match[2], path)
+		}
+
+		m.lastSuccess.Set(at, profile)
+	}
+
+	return lines.Err()
+}
+
+// WriteTextfile writes the metrics to path for the node_exporter textfile
+// collector. It is written next to it first and then renamed into place,
+// the collector never reads half a file.
+func (m *Run) WriteTextfile(path string) error {
+	var b bytes.Buffer
+
+	// Writing to a buffer doesn't fail
+	m.Write(&b)
+
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	defer os.Remove(tmp)
+
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func TestRunTestSuite(t *testing.T) {
+	suite.Run(t, new(RunTestSuite))
+}
+
+type RunTestSuite struct {
+	suite.Suite
+
+	dir  string
+	path string
+	run  *Run
+}
+
+func (s *RunTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "metrics")
+	s.Require().NoError(err)
+
+	s.dir = dir
+	s.path = filepath.Join(dir, "backup.prom")
+
+	s.run = NewRun()
+	s.run.now = func() time.Time { return time.Unix(1590000000, 0) }
+}
+
+func (s *RunTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+func (s *RunTestSuite) written() string {
+	var b bytes.Buffer
+	s.Require().NoError(s.run.Write(&b))
+	return b.String()
+}
+
+func (s *RunTestSuite) Test_Observe() {
+	for _, e := range []backup.LogEntry{
+		{Level: backup.INFO, ActionType: backup.PUSH, Size: 100, Duration: 2 * time.Second},
+		{Level: backup.INFO, ActionType: backup.PUSH, Size: 50, Deduplicated: true},
+		{Level: backup.ERROR, ActionType: backup.PUSH, Size: 30},
+		{Level: backup.INFO, ActionType: backup.REMOVE, Size: 10},
+		{Level: backup.INFO, ActionType: backup.REMOVE, Size: 20, Skipped: true},
+		{Level: backup.ERROR, Message: "listing failed"},
+		{Level: backup.INFO, Message: "nothing to count"},
+	} {
+		s.run.Observe("home", "nas", e)
+	}
+
+	written := s.written()
+	s.Contains(written, `backup_files_total{profile="home",destination="nas",action="push",result="succeeded"} 2`)
+	s.Contains(written, `backup_files_total{profile="home",destination="nas",action="push",result="failed"} 1`)
+	s.Contains(written, `backup_files_total{profile="home",destination="nas",action="remove",result="succeeded"} 1`)
+	s.Contains(written, `backup_files_total{profile="home",destination="nas",action="remove",result="skipped"} 1`)
+	s.Contains(written, `backup_errors_total{profile="home",destination="nas"} 1`)
+	s.Contains(written, `backup_pushed_bytes_total{profile="home",destination="nas"} 100`)
+	s.Contains(written, `backup_deduplicated_bytes_total{profile="home",destination="nas"} 50`)
+	s.Contains(written, `backup_action_duration_seconds_bucket{profile="home",destination="nas",action="push",le="1"} 0`)
+	s.Contains(written, `backup_action_duration_seconds_bucket{profile="home",destination="nas",action="push",le="5"} 1`)
+	s.Contains(written, `backup_action_duration_seconds_count{profile="home",destination="nas",action="push"} 1`)
+}
+
+func (s *RunTestSuite) Test_Gathered() {
+	s.run.Gathered("home", "", "local", 2*time.Second)
+
+	s.Contains(s.written(), `backup_gather_duration_seconds_sum{profile="home",destination="",source="local"} 2`)
+}
+
+func (s *RunTestSuite) Test_Finished() {
+	s.run.Finished("home", backup.Succeeded)
+	s.run.Finished("work", backup.PartlyFailed)
+
+	written := s.written()
+	s.Contains(written, `backup_last_run_timestamp_seconds{profile="home"} 1.59e+09`)
+	s.Contains(written, `backup_last_run_timestamp_seconds{profile="work"} 1.59e+09`)
+	s.Contains(written, `backup_last_run_exit_code{profile="home"} 0`)
+	s.Contains(written, `backup_last_run_exit_code{profile="work"} 2`)
+	s.Contains(written, `backup_last_success_timestamp_seconds{profile="home"} 1.59e+09`)
+	s.NotContains(written, `backup_last_success_timestamp_seconds{profile="work"}`)
+}
+
+func (s *RunTestSuite) Test_WriteTextfile_ThenLoadLastSuccess() {
+	s.run.Finished("home", backup.Succeeded)
+	s.run.Finished(`"odd" one`, backup.Succeeded)
+	s.run.Finished("work", backup.Failed)
+	s.Require().NoError(s.run.WriteTextfile(s.path))
+
+	body, err := ioutil.ReadFile(s.path)
+	s.Require().NoError(err)
+	s.Equal(s.written(), string(body))
+
+	// Nothing is left behind next to it
+	files, _ := ioutil.ReadDir(s.dir)
+	s.Len(files, 1)
+
+	// A later run that fails still has when the others last succeeded
+	later := NewRun()
+	s.Require().NoError(later.LoadLastSuccess(s.path))
+	later.Finished("home", backup.Failed)
+
+	var b bytes.Buffer
+	later.Write(&b)
+	s.Contains(b.String(), `backup_last_success_timestamp_seconds{profile="home"} 1.59e+09`)
+	s.Contains(b.String(), `backup_last_success_timestamp_seconds{profile="\"odd\" one"} 1.59e+09`)
+	s.NotContains(b.String(), `backup_last_success_timestamp_seconds{profile="work"}`)
+}
+
+func (s *RunTestSuite) Test_LoadLastSuccess_Errors() {
+	// There is no textfile yet the first time
+	s.NoError(s.run.LoadLastSuccess(s.path))
+
+	s.Require().NoError(ioutil.WriteFile(s.path, []byte(`backup_last_success_timestamp_seconds{profile="home"} soon`+"\n"), 0644))
+	s.EqualError(s.run.LoadLastSuccess(s.path), "'LoadLastSuccess' error: bad timestamp 'soon' in '"+s.path+"'")
+
+	s.Error(s.run.LoadLastSuccess(filepath.Join(s.path, "in", "a", "file")))
+
+	// A directory opens fine but can't be read
+	s.Error(s.run.LoadLastSuccess(s.dir))
+}
+
+func (s *RunTestSuite) Test_WriteTextfile_Errors() {
+	s.Error(s.run.WriteTextfile(filepath.Join(s.dir, "missing", "backup.prom")))
+
+	// A directory in the way can't be renamed over
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.path, "in", "the", "way"), 0755))
+	s.Error(s.run.WriteTextfile(s.path))
+}
+
+type fakeGatherer struct {
+	err error
+}
+
+func (g fakeGatherer) Root() string {
+	return "/home"
+}
+
+func (g fakeGatherer) Gather(context.Context, chan<- backup.File) error {
+	return g.err
+}
+
+type fakePrefixGatherer struct {
+	err error
+}
+
+func (g fakePrefixGatherer) Gather(context.Context, string, chan<- backup.File) error {
+	return g.err
+}
+
+func (s *RunTestSuite) Test_TimeGatherers() {
+	expectedErr := errors.New("asplode")
+
+	var observed []time.Duration
+	observe := func(d time.Duration) { observed = append(observed, d) }
+
+	local := TimeLocal(fakeGatherer{expectedErr}, observe)
+	s.Equal("/home", local.Root())
+	s.Equal(expectedErr, local.Gather(context.Background(), nil))
+
+	remote := TimeRemote(fakePrefixGatherer{expectedErr}, observe)
+	s.Equal(expectedErr, remote.Gather(context.Background(), "/home", nil))
+
+	// Failed walks and listings are timed as well
+	s.Len(observed, 2)
+}
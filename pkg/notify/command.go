@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Command runs a command with sh, with the summary as JSON on its stdin and
+// how the run went in BACKUP_* env variables
+type Command struct {
+	command string
+}
+
+func NewCommand(command string) Command {
+	return Command{command: command}
+}
+
+func (c Command) Notify(ctx context.Context, s Summary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("'Notify' error: %s failed: %s", c, err)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.command)
+	cmd.Env = append(os.Environ(), env(s)...)
+	cmd.Stdin = bytes.NewReader(body)
+
+	// What the command prints only matters if it fails, the report is on
+	// stdout and shouldn't get mixed up with it
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("'Notify' error: %s failed: %s, output: '%s'", c, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (c Command) String() string {
+	return fmt.Sprintf("command '%s'", c.command)
+}
+
+func env(s Summary) []string {
+	return []string{
+		"BACKUP_HOST=" + s.Host,
+		"BACKUP_OUTCOME=" + s.Outcome.String(),
+		"BACKUP_EXIT_CODE=" + strconv.Itoa(s.ExitCode),
+		"BACKUP_STARTED=" + s.Started.Format(time.RFC3339),
+		"BACKUP_FINISHED=" + s.Finished.Format(time.RFC3339),
+		"BACKUP_DURATION=" + strconv.FormatFloat(s.Duration, 'f', 0, 64),
+		"BACKUP_FILES=" + strconv.Itoa(s.Files),
+		"BACKUP_PUSHED=" + strconv.Itoa(s.Pushed),
+		"BACKUP_REMOVED=" + strconv.Itoa(s.Removed),
+		"BACKUP_MOVED=" + strconv.Itoa(s.Moved),
+		"BACKUP_SKIPPED=" + strconv.Itoa(s.Skipped),
+		"BACKUP_FAILED=" + strconv.Itoa(s.Failed),
+		"BACKUP_DEDUPLICATED_BYTES=" + strconv.FormatInt(s.DeduplicatedBytes, 10),
+		"BACKUP_PUSHED_BYTES=" + strconv.FormatInt(s.PushedBytes, 10),
+		"BACKUP_REMOVED_BYTES=" + strconv.FormatInt(s.RemovedBytes, 10),
+	}
+}
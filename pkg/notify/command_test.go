@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func Test_Command_Notify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	envFile, stdinFile := filepath.Join(dir, "env"), filepath.Join(dir, "stdin")
+
+	started := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s := Summary{
+		Host:     "laptop",
+		Outcome:  backup.Failed,
+		ExitCode: 1,
+		Started:  started,
+		Finished: started.Add(90 * time.Second),
+		Duration: 90,
+		ReportSummary: backup.ReportSummary{
+			Files: 6, Pushed: 1, Removed: 2, Moved: 3, Skipped: 9, Failed: 4, DeduplicatedBytes: 5, PushedBytes: 7, RemovedBytes: 8,
+		},
+	}
+
+	c := NewCommand("env | grep ^BACKUP_ | sort > " + envFile + "; cat > " + stdinFile)
+	require.NoError(t, c.Notify(context.Background(), s))
+
+	env, _ := ioutil.ReadFile(envFile)
+	assert.Equal(t, []string{
+		"BACKUP_DEDUPLICATED_BYTES=5",
+		"BACKUP_DURATION=90",
+		"BACKUP_EXIT_CODE=1",
+		"BACKUP_FAILED=4",
+		"BACKUP_FILES=6",
+		"BACKUP_FINISHED=2020-06-01T12:01:30Z",
+		"BACKUP_HOST=laptop",
+		"BACKUP_MOVED=3",
+		"BACKUP_OUTCOME=failed",
+		"BACKUP_PUSHED=1",
+		"BACKUP_PUSHED_BYTES=7",
+		"BACKUP_REMOVED=2",
+		"BACKUP_REMOVED_BYTES=8",
+		"BACKUP_SKIPPED=9",
+		"BACKUP_STARTED=2020-06-01T12:00:00Z",
+	}, strings.Split(strings.TrimSpace(string(env)), "\n"))
+
+	stdin, _ := ioutil.ReadFile(stdinFile)
+	var got Summary
+	require.NoError(t, json.Unmarshal(stdin, &got.ReportSummary))
+	assert.Equal(t, s.ReportSummary, got.ReportSummary)
+}
+
+func Test_Command_NotifyFails(t *testing.T) {
+	c := NewCommand("echo no mail today; exit 3")
+
+	assert.EqualError(t, c.Notify(context.Background(), Summary{}), "'Notify' error: command 'echo no mail today; exit 3' failed: exit status 3, output: 'no mail today'")
+}
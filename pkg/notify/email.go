@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP is the server that mail is sent through, Addr is its host:port. Mail
+// is sent without logging in unless there is a Username.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// Email mails a summary of the run to some addresses
+type Email struct {
+	server SMTP
+	to     []string
+
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+	now  func() time.Time
+}
+
+func NewEmail(server SMTP, to ...string) (Email, error) {
+	switch {
+	case server.Addr == "":
+		return Email{}, errors.New("'NewEmail' error: mail needs an SMTP host")
+	case server.From == "":
+		return Email{}, errors.New("'NewEmail' error: mail needs a from address")
+	case len(to) == 0:
+		return Email{}, errors.New("'NewEmail' error: mail needs someone to send it to")
+	}
+
+	if _, _, err := net.SplitHostPort(server.Addr); err != nil {
+		return Email{}, fmt.Errorf("'NewEmail' error: SMTP host '%s' has to be host:port", server.Addr)
+	}
+
+	return Email{
+		server: server,
+		to:     to,
+		send:   smtp.SendMail,
+		now:    time.Now,
+	}, nil
+}
+
+// Notify gives up on the server once ctx is done, sending itself can't be
+// cancelled and is left to finish or fail on its own
+func (e Email) Notify(ctx context.Context, s Summary) error {
+	var auth smtp.Auth
+	if e.server.Username != "" {
+		// The address was checked when the email was made
+		host, _, _ := net.SplitHostPort(e.server.Addr)
+		auth = smtp.PlainAuth("", e.server.Username, e.server.Password, host)
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- e.send(e.server.Addr, auth, e.server.From, e.to, e.message(s))
+	}()
+
+	select {
+	case err := <-sent:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e Email) String() string {
+	return fmt.Sprintf("mail to '%s'", strings.Join(e.to, ", "))
+}
+
+func (e Email) message(s Summary) []byte {
+	var b strings.Builder
+
+	header := func(key, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	header("From", e.server.From)
+	header("To", strings.Join(e.to, ", "))
+	header("Subject", fmt.Sprintf("backup %s on %s", s.Outcome, s.Host))
+	header("Date", e.now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	b.WriteString("\r\n")
+
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	line("The backup on %s %s, it took %s.", s.Host, s.Outcome, time.Duration(s.Duration*float64(time.Second)).Round(time.Second))
+	line("")
+	line("Files: %d - pushed: %d - removed: %d - moved: %d - failed: %d", s.Files, s.Pushed, s.Removed, s.Moved, s.Failed)
+
+	// Every profile has a line of its own when there are several, otherwise
+	// only an error is worth adding
+	line("")
+	for _, p := range s.Profiles {
+		switch {
+		case p.Error != "" && p.Name == "":
+			line("Error: %s", p.Error)
+		case p.Error != "":
+			line("Profile '%s' %s: %s", p.Name, p.Outcome, p.Error)
+		case len(s.Profiles) > 1:
+			line("Profile '%s' %s - files: %d - pushed: %d - removed: %d - moved: %d - failed: %d",
+				p.Name, p.Outcome, p.Files, p.Pushed, p.Removed, p.Moved, p.Failed)
+		}
+	}
+
+	return []byte(b.String())
+}
@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func TestEmailTestSuite(t *testing.T) {
+	suite.Run(t, new(EmailTestSuite))
+}
+
+type EmailTestSuite struct {
+	suite.Suite
+
+	server SMTP
+
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+	msg  string
+	err  error
+}
+
+func (s *EmailTestSuite) SetupTest() {
+	s.server = SMTP{Addr: "mail.example.com:587", From: "backup@example.com"}
+	s.err = nil
+}
+
+func (s *EmailTestSuite) email(to ...string) Email {
+	e, err := NewEmail(s.server, to...)
+	s.Require().NoError(err)
+
+	e.now = func() time.Time { return time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC) }
+	e.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		s.addr, s.auth, s.from, s.to, s.msg = addr, a, from, to, string(msg)
+		return s.err
+	}
+
+	return e
+}
+
+func (s *EmailTestSuite) Test_Notify() {
+	e := s.email("me@example.com", "you@example.com")
+
+	s.Require().NoError(e.Notify(context.Background(), Summary{
+		Host:          "laptop",
+		Outcome:       backup.Succeeded,
+		Duration:      62.4,
+		ReportSummary: backup.ReportSummary{Files: 3, Pushed: 2, Removed: 1},
+		Profiles:      []Profile{{Outcome: backup.Succeeded, ReportSummary: backup.ReportSummary{Files: 3, Pushed: 2, Removed: 1}}},
+	}))
+
+	s.Equal("mail.example.com:587", s.addr)
+	s.Nil(s.auth)
+	s.Equal("backup@example.com", s.from)
+	s.Equal([]string{"me@example.com", "you@example.com"}, s.to)
+	s.Equal("From: backup@example.com\r\n"+
+		"To: me@example.com, you@example.com\r\n"+
+		"Subject: backup succeeded on laptop\r\n"+
+		"Date: Mon, 01 Jun 2020 12:00:00 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"The backup on laptop succeeded, it took 1m2s.\r\n"+
+		"\r\n"+
+		"Files: 3 - pushed: 2 - removed: 1 - moved: 0 - failed: 0\r\n"+
+		"\r\n", s.msg)
+}
+
+func (s *EmailTestSuite) Test_Notify_Profiles() {
+	e := s.email("me@example.com")
+
+	e.Notify(context.Background(), Summary{
+		Host:    "laptop",
+		Outcome: backup.PartlyFailed,
+		Profiles: []Profile{
+			{Name: "code", Outcome: backup.Succeeded, ReportSummary: backup.ReportSummary{Files: 3, Pushed: 2}},
+			{Name: "documents", Outcome: backup.Failed, Error: "bucket is gone"},
+		},
+	})
+
+	s.Contains(s.msg, "Subject: backup partly failed on laptop\r\n")
+	s.Contains(s.msg, "\r\nProfile 'code' succeeded - files: 3 - pushed: 2 - removed: 0 - moved: 0 - failed: 0\r\n")
+	s.Contains(s.msg, "\r\nProfile 'documents' failed: bucket is gone\r\n")
+
+	e.Notify(context.Background(), Summary{
+		Host:     "laptop",
+		Outcome:  backup.Failed,
+		Profiles: []Profile{{Outcome: backup.Failed, Error: "bucket is gone"}},
+	})
+
+	s.Contains(s.msg, "\r\nError: bucket is gone\r\n")
+}
+
+func (s *EmailTestSuite) Test_Notify_LogsIn() {
+	s.server.Username, s.server.Password = "user", "secret"
+
+	s.Require().NoError(s.email("me@example.com").Notify(context.Background(), Summary{}))
+	s.Equal(smtp.PlainAuth("", "user", "secret", "mail.example.com"), s.auth)
+}
+
+func (s *EmailTestSuite) Test_Notify_Fails() {
+	s.err = errors.New("asplode")
+	s.Equal(s.err, s.email("me@example.com").Notify(context.Background(), Summary{}))
+}
+
+func (s *EmailTestSuite) Test_Notify_GivesUpOnceDone() {
+	e := s.email("me@example.com")
+
+	hung := make(chan struct{})
+	defer close(hung)
+	e.send = func(string, smtp.Auth, string, []string, []byte) error {
+		<-hung
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.Equal(context.Canceled, e.Notify(ctx, Summary{}))
+}
+
+func (s *EmailTestSuite) Test_NewEmail_Errors() {
+	for _, tc := range []struct {
+		server SMTP
+		to     []string
+		err    string
+	}{
+		{SMTP{From: "backup@example.com"}, []string{"me@example.com"}, "'NewEmail' error: mail needs an SMTP host"},
+		{SMTP{Addr: "mail.example.com:25"}, []string{"me@example.com"}, "'NewEmail' error: mail needs a from address"},
+		{SMTP{Addr: "mail.example.com:25", From: "backup@example.com"}, nil, "'NewEmail' error: mail needs someone to send it to"},
+		{SMTP{Addr: "mail.example.com", From: "backup@example.com"}, []string{"me@example.com"}, "'NewEmail' error: SMTP host 'mail.example.com' has to be host:port"},
+	} {
+		_, err := NewEmail(tc.server, tc.to...)
+		s.EqualError(err, tc.err)
+	}
+}
+
+func (s *EmailTestSuite) Test_String() {
+	s.Equal("mail to 'me@example.com, you@example.com'", s.email("me@example.com", "you@example.com").String())
+}
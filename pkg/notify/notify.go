@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"context"
+	"time"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// Summary is what notifiers are told about a run, as a whole and for every
+// profile in it. It is what a webhook or command gets as JSON.
+type Summary struct {
+	Host     string         `json:"host"`
+	Outcome  backup.Outcome `json:"outcome"`
+	ExitCode int            `json:"exitCode"`
+	Started  time.Time      `json:"started"`
+	Finished time.Time      `json:"finished"`
+
+	// Duration is in seconds
+	Duration float64 `json:"duration"`
+
+	// The totals of every profile
+	backup.ReportSummary
+
+	Profiles []Profile `json:"profiles"`
+}
+
+// Profile is how one profile of a run went, a profile that failed before
+// it got as far as a report only has its error. The totals of a dry run
+// are what would have been done.
+type Profile struct {
+	Name    string         `json:"name"`
+	Outcome backup.Outcome `json:"outcome"`
+	Error   string         `json:"error,omitempty"`
+	DryRun  bool           `json:"dryRun,omitempty"`
+
+	backup.ReportSummary
+}
+
+// NewSummary is the summary of a run on host that started at started and
+// is done now
+func NewSummary(host string, started time.Time, profiles ...Profile) Summary {
+	s := Summary{
+		Host:     host,
+		Started:  started.UTC(),
+		Finished: time.Now().UTC(),
+		Profiles: profiles,
+	}
+
+	outcomes := make([]backup.Outcome, len(profiles))
+	for i, p := range profiles {
+		s.ReportSummary = s.ReportSummary.Add(p.ReportSummary)
+		outcomes[i] = p.Outcome
+	}
+
+	s.Outcome = backup.CombineOutcomes(outcomes...)
+	s.ExitCode = s.Outcome.ExitCode()
+	s.Duration = s.Finished.Sub(s.Started).Seconds()
+
+	return s
+}
+
+// Notifier tells someone, or something, how a run went. String says which
+// notifier it is in the logs.
+type Notifier interface {
+	Notify(ctx context.Context, s Summary) error
+	String() string
+}
+
+// When a notifier is told about a run
+const (
+	Always    = "always"
+	OnFailure = "failure"
+	Never     = "never"
+)
+
+// Rule says which runs a notifier is told about, a failure being anything
+// but a success. RemovedOver has it told about a run that removed more than
+// that many files as well, whatever When says, 0 never.
+type Rule struct {
+	When        string
+	RemovedOver int
+}
+
+func (r Rule) Matches(s Summary) bool {
+	if r.RemovedOver > 0 && s.Removed > r.RemovedOver {
+		return true
+	}
+
+	switch r.When {
+	case Always:
+		return true
+	case OnFailure:
+		return s.Outcome != backup.Succeeded
+	default:
+		return false
+	}
+}
+
+// Notification is a notifier and when to use it
+type Notification struct {
+	Notifier
+	Rule
+}
+
+// Send tells every notifier whose rule matches about s, one after the
+// other, each getting up to timeout. A notifier that fails doesn't stop
+// the others, the errors are in the same order as the notifications.
+func Send(notifications []Notification, s Summary, timeout time.Duration) []error {
+	errs := make([]error, len(notifications))
+
+	for i, n := range notifications {
+		if !n.Matches(s) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		errs[i] = n.Notify(ctx, s)
+		cancel()
+	}
+
+	return errs
+}
@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func Test_NewSummary(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+
+	s := NewSummary("laptop", started,
+		Profile{Name: "code", Outcome: backup.Succeeded, ReportSummary: backup.ReportSummary{Files: 3, Pushed: 2}},
+		Profile{Name: "documents", Outcome: backup.Failed, ReportSummary: backup.ReportSummary{Files: 2, Removed: 1, Failed: 2}},
+	)
+
+	assert.Equal(t, "laptop", s.Host)
+	assert.Equal(t, backup.PartlyFailed, s.Outcome)
+	assert.Equal(t, 2, s.ExitCode)
+	assert.Equal(t, backup.ReportSummary{Files: 5, Pushed: 2, Removed: 1, Failed: 2}, s.ReportSummary)
+	assert.Equal(t, started.UTC(), s.Started)
+	assert.InDelta(t, 60, s.Duration, 1)
+	assert.Len(t, s.Profiles, 2)
+
+	// Nothing run at all is a success
+	assert.Equal(t, backup.Succeeded, NewSummary("laptop", started).Outcome)
+}
+
+func Test_Rule_Matches(t *testing.T) {
+	succeeded := Summary{Outcome: backup.Succeeded, ReportSummary: backup.ReportSummary{Removed: 10}}
+	failed := Summary{Outcome: backup.Aborted}
+
+	for _, tc := range []struct {
+		rule      Rule
+		succeeded bool
+		failed    bool
+	}{
+		{Rule{When: Always}, true, true},
+		{Rule{When: OnFailure}, false, true},
+		{Rule{When: Never}, false, false},
+		{Rule{When: Never, RemovedOver: 9}, true, false},
+		{Rule{When: OnFailure, RemovedOver: 10}, false, true},
+	} {
+		assert.Equal(t, tc.succeeded, tc.rule.Matches(succeeded), "%+v", tc.rule)
+		assert.Equal(t, tc.failed, tc.rule.Matches(failed), "%+v", tc.rule)
+	}
+}
+
+type fakeNotifier struct {
+	err      error
+	notified []Summary
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, s Summary) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no deadline")
+	}
+
+	n.notified = append(n.notified, s)
+	return n.err
+}
+
+func (n *fakeNotifier) String() string {
+	return "fake"
+}
+
+func Test_Send(t *testing.T) {
+	expectedErr := errors.New("asplode")
+	failing, always, never := &fakeNotifier{err: expectedErr}, &fakeNotifier{}, &fakeNotifier{}
+
+	s := Summary{Host: "laptop"}
+	errs := Send([]Notification{
+		{failing, Rule{When: Always}},
+		{never, Rule{When: Never}},
+		{always, Rule{When: Always}},
+	}, s, time.Minute)
+
+	assert.Equal(t, []error{expectedErr, nil, nil}, errs)
+	assert.Equal(t, []Summary{s}, failing.notified)
+	assert.Empty(t, never.notified)
+	assert.Equal(t, []Summary{s}, always.notified)
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Webhook POSTs the summary as JSON to a URL
+type Webhook struct {
+	url *url.URL
+}
+
+func NewWebhook(rawURL string) (Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("'NewWebhook' error: '%s' is not an http or https URL", rawURL)
+	}
+
+	return Webhook{url: u}, nil
+}
+
+func (w Webhook) Notify(ctx context.Context, s Summary) error {
+	body, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("'Notify' error: %s failed: %s", w, err)
+	}
+
+	// The URL was checked when the webhook was made
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, w.url.String(), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The client has the whole URL in its errors, the secret too
+		var urlErr *url.Error
+		errors.As(err, &urlErr)
+
+		return fmt.Errorf("'Notify' error: %s failed: %s", w, urlErr.Err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("'Notify' error: %s answered '%s'", w, resp.Status)
+	}
+
+	return nil
+}
+
+// String leaves out the path of the URL, webhooks often have their secret
+// in it
+func (w Webhook) String() string {
+	return fmt.Sprintf("webhook to '%s'", w.url.Host)
+}
@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func Test_Webhook_Notify(t *testing.T) {
+	var got map[string]interface{}
+	var contentType string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &got)
+
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	w, err := NewWebhook(server.URL + "/hook")
+	require.NoError(t, err)
+
+	s := Summary{
+		Host:          "laptop",
+		Outcome:       backup.PartlyFailed,
+		ExitCode:      2,
+		ReportSummary: backup.ReportSummary{Files: 3, Failed: 1},
+		Profiles:      []Profile{{Name: "code", Outcome: backup.PartlyFailed, ReportSummary: backup.ReportSummary{Files: 3, Failed: 1}}},
+	}
+	require.NoError(t, w.Notify(context.Background(), s))
+
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, "laptop", got["host"])
+	assert.Equal(t, "partly failed", got["outcome"])
+	assert.Equal(t, float64(2), got["exitCode"])
+	assert.Equal(t, float64(3), got["files"])
+	assert.Equal(t, float64(1), got["failed"])
+	assert.Equal(t, "code", got["profiles"].([]interface{})[0].(map[string]interface{})["name"])
+
+	broken, _ := NewWebhook(server.URL + "/broken")
+	assert.EqualError(t, broken.Notify(context.Background(), s), "'Notify' error: webhook to '"+server.Listener.Addr().String()+"' answered '500 Internal Server Error'")
+
+	server.Close()
+	err = w.Notify(context.Background(), s)
+	assert.Contains(t, err.Error(), "'Notify' error: webhook to '"+server.Listener.Addr().String()+"' failed: ")
+	assert.NotContains(t, err.Error(), "/hook")
+}
+
+func Test_NewWebhook_Errors(t *testing.T) {
+	for _, u := range []string{"", "hooks.example.com/backup", "ftp://hooks.example.com", "https://", "http://%zz"} {
+		_, err := NewWebhook(u)
+		assert.EqualError(t, err, "'NewWebhook' error: '"+u+"' is not an http or https URL")
+	}
+}
+
+func Test_Webhook_String(t *testing.T) {
+	w, _ := NewWebhook("https://hooks.example.com/services/secret")
+	assert.Equal(t, "webhook to 'hooks.example.com'", w.String())
+}
@@ -0,0 +1,85 @@
+package reporter
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// combinedReporter adds up the reports of every profile, or destination, in
+// a run. Each of them still prints its own report as it finishes.
+type combinedReporter struct {
+	logger *log.Logger
+
+	// kind is what is being added up, "profile" or "destination"
+	kind string
+
+	runs []runSummary
+}
+
+type runSummary struct {
+	name    string
+	summary backup.ReportSummary
+	err     error
+}
+
+func NewCombinedReporter(l *log.Logger, kind string) combinedReporter {
+	return combinedReporter{
+		logger: l,
+		kind:   kind,
+		runs:   make([]runSummary, 0),
+	}
+}
+
+// Add records how a profile or destination went, err is set if it didn't
+// finish
+func (r *combinedReporter) Add(name string, summary backup.ReportSummary, err error) {
+	r.runs = append(r.runs, runSummary{name: name, summary: summary, err: err})
+}
+
+func (r *combinedReporter) Print() {
+	var total backup.ReportSummary
+	failed := 0
+
+	for _, p := range r.runs {
+		total = total.Add(p.summary)
+
+		if p.err != nil {
+			failed++
+		}
+	}
+
+	title := strings.ToUpper(r.kind[:1]) + r.kind[1:]
+
+	r.logger.Println("Combined Report")
+	r.logger.Println("-------------------------------")
+	r.logger.Printf("%ss run: %d\n", title, len(r.runs))
+	r.logger.Printf("%ss failed: %d\n", title, failed)
+	r.logger.Printf("Total files processed: %d\n", total.Files)
+	r.logger.Printf("Files added to remote: %d\n", total.Pushed)
+	r.logger.Printf("Files removed from remote: %d\n", total.Removed)
+	r.logger.Printf("Files moved on remote: %d\n", total.Moved)
+	r.logger.Printf("Files skipped: %d\n", total.Skipped)
+	r.logger.Printf("Files failed: %d\n", total.Failed)
+	r.logger.Printf("Bytes saved by deduplication: %d\n", total.DeduplicatedBytes)
+	r.logger.Printf("Bytes added to remote: %d\n", total.PushedBytes)
+	r.logger.Printf("Bytes removed from remote: %d\n", total.RemovedBytes)
+	r.logger.Println("")
+	r.logger.Printf("%s Details\n", title)
+	r.logger.Println("-------------------------------")
+
+	for _, p := range r.runs {
+		if p.err != nil {
+			r.logger.Printf("%s: '%s' - failed: '%s'\n", r.kind, p.name, p.err)
+			continue
+		}
+
+		r.logger.Printf(
+			"%s: '%s' - processed: %d - added: %d - removed: %d - moved: %d - skipped: %d - failed: %d\n",
+			r.kind, p.name, p.summary.Files, p.summary.Pushed, p.summary.Removed, p.summary.Moved, p.summary.Skipped, p.summary.Failed,
+		)
+	}
+
+	r.logger.Println("")
+}
@@ -0,0 +1,85 @@
+package reporter
+
+import (
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func TestCombinedReporterTestSuite(t *testing.T) {
+	suite.Run(t, new(CombinedReporterTestSuite))
+}
+
+type CombinedReporterTestSuite struct {
+	suite.Suite
+
+	sliceLogger *sliceLogger
+	reporter    combinedReporter
+
+	messageIterator int
+}
+
+func (s *CombinedReporterTestSuite) SetupTest() {
+	s.sliceLogger = &sliceLogger{
+		messages: make([]string, 0),
+	}
+
+	s.reporter = NewCombinedReporter(log.New(s.sliceLogger, "REPORT: ", log.Ldate|log.Ltime|log.LUTC), "profile")
+	s.messageIterator = 0
+}
+
+func (s *CombinedReporterTestSuite) Test_Print_AddsUpEveryProfile() {
+	s.reporter.Add("home", backup.ReportSummary{Files: 4, Pushed: 2, Removed: 1, Skipped: 1, DeduplicatedBytes: 100, PushedBytes: 20, RemovedBytes: 5}, nil)
+	s.reporter.Add("mail", backup.ReportSummary{Files: 3, Pushed: 1, Moved: 1, Failed: 1, DeduplicatedBytes: 50, PushedBytes: 10}, nil)
+	s.reporter.Add("work", backup.ReportSummary{}, errors.New("asplode"))
+
+	s.reporter.Print()
+
+	s.contains("Combined Report")
+	s.contains("-------------------------------")
+	s.contains("Profiles run: 3")
+	s.contains("Profiles failed: 1")
+	s.contains("Total files processed: 7")
+	s.contains("Files added to remote: 3")
+	s.contains("Files removed from remote: 1")
+	s.contains("Files moved on remote: 1")
+	s.contains("Files skipped: 1")
+	s.contains("Files failed: 1")
+	s.contains("Bytes saved by deduplication: 150")
+	s.contains("Bytes added to remote: 30")
+	s.contains("Bytes removed from remote: 5")
+	s.contains("")
+	s.contains("Profile Details")
+	s.contains("-------------------------------")
+	s.contains("profile: 'home' - processed: 4 - added: 2 - removed: 1 - moved: 0 - skipped: 1 - failed: 0")
+	s.contains("profile: 'mail' - processed: 3 - added: 1 - removed: 0 - moved: 1 - skipped: 0 - failed: 1")
+	s.contains("profile: 'work' - failed: 'asplode'")
+	s.contains("")
+}
+
+func (s *CombinedReporterTestSuite) Test_Print_Destinations() {
+	s.reporter.kind = "destination"
+	s.reporter.Add("nas", backup.ReportSummary{Files: 3, Pushed: 3}, nil)
+	s.reporter.Add("offsite", backup.ReportSummary{}, errors.New("asplode"))
+
+	s.reporter.Print()
+
+	s.contains("Combined Report")
+	s.contains("-------------------------------")
+	s.contains("Destinations run: 2")
+	s.contains("Destinations failed: 1")
+	s.messageIterator = 14
+	s.contains("Destination Details")
+	s.contains("-------------------------------")
+	s.contains("destination: 'nas' - processed: 3 - added: 3 - removed: 0 - moved: 0 - skipped: 0 - failed: 0")
+	s.contains("destination: 'offsite' - failed: 'asplode'")
+}
+
+func (s *CombinedReporterTestSuite) contains(expected string) {
+	s.Contains(s.sliceLogger.messages[s.messageIterator], expected)
+	s.messageIterator++
+}
@@ -12,7 +12,8 @@ type dryRunReporter struct {
 
 	entries []backup.LogEntry
 
-	pushCount, removeCount int
+	pushCount, removeCount, copyCount int
+	skipCount, failCount              int
 }
 
 func NewDryRunReporter(
@@ -25,18 +26,30 @@ func NewDryRunReporter(
 		entries:     make([]backup.LogEntry, 0),
 		pushCount:   0,
 		removeCount: 0,
+		copyCount:   0,
+		skipCount:   0,
+		failCount:   0,
 	}
 }
 
+// Run takes entries until in is closed, only then is everything in the
+// report
 func (r *dryRunReporter) Run() {
-	for {
-		entry := <-r.in
+	for entry := range r.in {
 		r.entries = append(r.entries, entry)
 
-		if entry.ActionType == backup.PUSH {
+		if entry.Level == backup.ERROR {
+			r.failCount++
+		}
+
+		if entry.Skipped {
+			r.skipCount++
+		} else if entry.ActionType == backup.PUSH {
 			r.pushCount++
 		} else if entry.ActionType == backup.REMOVE {
 			r.removeCount++
+		} else if entry.ActionType == backup.COPY {
+			r.copyCount++
 		}
 	}
 }
@@ -47,6 +60,9 @@ func (r *dryRunReporter) Print() {
 	r.logger.Printf("Total files processed: %d\n", len(r.entries))
 	r.logger.Printf("Files that would be added to remote: %d\n", r.pushCount)
 	r.logger.Printf("Files that would be removed from remote: %d\n", r.removeCount)
+	r.logger.Printf("Files that would be moved on remote: %d\n", r.copyCount)
+	r.logger.Printf("Files that would be left alone: %d\n", r.skipCount)
+	r.logger.Printf("Files that failed: %d\n", r.failCount)
 	r.logger.Println("")
 	r.logger.Println("File Details")
 	r.logger.Println("-------------------------------")
@@ -57,3 +73,14 @@ func (r *dryRunReporter) Print() {
 
 	r.logger.Println("")
 }
+
+func (r *dryRunReporter) Summary() backup.ReportSummary {
+	return backup.ReportSummary{
+		Files:   len(r.entries),
+		Pushed:  r.pushCount,
+		Removed: r.removeCount,
+		Moved:   r.copyCount,
+		Skipped: r.skipCount,
+		Failed:  r.failCount,
+	}
+}
@@ -57,6 +57,8 @@ func (s *DryRunReporterTestSuite) Test_Print_GeneratesReport() {
 	s.in <- backup.LogEntry{Message: "test2", File: "file2", ActionType: backup.PUSH}
 	s.in <- backup.LogEntry{Message: "test3", File: "file3", ActionType: backup.PUSH}
 	s.in <- backup.LogEntry{Message: "test4", File: "file4", ActionType: backup.REMOVE}
+	s.in <- backup.LogEntry{Message: "test5", File: "file5", ActionType: backup.COPY}
+	s.in <- backup.LogEntry{Message: "test6", Level: backup.ERROR}
 
 	// Seems like it is possible for the 'Run' not getting the value in time
 	time.Sleep(10 * time.Millisecond)
@@ -65,9 +67,12 @@ func (s *DryRunReporterTestSuite) Test_Print_GeneratesReport() {
 
 	s.contains("Dry Run Report")
 	s.contains("-------------------------------")
-	s.contains("Total files processed: 4")
+	s.contains("Total files processed: 6")
 	s.contains("Files that would be added to remote: 3")
 	s.contains("Files that would be removed from remote: 1")
+	s.contains("Files that would be moved on remote: 1")
+	s.contains("Files that would be left alone: 0")
+	s.contains("Files that failed: 1")
 	s.contains("")
 	s.contains("File Details")
 	s.contains("-------------------------------")
@@ -75,10 +80,41 @@ func (s *DryRunReporterTestSuite) Test_Print_GeneratesReport() {
 	s.contains("file: 'file2' - action: 'push' - message: 'test2'")
 	s.contains("file: 'file3' - action: 'push' - message: 'test3'")
 	s.contains("file: 'file4' - action: 'remove' - message: 'test4'")
+	s.contains("file: 'file5' - action: 'copy' - message: 'test5'")
+	s.contains("file: '' - action: '' - message: 'test6'")
 	s.contains("")
 }
 
+func (s *DryRunReporterTestSuite) Test_Summary() {
+	go s.reporter.Run()
+
+	s.in <- backup.LogEntry{File: "file1", ActionType: backup.PUSH}
+	s.in <- backup.LogEntry{File: "file2", ActionType: backup.PUSH}
+	s.in <- backup.LogEntry{File: "file3", ActionType: backup.COPY}
+	s.in <- backup.LogEntry{File: "file4", Level: backup.ERROR}
+	s.in <- backup.LogEntry{File: "file5", ActionType: backup.REMOVE, Skipped: true}
+
+	// Seems like it is possible for the 'Run' not getting the value in time
+	time.Sleep(10 * time.Millisecond)
+
+	s.Equal(backup.ReportSummary{Files: 5, Pushed: 2, Moved: 1, Skipped: 1, Failed: 1}, s.reporter.Summary())
+}
+
 func (s *DryRunReporterTestSuite) contains(expected string) {
 	s.Contains(s.sliceLogger.messages[s.messageIterator], expected)
 	s.messageIterator++
 }
+
+func (s *DryRunReporterTestSuite) Test_Run_ReturnsOnceClosed() {
+	done := make(chan struct{})
+	go func() {
+		s.reporter.Run()
+		close(done)
+	}()
+
+	s.in <- backup.LogEntry{File: "file1", ActionType: backup.PUSH}
+	close(s.in)
+
+	<-done
+	s.Equal(1, s.reporter.Summary().Pushed)
+}
@@ -0,0 +1,121 @@
+package reporter
+
+import "html/template"
+
+// page is the HTML report. Everything it needs is in it, the styles and
+// the script that sorts the tables included, so it can be mailed or copied
+// anywhere and still be opened.
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size":  size,
+	"class": class,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Backup Report - {{.Host}} - {{.Started.Format "2006-01-02 15:04"}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0 auto; max-width: 72em; padding: 1em 2em; color: #222; }
+h1, h2, h3 { font-weight: 600; }
+h2 { border-bottom: 1px solid #ddd; padding-bottom: .3em; margin-top: 2em; }
+.meta { color: #666; }
+.outcome { display: inline-block; padding: .2em .6em; border-radius: .3em; color: #fff; font-size: .8em; vertical-align: middle; }
+.succeeded { background: #2e7d32; }
+.partly-failed { background: #ef6c00; }
+.failed { background: #c62828; }
+.aborted { background: #616161; }
+.dry-run { background: #1565c0; }
+.error { color: #c62828; }
+.cards { display: flex; flex-wrap: wrap; gap: 1em; margin: 1em 0; }
+.card { flex: 1 1 9em; border: 1px solid #ddd; border-radius: .5em; padding: .8em 1em; }
+.card .value { font-size: 1.6em; font-weight: 600; }
+.card .label { color: #666; font-size: .9em; }
+table { border-collapse: collapse; width: 100%; margin: 1em 0; font-size: .9em; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #eee; }
+td.number, th.number { text-align: right; }
+th { background: #f5f5f5; }
+table.sortable th { cursor: pointer; user-select: none; }
+table.sortable th::after { content: " \2195"; color: #aaa; }
+tr.failed td { background: #fdecea; color: #222; }
+tr.skipped td { color: #888; }
+.bar { background: #eee; border-radius: .2em; min-width: 8em; }
+.bar div { background: #42a5f5; border-radius: .2em; height: .8em; }
+details summary { cursor: pointer; margin: 1em 0; }
+</style>
+</head>
+<body>
+<h1>Backup Report <span class="outcome {{class .Outcome}}">{{.Outcome}}</span></h1>
+<p class="meta">Host: {{.Host}} - started: {{.Started.Format "2006-01-02 15:04:05 MST"}} - took: {{.Duration}}</p>
+{{template "cards" .Total}}
+{{range .Sections}}
+<h2>{{if .Name}}{{.Name}}{{else}}Backup{{end}} <span class="outcome {{class .Outcome}}">{{.Outcome}}</span>{{if .DryRun}} <span class="outcome dry-run">dry run</span>{{end}}</h2>
+{{if .Err}}<p class="error">Error: {{.Err}}</p>{{end}}
+{{if .Reported}}
+{{template "cards" .Summary}}
+{{if .Dirs}}
+<h3>Target Directories</h3>
+<table class="sortable">
+<thead><tr><th>Directory</th><th class="number">Processed</th><th class="number">Added</th><th class="number">Size added</th><th>Share of size added</th><th class="number">Removed</th><th class="number">Size removed</th><th class="number">Failed</th></tr></thead>
+<tbody>
+{{range .Dirs}}<tr><td>{{.Dir}}</td><td class="number">{{.Files}}</td><td class="number">{{.Pushed}}</td><td class="number" data-sort="{{.PushedBytes}}">{{size .PushedBytes}}</td><td data-sort="{{.Share}}"><div class="bar" title="{{printf "%.1f" .Share}}%"><div style="width: {{printf "%.1f" .Share}}%"></div></div></td><td class="number">{{.Removed}}</td><td class="number" data-sort="{{.RemovedBytes}}">{{size .RemovedBytes}}</td><td class="number">{{.Failed}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+{{if .Failures}}
+<h3>Failures</h3>
+<table class="sortable">
+<thead><tr><th>File</th><th>Action</th><th>Error</th></tr></thead>
+<tbody>
+{{range .Failures}}<tr class="failed"><td>{{.File}}</td><td>{{.ActionType}}</td><td>{{.Message}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+<details{{if le (len .Entries) 1000}} open{{end}}>
+<summary>Files ({{len .Entries}})</summary>
+<table class="sortable">
+<thead><tr><th>File</th><th>Action</th><th>Status</th><th class="number">Size</th><th class="number">Took</th><th>Message</th></tr></thead>
+<tbody>
+{{range .Entries}}<tr class="{{.Status}}"><td>{{.File}}</td><td>{{.ActionType}}</td><td>{{.Status}}</td><td class="number" data-sort="{{.Size}}">{{size .Size}}</td><td class="number" data-sort="{{.Duration.Nanoseconds}}">{{.Duration}}</td><td>{{.Message}}</td></tr>
+{{end}}</tbody>
+</table>
+</details>
+{{end}}
+{{end}}
+<script>
+document.querySelectorAll("table.sortable th").forEach(function (th) {
+  var ascending = true;
+  th.addEventListener("click", function () {
+    var column = th.cellIndex, body = th.closest("table").tBodies[0];
+    var value = function (row) {
+      var cell = row.cells[column];
+      return cell.dataset.sort !== undefined ? cell.dataset.sort : cell.textContent;
+    };
+    var rows = Array.prototype.slice.call(body.rows);
+    rows.sort(function (a, b) {
+      var x = value(a), y = value(b), order;
+      if (!isNaN(parseFloat(x)) && !isNaN(parseFloat(y))) {
+        order = parseFloat(x) - parseFloat(y);
+      } else {
+        order = x.localeCompare(y);
+      }
+      return ascending ? order : -order;
+    });
+    ascending = !ascending;
+    rows.forEach(function (row) { body.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+{{define "cards"}}<div class="cards">
+<div class="card"><div class="value">{{.Files}}</div><div class="label">Files processed</div></div>
+<div class="card"><div class="value">{{.Pushed}}</div><div class="label">Files added</div></div>
+<div class="card"><div class="value">{{.Removed}}</div><div class="label">Files removed</div></div>
+<div class="card"><div class="value">{{.Moved}}</div><div class="label">Files moved</div></div>
+<div class="card"><div class="value">{{.Skipped}}</div><div class="label">Files skipped</div></div>
+<div class="card"><div class="value{{if .Failed}} error{{end}}">{{.Failed}}</div><div class="label">Files failed</div></div>
+<div class="card"><div class="value">{{size .PushedBytes}}</div><div class="label">Added</div></div>
+<div class="card"><div class="value">{{size .RemovedBytes}}</div><div class="label">Removed</div></div>
+<div class="card"><div class="value">{{size .DeduplicatedBytes}}</div><div class="label">Saved by deduplication</div></div>
+</div>{{end}}
+`))
@@ -0,0 +1,222 @@
+package reporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+// The formats that the reports can be in
+const (
+	TextFormat = "text"
+	HTMLFormat = "html"
+)
+
+// CheckFormat fails for a report format that there is no report for
+func CheckFormat(format string) error {
+	if format != TextFormat && format != HTMLFormat {
+		return fmt.Errorf("'CheckFormat' error: unknown report format '%s', it has to be text or html", format)
+	}
+
+	return nil
+}
+
+// HTMLReport is the reports of a run as a single HTML page that needs
+// nothing but a browser, for whoever would rather not read the text ones.
+// Every report that is added is a section of its own.
+type HTMLReport struct {
+	host    string
+	started time.Time
+
+	sections []htmlSection
+
+	now func() time.Time
+}
+
+type htmlSection struct {
+	Name    string
+	Outcome backup.Outcome
+	Err     error
+	DryRun  bool
+
+	// Reported is whether there is a report, one that failed early only has
+	// its error
+	Reported bool
+	Summary  backup.ReportSummary
+	Dirs     []htmlDir
+	Failures []backup.LogEntry
+	Entries  []htmlEntry
+}
+
+type htmlDir struct {
+	Dir                            string
+	Files, Pushed, Removed, Failed int
+	PushedBytes, RemovedBytes      int64
+
+	// Share is how much of what was added to the target dirs went to this
+	// one, in percent
+	Share float64
+}
+
+type htmlEntry struct {
+	backup.LogEntry
+
+	// Status is succeeded, failed or skipped, there is none for an entry
+	// that isn't about an action
+	Status string
+}
+
+func NewHTMLReport(host string, started time.Time) HTMLReport {
+	return HTMLReport{
+		host:     host,
+		started:  started,
+		sections: make([]htmlSection, 0),
+		now:      time.Now,
+	}
+}
+
+// Add adds the report r of a profile or destination called name, once it
+// has everything in it. One that failed before it got a report has only its
+// error, r is nil then.
+func (h *HTMLReport) Add(name string, r backup.Reporter, outcome backup.Outcome, err error) {
+	section := htmlSection{Name: name, Outcome: outcome, Err: err, Reported: r != nil}
+
+	var entries []backup.LogEntry
+	switch r := r.(type) {
+	case *reporter:
+		section.Summary = r.Summary()
+		section.Dirs = r.htmlDirs()
+		section.Failures = r.failures
+		entries = r.entries
+	case *dryRunReporter:
+		section.DryRun = true
+		section.Summary = r.Summary()
+		entries = r.entries
+	}
+
+	for _, entry := range entries {
+		section.Entries = append(section.Entries, htmlEntry{LogEntry: entry, Status: status(entry)})
+
+		if section.DryRun && entry.Level == backup.ERROR {
+			section.Failures = append(section.Failures, entry)
+		}
+	}
+
+	h.sections = append(h.sections, section)
+}
+
+// htmlDirs are the totals of every target dir, with their share of the
+// bytes that were added
+func (r *reporter) htmlDirs() []htmlDir {
+	var pushed int64
+	for _, dir := range r.targetDirs {
+		pushed += r.dirs[dir].pushedBytes
+	}
+
+	dirs := make([]htmlDir, len(r.targetDirs))
+	for i, dir := range r.targetDirs {
+		total := r.dirs[dir]
+		dirs[i] = htmlDir{
+			Dir:          dir,
+			Files:        total.files,
+			Pushed:       total.pushed,
+			Removed:      total.removed,
+			Failed:       total.failed,
+			PushedBytes:  total.pushedBytes,
+			RemovedBytes: total.removedBytes,
+		}
+
+		if pushed > 0 {
+			dirs[i].Share = float64(total.pushedBytes) * 100 / float64(pushed)
+		}
+	}
+
+	return dirs
+}
+
+func status(entry backup.LogEntry) string {
+	switch {
+	case entry.Level == backup.ERROR:
+		return "failed"
+	case entry.ActionType == "":
+		return ""
+	case entry.Skipped:
+		return "skipped"
+	default:
+		return "succeeded"
+	}
+}
+
+// Write writes the page with every report that was added so far
+func (h *HTMLReport) Write(w io.Writer) error {
+	var total backup.ReportSummary
+	outcomes := make([]backup.Outcome, len(h.sections))
+	for i, s := range h.sections {
+		total = total.Add(s.Summary)
+		outcomes[i] = s.Outcome
+	}
+
+	return page.Execute(w, struct {
+		Host     string
+		Started  time.Time
+		Duration time.Duration
+		Outcome  backup.Outcome
+		Total    backup.ReportSummary
+		Sections []htmlSection
+	}{
+		Host:     h.host,
+		Started:  h.started,
+		Duration: h.now().Sub(h.started).Round(time.Second),
+		Outcome:  backup.CombineOutcomes(outcomes...),
+		Total:    total,
+		Sections: h.sections,
+	})
+}
+
+// WriteFile writes the page to path, a page that is being viewed is only
+// ever replaced by a whole one
+func (h *HTMLReport) WriteFile(path string) error {
+	var b bytes.Buffer
+
+	// Writing to a buffer doesn't fail
+	h.Write(&b)
+
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	defer os.Remove(tmp)
+
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// prefixes are those of size, from kilo up
+const prefixes = "KMGTPE"
+
+// size is bytes the way a person would write them
+func size(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	value, prefix := float64(bytes)/unit, 0
+	for value >= unit && prefix < len(prefixes)-1 {
+		value /= unit
+		prefix++
+	}
+
+	return fmt.Sprintf("%.1f %cB", value, prefixes[prefix])
+}
+
+// class is the CSS class that shows how o went
+func class(o backup.Outcome) string {
+	return strings.ReplaceAll(o.String(), " ", "-")
+}
@@ -0,0 +1,169 @@
+package reporter
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+)
+
+func TestHTMLReportTestSuite(t *testing.T) {
+	suite.Run(t, new(HTMLReportTestSuite))
+}
+
+type HTMLReportTestSuite struct {
+	suite.Suite
+
+	started time.Time
+	report  HTMLReport
+}
+
+func (s *HTMLReportTestSuite) SetupTest() {
+	s.started = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.report = NewHTMLReport("host1", s.started)
+	s.report.now = func() time.Time { return s.started.Add(90 * time.Second) }
+}
+
+// reported is a report that has every one of entries in it
+func reported(r backup.Reporter, in chan backup.LogEntry, entries ...backup.LogEntry) backup.Reporter {
+	for _, entry := range entries {
+		in <- entry
+	}
+	close(in)
+
+	r.Run()
+	return r
+}
+
+func (s *HTMLReportTestSuite) written() string {
+	var b bytes.Buffer
+	s.Require().NoError(s.report.Write(&b))
+
+	return b.String()
+}
+
+func (s *HTMLReportTestSuite) Test_Write_Report() {
+	in := make(chan backup.LogEntry, 10)
+	r := NewReporter(in, log.New(ioutil.Discard, "", 0)).WithTargetDirs("/home", "/srv")
+
+	s.report.Add("home - nas", reported(&r, in,
+		backup.LogEntry{File: "/home/a", ActionType: backup.PUSH, Size: 3 * megabyte, Duration: time.Second, Message: "pushed"},
+		backup.LogEntry{File: "/home/<b>", ActionType: backup.PUSH, Size: 1 * megabyte, Level: backup.ERROR, Message: "asplode"},
+		backup.LogEntry{File: "/srv/c", ActionType: backup.PUSH, Size: 1 * megabyte},
+		backup.LogEntry{File: "/srv/d", ActionType: backup.REMOVE, Skipped: true},
+		backup.LogEntry{Message: "listing failed", Level: backup.ERROR},
+	), backup.PartlyFailed, nil)
+
+	html := s.written()
+
+	s.Contains(html, "<title>Backup Report - host1 - 2020-06-01 12:00</title>")
+	s.Contains(html, `<h1>Backup Report <span class="outcome partly-failed">partly failed</span></h1>`)
+	s.Contains(html, "Host: host1 - started: 2020-06-01 12:00:00 UTC - took: 1m30s")
+	s.Contains(html, `<h2>home - nas <span class="outcome partly-failed">partly failed</span></h2>`)
+
+	// The cards of the run and of the report
+	s.Equal(2, bytes.Count([]byte(html), []byte(`<div class="value">5</div><div class="label">Files processed</div>`)))
+	s.Contains(html, `<div class="value error">2</div><div class="label">Files failed</div>`)
+	s.Contains(html, `<div class="value">1</div><div class="label">Files skipped</div>`)
+	s.Contains(html, `<div class="value">4.0 MB</div><div class="label">Added</div>`)
+
+	// Every target dir has its share of what was added
+	s.Contains(html, `<tr><td>/home</td><td class="number">2</td><td class="number">1</td><td class="number" data-sort="3145728">3.0 MB</td><td data-sort="75"><div class="bar" title="75.0%"><div style="width: 75.0%"></div></div></td><td class="number">0</td><td class="number" data-sort="0">0 B</td><td class="number">1</td></tr>`)
+	s.Contains(html, `<tr><td>/srv</td><td class="number">2</td><td class="number">1</td><td class="number" data-sort="1048576">1.0 MB</td><td data-sort="25">`)
+
+	// File names are escaped, they can be anything
+	s.Contains(html, `<tr class="failed"><td>/home/&lt;b&gt;</td><td>push</td><td>asplode</td></tr>`)
+	s.Contains(html, `<tr class="failed"><td></td><td></td><td>listing failed</td></tr>`)
+	s.NotContains(html, "/home/<b>")
+
+	s.Contains(html, "<details open>\n<summary>Files (5)</summary>")
+	s.Contains(html, `<tr class="succeeded"><td>/home/a</td><td>push</td><td>succeeded</td><td class="number" data-sort="3145728">3.0 MB</td><td class="number" data-sort="1000000000">1s</td><td>pushed</td></tr>`)
+	s.Contains(html, `<tr class="skipped"><td>/srv/d</td><td>remove</td><td>skipped</td>`)
+	s.Contains(html, `<tr class="failed"><td></td><td></td><td>failed</td>`)
+}
+
+func (s *HTMLReportTestSuite) Test_Write_DryRunAndFailed() {
+	in := make(chan backup.LogEntry, 10)
+	r := NewDryRunReporter(in, log.New(ioutil.Discard, "", 0))
+
+	s.report.Add("", reported(&r, in,
+		backup.LogEntry{File: "/home/a", ActionType: backup.PUSH, Size: 100},
+		backup.LogEntry{File: "/home/b", Level: backup.ERROR, Message: "unreadable"},
+		backup.LogEntry{Message: "nothing else to do"},
+	), backup.PartlyFailed, nil)
+	s.report.Add("work", nil, backup.Failed, errors.New("asplode"))
+
+	html := s.written()
+
+	s.Contains(html, `<h1>Backup Report <span class="outcome partly-failed">partly failed</span></h1>`)
+	s.Contains(html, `<h2>Backup <span class="outcome partly-failed">partly failed</span> <span class="outcome dry-run">dry run</span></h2>`)
+	s.NotContains(html, "<h3>Target Directories</h3>")
+	s.Contains(html, `<tr class="failed"><td>/home/b</td><td></td><td>unreadable</td></tr>`)
+	s.Contains(html, `<tr class="succeeded"><td>/home/a</td><td>push</td><td>succeeded</td><td class="number" data-sort="100">100 B</td>`)
+	s.Contains(html, `<tr class=""><td></td><td></td><td></td>`)
+
+	// There is only an error for a report that failed before it got one
+	s.Contains(html, "<h2>work <span class=\"outcome failed\">failed</span></h2>\n<p class=\"error\">Error: asplode</p>\n\n\n<script>")
+	s.Equal(2, bytes.Count([]byte(html), []byte(`<div class="cards">`)))
+}
+
+func (s *HTMLReportTestSuite) Test_Write_ManyFiles() {
+	in := make(chan backup.LogEntry, 1001)
+	r := NewReporter(in, log.New(ioutil.Discard, "", 0))
+
+	entries := make([]backup.LogEntry, 1001)
+	for i := range entries {
+		entries[i] = backup.LogEntry{File: "file", ActionType: backup.PUSH}
+	}
+
+	s.report.Add("", reported(&r, in, entries...), backup.Succeeded, nil)
+
+	// There are too many files to show them all right away
+	html := s.written()
+	s.Contains(html, "<details>\n<summary>Files (1001)</summary>")
+	s.Contains(html, `<h1>Backup Report <span class="outcome succeeded">succeeded</span></h1>`)
+}
+
+func (s *HTMLReportTestSuite) Test_WriteFile() {
+	dir, err := ioutil.TempDir("", "report")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "report.html")
+	s.report.Add("home", nil, backup.Aborted, errors.New("interrupted"))
+	s.Require().NoError(s.report.WriteFile(path))
+
+	written, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	s.Equal(s.written(), string(written))
+
+	// Nothing is left behind next to it
+	files, _ := ioutil.ReadDir(dir)
+	s.Len(files, 1)
+
+	s.Error(s.report.WriteFile(filepath.Join(dir, "missing", "report.html")))
+}
+
+func Test_CheckFormat(t *testing.T) {
+	assert.NoError(t, CheckFormat(TextFormat))
+	assert.NoError(t, CheckFormat(HTMLFormat))
+	assert.EqualError(t, CheckFormat("pdf"), "'CheckFormat' error: unknown report format 'pdf', it has to be text or html")
+}
+
+func Test_Size(t *testing.T) {
+	assert.Equal(t, "0 B", size(0))
+	assert.Equal(t, "1023 B", size(1023))
+	assert.Equal(t, "1.0 KB", size(1024))
+	assert.Equal(t, "1.5 MB", size(3*megabyte/2))
+	assert.Equal(t, "2.0 GB", size(2<<30))
+	assert.Equal(t, "8.0 EB", size(1<<63-1))
+}
@@ -2,6 +2,10 @@ package reporter
 
 import (
 	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ppeble/s3-personal-backup/pkg/backup"
@@ -14,9 +18,61 @@ type reporter struct {
 	entries []backup.LogEntry
 	start   time.Time
 
-	pushCount, removeCount int
+	// actions counts how each type of action went, failCount has every
+	// failure, those that weren't about an action as well
+	actions           map[backup.ActionType]actionTotal
+	failCount         int
+	deduplicatedBytes int64
+
+	// failures are the entries that failed, in the order they came in
+	failures []backup.LogEntry
+
+	// storageClasses adds up what was pushed with each storage class, for
+	// an idea of what it is going to cost
+	storageClasses map[string]classTotal
+
+	// pushedBytes were uploaded, deduplicated files aside, and removedBytes
+	// removed. Only actions that succeeded count.
+	pushedBytes, removedBytes int64
+
+	// throughput has the bytes uploaded in every second since the start,
+	// each upload spread over the seconds that it took
+	throughput map[int64]float64
+
+	// largest are the biggest uploads, biggest first
+	largest []backup.LogEntry
+
+	// targetDirs add up what happened to the files in each of them
+	targetDirs []string
+	dirs       map[string]dirTotal
+
+	now func() time.Time
 }
 
+type actionTotal struct {
+	succeeded, failed, skipped int
+}
+
+// actionTypes are the types of action that the report counts, in the order
+// it lists them
+var actionTypes = []backup.ActionType{backup.PUSH, backup.REMOVE, backup.COPY}
+
+type classTotal struct {
+	files int
+	bytes int64
+}
+
+type dirTotal struct {
+	files, pushed, removed, failed int
+	pushedBytes, removedBytes      int64
+}
+
+// largestCount is how many of the biggest uploads the report lists
+const largestCount = 5
+
+// megabyte is what throughput is in, the same as the log file sizes
+const megabyte = 1 << 20
+
 func NewReporter(
 	in <-chan backup.LogEntry,
 	l *log.Logger,
@@ -26,28 +82,168 @@ func NewReporter(
 		logger:      l,
 		entries:     make([]backup.LogEntry, 0),
 		start:       time.Now(),
-		pushCount:   0,
-		removeCount: 0,
+		actions:     make(map[backup.ActionType]actionTotal),
+		failCount:   0,
+		failures:    make([]backup.LogEntry, 0),
+
+		deduplicatedBytes: 0,
+		storageClasses:    make(map[string]classTotal),
+
+		throughput: make(map[int64]float64),
+		dirs:       make(map[string]dirTotal),
+		now:        time.Now,
+	}
+}
+
+// WithTargetDirs has the report break its totals down by the target dirs
+// that the files are in
+func (r reporter) WithTargetDirs(dirs ...string) reporter {
+	r.targetDirs = make([]string, len(dirs))
+	for i, dir := range dirs {
+		r.targetDirs[i] = filepath.Clean(dir)
 	}
+
+	return r
 }
 
+// Run takes entries until in is closed, only then is everything in the
+// report
 func (r *reporter) Run() {
-	for {
-		entry := <-r.in
+	for entry := range r.in {
 		r.entries = append(r.entries, entry)
 
-		if entry.ActionType == backup.PUSH {
-			r.pushCount++
-		} else if entry.ActionType == backup.REMOVE {
-			r.removeCount++
+		failed := entry.Level == backup.ERROR
+		if failed {
+			r.failCount++
+			r.failures = append(r.failures, entry)
 		}
+
+		if entry.ActionType != "" {
+			total := r.actions[entry.ActionType]
+			switch {
+			case failed:
+				total.failed++
+			case entry.Skipped:
+				total.skipped++
+			default:
+				total.succeeded++
+			}
+			r.actions[entry.ActionType] = total
+		}
+
+		if entry.Deduplicated {
+			r.deduplicatedBytes += entry.Size
+		}
+
+		if entry.StorageClass != "" {
+			total := r.storageClasses[entry.StorageClass]
+			total.files++
+			total.bytes += entry.Size
+			r.storageClasses[entry.StorageClass] = total
+		}
+
+		r.addBytes(entry)
 	}
 }
 
+// addBytes adds up the bytes of entry, overall and for its target dir
+func (r *reporter) addBytes(entry backup.LogEntry) {
+	failed := entry.Level == backup.ERROR
+	uploaded := !failed && !entry.Skipped && entry.ActionType == backup.PUSH && !entry.Deduplicated
+	removed := !failed && !entry.Skipped && entry.ActionType == backup.REMOVE
+
+	if uploaded {
+		r.pushedBytes += entry.Size
+		r.transferred(entry)
+	} else if removed {
+		r.removedBytes += entry.Size
+	}
+
+	dir, ok := r.targetDir(entry.File)
+	if !ok {
+		return
+	}
+
+	total := r.dirs[dir]
+	total.files++
+	switch {
+	case failed:
+		total.failed++
+	case uploaded:
+		total.pushed++
+		total.pushedBytes += entry.Size
+	case removed:
+		total.removed++
+		total.removedBytes += entry.Size
+	}
+	r.dirs[dir] = total
+}
+
+// transferred spreads an upload that is done by now over the seconds that
+// it took and keeps it if it is one of the largest, empty files aside
+func (r *reporter) transferred(entry backup.LogEntry) {
+	end := r.now().Sub(r.start).Seconds()
+	begin := math.Max(0, end-entry.Duration.Seconds())
+
+	if end <= begin {
+		r.throughput[int64(end)] += float64(entry.Size)
+	} else {
+		for second := math.Floor(begin); second < end; second++ {
+			overlap := math.Min(end, second+1) - math.Max(begin, second)
+			r.throughput[int64(second)] += float64(entry.Size) * overlap / (end - begin)
+		}
+	}
+
+	if entry.Size == 0 {
+		return
+	}
+
+	r.largest = append(r.largest, entry)
+	sort.SliceStable(r.largest, func(i, j int) bool {
+		return r.largest[i].Size > r.largest[j].Size
+	})
+
+	if len(r.largest) > largestCount {
+		r.largest = r.largest[:largestCount]
+	}
+}
+
+// targetDir is the target dir that file is in, the innermost one if they
+// are nested
+func (r *reporter) targetDir(file string) (string, bool) {
+	found := ""
+	for _, dir := range r.targetDirs {
+		if (file == dir || strings.HasPrefix(file, dir+"/")) && len(dir) > len(found) {
+			found = dir
+		}
+	}
+
+	return found, found != ""
+}
+
+// mbPerSecond is bytes over d, nothing if it took no time at all
+func mbPerSecond(bytes float64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+
+	return bytes / megabyte / d.Seconds()
+}
+
+// peakThroughput is the most that was uploaded in any one second, in bytes
+func (r *reporter) peakThroughput() float64 {
+	peak := 0.0
+	for _, bytes := range r.throughput {
+		peak = math.Max(peak, bytes)
+	}
+
+	return peak
+}
+
 //TODO Add some kind of timestamp in here, this is what we will probably want to be
 // printed to a separate file, it'll be nice to have some indication
 func (r *reporter) Print() {
-	runDuration := time.Since(r.start)
+	runDuration := r.now().Sub(r.start)
 	timePerFile := runDuration.Seconds() / float64(len(r.entries))
 
 	r.logger.Println("Backup Report")
@@ -55,9 +251,38 @@ func (r *reporter) Print() {
 	r.logger.Printf("Total run time (in minutes): %d\n", int(runDuration.Minutes()))
 	r.logger.Printf("Total files processed: %d\n", len(r.entries))
 	r.logger.Printf("Time per file (in seconds): %.4f\n", timePerFile)
-	r.logger.Printf("Files added to remote: %d\n", r.pushCount)
-	r.logger.Printf("Files removed from remote: %d\n", r.removeCount)
+	r.logger.Printf("Files added to remote: %d\n", r.actions[backup.PUSH].succeeded)
+	r.logger.Printf("Files removed from remote: %d\n", r.actions[backup.REMOVE].succeeded)
+	r.logger.Printf("Files moved on remote: %d\n", r.actions[backup.COPY].succeeded)
+	r.logger.Printf("Files skipped: %d\n", r.skipped())
+	r.logger.Printf("Files failed: %d\n", r.failCount)
+	r.logger.Printf("Bytes saved by deduplication: %d\n", r.deduplicatedBytes)
+	r.logger.Printf("Bytes added to remote: %d\n", r.pushedBytes)
+	r.logger.Printf("Bytes removed from remote: %d\n", r.removedBytes)
+	r.logger.Printf("Average throughput (in MB/s): %.2f\n", mbPerSecond(float64(r.pushedBytes), runDuration))
+	r.logger.Printf("Peak throughput (in MB/s): %.2f\n", mbPerSecond(r.peakThroughput(), time.Second))
 	r.logger.Println("")
+
+	if len(r.actions) > 0 {
+		r.printActions()
+	}
+
+	if len(r.failures) > 0 {
+		r.printFailures()
+	}
+
+	if len(r.largest) > 0 {
+		r.printLargest()
+	}
+
+	if len(r.targetDirs) > 0 {
+		r.printTargetDirs()
+	}
+
+	if len(r.storageClasses) > 0 {
+		r.printStorageClasses()
+	}
+
 	r.logger.Println("File Details")
 	r.logger.Println("-------------------------------")
 
@@ -67,3 +292,106 @@ func (r *reporter) Print() {
 
 	r.logger.Println("")
 }
+
+func (r *reporter) printActions() {
+	r.logger.Println("Actions")
+	r.logger.Println("-------------------------------")
+
+	for _, action := range actionTypes {
+		total := r.actions[action]
+		r.logger.Printf(
+			"action: '%s' - succeeded: %d - failed: %d - skipped: %d\n",
+			action, total.succeeded, total.failed, total.skipped,
+		)
+	}
+
+	r.logger.Println("")
+}
+
+// printFailures lists what failed with its error, so that it doesn't have
+// to be picked out of the file details
+func (r *reporter) printFailures() {
+	r.logger.Println("Failures")
+	r.logger.Println("-------------------------------")
+
+	for _, entry := range r.failures {
+		r.logger.Println(entry.String())
+	}
+
+	r.logger.Println("")
+}
+
+func (r *reporter) printLargest() {
+	r.logger.Println("Largest Transfers")
+	r.logger.Println("-------------------------------")
+
+	for _, entry := range r.largest {
+		r.logger.Printf(
+			"file: '%s' - bytes: %d - duration: %s - throughput (in MB/s): %.2f\n",
+			entry.File, entry.Size, entry.Duration,
+			mbPerSecond(float64(entry.Size), entry.Duration),
+		)
+	}
+
+	r.logger.Println("")
+}
+
+func (r *reporter) printTargetDirs() {
+	r.logger.Println("Target Directories")
+	r.logger.Println("-------------------------------")
+
+	for _, dir := range r.targetDirs {
+		total := r.dirs[dir]
+		r.logger.Printf(
+			"target dir: '%s' - processed: %d - added: %d - bytes added: %d - removed: %d - bytes removed: %d - failed: %d\n",
+			dir, total.files, total.pushed, total.pushedBytes, total.removed, total.removedBytes, total.failed,
+		)
+	}
+
+	r.logger.Println("")
+}
+
+func (r *reporter) printStorageClasses() {
+	classes := make([]string, 0, len(r.storageClasses))
+	for class := range r.storageClasses {
+		classes = append(classes, class)
+	}
+
+	sort.Strings(classes)
+
+	r.logger.Println("Storage Classes")
+	r.logger.Println("-------------------------------")
+
+	for _, class := range classes {
+		total := r.storageClasses[class]
+		r.logger.Printf("storage class: '%s' - files: %d - bytes: %d\n", class, total.files, total.bytes)
+	}
+
+	r.logger.Println("")
+}
+
+// skipped is how many actions of any type were skipped
+func (r *reporter) skipped() int {
+	skipped := 0
+	for _, total := range r.actions {
+		skipped += total.skipped
+	}
+
+	return skipped
+}
+
+// Summary only has the actions that succeeded as pushed, removed or moved,
+// those that failed are in Failed and those skipped in Skipped
+func (r *reporter) Summary() backup.ReportSummary {
+	return backup.ReportSummary{
+		Files:             len(r.entries),
+		Pushed:            r.actions[backup.PUSH].succeeded,
+		Removed:           r.actions[backup.REMOVE].succeeded,
+		Moved:             r.actions[backup.COPY].succeeded,
+		Skipped:           r.skipped(),
+		Failed:            r.failCount,
+		DeduplicatedBytes: r.deduplicatedBytes,
+		PushedBytes:       r.pushedBytes,
+		RemovedBytes:      r.removedBytes,
+	}
+}
@@ -55,8 +55,12 @@ func (s *ReporterTestSuite) Test_Print_GeneratesReport() {
 
 	s.in <- backup.LogEntry{Message: "test1", File: "file1", ActionType: backup.PUSH}
 	s.in <- backup.LogEntry{Message: "test2", File: "file2", ActionType: backup.PUSH}
-	s.in <- backup.LogEntry{Message: "test3", File: "file3", ActionType: backup.PUSH}
+	s.in <- backup.LogEntry{Message: "test3", File: "file3", ActionType: backup.PUSH, Size: 300, Deduplicated: true}
 	s.in <- backup.LogEntry{Message: "test4", File: "file4", ActionType: backup.REMOVE}
+	s.in <- backup.LogEntry{Message: "test5", File: "file5", ActionType: backup.COPY}
+	s.in <- backup.LogEntry{Message: "test6", Level: backup.ERROR}
+	s.in <- backup.LogEntry{Message: "test7", File: "file7", ActionType: backup.PUSH, Level: backup.ERROR}
+	s.in <- backup.LogEntry{Message: "test8", File: "file8", ActionType: backup.REMOVE, Skipped: true}
 
 	// Seems like it is possible for the 'Run' not getting the value in time
 	time.Sleep(10 * time.Millisecond)
@@ -66,10 +70,29 @@ func (s *ReporterTestSuite) Test_Print_GeneratesReport() {
 	s.contains("Backup Report")
 	s.contains("-------------------------------")
 	s.contains("Total run time (in minutes): 0")
-	s.contains("Total files processed: 4")
+	s.contains("Total files processed: 8")
 	s.contains("Time per file (in seconds):") // The time per file is highly variable
 	s.contains("Files added to remote: 3")
 	s.contains("Files removed from remote: 1")
+	s.contains("Files moved on remote: 1")
+	s.contains("Files skipped: 1")
+	s.contains("Files failed: 2")
+	s.contains("Bytes saved by deduplication: 300")
+	s.contains("Bytes added to remote: 0")
+	s.contains("Bytes removed from remote: 0")
+	s.contains("Average throughput (in MB/s): 0.00")
+	s.contains("Peak throughput (in MB/s): 0.00")
+	s.contains("")
+	s.contains("Actions")
+	s.contains("-------------------------------")
+	s.contains("action: 'push' - succeeded: 3 - failed: 1 - skipped: 0")
+	s.contains("action: 'remove' - succeeded: 1 - failed: 0 - skipped: 1")
+	s.contains("action: 'copy' - succeeded: 1 - failed: 0 - skipped: 0")
+	s.contains("")
+	s.contains("Failures")
+	s.contains("-------------------------------")
+	s.contains("file: '' - action: '' - message: 'test6'")
+	s.contains("file: 'file7' - action: 'push' - message: 'test7'")
 	s.contains("")
 	s.contains("File Details")
 	s.contains("-------------------------------")
@@ -77,10 +100,159 @@ func (s *ReporterTestSuite) Test_Print_GeneratesReport() {
 	s.contains("file: 'file2' - action: 'push' - message: 'test2'")
 	s.contains("file: 'file3' - action: 'push' - message: 'test3'")
 	s.contains("file: 'file4' - action: 'remove' - message: 'test4'")
+	s.contains("file: 'file5' - action: 'copy' - message: 'test5'")
+	s.contains("file: '' - action: '' - message: 'test6'")
+	s.contains("file: 'file7' - action: 'push' - message: 'test7'")
+	s.contains("file: 'file8' - action: 'remove' - message: 'test8'")
+	s.contains("")
+}
+
+func (s *ReporterTestSuite) Test_Print_StorageClasses() {
+	go s.reporter.Run()
+
+	s.in <- backup.LogEntry{Message: "test1", File: "file1", ActionType: backup.PUSH, Size: 100, StorageClass: "STANDARD"}
+	s.in <- backup.LogEntry{Message: "test2", File: "file2", ActionType: backup.PUSH, Size: 200, StorageClass: "GLACIER"}
+	s.in <- backup.LogEntry{Message: "test3", File: "file3", ActionType: backup.PUSH, Size: 300, StorageClass: "GLACIER"}
+
+	// Seems like it is possible for the 'Run' not getting the value in time
+	time.Sleep(10 * time.Millisecond)
+
+	s.reporter.Print()
+
+	s.messageIterator = 28
+	s.contains("Storage Classes")
+	s.contains("-------------------------------")
+	s.contains("storage class: 'GLACIER' - files: 2 - bytes: 500")
+	s.contains("storage class: 'STANDARD' - files: 1 - bytes: 100")
+	s.contains("")
+	s.contains("File Details")
+	s.contains("-------------------------------")
+	s.contains("file: 'file1' - action: 'push' - storage class: 'STANDARD' - message: 'test1'")
+}
+
+func (s *ReporterTestSuite) Test_Print_BytesAndThroughput() {
+	start := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+
+	s.reporter = s.reporter.WithTargetDirs("/home/docs/", "/home", "/srv")
+	s.reporter.start = start
+	s.reporter.now = func() time.Time { return now }
+
+	go s.reporter.Run()
+
+	send := func(at time.Duration, entry backup.LogEntry) {
+		now = start.Add(at)
+		s.in <- entry
+
+		// Seems like it is possible for the 'Run' not getting the value in time
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 4 MB over the first 2 seconds, then 3 MB in the second after that
+	send(2*time.Second, backup.LogEntry{File: "/home/docs/a", ActionType: backup.PUSH, Size: 4 * megabyte, Duration: 2 * time.Second})
+	send(2500*time.Millisecond, backup.LogEntry{File: "/home/c", ActionType: backup.PUSH, Size: 2 * megabyte})
+	send(3*time.Second, backup.LogEntry{File: "/home/b", ActionType: backup.PUSH, Size: 1 * megabyte, Duration: 500 * time.Millisecond})
+
+	// Nothing here was uploaded
+	send(4*time.Second, backup.LogEntry{File: "/home/d", ActionType: backup.PUSH, Size: 8 * megabyte, Deduplicated: true})
+	send(4*time.Second, backup.LogEntry{File: "/home/e", ActionType: backup.PUSH, Size: 8 * megabyte, Level: backup.ERROR})
+
+	// An empty file is uploaded but isn't much of a transfer
+	send(4*time.Second, backup.LogEntry{File: "/home/f", ActionType: backup.PUSH})
+
+	send(4*time.Second, backup.LogEntry{File: "/home/docs/g", ActionType: backup.REMOVE, Size: 100})
+	send(4*time.Second, backup.LogEntry{File: "/home/docs/h", ActionType: backup.REMOVE, Size: 50, Level: backup.ERROR})
+	send(4*time.Second, backup.LogEntry{File: "/elsewhere/i", ActionType: backup.COPY, Size: 10})
+	send(4*time.Second, backup.LogEntry{Message: "listing failed", Level: backup.ERROR})
+
+	now = start.Add(7 * time.Second)
+	s.reporter.Print()
+
+	s.messageIterator = 11
+	s.contains("Bytes added to remote: 7340032")
+	s.contains("Bytes removed from remote: 100")
+	s.contains("Average throughput (in MB/s): 1.00")
+	s.contains("Peak throughput (in MB/s): 3.00")
+	s.contains("")
+	s.messageIterator += 6
+	s.contains("Failures")
+	s.contains("-------------------------------")
+	s.contains("file: '/home/e' - action: 'push'")
+	s.contains("file: '/home/docs/h' - action: 'remove'")
+	s.contains("file: '' - action: '' - message: 'listing failed'")
+	s.contains("")
+	s.contains("Largest Transfers")
+	s.contains("-------------------------------")
+	s.contains("file: '/home/docs/a' - bytes: 4194304 - duration: 2s - throughput (in MB/s): 2.00")
+	s.contains("file: '/home/c' - bytes: 2097152 - duration: 0s - throughput (in MB/s): 0.00")
+	s.contains("file: '/home/b' - bytes: 1048576 - duration: 500ms - throughput (in MB/s): 2.00")
+	s.contains("")
+	s.contains("Target Directories")
+	s.contains("-------------------------------")
+	s.contains("target dir: '/home/docs' - processed: 3 - added: 1 - bytes added: 4194304 - removed: 1 - bytes removed: 100 - failed: 1")
+	s.contains("target dir: '/home' - processed: 5 - added: 3 - bytes added: 3145728 - removed: 0 - bytes removed: 0 - failed: 1")
+	s.contains("target dir: '/srv' - processed: 0 - added: 0 - bytes added: 0 - removed: 0 - bytes removed: 0 - failed: 0")
 	s.contains("")
+
+	summary := s.reporter.Summary()
+	s.Equal(int64(7*megabyte), summary.PushedBytes)
+	s.Equal(int64(100), summary.RemovedBytes)
+}
+
+func (s *ReporterTestSuite) Test_Print_KeepsTheLargestTransfers() {
+	go s.reporter.Run()
+
+	for i := int64(1); i <= largestCount+2; i++ {
+		s.in <- backup.LogEntry{File: "file", ActionType: backup.PUSH, Size: i}
+	}
+
+	// Seems like it is possible for the 'Run' not getting the value in time
+	time.Sleep(10 * time.Millisecond)
+
+	s.Len(s.reporter.largest, largestCount)
+	s.Equal(int64(largestCount+2), s.reporter.largest[0].Size)
+	s.Equal(int64(3), s.reporter.largest[largestCount-1].Size)
+}
+
+func (s *ReporterTestSuite) Test_Summary() {
+	go s.reporter.Run()
+
+	s.in <- backup.LogEntry{File: "file1", ActionType: backup.PUSH, Size: 300, Deduplicated: true}
+	s.in <- backup.LogEntry{File: "file2", ActionType: backup.REMOVE}
+	s.in <- backup.LogEntry{File: "file3", ActionType: backup.COPY}
+	s.in <- backup.LogEntry{File: "file4", Level: backup.ERROR}
+	s.in <- backup.LogEntry{File: "file5", ActionType: backup.PUSH, Size: 100, Level: backup.ERROR}
+	s.in <- backup.LogEntry{File: "file6", ActionType: backup.REMOVE, Size: 100, Skipped: true}
+
+	// Seems like it is possible for the 'Run' not getting the value in time
+	time.Sleep(10 * time.Millisecond)
+
+	s.Equal(backup.ReportSummary{
+		Files:             6,
+		Pushed:            1,
+		Removed:           1,
+		Moved:             1,
+		Skipped:           1,
+		Failed:            2,
+		DeduplicatedBytes: 300,
+	}, s.reporter.Summary())
 }
 
 func (s *ReporterTestSuite) contains(expected string) {
 	s.Contains(s.sliceLogger.messages[s.messageIterator], expected)
 	s.messageIterator++
 }
+
+func (s *ReporterTestSuite) Test_Run_ReturnsOnceClosed() {
+	done := make(chan struct{})
+	go func() {
+		s.reporter.Run()
+		close(done)
+	}()
+
+	s.in <- backup.LogEntry{File: "file1", ActionType: backup.PUSH}
+	close(s.in)
+
+	<-done
+	s.Equal(1, s.reporter.Summary().Pushed)
+}
@@ -0,0 +1,38 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// EnsureBucket checks that bucket is there, creating it in region when
+// create is set. It is expected to be given minio's BucketExists for e and
+// MakeBucket for m. An empty region is left for the host to pick.
+func EnsureBucket(
+	ctx context.Context,
+	bucket, region string,
+	create bool,
+	e func(context.Context, string) (bool, error),
+	m func(context.Context, string, minio.MakeBucketOptions) error,
+) error {
+	exists, err := e(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("'EnsureBucket' error: unable to check for bucket '%s', err: %s", bucket, err)
+	}
+
+	if exists {
+		return nil
+	}
+
+	if !create {
+		return fmt.Errorf("'EnsureBucket' error: bucket '%s' does not exist", bucket)
+	}
+
+	if err := m(ctx, bucket, minio.MakeBucketOptions{Region: region}); err != nil {
+		return fmt.Errorf("'EnsureBucket' error: unable to create bucket '%s', err: %s", bucket, err)
+	}
+
+	return nil
+}
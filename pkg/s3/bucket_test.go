@@ -0,0 +1,87 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestBucketTestSuite(t *testing.T) {
+	suite.Run(t, new(BucketTestSuite))
+}
+
+type BucketTestSuite struct {
+	suite.Suite
+
+	exists    bool
+	existsErr error
+	makeErr   error
+
+	made []minio.MakeBucketOptions
+}
+
+func (s *BucketTestSuite) SetupTest() {
+	s.exists = true
+	s.existsErr = nil
+	s.makeErr = nil
+	s.made = nil
+}
+
+func (s *BucketTestSuite) ensure(create bool) error {
+	return EnsureBucket(
+		context.Background(),
+		"testBucket",
+		"eu-west-1",
+		create,
+		func(_ context.Context, bucket string) (bool, error) {
+			s.Equal("testBucket", bucket)
+			return s.exists, s.existsErr
+		},
+		func(_ context.Context, bucket string, opts minio.MakeBucketOptions) error {
+			s.Equal("testBucket", bucket)
+			s.made = append(s.made, opts)
+			return s.makeErr
+		},
+	)
+}
+
+func (s *BucketTestSuite) Test_EnsureBucket_Exists() {
+	s.NoError(s.ensure(true))
+	s.Empty(s.made)
+}
+
+func (s *BucketTestSuite) Test_EnsureBucket_CreatesMissingBucket() {
+	s.exists = false
+
+	s.NoError(s.ensure(true))
+	s.Equal([]minio.MakeBucketOptions{{Region: "eu-west-1"}}, s.made)
+}
+
+func (s *BucketTestSuite) Test_EnsureBucket_ErrorMissingBucket() {
+	s.exists = false
+
+	err := s.ensure(false)
+
+	s.Equal(errors.New("'EnsureBucket' error: bucket 'testBucket' does not exist"), err)
+	s.Empty(s.made)
+}
+
+func (s *BucketTestSuite) Test_EnsureBucket_ErrorChecking() {
+	s.existsErr = errors.New("access denied")
+
+	err := s.ensure(true)
+
+	s.Equal(errors.New("'EnsureBucket' error: unable to check for bucket 'testBucket', err: access denied"), err)
+}
+
+func (s *BucketTestSuite) Test_EnsureBucket_ErrorCreating() {
+	s.exists = false
+	s.makeErr = errors.New("bucket name taken")
+
+	err := s.ensure(true)
+
+	s.Equal(errors.New("'EnsureBucket' error: unable to create bucket 'testBucket', err: bucket name taken"), err)
+}
@@ -0,0 +1,123 @@
+package s3
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Options are the ways that talking to a self hosted S3 can differ from
+// talking to AWS
+type Options struct {
+	// InsecureHTTP talks plain HTTP, only ever for hosts on a trusted network
+	InsecureHTTP bool
+
+	// CACertFile is a PEM file of certificates trusted on top of the
+	// system ones, for hosts with a certificate from a private CA
+	CACertFile string
+
+	// SkipTLSVerify trusts any certificate at all
+	SkipTLSVerify bool
+
+	// Region is only needed by hosts that won't say what theirs is
+	Region string
+
+	// BucketLookup is "path", "dns" or, when empty, whatever minio guesses
+	BucketLookup string
+}
+
+var bucketLookups = map[string]minio.BucketLookupType{
+	"":     minio.BucketLookupAuto,
+	"auto": minio.BucketLookupAuto,
+	"path": minio.BucketLookupPath,
+	"dns":  minio.BucketLookupDNS,
+}
+
+// NewClient checks o and makes a client for host. Nothing is sent to the
+// host, a client that is made can still fail on its first request.
+func NewClient(host string, creds *credentials.Credentials, o Options) (*minio.Client, error) {
+	if host == "" {
+		return nil, errors.New("'NewClient' error: s3 host cannot be missing")
+	}
+
+	if strings.Contains(host, "://") {
+		return nil, fmt.Errorf("'NewClient' error: s3 host '%s' must not have a scheme, use insecureHTTP for plain HTTP", host)
+	}
+
+	opts, err := minioOptions(o)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Creds = creds
+
+	return minio.New(host, opts)
+}
+
+func minioOptions(o Options) (*minio.Options, error) {
+	lookup, ok := bucketLookups[strings.ToLower(o.BucketLookup)]
+	if !ok {
+		return nil, fmt.Errorf("'NewClient' error: bucket lookup '%s' is not one of path or dns", o.BucketLookup)
+	}
+
+	if o.InsecureHTTP && (o.CACertFile != "" || o.SkipTLSVerify) {
+		return nil, errors.New("'NewClient' error: TLS options can't be used with insecure HTTP")
+	}
+
+	if o.CACertFile != "" && o.SkipTLSVerify {
+		return nil, errors.New("'NewClient' error: a CA cert file does nothing when TLS is not verified")
+	}
+
+	transport, err := minio.DefaultTransport(!o.InsecureHTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.CACertFile != "" {
+		pool, err := certPool(o.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if o.SkipTLSVerify {
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	return &minio.Options{
+		Secure:       !o.InsecureHTTP,
+		Transport:    transport,
+		Region:       o.Region,
+		BucketLookup: lookup,
+	}, nil
+}
+
+// systemCertPool can fail on some platforms, the private CA is then the
+// only one trusted
+var systemCertPool = x509.SystemCertPool
+
+// certPool is the system pool with the certificates in file added to it
+func certPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("'NewClient' error: unable to read CA cert file, err: %s", err)
+	}
+
+	pool, err := systemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("'NewClient' error: no certificates found in CA cert file '%s'", file)
+	}
+
+	return pool, nil
+}
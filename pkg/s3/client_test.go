@@ -0,0 +1,180 @@
+package s3
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestClientTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientTestSuite))
+}
+
+type ClientTestSuite struct {
+	suite.Suite
+
+	dir   string
+	creds *credentials.Credentials
+}
+
+func (s *ClientTestSuite) SetupTest() {
+	dir, err := ioutil.TempDir("", "s3")
+	s.Require().NoError(err)
+	s.dir = dir
+
+	s.creds = credentials.NewStaticV4("key", "secret", "")
+}
+
+func (s *ClientTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+}
+
+// caCert writes a self signed CA certificate as PEM
+func (s *ClientTestSuite) caCert() string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	s.Require().NoError(err)
+
+	file := filepath.Join(s.dir, "ca.pem")
+	s.Require().NoError(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+	return file
+}
+
+func (s *ClientTestSuite) transport(opts *minio.Options) *http.Transport {
+	return opts.Transport.(*http.Transport)
+}
+
+func (s *ClientTestSuite) Test_NewClient_DefaultsToHTTPS() {
+	client, err := NewClient("s3.example.com", s.creds, Options{})
+
+	s.Require().NoError(err)
+	s.Equal("https", client.EndpointURL().Scheme)
+	s.Equal("s3.example.com", client.EndpointURL().Host)
+}
+
+func (s *ClientTestSuite) Test_NewClient_InsecureHTTP() {
+	client, err := NewClient("minio.lan:9000", s.creds, Options{InsecureHTTP: true})
+
+	s.Require().NoError(err)
+	s.Equal("http", client.EndpointURL().Scheme)
+}
+
+func (s *ClientTestSuite) Test_NewClient_Errors() {
+	_, err := NewClient("", s.creds, Options{})
+	s.Equal(errors.New("'NewClient' error: s3 host cannot be missing"), err)
+
+	_, err = NewClient("http://minio.lan", s.creds, Options{})
+	s.Equal(errors.New("'NewClient' error: s3 host 'http://minio.lan' must not have a scheme, use insecureHTTP for plain HTTP"), err)
+
+	_, err = NewClient("minio.lan", s.creds, Options{BucketLookup: "virtual"})
+	s.Equal(errors.New("'NewClient' error: bucket lookup 'virtual' is not one of path or dns"), err)
+
+	// minio turns away what it can't parse as a host
+	_, err = NewClient("minio lan", s.creds, Options{})
+	s.Error(err)
+}
+
+func (s *ClientTestSuite) Test_minioOptions_RegionAndBucketLookup() {
+	for lookup, expected := range map[string]minio.BucketLookupType{
+		"":     minio.BucketLookupAuto,
+		"auto": minio.BucketLookupAuto,
+		"path": minio.BucketLookupPath,
+		"DNS":  minio.BucketLookupDNS,
+	} {
+		opts, err := minioOptions(Options{Region: "eu-west-1", BucketLookup: lookup})
+		s.Require().NoError(err)
+
+		s.Equal(expected, opts.BucketLookup, lookup)
+		s.Equal("eu-west-1", opts.Region)
+		s.True(opts.Secure)
+	}
+}
+
+func (s *ClientTestSuite) Test_minioOptions_CACertFile() {
+	opts, err := minioOptions(Options{CACertFile: s.caCert()})
+	s.Require().NoError(err)
+
+	tlsConfig := s.transport(opts).TLSClientConfig
+	s.NotNil(tlsConfig.RootCAs)
+	s.False(tlsConfig.InsecureSkipVerify)
+}
+
+func (s *ClientTestSuite) Test_minioOptions_CACertFileWithoutSystemPool() {
+	systemCertPool = func() (*x509.CertPool, error) {
+		return nil, errors.New("asplode")
+	}
+	defer func() { systemCertPool = x509.SystemCertPool }()
+
+	opts, err := minioOptions(Options{CACertFile: s.caCert()})
+	s.Require().NoError(err)
+
+	s.NotNil(s.transport(opts).TLSClientConfig.RootCAs)
+}
+
+func (s *ClientTestSuite) Test_minioOptions_SkipTLSVerify() {
+	opts, err := minioOptions(Options{SkipTLSVerify: true})
+	s.Require().NoError(err)
+
+	s.True(s.transport(opts).TLSClientConfig.InsecureSkipVerify)
+}
+
+func (s *ClientTestSuite) Test_minioOptions_BadCACertFile() {
+	_, err := minioOptions(Options{CACertFile: filepath.Join(s.dir, "missing.pem")})
+	s.Error(err)
+	s.Contains(err.Error(), "'NewClient' error: unable to read CA cert file")
+
+	notPem := filepath.Join(s.dir, "not.pem")
+	s.Require().NoError(ioutil.WriteFile(notPem, []byte("nope"), 0600))
+
+	_, err = minioOptions(Options{CACertFile: notPem})
+	s.Equal(errors.New("'NewClient' error: no certificates found in CA cert file '"+notPem+"'"), err)
+}
+
+func (s *ClientTestSuite) Test_minioOptions_ConflictingOptions() {
+	_, err := minioOptions(Options{InsecureHTTP: true, SkipTLSVerify: true})
+	s.Equal(errors.New("'NewClient' error: TLS options can't be used with insecure HTTP"), err)
+
+	_, err = minioOptions(Options{InsecureHTTP: true, CACertFile: "ca.pem"})
+	s.Equal(errors.New("'NewClient' error: TLS options can't be used with insecure HTTP"), err)
+
+	_, err = minioOptions(Options{SkipTLSVerify: true, CACertFile: "ca.pem"})
+	s.Equal(errors.New("'NewClient' error: a CA cert file does nothing when TLS is not verified"), err)
+}
+
+func (s *ClientTestSuite) Test_minioOptions_TransportError() {
+	defaultTransport := minio.DefaultTransport
+	minio.DefaultTransport = func(bool) (*http.Transport, error) {
+		return nil, errors.New("asplode")
+	}
+	defer func() { minio.DefaultTransport = defaultTransport }()
+
+	_, err := minioOptions(Options{})
+	s.Equal(errors.New("asplode"), err)
+}
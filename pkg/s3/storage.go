@@ -0,0 +1,208 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
+)
+
+// unknownSizePartSize keeps minio from buffering huge parts when streaming
+// something of unknown size, like a pack, it still allows for objects far
+// bigger than those should get
+const unknownSizePartSize = 16 << 20
+
+// Storage keeps objects in an S3 bucket
+type Storage struct {
+	bucket string
+
+	list   func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	stat   func(context.Context, string, string, minio.StatObjectOptions) (minio.ObjectInfo, error)
+	tags   func(context.Context, string, string, minio.GetObjectTaggingOptions) (*tags.Tags, error)
+	get    func(context.Context, string, string) (io.ReadCloser, error)
+	put    func(context.Context, string, string, io.Reader, int64, minio.PutObjectOptions) (minio.UploadInfo, error)
+	remove func(context.Context, string, string, minio.RemoveObjectOptions) error
+	copy   func(context.Context, minio.CopyDestOptions, ...minio.CopySrcOptions) (minio.UploadInfo, error)
+}
+
+// NewStorage is expected to be given minio's ComposeObject for c, plain
+// CopyObject is limited to 5GiB
+func NewStorage(
+	b string,
+	l func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo,
+	s func(context.Context, string, string, minio.StatObjectOptions) (minio.ObjectInfo, error),
+	t func(context.Context, string, string, minio.GetObjectTaggingOptions) (*tags.Tags, error),
+	g func(context.Context, string, string) (io.ReadCloser, error),
+	p func(context.Context, string, string, io.Reader, int64, minio.PutObjectOptions) (minio.UploadInfo, error),
+	r func(context.Context, string, string, minio.RemoveObjectOptions) error,
+	c func(context.Context, minio.CopyDestOptions, ...minio.CopySrcOptions) (minio.UploadInfo, error),
+) (Storage, error) {
+	if b == "" {
+		return Storage{}, errors.New("'NewStorage' error: bucket cannot be missing")
+	}
+
+	return Storage{
+		bucket: b,
+		list:   l,
+		stat:   s,
+		tags:   t,
+		get:    g,
+		put:    p,
+		remove: r,
+		copy:   c,
+	}, nil
+}
+
+// List relies on S3, and minio's paging through it, listing in key order.
+// Listing with metadata is a MinIO extension, other hosts leave it out.
+// Tags only ever come from the same listing, asking for every object's
+// tags one by one would cost a request per object.
+func (s *Storage) List(ctx context.Context, prefix string, opts backup.ListOptions, out chan<- backup.Object) (err error) {
+	ctx, end := s.call(ctx, "ListObjects", trace.Attr("prefix", prefix))
+	defer func() { end(err) }()
+
+	listOpts := minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithMetadata: opts.Metadata || opts.Tags}
+
+	for info := range s.list(ctx, s.bucket, listOpts) {
+		if info.Err != nil {
+			return info.Err
+		}
+
+		if err := backup.SendObject(ctx, out, object(info)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) Put(ctx context.Context, key string, r io.Reader, size int64, opts backup.PutOptions) (err error) {
+	ctx, end := s.call(ctx, "PutObject", trace.Attr("key", key), trace.Attr("bytes", size))
+	defer func() { end(err) }()
+
+	putOpts := minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: opts.Metadata,
+		StorageClass: opts.StorageClass,
+		UserTags:     opts.Tags,
+	}
+
+	if size < 0 {
+		putOpts.PartSize = unknownSizePartSize
+	}
+
+	_, err = s.put(ctx, s.bucket, key, r, size, putOpts)
+	return err
+}
+
+// Get is only traced until the object starts coming in, reading it isn't
+func (s *Storage) Get(ctx context.Context, key string) (_ io.ReadCloser, err error) {
+	ctx, end := s.call(ctx, "GetObject", trace.Attr("key", key))
+	defer func() { end(err) }()
+
+	return s.get(ctx, s.bucket, key)
+}
+
+func (s *Storage) Remove(ctx context.Context, key string) (err error) {
+	ctx, end := s.call(ctx, "DeleteObject", trace.Attr("key", key))
+	defer func() { end(err) }()
+
+	return s.remove(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *Storage) Stat(ctx context.Context, key string) (_ backup.Object, err error) {
+	ctx, end := s.call(ctx, "HeadObject", trace.Attr("key", key))
+	defer func() { end(err) }()
+
+	info, err := s.stat(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return backup.Object{}, backup.ErrNotFound
+	} else if err != nil {
+		return backup.Object{}, err
+	}
+
+	return object(info), nil
+}
+
+func (s *Storage) Tags(ctx context.Context, key string) (_ map[string]string, err error) {
+	ctx, end := s.call(ctx, "GetObjectTagging", trace.Attr("key", key))
+	defer func() { end(err) }()
+
+	t, err := s.tags(ctx, s.bucket, key, minio.GetObjectTaggingOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, backup.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return t.ToMap(), nil
+}
+
+// Copy keeps the tags of src, S3 copies them unless told otherwise
+func (s *Storage) Copy(ctx context.Context, src, dst string) (err error) {
+	ctx, end := s.call(ctx, "CopyObject", trace.Attr("key", dst), trace.Attr("source", src))
+	defer func() { end(err) }()
+
+	_, err = s.copy(
+		ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: src},
+	)
+	return err
+}
+
+// call traces a single request to the bucket, end is called with how it
+// went. An object that isn't there is an answer, not a failure.
+func (s *Storage) call(ctx context.Context, operation string, attributes ...trace.Attribute) (context.Context, func(error)) {
+	ctx, span := trace.Start(ctx, "S3."+operation, append([]trace.Attribute{trace.Attr("bucket", s.bucket)}, attributes...)...)
+
+	return ctx, func(err error) {
+		if errors.Is(err, backup.ErrNotFound) {
+			err = nil
+		}
+
+		span.End(err)
+	}
+}
+
+func object(info minio.ObjectInfo) backup.Object {
+	return backup.Object{
+		Key:      info.Key,
+		Size:     info.Size,
+		ETag:     info.ETag,
+		Metadata: metadata(info.UserMetadata),
+		Tags:     objectTags(info.UserTags),
+	}
+}
+
+// objectTags leaves out the empty tags minio decodes from a stat of an
+// object without any
+func objectTags(t map[string]string) map[string]string {
+	if len(t) == 0 {
+		return nil
+	}
+
+	return t
+}
+
+// metadata lower cases the keys of user metadata no matter whether it came
+// from a listing, which keeps the 'X-Amz-Meta-' prefix, or a stat, which
+// strips it
+func metadata(meta map[string]string) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[strings.TrimPrefix(strings.ToLower(k), "x-amz-meta-")] = v
+	}
+
+	return m
+}
@@ -0,0 +1,388 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
+)
+
+func TestStorageTestSuite(t *testing.T) {
+	suite.Run(t, new(StorageTestSuite))
+}
+
+type StorageTestSuite struct {
+	suite.Suite
+	bucket string
+
+	listFunc   func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	statFunc   func(context.Context, string, string, minio.StatObjectOptions) (minio.ObjectInfo, error)
+	tagsFunc   func(context.Context, string, string, minio.GetObjectTaggingOptions) (*tags.Tags, error)
+	getFunc    func(context.Context, string, string) (io.ReadCloser, error)
+	putFunc    func(context.Context, string, string, io.Reader, int64, minio.PutObjectOptions) (minio.UploadInfo, error)
+	removeFunc func(context.Context, string, string, minio.RemoveObjectOptions) error
+	copyFunc   func(context.Context, minio.CopyDestOptions, ...minio.CopySrcOptions) (minio.UploadInfo, error)
+}
+
+func (s *StorageTestSuite) SetupTest() {
+	s.bucket = "testBucket"
+
+	s.listFunc = func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+		objectCh := make(chan minio.ObjectInfo)
+		close(objectCh)
+		return objectCh
+	}
+
+	s.statFunc = func(context.Context, string, string, minio.StatObjectOptions) (minio.ObjectInfo, error) {
+		return minio.ObjectInfo{}, nil
+	}
+	s.tagsFunc = func(context.Context, string, string, minio.GetObjectTaggingOptions) (*tags.Tags, error) {
+		return tags.NewTags(nil, true)
+	}
+	s.getFunc = func(context.Context, string, string) (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	s.putFunc = func(context.Context, string, string, io.Reader, int64, minio.PutObjectOptions) (minio.UploadInfo, error) {
+		return minio.UploadInfo{}, nil
+	}
+	s.removeFunc = func(context.Context, string, string, minio.RemoveObjectOptions) error { return nil }
+	s.copyFunc = func(context.Context, minio.CopyDestOptions, ...minio.CopySrcOptions) (minio.UploadInfo, error) {
+		return minio.UploadInfo{}, nil
+	}
+}
+
+func (s *StorageTestSuite) storage() Storage {
+	storage, err := NewStorage(s.bucket, s.listFunc, s.statFunc, s.tagsFunc, s.getFunc, s.putFunc, s.removeFunc, s.copyFunc)
+	s.Require().NoError(err)
+	return storage
+}
+
+func (s *StorageTestSuite) listOf(objects ...minio.ObjectInfo) {
+	s.listFunc = func(context.Context, string, minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+		objectCh := make(chan minio.ObjectInfo, len(objects))
+		defer close(objectCh)
+
+		for _, o := range objects {
+			objectCh <- o
+		}
+
+		return objectCh
+	}
+}
+
+func (s *StorageTestSuite) list(storage Storage, prefix string, opts backup.ListOptions) ([]backup.Object, error) {
+	out := make(chan backup.Object, 10)
+	err := storage.List(context.Background(), prefix, opts, out)
+	close(out)
+
+	objects := make([]backup.Object, 0)
+	for o := range out {
+		objects = append(objects, o)
+	}
+
+	return objects, err
+}
+
+func (s *StorageTestSuite) Test_NewStorage_ErrorBlankBucketName() {
+	_, err := NewStorage("", s.listFunc, s.statFunc, s.tagsFunc, s.getFunc, s.putFunc, s.removeFunc, s.copyFunc)
+	s.Equal(errors.New("'NewStorage' error: bucket cannot be missing"), err)
+}
+
+func (s *StorageTestSuite) Test_List_ListsPrefixRecursively() {
+	called := false
+	s.listFunc = func(_ context.Context, bucket string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+		s.Equal(s.bucket, bucket)
+		s.Equal(minio.ListObjectsOptions{Prefix: "/home/user/", Recursive: true, WithMetadata: true}, opts)
+		called = true
+
+		objectCh := make(chan minio.ObjectInfo)
+		close(objectCh)
+		return objectCh
+	}
+
+	_, err := s.list(s.storage(), "/home/user/", backup.ListOptions{Metadata: true})
+
+	s.Require().NoError(err)
+	s.True(called)
+}
+
+func (s *StorageTestSuite) Test_List_SendsObjects() {
+	s.listOf(
+		minio.ObjectInfo{Key: "test1", Size: 100, ETag: "etag"},
+		minio.ObjectInfo{Key: "test2", Size: 500, UserMetadata: map[string]string{"X-Amz-Meta-Size": "5"}},
+		minio.ObjectInfo{Key: "test3", Size: 5, UserTags: map[string]string{"backup-host": "laptop"}},
+	)
+
+	objects, err := s.list(s.storage(), "", backup.ListOptions{})
+
+	s.Require().NoError(err)
+	s.Equal([]backup.Object{
+		{Key: "test1", Size: 100, ETag: "etag"},
+		{Key: "test2", Size: 500, Metadata: map[string]string{"size": "5"}},
+		{Key: "test3", Size: 5, Tags: map[string]string{"backup-host": "laptop"}},
+	}, objects)
+}
+
+func (s *StorageTestSuite) Test_List_AsksForMetadataToGetTags() {
+	s.listFunc = func(_ context.Context, _ string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+		s.True(opts.WithMetadata)
+
+		objectCh := make(chan minio.ObjectInfo)
+		close(objectCh)
+		return objectCh
+	}
+
+	_, err := s.list(s.storage(), "", backup.ListOptions{Tags: true})
+	s.Require().NoError(err)
+}
+
+func (s *StorageTestSuite) Test_List_ReturnsErrorForFailedObjects() {
+	expectedErr := errors.New("asplode")
+	s.listOf(minio.ObjectInfo{Err: expectedErr})
+
+	_, err := s.list(s.storage(), "", backup.ListOptions{})
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *StorageTestSuite) Test_List_StopsWhenCancelled() {
+	s.listOf(minio.ObjectInfo{Key: "test", Size: 100})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	storage := s.storage()
+	err := storage.List(ctx, "", backup.ListOptions{}, make(chan backup.Object))
+
+	s.Equal(context.Canceled, err)
+}
+
+func (s *StorageTestSuite) Test_Put_Happy() {
+	called := false
+	s.putFunc = func(_ context.Context, bucket, key string, r io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+		s.Equal(s.bucket, bucket)
+		s.Equal("/tmp/test", key)
+		s.Equal(int64(5), size)
+		s.Equal(minio.PutObjectOptions{
+			ContentType:  "text/plain",
+			UserMetadata: map[string]string{"size": "5"},
+			StorageClass: "GLACIER",
+			UserTags:     map[string]string{"backup-host": "laptop"},
+		}, opts)
+
+		called = true
+		return minio.UploadInfo{}, nil
+	}
+
+	storage := s.storage()
+	err := storage.Put(context.Background(), "/tmp/test", strings.NewReader("hello"), 5, backup.PutOptions{
+		ContentType:  "text/plain",
+		Metadata:     map[string]string{"size": "5"},
+		StorageClass: "GLACIER",
+		Tags:         map[string]string{"backup-host": "laptop"},
+	})
+
+	s.Require().NoError(err)
+	s.True(called)
+}
+
+func (s *StorageTestSuite) Test_Put_LimitsPartSizeForUnknownSize() {
+	s.putFunc = func(_ context.Context, _, _ string, _ io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+		s.Equal(int64(-1), size)
+		s.Equal(uint64(unknownSizePartSize), opts.PartSize)
+		return minio.UploadInfo{}, nil
+	}
+
+	storage := s.storage()
+	s.Require().NoError(storage.Put(context.Background(), "pack", strings.NewReader("hello"), -1, backup.PutOptions{}))
+}
+
+func (s *StorageTestSuite) Test_Put_ReturnsErrorOnFailure() {
+	expectedErr := errors.New("asplode")
+	s.putFunc = func(context.Context, string, string, io.Reader, int64, minio.PutObjectOptions) (minio.UploadInfo, error) {
+		return minio.UploadInfo{}, expectedErr
+	}
+
+	storage := s.storage()
+	err := storage.Put(context.Background(), "/tmp/test", strings.NewReader(""), 0, backup.PutOptions{})
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *StorageTestSuite) Test_Get_Happy() {
+	s.getFunc = func(_ context.Context, bucket, key string) (io.ReadCloser, error) {
+		s.Equal(s.bucket, bucket)
+		s.Equal("/tmp/test", key)
+		return ioutil.NopCloser(strings.NewReader("hello")), nil
+	}
+
+	storage := s.storage()
+	r, err := storage.Get(context.Background(), "/tmp/test")
+	s.Require().NoError(err)
+
+	body, err := ioutil.ReadAll(r)
+	s.Require().NoError(err)
+	s.Equal("hello", string(body))
+}
+
+func (s *StorageTestSuite) Test_Remove_Happy() {
+	called := false
+	s.removeFunc = func(_ context.Context, bucket, key string, _ minio.RemoveObjectOptions) error {
+		s.Equal(s.bucket, bucket)
+		s.Equal("test", key)
+		called = true
+		return nil
+	}
+
+	storage := s.storage()
+	s.Require().NoError(storage.Remove(context.Background(), "test"))
+	s.True(called)
+}
+
+func (s *StorageTestSuite) Test_Stat_Happy() {
+	s.statFunc = func(_ context.Context, bucket, key string, _ minio.StatObjectOptions) (minio.ObjectInfo, error) {
+		s.Equal(s.bucket, bucket)
+		return minio.ObjectInfo{Key: key, Size: 5, UserMetadata: map[string]string{"Blob": "abc"}}, nil
+	}
+
+	storage := s.storage()
+	object, err := storage.Stat(context.Background(), "test")
+
+	s.Require().NoError(err)
+	s.Equal(backup.Object{Key: "test", Size: 5, Metadata: map[string]string{"blob": "abc"}}, object)
+}
+
+func (s *StorageTestSuite) Test_Stat_ReturnsNotFoundForMissingKey() {
+	s.statFunc = func(context.Context, string, string, minio.StatObjectOptions) (minio.ObjectInfo, error) {
+		return minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey"}
+	}
+
+	storage := s.storage()
+	_, err := storage.Stat(context.Background(), "test")
+
+	s.Equal(backup.ErrNotFound, err)
+}
+
+func (s *StorageTestSuite) Test_Stat_ReturnsOtherErrors() {
+	expectedErr := errors.New("asplode")
+	s.statFunc = func(context.Context, string, string, minio.StatObjectOptions) (minio.ObjectInfo, error) {
+		return minio.ObjectInfo{}, expectedErr
+	}
+
+	storage := s.storage()
+	_, err := storage.Stat(context.Background(), "test")
+
+	s.Equal(expectedErr, err)
+}
+
+func (s *StorageTestSuite) Test_Tags_Happy() {
+	s.tagsFunc = func(_ context.Context, bucket, key string, _ minio.GetObjectTaggingOptions) (*tags.Tags, error) {
+		s.Equal(s.bucket, bucket)
+		s.Equal("test", key)
+		return tags.NewTags(map[string]string{"backup-host": "laptop"}, true)
+	}
+
+	storage := s.storage()
+	t, err := storage.Tags(context.Background(), "test")
+
+	s.Require().NoError(err)
+	s.Equal(map[string]string{"backup-host": "laptop"}, t)
+}
+
+func (s *StorageTestSuite) Test_Tags_Errors() {
+	s.tagsFunc = func(context.Context, string, string, minio.GetObjectTaggingOptions) (*tags.Tags, error) {
+		return nil, minio.ErrorResponse{Code: "NoSuchKey"}
+	}
+
+	storage := s.storage()
+	_, err := storage.Tags(context.Background(), "test")
+	s.Equal(backup.ErrNotFound, err)
+
+	expectedErr := errors.New("asplode")
+	s.tagsFunc = func(context.Context, string, string, minio.GetObjectTaggingOptions) (*tags.Tags, error) {
+		return nil, expectedErr
+	}
+
+	storage = s.storage()
+	_, err = storage.Tags(context.Background(), "test")
+	s.Equal(expectedErr, err)
+}
+
+func (s *StorageTestSuite) Test_Copy_Happy() {
+	called := false
+	s.copyFunc = func(_ context.Context, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) (minio.UploadInfo, error) {
+		s.Equal(minio.CopyDestOptions{Bucket: s.bucket, Object: "/tmp/new"}, dst)
+		s.Equal([]minio.CopySrcOptions{{Bucket: s.bucket, Object: "/tmp/old"}}, srcs)
+
+		called = true
+		return minio.UploadInfo{}, nil
+	}
+
+	storage := s.storage()
+	s.Require().NoError(storage.Copy(context.Background(), "/tmp/old", "/tmp/new"))
+	s.True(called)
+}
+
+func (s *StorageTestSuite) Test_Copy_ReturnsErrorOnFailure() {
+	expectedErr := errors.New("asplode")
+	s.copyFunc = func(context.Context, minio.CopyDestOptions, ...minio.CopySrcOptions) (minio.UploadInfo, error) {
+		return minio.UploadInfo{}, expectedErr
+	}
+
+	storage := s.storage()
+	s.Equal(expectedErr, storage.Copy(context.Background(), "/tmp/old", "/tmp/new"))
+}
+
+func (s *StorageTestSuite) Test_TracesEveryCall() {
+	s.statFunc = func(_ context.Context, _, key string, _ minio.StatObjectOptions) (minio.ObjectInfo, error) {
+		if key == "missing" {
+			return minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey"}
+		}
+		return minio.ObjectInfo{}, errors.New("asplode")
+	}
+
+	exported := &exportedTraces{}
+	tracer := trace.NewTracer(nil, exported)
+	ctx, root := tracer.Start(context.Background(), "push")
+
+	storage := s.storage()
+	storage.Put(ctx, "/tmp/file", strings.NewReader("body"), 4, backup.PutOptions{})
+	storage.Stat(ctx, "missing")
+	storage.Stat(ctx, "broken")
+
+	root.End(nil)
+	s.Empty(tracer.Export(context.Background()))
+
+	s.Require().Len(exported.requests, 1)
+	request := exported.requests[0]
+
+	s.Contains(request, `"name":"S3.PutObject","kind":1`)
+	s.Contains(request, `{"key":"bucket","value":{"stringValue":"testBucket"}},{"key":"key","value":{"stringValue":"/tmp/file"}},{"key":"bytes","value":{"intValue":"4"}}`)
+
+	// Only the stat that failed failed, a missing key is an answer
+	s.Equal(2, strings.Count(request, `"name":"S3.HeadObject"`))
+	s.Equal(1, strings.Count(request, `"status":{"code":2,"message":"asplode"}`))
+}
+
+// exportedTraces keeps every request that a tracer exports
+type exportedTraces struct {
+	requests []string
+}
+
+func (e *exportedTraces) Export(_ context.Context, request []byte) error {
+	e.requests = append(e.requests, string(request))
+	return nil
+}
+
+func (e *exportedTraces) String() string {
+	return "exported traces"
+}
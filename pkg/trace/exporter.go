@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Exporter sends an OTLP/JSON export request somewhere
+type Exporter interface {
+	Export(ctx context.Context, request []byte) error
+	String() string
+}
+
+// File appends every request to a file, a line each. That is what the
+// OpenTelemetry collector's file exporter writes and its otlpjsonfile
+// receiver reads.
+type File struct {
+	path string
+}
+
+func NewFile(path string) File {
+	return File{path: path}
+}
+
+func (f File) Export(_ context.Context, request []byte) error {
+	out, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(append(request, '\n'))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (f File) String() string {
+	return fmt.Sprintf("trace file '%s'", f.path)
+}
+
+// HTTP POSTs every request to an OTLP/HTTP endpoint, like a collector or
+// Jaeger, as JSON
+type HTTP struct {
+	url *url.URL
+}
+
+// NewHTTP sends to /v1/traces on endpoint unless it has a path of its own
+func NewHTTP(endpoint string) (HTTP, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return HTTP{}, fmt.Errorf("'NewHTTP' error: '%s' is not an http or https URL", endpoint)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	return HTTP{url: u}, nil
+}
+
+func (h HTTP) Export(ctx context.Context, request []byte) error {
+	// The URL was checked when the exporter was made
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, h.url.String(), bytes.NewReader(request))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The client has the URL in its errors, it is already in String
+		var urlErr *url.Error
+		errors.As(err, &urlErr)
+
+		return fmt.Errorf("'Export' error: %s", urlErr.Err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("'Export' error: answered '%s'", resp.Status)
+	}
+
+	return nil
+}
+
+// String leaves out any password in the URL
+func (h HTTP) String() string {
+	return fmt.Sprintf("OTLP endpoint '%s'", h.url.Redacted())
+}
@@ -0,0 +1,145 @@
+package trace
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// scopeName is the instrumentation scope that every span is exported with
+const scopeName = "github.com/ppeble/s3-personal-backup"
+
+// The span kind and status codes of OTLP, every span here is internal
+const (
+	kindInternal = 1
+	statusError  = 2
+)
+
+// The OTLP/JSON encoding of an export request. IDs are hex and 64 bit
+// integers are strings, like the OTLP spec has it for JSON.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanJSON `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanJSON struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            status     `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// encode is a single export request with spans from resource
+func encode(res []Attribute, spans []*Span) []byte {
+	encoded := make([]spanJSON, len(spans))
+	for i, s := range spans {
+		encoded[i] = spanJSON{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              kindInternal,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        keyValues(s.attributes),
+		}
+
+		if s.parentID != [8]byte{} {
+			encoded[i].ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+
+		if s.err != nil {
+			encoded[i].Status = status{Code: statusError, Message: s.err.Error()}
+		}
+	}
+
+	// Nothing in here fails to marshal, every value is one of a few types
+	request, _ := json.Marshal(exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: keyValues(res)},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName},
+				Spans: encoded,
+			}},
+		}},
+	})
+
+	return request
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func keyValues(attributes []Attribute) []keyValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	kvs := make([]keyValue, len(attributes))
+	for i, a := range attributes {
+		kvs[i] = keyValue{Key: a.Key, Value: value(a.Value)}
+	}
+
+	return kvs
+}
+
+func value(v interface{}) anyValue {
+	switch v := v.(type) {
+	case string:
+		return anyValue{StringValue: &v}
+	case bool:
+		return anyValue{BoolValue: &v}
+	case int:
+		i := strconv.Itoa(v)
+		return anyValue{IntValue: &i}
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		return anyValue{IntValue: &i}
+	case float64:
+		return anyValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return anyValue{StringValue: &s}
+	}
+}
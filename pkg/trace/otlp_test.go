@@ -0,0 +1,48 @@
+package trace
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Encode_Values(t *testing.T) {
+	s := &Span{
+		name:  "push",
+		start: time.Unix(1, 0),
+		end:   time.Unix(2, 0),
+		attributes: []Attribute{
+			Attr("file", "/home/a"),
+			Attr("deduplicated", true),
+			Attr("members", 3),
+			Attr("bytes", int64(100)),
+			Attr("ratio", 0.5),
+			Attr("took", time.Second),
+		},
+	}
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal(encode(nil, []*Span{s}), &got))
+
+	span := got["resourceSpans"].([]interface{})[0].(map[string]interface{})["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+
+	assert.Equal(t, "1000000000", span["startTimeUnixNano"])
+	assert.Equal(t, "2000000000", span["endTimeUnixNano"])
+	assert.NotContains(t, span, "parentSpanId")
+
+	values := make([]interface{}, 0)
+	for _, a := range span["attributes"].([]interface{}) {
+		values = append(values, a.(map[string]interface{})["value"])
+	}
+
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"stringValue": "/home/a"},
+		map[string]interface{}{"boolValue": true},
+		map[string]interface{}{"intValue": "3"},
+		map[string]interface{}{"intValue": "100"},
+		map[string]interface{}{"doubleValue": 0.5},
+		map[string]interface{}{"stringValue": "1s"},
+	}, values)
+}
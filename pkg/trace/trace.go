@@ -0,0 +1,232 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// batchSize is how many spans go into a single export request, a run with
+// many files has a span for each of them
+const batchSize = 1000
+
+// batchTimeout is how long a batch that is exported while the run is still
+// going gets
+const batchTimeout = 30 * time.Second
+
+// Attribute is a key and value that a span is about, values are strings,
+// bools, integers or floats, anything else is exported as text
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Attr is the attribute key with value
+func Attr(key string, value interface{}) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer keeps the spans of a run as they end and exports them a batch at a
+// time, in the background while the run is going and whatever is left once
+// it is done. A nil Tracer traces nothing.
+type Tracer struct {
+	resource  []Attribute
+	exporters []Exporter
+
+	lock    sync.Mutex
+	ended   []*Span
+	pending sync.WaitGroup
+
+	// exporting is held while a batch is being sent. failed is which of the
+	// exporters gave up, errs is why.
+	exporting sync.Mutex
+	failed    []bool
+	errs      []error
+
+	batch int
+	ids   io.Reader
+	now   func() time.Time
+}
+
+// NewTracer has every span it exports come from resource, like the host
+// the run was on
+func NewTracer(resource []Attribute, exporters ...Exporter) *Tracer {
+	return &Tracer{
+		resource:  resource,
+		exporters: exporters,
+		failed:    make([]bool, len(exporters)),
+		batch:     batchSize,
+		ids:       rand.Reader,
+		now:       time.Now,
+	}
+}
+
+// Span is a part of a run that took a while, from Start to End. A nil Span
+// is one that isn't traced, all of its methods do nothing.
+type Span struct {
+	tracer *Tracer
+
+	traceID      [16]byte
+	id, parentID [8]byte
+
+	name       string
+	start, end time.Time
+	attributes []Attribute
+	err        error
+}
+
+type spanKey struct{}
+
+// Start starts a new trace with a span called name, it is in the context
+// that is returned
+func (t *Tracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := t.span(name, attributes)
+	t.read(s.traceID[:])
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Start starts a span called name within the span in ctx, there is nothing
+// to trace if there is none
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		return ctx, nil
+	}
+
+	s := parent.tracer.span(name, attributes)
+	s.traceID = parent.traceID
+	s.parentID = parent.id
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Into carries the span in from over to ctx, for a context that isn't
+// derived from the one that the span was started in
+func Into(ctx, from context.Context) context.Context {
+	s, ok := from.Value(spanKey{}).(*Span)
+	if !ok {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+func (t *Tracer) span(name string, attributes []Attribute) *Span {
+	s := &Span{
+		tracer:     t,
+		name:       name,
+		start:      t.now(),
+		attributes: attributes,
+	}
+	t.read(s.id[:])
+
+	return s
+}
+
+func (t *Tracer) read(id []byte) {
+	// crypto/rand doesn't fail on any system this runs on, and an ID that
+	// is off only makes for a broken trace
+	io.ReadFull(t.ids, id)
+}
+
+// SetAttributes adds to the attributes of s, it is only safe to call from
+// the goroutine that started s
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// End ends s, it failed if err is set. A span is only exported once it has
+// ended and it can only end once. The span that fills a batch has the batch
+// exported in the background.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.end = s.tracer.now()
+	s.err = err
+
+	t := s.tracer
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.ended = append(t.ended, s)
+	if len(t.ended) < t.batch {
+		return
+	}
+
+	batch := t.ended
+	t.ended = nil
+
+	t.pending.Add(1)
+	go func() {
+		defer t.pending.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+		defer cancel()
+
+		t.send(ctx, batch)
+	}()
+}
+
+// Export waits for the batches that are already on their way and sends
+// every span that has ended since to each of the exporters. Every exporter
+// is tried, the errors are those of the ones that failed since the last
+// export.
+func (t *Tracer) Export(ctx context.Context) []error {
+	if t == nil {
+		return nil
+	}
+
+	t.pending.Wait()
+
+	t.lock.Lock()
+	spans := t.ended
+	t.ended = nil
+	t.lock.Unlock()
+
+	if len(spans) > 0 {
+		t.send(ctx, spans)
+	}
+
+	t.exporting.Lock()
+	defer t.exporting.Unlock()
+
+	errs := t.errs
+	t.errs = nil
+
+	return errs
+}
+
+// send exports spans as a single request. An exporter that fails is given
+// up on for the rest of the run.
+func (t *Tracer) send(ctx context.Context, spans []*Span) {
+	request := encode(t.resource, spans)
+
+	t.exporting.Lock()
+	defer t.exporting.Unlock()
+
+	for i, e := range t.exporters {
+		if t.failed[i] {
+			continue
+		}
+
+		if err := e.Export(ctx, request); err != nil {
+			t.failed[i] = true
+			t.errs = append(t.errs, fmt.Errorf("%s: %s", e, err))
+		}
+	}
+}
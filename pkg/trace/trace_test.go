@@ -0,0 +1,183 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder keeps every request it is given, or fails with err
+type recorder struct {
+	requests []exportRequest
+	err      error
+}
+
+func (r *recorder) Export(_ context.Context, request []byte) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	var decoded exportRequest
+	if err := json.Unmarshal(request, &decoded); err != nil {
+		return err
+	}
+
+	r.requests = append(r.requests, decoded)
+	return nil
+}
+
+func (r *recorder) String() string {
+	return "recorder"
+}
+
+// testTracer has IDs that count up from 1 and a clock that moves on a
+// second every time it is read
+func testTracer(exporters ...Exporter) *Tracer {
+	t := NewTracer([]Attribute{Attr("service.name", "s3-personal-backup")}, exporters...)
+
+	ids := make([]byte, 0, 256)
+	for i := 1; i <= 256; i++ {
+		ids = append(ids, byte(i))
+	}
+	t.ids = bytes.NewReader(ids)
+
+	now := time.Unix(1591012800, 0)
+	t.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	return t
+}
+
+func Test_Tracer_ExportsSpansWithTheirParents(t *testing.T) {
+	r := &recorder{}
+	tracer := testTracer(r)
+
+	ctx, root := tracer.Start(context.Background(), "backup", Attr("host", "laptop"))
+
+	child, upload := Start(ctx, "push", Attr("file", "/home/a"))
+	upload.SetAttributes(Attr("bytes", int64(100)))
+	upload.End(errors.New("asplode"))
+
+	// A context that isn't derived from ctx still gets its spans in there
+	transfers := Into(context.Background(), child)
+	_, remove := Start(transfers, "remove")
+	remove.End(nil)
+
+	root.End(nil)
+
+	require.Empty(t, tracer.Export(context.Background()))
+	require.Len(t, r.requests, 1)
+
+	rs := r.requests[0].ResourceSpans[0]
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, scopeName, rs.ScopeSpans[0].Scope.Name)
+
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 3)
+
+	// Spans are exported in the order they ended
+	pushed, removed, backedUp := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "backup", backedUp.Name)
+	assert.Equal(t, "090a0b0c0d0e0f101112131415161718", backedUp.TraceID)
+	assert.Equal(t, "0102030405060708", backedUp.SpanID)
+	assert.Empty(t, backedUp.ParentSpanID)
+	assert.Equal(t, "1591012801000000000", backedUp.StartTimeUnixNano)
+	assert.Equal(t, "1591012806000000000", backedUp.EndTimeUnixNano)
+	assert.Equal(t, status{}, backedUp.Status)
+
+	assert.Equal(t, "push", pushed.Name)
+	assert.Equal(t, "191a1b1c1d1e1f20", pushed.SpanID)
+	assert.Equal(t, backedUp.TraceID, pushed.TraceID)
+	assert.Equal(t, backedUp.SpanID, pushed.ParentSpanID)
+	assert.Equal(t, kindInternal, pushed.Kind)
+	assert.Equal(t, status{Code: statusError, Message: "asplode"}, pushed.Status)
+	assert.Equal(t, []string{"file", "bytes"}, []string{pushed.Attributes[0].Key, pushed.Attributes[1].Key})
+
+	assert.Equal(t, "remove", removed.Name)
+	assert.Equal(t, pushed.SpanID, removed.ParentSpanID)
+
+	// Everything was exported, there is nothing left to send next time
+	require.Empty(t, tracer.Export(context.Background()))
+	assert.Len(t, r.requests, 1)
+}
+
+func Test_Tracer_ExportsInBatches(t *testing.T) {
+	r := &recorder{}
+	broken := &recorder{err: errors.New("asplode")}
+
+	tracer := testTracer(broken, r)
+	tracer.batch = 2
+
+	ctx, root := tracer.Start(context.Background(), "backup")
+	for i := 0; i < 2; i++ {
+		_, s := Start(ctx, "push")
+		s.End(nil)
+	}
+	root.End(nil)
+
+	// A failing exporter gives up after its first request, the others
+	// still get everything
+	errs := tracer.Export(context.Background())
+	assert.Equal(t, []error{errors.New("recorder: asplode")}, errs)
+
+	require.Len(t, r.requests, 2)
+	assert.Len(t, r.requests[0].ResourceSpans[0].ScopeSpans[0].Spans, 2)
+	assert.Len(t, r.requests[1].ResourceSpans[0].ScopeSpans[0].Spans, 1)
+}
+
+func Test_Tracer_ExportsFullBatchesRightAway(t *testing.T) {
+	r := &recorder{}
+
+	tracer := testTracer(r)
+	tracer.batch = 2
+
+	ctx, root := tracer.Start(context.Background(), "backup")
+	for i := 0; i < 2; i++ {
+		_, s := Start(ctx, "push")
+		s.End(nil)
+	}
+
+	// The full batch is gone before anything asks for it, only the spans
+	// since are held
+	tracer.pending.Wait()
+	require.Len(t, r.requests, 1)
+	assert.Len(t, r.requests[0].ResourceSpans[0].ScopeSpans[0].Spans, 2)
+	assert.Empty(t, tracer.ended)
+
+	root.End(nil)
+
+	require.Empty(t, tracer.Export(context.Background()))
+	require.Len(t, r.requests, 2)
+	assert.Equal(t, "backup", r.requests[1].ResourceSpans[0].ScopeSpans[0].Spans[0].Name)
+}
+
+func Test_NothingIsTraced(t *testing.T) {
+	var tracer *Tracer
+
+	ctx := context.Background()
+
+	got, root := tracer.Start(ctx, "backup")
+	assert.Equal(t, ctx, got)
+	assert.Nil(t, root)
+
+	got, s := Start(ctx, "push")
+	assert.Equal(t, ctx, got)
+	assert.Nil(t, s)
+
+	assert.Equal(t, ctx, Into(ctx, context.Background()))
+
+	// None of these do anything without a span
+	s.SetAttributes(Attr("file", "/home/a"))
+	s.End(errors.New("asplode"))
+
+	assert.Nil(t, tracer.Export(ctx))
+}
@@ -1,6 +1,8 @@
 package worker
 
 import (
+	"context"
+	"fmt"
 	"sync"
 
 	"github.com/ppeble/s3-personal-backup/pkg/backup"
@@ -20,15 +22,51 @@ func NewDryRunActionWorker(wg *sync.WaitGroup, in <-chan backup.RemoteAction, re
 	}
 }
 
-func (w DryRunActionWorker) Run() {
-	for {
-		action := <-w.in
+// Run works through in until it is closed, once ctx is cancelled whatever
+// is still queued is dropped
+func (w DryRunActionWorker) Run(ctx context.Context) {
+	for action := range w.in {
+		if ctx.Err() != nil {
+			w.wg.Done()
+			continue
+		}
 
-		w.report <- backup.LogEntry{
+		if action.Type == backup.PACK {
+			w.reportPack(action)
+			w.wg.Done()
+			continue
+		}
+
+		entry := backup.LogEntry{
 			File:       action.File.Name,
 			ActionType: action.Type,
 		}
 
+		if action.Type == backup.COPY {
+			entry.Message = fmt.Sprintf("moved from '%s'", action.Source.Name)
+		}
+
+		w.report <- entry
+
 		w.wg.Done()
 	}
 }
+
+// reportPack reports every member of a pack, the report counts files
+func (w DryRunActionWorker) reportPack(action backup.RemoteAction) {
+	for _, m := range action.Pack.Members {
+		w.report <- backup.LogEntry{
+			Message:    fmt.Sprintf("packed into '%s'", action.File.Name),
+			File:       m.Name,
+			ActionType: backup.PUSH,
+		}
+	}
+
+	for _, d := range action.Pack.Dropped {
+		w.report <- backup.LogEntry{
+			Message:    fmt.Sprintf("removed from pack '%s'", d.Pack),
+			File:       d.Name,
+			ActionType: backup.REMOVE,
+		}
+	}
+}
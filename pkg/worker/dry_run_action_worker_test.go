@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -49,7 +50,7 @@ func (s DryRunActionWorkerTestSuite) worker() DryRunActionWorker {
 }
 
 func (s *DryRunActionWorkerTestSuite) Test_Run_SendsToReportChannel() {
-	go s.worker().Run()
+	go s.worker().Run(context.Background())
 
 	s.wg.Add(1)
 	s.input <- backup.RemoteAction{
@@ -67,3 +68,86 @@ func (s *DryRunActionWorkerTestSuite) Test_Run_SendsToReportChannel() {
 		s.reportMsg,
 	)
 }
+
+func (s *DryRunActionWorkerTestSuite) Test_Run_ReportsWhereCopiesComeFrom() {
+	go s.worker().Run(context.Background())
+
+	s.wg.Add(1)
+	s.input <- backup.RemoteAction{
+		Type:   backup.COPY,
+		File:   s.file,
+		Source: backup.File{Name: "old", Size: 100},
+	}
+
+	s.wg.Wait()
+
+	s.Equal(
+		backup.LogEntry{
+			Message:    "moved from 'old'",
+			ActionType: backup.COPY,
+			File:       s.file.Name,
+		},
+		s.reportMsg,
+	)
+}
+
+func (s *DryRunActionWorkerTestSuite) Test_Run_ReportsEveryPackMember() {
+	report := make(chan backup.LogEntry, 2)
+	go NewDryRunActionWorker(s.wg, s.input, report).Run(context.Background())
+
+	s.wg.Add(1)
+	s.input <- backup.RemoteAction{
+		Type: backup.PACK,
+		File: backup.File{Name: "_packs/new", Size: 100},
+		Pack: &backup.Pack{
+			Members:  []backup.File{s.file},
+			Replaces: []string{"_packs/old"},
+			Dropped:  []backup.File{{Name: "gone", Size: 5, Pack: "_packs/old"}},
+		},
+	}
+
+	// Release the reader that SetupTest started, it is not used here
+	s.report <- backup.LogEntry{}
+	s.wg.Wait()
+
+	s.Equal(
+		backup.LogEntry{
+			Message:    "packed into '_packs/new'",
+			ActionType: backup.PUSH,
+			File:       s.file.Name,
+		},
+		<-report,
+	)
+
+	s.Equal(
+		backup.LogEntry{
+			Message:    "removed from pack '_packs/old'",
+			ActionType: backup.REMOVE,
+			File:       "gone",
+		},
+		<-report,
+	)
+}
+
+func (s *DryRunActionWorkerTestSuite) Test_Run_DropsWhatIsQueuedOnceCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.worker().Run(ctx)
+		close(done)
+	}()
+
+	s.wg.Add(1)
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+	close(s.input)
+
+	<-done
+
+	// Release the reader that SetupTest started, nothing was reported
+	s.report <- backup.LogEntry{}
+	s.wg.Wait()
+
+	s.Equal(backup.LogEntry{}, s.reportMsg)
+}
@@ -0,0 +1,32 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// Grace is a context for transfers that is only cancelled grace after ctx
+// is, or once cancel is called. Transfers that are in flight when a run is
+// stopped get that long to finish before they are aborted.
+func Grace(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	transfers, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-transfers.Done():
+			return
+		}
+
+		t := time.NewTimer(grace)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+			cancel()
+		case <-transfers.Done():
+		}
+	}()
+
+	return transfers, cancel
+}
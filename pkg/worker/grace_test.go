@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Grace_OutlastsCancel(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+
+	transfers, cancel := Grace(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	stop()
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, transfers.Err(), "transfers have to get their grace period")
+
+	<-transfers.Done()
+	assert.Equal(t, context.Canceled, transfers.Err())
+}
+
+func Test_Grace_CancelledEarly(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	transfers, cancel := Grace(ctx, time.Hour)
+	cancel()
+
+	<-transfers.Done()
+	assert.Equal(t, context.Canceled, transfers.Err())
+
+	// Cancelling early during the grace period is just as quick
+	ctx, stop = context.WithCancel(context.Background())
+	transfers, cancel = Grace(ctx, time.Hour)
+	stop()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	<-transfers.Done()
+	assert.Equal(t, context.Canceled, transfers.Err())
+}
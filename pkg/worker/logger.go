@@ -5,6 +5,7 @@ import (
 )
 
 type backupLogger interface {
+	Debug(backup.LogEntry)
 	Info(backup.LogEntry)
 	Error(backup.LogEntry)
 }
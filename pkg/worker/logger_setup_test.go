@@ -5,10 +5,17 @@ import (
 )
 
 type testLogger struct {
+	logDebug func(backup.LogEntry)
 	logInfo  func(backup.LogEntry)
 	logError func(backup.LogEntry)
 }
 
+func (l testLogger) Debug(i backup.LogEntry) {
+	if l.logDebug != nil {
+		l.logDebug(i)
+	}
+}
+
 func (l testLogger) Info(i backup.LogEntry) {
 	l.logInfo(i)
 }
@@ -1,10 +1,14 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
 )
 
 type RemoteActionWorker struct {
@@ -12,12 +16,21 @@ type RemoteActionWorker struct {
 	in     <-chan backup.RemoteAction
 	logger backupLogger
 
-	putToRemote      func(string) error
-	removeFromRemote func(string) error
+	putToRemote      func(context.Context, string) (backup.PutResult, error)
+	removeFromRemote func(context.Context, string) error
+	copyOnRemote     func(context.Context, string, string) error
+	packOnRemote     func(context.Context, backup.File, []backup.File) error
+	removePack       func(context.Context, string) error
+
+	now func() time.Time
 }
 
 func NewRemoteActionWorker(
-	putToRemote, removeFromRemote func(string) error,
+	putToRemote func(context.Context, string) (backup.PutResult, error),
+	removeFromRemote func(context.Context, string) error,
+	copyOnRemote func(context.Context, string, string) error,
+	packOnRemote func(context.Context, backup.File, []backup.File) error,
+	removePack func(context.Context, string) error,
 	wg *sync.WaitGroup,
 	in <-chan backup.RemoteAction,
 	log backupLogger,
@@ -25,61 +38,257 @@ func NewRemoteActionWorker(
 	return RemoteActionWorker{
 		putToRemote:      putToRemote,
 		removeFromRemote: removeFromRemote,
+		copyOnRemote:     copyOnRemote,
+		packOnRemote:     packOnRemote,
+		removePack:       removePack,
 		wg:               wg,
 		in:               in,
 		logger:           log,
+		now:              time.Now,
 	}
 }
 
-func (w RemoteActionWorker) Run() {
-	for {
-		action := <-w.in
+// Run works through in until it is closed. Once ctx is cancelled whatever
+// is still queued is dropped, what is already in flight runs with transfers
+// so that it can be given a while longer to finish.
+func (w RemoteActionWorker) Run(ctx, transfers context.Context) {
+	for action := range w.in {
+		if ctx.Err() != nil {
+			w.wg.Done()
+			continue
+		}
 
 		switch action.Type {
 		case backup.PUSH:
-			w.push(action.File)
+			w.push(transfers, action.File)
 		case backup.REMOVE:
-			w.remove(action.File)
+			w.remove(transfers, action.File)
+		case backup.COPY:
+			w.copy(transfers, action.Source, action.File)
+		case backup.PACK:
+			w.pack(transfers, action.File, action.Pack)
 		}
 	}
 }
 
-func (w RemoteActionWorker) push(file backup.File) {
+func (w RemoteActionWorker) push(ctx context.Context, file backup.File) {
 	defer w.wg.Done()
+	log := w.start(backup.LogEntry{Message: fmt.Sprintf("pushing %s", file), File: file.Name, ActionType: backup.PUSH, Size: file.Size})
+
+	ctx, span := trace.Start(ctx, "push", trace.Attr("file", file.Name), trace.Attr("bytes", file.Size))
+	result, err := w.putToRemote(ctx, file.Name)
+	if errors.Is(err, backup.ErrNotOwned) {
+		// Not a push at all, another host or profile backed it up
+		span.SetAttributes(trace.Attr("skipped", true))
+		span.End(nil)
+		log.Info(backup.LogEntry{
+			Message:    fmt.Sprintf("%s changed locally but left on remote, it is %s", file, err.Error()),
+			File:       file.Name,
+			ActionType: backup.PUSH,
+			Size:       file.Size,
+			Skipped:    true,
+		})
+		return
+	}
+
+	span.SetAttributes(trace.Attr("deduplicated", result.Deduplicated))
+	span.End(err)
 
-	err := w.putToRemote(file.Name)
 	if err != nil {
-		w.logger.Error(backup.LogEntry{
+		log.Error(backup.LogEntry{
 			Message:    fmt.Sprintf("unable to push to remote for file '%s', error: '%s'", file, err.Error()),
 			File:       file.Name,
 			ActionType: backup.PUSH,
+			Size:       file.Size,
+		})
+	} else if result.Deduplicated {
+		log.Info(backup.LogEntry{
+			Message:      fmt.Sprintf("%s already on remote, only its index was pushed", file),
+			File:         file.Name,
+			ActionType:   backup.PUSH,
+			Size:         file.Size,
+			Deduplicated: true,
 		})
 	} else {
-		w.logger.Info(backup.LogEntry{
-			Message:    fmt.Sprintf("%s pushed to remote", file),
-			File:       file.Name,
-			ActionType: backup.PUSH,
+		log.Info(backup.LogEntry{
+			Message:      fmt.Sprintf("%s pushed to remote", file),
+			File:         file.Name,
+			ActionType:   backup.PUSH,
+			Size:         file.Size,
+			StorageClass: result.StorageClass,
 		})
 	}
 }
 
-func (w RemoteActionWorker) remove(file backup.File) {
+func (w RemoteActionWorker) remove(ctx context.Context, file backup.File) {
 	defer w.wg.Done()
+	log := w.start(backup.LogEntry{Message: fmt.Sprintf("removing %s", file), File: file.Name, ActionType: backup.REMOVE, Size: file.Size})
 
-	err := w.removeFromRemote(file.Name)
-	if err != nil {
+	ctx, span := trace.Start(ctx, "remove", trace.Attr("file", file.Name), trace.Attr("bytes", file.Size))
+	err := w.removeFromRemote(ctx, file.Name)
+	if errors.Is(err, backup.ErrNotOwned) {
+		// Not a removal at all, another host or profile backed it up
+		span.SetAttributes(trace.Attr("skipped", true))
+		span.End(nil)
+		log.Info(backup.LogEntry{
+			Message:    fmt.Sprintf("%s not found locally but left on remote, it is %s", file, err.Error()),
+			File:       file.Name,
+			ActionType: backup.REMOVE,
+			Size:       file.Size,
+			Skipped:    true,
+		})
+	} else if err != nil {
+		span.End(err)
 		entry := backup.LogEntry{
 			Message:    fmt.Sprintf("%s not found locally but unable to remove from remote, error: '%s'", file, err.Error()),
 			File:       file.Name,
 			ActionType: backup.REMOVE,
+			Size:       file.Size,
 		}
-		w.logger.Error(entry)
+		log.Error(entry)
 	} else {
+		span.End(nil)
 		entry := backup.LogEntry{
 			Message:    fmt.Sprintf("%s not found locally, removing from remote", file),
 			File:       file.Name,
 			ActionType: backup.REMOVE,
+			Size:       file.Size,
+		}
+		log.Info(entry)
+	}
+}
+
+// copy is how a moved file ends up at its new key, the old key is only
+// removed once the copy has succeeded
+func (w RemoteActionWorker) copy(ctx context.Context, src, dst backup.File) {
+	defer w.wg.Done()
+	log := w.start(backup.LogEntry{Message: fmt.Sprintf("moving '%s' to %s", src.Name, dst), File: dst.Name, ActionType: backup.COPY, Size: dst.Size})
+
+	ctx, span := trace.Start(ctx, "copy", trace.Attr("file", dst.Name), trace.Attr("source", src.Name), trace.Attr("bytes", dst.Size))
+
+	err := w.copyOnRemote(ctx, src.Name, dst.Name)
+	if err != nil {
+		span.End(err)
+		log.Error(backup.LogEntry{
+			Message:    fmt.Sprintf("unable to copy '%s' to %s on remote, error: '%s'", src.Name, dst, err.Error()),
+			File:       dst.Name,
+			ActionType: backup.COPY,
+			Size:       dst.Size,
+		})
+		return
+	}
+
+	err = w.removeFromRemote(ctx, src.Name)
+	span.End(err)
+
+	if err != nil {
+		log.Error(backup.LogEntry{
+			Message:    fmt.Sprintf("%s copied from '%s' on remote but unable to remove the old copy, error: '%s'", dst, src.Name, err.Error()),
+			File:       dst.Name,
+			ActionType: backup.COPY,
+			Size:       dst.Size,
+		})
+		return
+	}
+
+	log.Info(backup.LogEntry{
+		Message:    fmt.Sprintf("%s moved on remote from '%s'", dst, src.Name),
+		File:       dst.Name,
+		ActionType: backup.COPY,
+	})
+}
+
+// pack uploads a new pack and only then removes the packs it replaces, so
+// that its members are on the remote the whole time. Every member is logged
+// on its own so that the report counts files, not packs.
+func (w RemoteActionWorker) pack(ctx context.Context, file backup.File, pack *backup.Pack) {
+	defer w.wg.Done()
+	log := w.start(backup.LogEntry{Message: fmt.Sprintf("packing %d files into '%s'", len(pack.Members), file.Name), File: file.Name, ActionType: backup.PACK, Size: file.Size})
+
+	ctx, span := trace.Start(ctx, "pack",
+		trace.Attr("file", file.Name),
+		trace.Attr("members", len(pack.Members)),
+		trace.Attr("replaces", len(pack.Replaces)),
+	)
+
+	if len(pack.Members) > 0 {
+		err := w.packOnRemote(ctx, file, pack.Members)
+		if err != nil {
+			span.End(err)
+			for _, m := range pack.Members {
+				log.Error(backup.LogEntry{
+					Message:    fmt.Sprintf("unable to pack %s into '%s', error: '%s'", m, file.Name, err.Error()),
+					File:       m.Name,
+					ActionType: backup.PUSH,
+					Size:       m.Size,
+				})
+			}
+			return
 		}
-		w.logger.Info(entry)
 	}
+
+	for _, m := range pack.Members {
+		log.Info(backup.LogEntry{
+			Message:    fmt.Sprintf("%s packed into '%s'", m, file.Name),
+			File:       m.Name,
+			ActionType: backup.PUSH,
+			Size:       m.Size,
+		})
+	}
+
+	for _, old := range pack.Replaces {
+		err := w.removePack(ctx, old)
+		if err != nil {
+			span.End(err)
+			for _, d := range pack.Dropped {
+				log.Error(backup.LogEntry{
+					Message:    fmt.Sprintf("%s not found locally but unable to remove pack '%s', error: '%s'", d, old, err.Error()),
+					File:       d.Name,
+					ActionType: backup.REMOVE,
+					Size:       d.Size,
+				})
+			}
+			return
+		}
+	}
+
+	span.End(nil)
+
+	for _, d := range pack.Dropped {
+		log.Info(backup.LogEntry{
+			Message:    fmt.Sprintf("%s not found locally, removed from pack '%s'", d, d.Pack),
+			File:       d.Name,
+			ActionType: backup.REMOVE,
+			Size:       d.Size,
+		})
+	}
+}
+
+// actionLogger logs for a single action, every entry gets how long the
+// action has taken so far
+type actionLogger struct {
+	logger backupLogger
+	start  time.Time
+	now    func() time.Time
+}
+
+// start logs that an action is starting, at DEBUG
+func (w RemoteActionWorker) start(e backup.LogEntry) actionLogger {
+	w.logger.Debug(e)
+
+	return actionLogger{logger: w.logger, start: w.now(), now: w.now}
+}
+
+func (l actionLogger) Info(e backup.LogEntry) {
+	l.logger.Info(l.timed(e))
+}
+
+func (l actionLogger) Error(e backup.LogEntry) {
+	l.logger.Error(l.timed(e))
+}
+
+func (l actionLogger) timed(e backup.LogEntry) backup.LogEntry {
+	e.Duration = l.now().Sub(l.start)
+	e.Attempt = 1
+	return e
 }
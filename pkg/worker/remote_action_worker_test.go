@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"testing"
@@ -9,6 +10,7 @@ import (
 	"github.com/stretchr/testify/suite"
 
 	"github.com/ppeble/s3-personal-backup/pkg/backup"
+	"github.com/ppeble/s3-personal-backup/pkg/trace"
 )
 
 func TestRemoteActionWorkerTestSuite(t *testing.T) {
@@ -18,9 +20,16 @@ func TestRemoteActionWorkerTestSuite(t *testing.T) {
 type RemoteActionWorkerTestSuite struct {
 	suite.Suite
 
-	putToRemoteCalled, removeFromRemoteCalled bool
+	putToRemoteCalled, removeFromRemoteCalled, copyOnRemoteCalled bool
+	packOnRemoteCalled, removePackCalled                          bool
 
-	putToRemote, removeFromRemote func(string) error
+	putToRemote      func(context.Context, string) (backup.PutResult, error)
+	removeFromRemote func(context.Context, string) error
+	copyOnRemote     func(context.Context, string, string) error
+	packOnRemote     func(context.Context, backup.File, []backup.File) error
+	removePack       func(context.Context, string) error
+
+	logged backup.LogEntry
 
 	logInfoCalled, logErrorCalled bool
 	logger                        testLogger
@@ -39,25 +48,49 @@ func (s *RemoteActionWorkerTestSuite) SetupTest() {
 
 	s.putToRemoteCalled = false
 	s.removeFromRemoteCalled = false
+	s.copyOnRemoteCalled = false
+	s.packOnRemoteCalled = false
+	s.removePackCalled = false
 
-	s.putToRemote = func(f string) error {
+	s.putToRemote = func(_ context.Context, f string) (backup.PutResult, error) {
 		s.putToRemoteCalled = true
 		s.Equal(s.file.Name, f)
-		return nil
+		return backup.PutResult{}, nil
 	}
 
-	s.removeFromRemote = func(f string) error {
+	s.removeFromRemote = func(_ context.Context, f string) error {
 		s.removeFromRemoteCalled = true
 		s.Equal(s.file.Name, f)
 		return nil
 	}
 
+	s.copyOnRemote = func(_ context.Context, src, dst string) error {
+		s.copyOnRemoteCalled = true
+		s.Equal(s.file.Name, src)
+		s.Equal("test2", dst)
+		return nil
+	}
+
+	s.packOnRemote = func(_ context.Context, pack backup.File, members []backup.File) error {
+		s.packOnRemoteCalled = true
+		s.Equal("_packs/new", pack.Name)
+		s.Equal([]backup.File{s.file}, members)
+		return nil
+	}
+
+	s.removePack = func(_ context.Context, pack string) error {
+		s.removePackCalled = true
+		s.Equal("_packs/old", pack)
+		return nil
+	}
+
 	s.logInfoCalled = false
 	s.logErrorCalled = false
 
 	s.logger = testLogger{
 		logInfo: func(i backup.LogEntry) {
 			s.logInfoCalled = true
+			s.logged = i
 		},
 		logError: func(i backup.LogEntry) {
 			s.logErrorCalled = true
@@ -70,27 +103,75 @@ func (s *RemoteActionWorkerTestSuite) SetupTest() {
 	s.wg.Add(1)
 }
 
+// worker is a worker whose clock moves on a second every time it is read
 func (s RemoteActionWorkerTestSuite) worker() RemoteActionWorker {
-	return NewRemoteActionWorker(s.putToRemote, s.removeFromRemote, s.wg, s.input, s.logger)
+	w := NewRemoteActionWorker(s.putToRemote, s.removeFromRemote, s.copyOnRemote, s.packOnRemote, s.removePack, s.wg, s.input, s.logger)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	w.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	return w
 }
 
 func (s *RemoteActionWorkerTestSuite) Test_Run_HandlePush() {
-	go s.worker().Run()
+	go s.worker().Run(context.Background(), context.Background())
 
 	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
 
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
 	s.True(s.putToRemoteCalled)
 	s.False(s.removeFromRemoteCalled)
 	s.True(s.logInfoCalled)
 }
 
+func (s *RemoteActionWorkerTestSuite) Test_Run_Push_ReportsDeduplicatedContent() {
+	s.putToRemote = func(_ context.Context, f string) (backup.PutResult, error) {
+		s.putToRemoteCalled = true
+		return backup.PutResult{Deduplicated: true}, nil
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.putToRemoteCalled)
+	s.True(s.logInfoCalled)
+	s.True(s.logged.Deduplicated)
+	s.Equal(s.file.Size, s.logged.Size)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_Push_ReportsStorageClass() {
+	s.putToRemote = func(_ context.Context, f string) (backup.PutResult, error) {
+		s.putToRemoteCalled = true
+		return backup.PutResult{StorageClass: "GLACIER"}, nil
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.logInfoCalled)
+	s.Equal("GLACIER", s.logged.StorageClass)
+}
+
 func (s *RemoteActionWorkerTestSuite) Test_Run_Push_LogsErrorOnFailure() {
-	s.putToRemote = func(f string) error {
+	s.putToRemote = func(_ context.Context, f string) (backup.PutResult, error) {
 		s.putToRemoteCalled = true
-		return errors.New("asplode")
+		return backup.PutResult{}, errors.New("asplode")
 	}
 
-	go s.worker().Run()
+	go s.worker().Run(context.Background(), context.Background())
 
 	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
 
@@ -103,8 +184,28 @@ func (s *RemoteActionWorkerTestSuite) Test_Run_Push_LogsErrorOnFailure() {
 	s.True(s.logErrorCalled, "Error should be called")
 }
 
+func (s *RemoteActionWorkerTestSuite) Test_Run_Push_LeavesWhatItDoesNotOwn() {
+	s.putToRemote = func(_ context.Context, f string) (backup.PutResult, error) {
+		s.putToRemoteCalled = true
+		return backup.PutResult{}, backup.ErrNotOwned
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.putToRemoteCalled, "putToRemote should be called")
+	s.True(s.logInfoCalled, "Info should be called")
+	s.False(s.logErrorCalled, "Error should not be called")
+	s.Equal(backup.ActionType(backup.PUSH), s.logged.ActionType)
+	s.True(s.logged.Skipped)
+}
+
 func (s *RemoteActionWorkerTestSuite) Test_Run_HandleRemove() {
-	go s.worker().Run()
+	go s.worker().Run(context.Background(), context.Background())
 
 	s.input <- backup.RemoteAction{Type: backup.REMOVE, File: s.file}
 
@@ -117,12 +218,12 @@ func (s *RemoteActionWorkerTestSuite) Test_Run_HandleRemove() {
 }
 
 func (s *RemoteActionWorkerTestSuite) Test_Run_Remove_LogsErrorOnFailure() {
-	s.removeFromRemote = func(f string) error {
+	s.removeFromRemote = func(_ context.Context, f string) error {
 		s.removeFromRemoteCalled = true
 		return errors.New("asplode")
 	}
 
-	go s.worker().Run()
+	go s.worker().Run(context.Background(), context.Background())
 
 	s.input <- backup.RemoteAction{Type: backup.REMOVE, File: s.file}
 
@@ -134,3 +235,326 @@ func (s *RemoteActionWorkerTestSuite) Test_Run_Remove_LogsErrorOnFailure() {
 	s.False(s.logInfoCalled, "Info should not be called")
 	s.True(s.logErrorCalled, "Error should be called")
 }
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_Remove_LeavesWhatItDoesNotOwn() {
+	s.removeFromRemote = func(_ context.Context, f string) error {
+		s.removeFromRemoteCalled = true
+		return backup.ErrNotOwned
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- backup.RemoteAction{Type: backup.REMOVE, File: s.file}
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.removeFromRemoteCalled, "removeFromRemote should be called")
+	s.True(s.logInfoCalled, "Info should be called")
+	s.False(s.logErrorCalled, "Error should not be called")
+	s.Equal(backup.ActionType(backup.REMOVE), s.logged.ActionType)
+	s.True(s.logged.Skipped)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_HandleCopy() {
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- backup.RemoteAction{Type: backup.COPY, File: backup.File{Name: "test2", Size: 100}, Source: s.file}
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.False(s.putToRemoteCalled)
+	s.True(s.copyOnRemoteCalled)
+	s.True(s.removeFromRemoteCalled, "the old copy should be removed")
+	s.True(s.logInfoCalled)
+	s.False(s.logErrorCalled)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_Copy_LogsErrorOnFailure() {
+	s.copyOnRemote = func(_ context.Context, src, dst string) error {
+		s.copyOnRemoteCalled = true
+		return errors.New("asplode")
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- backup.RemoteAction{Type: backup.COPY, File: backup.File{Name: "test2", Size: 100}, Source: s.file}
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.copyOnRemoteCalled, "copyOnRemote should be called")
+	s.False(s.removeFromRemoteCalled, "the old copy must be kept if the copy failed")
+	s.False(s.logInfoCalled, "Info should not be called")
+	s.True(s.logErrorCalled, "Error should be called")
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_Copy_LogsErrorWhenOldCopyIsKept() {
+	s.removeFromRemote = func(_ context.Context, f string) error {
+		s.removeFromRemoteCalled = true
+		return errors.New("asplode")
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- backup.RemoteAction{Type: backup.COPY, File: backup.File{Name: "test2", Size: 100}, Source: s.file}
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.copyOnRemoteCalled, "copyOnRemote should be called")
+	s.True(s.removeFromRemoteCalled, "removeFromRemote should be called")
+	s.False(s.logInfoCalled, "Info should not be called")
+	s.True(s.logErrorCalled, "Error should be called")
+}
+
+func (s *RemoteActionWorkerTestSuite) packAction() backup.RemoteAction {
+	return backup.RemoteAction{
+		Type: backup.PACK,
+		File: backup.File{Name: "_packs/new", Size: 100},
+		Pack: &backup.Pack{
+			Members:  []backup.File{s.file},
+			Replaces: []string{"_packs/old"},
+			Dropped:  []backup.File{{Name: "gone", Size: 5, Pack: "_packs/old"}},
+		},
+	}
+}
+
+// logEntries records everything logged, in order, Info and Error alike
+func (s *RemoteActionWorkerTestSuite) logEntries() *[]backup.LogEntry {
+	entries := make([]backup.LogEntry, 0)
+
+	s.logger = testLogger{
+		logInfo: func(i backup.LogEntry) {
+			s.logInfoCalled = true
+			entries = append(entries, i)
+		},
+		logError: func(i backup.LogEntry) {
+			s.logErrorCalled = true
+			entries = append(entries, i)
+		},
+	}
+
+	return &entries
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_HandlePack() {
+	entries := s.logEntries()
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- s.packAction()
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.packOnRemoteCalled)
+	s.True(s.removePackCalled)
+	s.False(s.logErrorCalled)
+	s.Equal([]backup.LogEntry{
+		{
+			Message:    "name: 'test1' - size: '100' packed into '_packs/new'",
+			File:       "test1",
+			ActionType: backup.PUSH,
+			Size:       100,
+			Duration:   time.Second,
+			Attempt:    1,
+		},
+		{
+			Message:    "name: 'gone' - size: '5' not found locally, removed from pack '_packs/old'",
+			File:       "gone",
+			ActionType: backup.REMOVE,
+			Size:       5,
+			Duration:   2 * time.Second,
+			Attempt:    1,
+		},
+	}, *entries)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_Pack_OnlyRemovesWhenNothingIsLeft() {
+	entries := s.logEntries()
+
+	action := s.packAction()
+	action.Pack.Members = nil
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- action
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.False(s.packOnRemoteCalled)
+	s.True(s.removePackCalled)
+	s.Len(*entries, 1)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_Pack_KeepsOldPackOnFailure() {
+	entries := s.logEntries()
+	s.packOnRemote = func(context.Context, backup.File, []backup.File) error {
+		s.packOnRemoteCalled = true
+		return errors.New("asplode")
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- s.packAction()
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.packOnRemoteCalled)
+	s.False(s.removePackCalled, "the old pack must be kept if packing failed")
+	s.False(s.logInfoCalled)
+	s.Equal([]backup.LogEntry{{
+		Message:    "unable to pack name: 'test1' - size: '100' into '_packs/new', error: 'asplode'",
+		File:       "test1",
+		ActionType: backup.PUSH,
+		Size:       100,
+		Duration:   time.Second,
+		Attempt:    1,
+	}}, *entries)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_Pack_LogsErrorWhenOldPackIsKept() {
+	entries := s.logEntries()
+	s.removePack = func(context.Context, string) error {
+		s.removePackCalled = true
+		return errors.New("asplode")
+	}
+
+	go s.worker().Run(context.Background(), context.Background())
+
+	s.input <- s.packAction()
+
+	// Pretty sure that the worker sometimes loses in a race with the checks below
+	time.Sleep(20 * time.Millisecond)
+
+	s.True(s.packOnRemoteCalled)
+	s.True(s.removePackCalled)
+	s.True(s.logErrorCalled)
+	s.Len(*entries, 2)
+	s.Equal(
+		"name: 'gone' - size: '5' not found locally but unable to remove pack '_packs/old', error: 'asplode'",
+		(*entries)[1].Message,
+	)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_DropsWhatIsQueuedOnceCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.worker().Run(ctx, context.Background())
+		close(done)
+	}()
+
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+	close(s.input)
+
+	<-done
+	s.wg.Wait()
+
+	s.False(s.putToRemoteCalled)
+	s.False(s.logInfoCalled)
+	s.False(s.logErrorCalled)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_TransfersRunWithTheirOwnContext() {
+	type key struct{}
+	transfers := context.WithValue(context.Background(), key{}, "transfers")
+
+	s.putToRemote = func(ctx context.Context, f string) (backup.PutResult, error) {
+		s.putToRemoteCalled = true
+		s.Equal("transfers", ctx.Value(key{}))
+		return backup.PutResult{}, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.worker().Run(context.Background(), transfers)
+		close(done)
+	}()
+
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+	close(s.input)
+
+	<-done
+	s.True(s.putToRemoteCalled)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_LogsStartAtDebug() {
+	var started backup.LogEntry
+	s.logger.logDebug = func(i backup.LogEntry) {
+		started = i
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.worker().Run(context.Background(), context.Background())
+		close(done)
+	}()
+
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+	close(s.input)
+	<-done
+
+	s.Equal(backup.LogEntry{
+		Message:    "pushing name: 'test1' - size: '100'",
+		File:       "test1",
+		ActionType: backup.PUSH,
+		Size:       100,
+	}, started)
+	s.Equal(time.Second, s.logged.Duration)
+	s.Equal(1, s.logged.Attempt)
+}
+
+func (s *RemoteActionWorkerTestSuite) Test_Run_TracesEveryAction() {
+	s.putToRemote = func(context.Context, string) (backup.PutResult, error) {
+		return backup.PutResult{}, errors.New("asplode")
+	}
+	s.removeFromRemote = func(context.Context, string) error {
+		return backup.ErrNotOwned
+	}
+
+	exported := &exportedTraces{}
+	tracer := trace.NewTracer(nil, exported)
+	transfers, root := tracer.Start(context.Background(), "destination")
+
+	done := make(chan struct{})
+	go func() {
+		s.worker().Run(context.Background(), transfers)
+		close(done)
+	}()
+
+	s.wg.Add(1)
+	s.input <- backup.RemoteAction{Type: backup.PUSH, File: s.file}
+	s.input <- backup.RemoteAction{Type: backup.REMOVE, File: s.file}
+	close(s.input)
+	<-done
+
+	root.End(nil)
+	s.Empty(tracer.Export(context.Background()))
+
+	s.Require().Len(exported.requests, 1)
+	s.Contains(exported.requests[0], `"name":"push"`)
+	s.Contains(exported.requests[0], `"status":{"code":2,"message":"asplode"}`)
+	s.Contains(exported.requests[0], `"name":"remove"`)
+	s.Contains(exported.requests[0], `{"key":"skipped","value":{"boolValue":true}}`)
+}
+
+// exportedTraces keeps every request that a tracer exports
+type exportedTraces struct {
+	requests []string
+}
+
+func (e *exportedTraces) Export(_ context.Context, request []byte) error {
+	e.requests = append(e.requests, string(request))
+	return nil
+}
+
+func (e *exportedTraces) String() string {
+	return "exported traces"
+}